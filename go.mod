@@ -5,6 +5,7 @@ go 1.22.1
 require (
 	github.com/a-h/templ v0.2.793
 	github.com/joho/godotenv v1.5.1
+	github.com/lib/pq v1.9.0
 	github.com/lsymds/go-utils/pkg/http/middleware v0.0.0-20240514204121-e7dcd0749a50
 	github.com/lsymds/staticmodtimefs v1.0.0
 	github.com/rs/zerolog v1.33.0
@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lsymds/shareasecret/internal/shareasecret"
+)
+
+// RunAccount executes the passkey account management command named by the first argument. Like the token commands,
+// these operate on the server's database directly and so must be run with the same configuration as the server.
+func RunAccount(args []string, app *shareasecret.Application, stdout io.Writer, stderr io.Writer) error {
+	if len(args) == 0 {
+		accountUsage(stderr)
+		return errUsage
+	}
+
+	switch args[0] {
+	case "invite":
+		return inviteAccount(args[1:], app, stdout, stderr)
+	case "list":
+		return listAccounts(app, stdout)
+	case "remove":
+		return removeAccount(args[1:], app, stdout, stderr)
+	default:
+		accountUsage(stderr)
+		return errUsage
+	}
+}
+
+// accountUsage writes the usage of the account management commands to w
+func accountUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage:")
+	fmt.Fprintln(w, "  shareasecret account invite --name name [--ttl duration]")
+	fmt.Fprintln(w, "  shareasecret account list")
+	fmt.Fprintln(w, "  shareasecret account remove <id>")
+}
+
+// inviteAccount creates an account, writing the link used to enrol it to stdout
+func inviteAccount(args []string, app *shareasecret.Application, stdout io.Writer, stderr io.Writer) error {
+	fs := flag.NewFlagSet("account invite", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	name := fs.String("name", "", "name of the person the account is for")
+	ttl := fs.Duration("ttl", 72*time.Hour, "duration until the enrolment link expires")
+
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	acc, link, err := app.InviteAccount(*name, *ttl)
+	if err != nil {
+		return fmt.Errorf("inviting account: %w", err)
+	}
+
+	fmt.Fprintln(stdout, link)
+	fmt.Fprintf(stderr, "account id: %s\n", acc.ID)
+	fmt.Fprintf(stderr, "enrolment link expires at: %s\n", acc.EnrolmentExpiresAt.UTC().Format(time.RFC3339))
+
+	return nil
+}
+
+// listAccounts writes a table describing every account to stdout
+func listAccounts(app *shareasecret.Application, stdout io.Writer) error {
+	accounts, err := app.Accounts()
+	if err != nil {
+		return fmt.Errorf("listing accounts: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSTATUS\tCREATED\tLAST SIGNED IN")
+
+	for _, acc := range accounts {
+		status := "enrolled"
+		if acc.EnrolledAt.IsZero() && acc.EnrolmentExpiresAt.Before(time.Now()) {
+			status = "invite expired"
+		} else if acc.EnrolledAt.IsZero() {
+			status = "invited"
+		}
+
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%s\n",
+			acc.ID,
+			acc.Name,
+			status,
+			formatTime(acc.CreatedAt, "never"),
+			formatTime(acc.LastSignedInAt, "never"),
+		)
+	}
+
+	return tw.Flush()
+}
+
+// removeAccount removes the account with the given identifier
+func removeAccount(args []string, app *shareasecret.Application, stdout io.Writer, stderr io.Writer) error {
+	if len(args) != 1 {
+		accountUsage(stderr)
+		return errUsage
+	}
+
+	err := app.RemoveAccount(args[0])
+	if errors.Is(err, shareasecret.ErrAccountNotFound) {
+		return fmt.Errorf("account %s does not exist or has already been removed", args[0])
+	} else if err != nil {
+		return fmt.Errorf("removing account: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "removed account %s\n", args[0])
+
+	return nil
+}
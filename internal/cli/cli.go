@@ -0,0 +1,225 @@
+// Package cli contains the command line client for shareasecret. It encrypts secrets locally (via the secretcrypto
+// package) in the same format as the web interface so that links created by it can be opened in a browser and vice
+// versa.
+package cli
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lsymds/shareasecret/pkg/client"
+)
+
+// errUsage is returned when the command line arguments are invalid. The usage will already have been written.
+var errUsage = errors.New("invalid usage")
+
+// Run executes the client command named by the first argument, reading from stdin and writing to stdout and stderr.
+func Run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	if len(args) == 0 {
+		usage(stderr)
+		return errUsage
+	}
+
+	switch args[0] {
+	case "send":
+		return send(args[1:], stdin, stdout, stderr)
+	case "open":
+		return open(args[1:], stdin, stdout, stderr)
+	default:
+		usage(stderr)
+		return errUsage
+	}
+}
+
+// IsCommand identifies whether the given name is a command handled by [Run]
+func IsCommand(name string) bool {
+	return name == "send" || name == "open"
+}
+
+// usage writes the top level usage of the client to w
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage:")
+	fmt.Fprintln(w, "  shareasecret send [--server url] [--invite url] [--organisation id] [--token token] [--ttl minutes] [--max-views n] [--key key | --key-in-url] [--verify-key] [--attach file]... < secret.txt")
+	fmt.Fprintln(w, "  shareasecret open [--key key] [--attachments-dir dir] <url>")
+}
+
+// send encrypts the secret read from stdin and persists it on the server, writing the viewing URL to stdout
+func send(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	server := fs.String("server", os.Getenv("SHAREASECRET_SERVER_URL"), "base URL of the shareasecret server (env: SHAREASECRET_SERVER_URL)")
+	ttl := fs.Int("ttl", 60, "minutes until the secret expires")
+	maxViews := fs.Int("max-views", 1, "maximum number of times the secret can be viewed (0 = infinite)")
+	key := fs.String("key", os.Getenv("SHAREASECRET_KEY"), "encryption key, generated if empty (env: SHAREASECRET_KEY)")
+	keyInURL := fs.Bool("key-in-url", false, "encrypt with a random key that is added to the viewing URL, so no key has to be shared separately")
+	verifyKey := fs.Bool("verify-key", false, "only release the secret to viewers that prove they know the key, deleting it after too many incorrect keys")
+	invite := fs.String("invite", "", "invite link to create the secret with, which also sets --server if it is empty")
+	token := fs.String("token", os.Getenv("SHAREASECRET_TOKEN"), "API token to create the secret with (env: SHAREASECRET_TOKEN)")
+	organisation := fs.String("organisation", os.Getenv("SHAREASECRET_ORGANISATION"), "identifier of the organisation that will own the secret (env: SHAREASECRET_ORGANISATION)")
+
+	var attachments []client.File
+	fs.Func("attach", "file to encrypt and attach to the secret (repeatable)", func(path string) error {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		attachments = append(attachments, client.File{Name: filepath.Base(path), Content: content})
+		return nil
+	})
+
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	var inviteToken string
+	if *invite != "" {
+		baseURL, token, err := client.ParseInviteURL(*invite)
+		if err != nil {
+			return err
+		}
+
+		if *server == "" {
+			*server = baseURL
+		}
+
+		inviteToken = token
+	}
+
+	if *server == "" {
+		fmt.Fprintln(stderr, "--server, --invite or SHAREASECRET_SERVER_URL must be set")
+		return errUsage
+	}
+
+	plainText, err := io.ReadAll(stdin)
+	if err != nil {
+		return fmt.Errorf("reading secret from stdin: %w", err)
+	} else if len(plainText) == 0 {
+		return errors.New("secret read from stdin is empty")
+	}
+
+	if *keyInURL && *key != "" {
+		fmt.Fprintln(stderr, "--key and --key-in-url cannot be used together")
+		return errUsage
+	}
+
+	if *keyInURL && *verifyKey {
+		fmt.Fprintln(stderr, "--key-in-url and --verify-key cannot be used together")
+		return errUsage
+	}
+
+	generatedKey := *key == "" && !*keyInURL
+	if generatedKey {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("generating key: %w", err)
+		}
+
+		*key = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	c := client.New(*server)
+	c.Invite = inviteToken
+	c.Token = *token
+	c.Organisation = *organisation
+	c.Attachments = attachments
+
+	var created *client.CreatedSecret
+	if *keyInURL {
+		created, err = c.SendSecretWithKeyInURL(context.Background(), plainText, *ttl, *maxViews)
+	} else if *verifyKey {
+		created, err = c.SendSecretWithVerifier(context.Background(), plainText, *key, *ttl, *maxViews)
+	} else {
+		created, err = c.SendSecret(context.Background(), plainText, *key, *ttl, *maxViews)
+	}
+	if err != nil {
+		return fmt.Errorf("creating secret: %w", err)
+	}
+
+	fmt.Fprintln(stdout, created.ViewSecretURL)
+	fmt.Fprintf(stderr, "management url: %s\n", created.ManagementURL)
+	if generatedKey {
+		fmt.Fprintf(stderr, "encryption key: %s\n", *key)
+	}
+
+	return nil
+}
+
+// open uses a view of the secret at the given URL, decrypting it and writing the plaintext to stdout
+func open(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	fs := flag.NewFlagSet("open", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	key := fs.String("key", os.Getenv("SHAREASECRET_KEY"), "encryption key, read from the URL or stdin if empty (env: SHAREASECRET_KEY)")
+	attachmentsDir := fs.String("attachments-dir", "", "directory to save the secret's attachments to")
+
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if fs.NArg() != 1 {
+		usage(stderr)
+		return errUsage
+	}
+
+	baseURL, accessID, err := client.ParseSecretURL(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *key == "" {
+		*key = client.KeyFromSecretURL(fs.Arg(0))
+	}
+
+	if *key == "" {
+		fmt.Fprint(stderr, "encryption key: ")
+
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("reading key from stdin: %w", err)
+		}
+
+		*key = strings.TrimRight(line, "\r\n")
+	}
+
+	plainText, files, err := client.New(baseURL).OpenSecretWithAttachments(context.Background(), accessID, *key)
+	if err != nil {
+		return fmt.Errorf("opening secret: %w", err)
+	}
+
+	if _, err := stdout.Write(plainText); err != nil {
+		return err
+	}
+
+	// the secret cannot be viewed again once its final view has been used, so its attachments are reported even if
+	// there is nowhere to save them
+	if len(files) > 0 && *attachmentsDir == "" {
+		fmt.Fprintf(stderr, "%d attachments were not saved, as --attachments-dir was not set\n", len(files))
+		return nil
+	}
+
+	for i, f := range files {
+		// the name was chosen by the sender, so only its final element is used
+		name := filepath.Base(filepath.Clean("/" + f.Name))
+		if name == string(filepath.Separator) {
+			name = fmt.Sprintf("attachment-%d", i+1)
+		}
+
+		path := filepath.Join(*attachmentsDir, name)
+		if err := os.WriteFile(path, f.Content, 0o600); err != nil {
+			return fmt.Errorf("saving attachment: %w", err)
+		}
+
+		fmt.Fprintf(stderr, "saved attachment: %s\n", path)
+	}
+
+	return nil
+}
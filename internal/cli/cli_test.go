@@ -0,0 +1,441 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lsymds/shareasecret/internal/shareasecret"
+)
+
+func TestRun(t *testing.T) {
+	t.Run("writes usage for missing and unknown commands", func(t *testing.T) {
+		for _, args := range [][]string{{}, {"unknown"}} {
+			_, stderr, err := run(t, "", args...)
+			if !errors.Is(err, errUsage) {
+				t.Errorf("expected usage error for %v, got %v", args, err)
+			} else if !strings.Contains(stderr, "shareasecret send") {
+				t.Errorf("expected usage to be written for %v, got %q", args, stderr)
+			}
+		}
+	})
+
+	t.Run("identifies client commands", func(t *testing.T) {
+		if !IsCommand("send") || !IsCommand("open") || IsCommand("serve") {
+			t.Errorf("unexpected client commands")
+		}
+	})
+}
+
+func TestSend(t *testing.T) {
+	server, app := newTestServer(t, func(config *shareasecret.Configuration) {
+		config.Policy.MaxAttachments = 1
+	})
+
+	t.Run("rejects invalid usage", func(t *testing.T) {
+		cases := map[string][]string{
+			"no server":              {"send"},
+			"unknown flag":           {"send", "--server", server, "--unknown"},
+			"invalid number":         {"send", "--server", server, "--ttl", "an hour"},
+			"missing attachment":     {"send", "--server", server, "--attach", filepath.Join(t.TempDir(), "missing")},
+			"key and key in url":     {"send", "--server", server, "--key", "a key", "--key-in-url"},
+			"key in url and verify":  {"send", "--server", server, "--key-in-url", "--verify-key"},
+			"open without url":       {"open"},
+			"open with too many url": {"open", server + "/secret/a", server + "/secret/b"},
+		}
+
+		for name, args := range cases {
+			if _, _, err := run(t, "a secret", args...); !errors.Is(err, errUsage) {
+				t.Errorf("%v: expected usage error, got %v", name, err)
+			}
+		}
+	})
+
+	t.Run("rejects an empty secret", func(t *testing.T) {
+		if _, _, err := run(t, "", "send", "--server", server); err == nil || errors.Is(err, errUsage) {
+			t.Errorf("expected empty secret to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("rejects invalid invite links", func(t *testing.T) {
+		if _, _, err := run(t, "a secret", "send", "--invite", "not an invite"); err == nil || errors.Is(err, errUsage) {
+			t.Errorf("expected invalid invite to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("sends and opens a secret with a key", func(t *testing.T) {
+		stdout, stderr, err := run(t, "a secret", "send", "--server", server, "--key", "a key")
+		if err != nil {
+			t.Fatalf("sending secret: %v", err)
+		} else if !strings.Contains(stderr, "management url: ") || strings.Contains(stderr, "encryption key: ") {
+			t.Errorf("unexpected stderr %q", stderr)
+		}
+
+		if pt, _, err := run(t, "", "open", "--key", "a key", strings.TrimSpace(stdout)); err != nil {
+			t.Errorf("opening secret: %v", err)
+		} else if pt != "a secret" {
+			t.Errorf("wanted 'a secret', got %q", pt)
+		}
+
+		if _, _, err := run(t, "", "open", "--key", "a key", strings.TrimSpace(stdout)); err == nil {
+			t.Errorf("expected secret with no views left not to be opened")
+		}
+	})
+
+	t.Run("generates a key and reads it from stdin when opening", func(t *testing.T) {
+		stdout, stderr, err := run(t, "a secret", "send", "--server", server)
+		if err != nil {
+			t.Fatalf("sending secret: %v", err)
+		}
+
+		_, key, ok := strings.Cut(stderr, "encryption key: ")
+		if !ok {
+			t.Fatalf("expected generated key in stderr %q", stderr)
+		}
+
+		if pt, _, err := run(t, key, "open", strings.TrimSpace(stdout)); err != nil {
+			t.Errorf("opening secret: %v", err)
+		} else if pt != "a secret" {
+			t.Errorf("wanted 'a secret', got %q", pt)
+		}
+	})
+
+	t.Run("sends and opens a secret with its key in its url", func(t *testing.T) {
+		stdout, stderr, err := run(t, "a secret", "send", "--server", server, "--key-in-url")
+		if err != nil {
+			t.Fatalf("sending secret: %v", err)
+		} else if !strings.Contains(stdout, "#") || strings.Contains(stderr, "encryption key: ") {
+			t.Errorf("expected key in url %q and not in stderr %q", stdout, stderr)
+		}
+
+		if pt, _, err := run(t, "", "open", strings.TrimSpace(stdout)); err != nil {
+			t.Errorf("opening secret: %v", err)
+		} else if pt != "a secret" {
+			t.Errorf("wanted 'a secret', got %q", pt)
+		}
+	})
+
+	t.Run("sends and opens a secret protected by a key verifier", func(t *testing.T) {
+		stdout, _, err := run(t, "a secret", "send", "--server", server, "--key", "a key", "--verify-key", "--max-views", "2")
+		if err != nil {
+			t.Fatalf("sending secret: %v", err)
+		}
+
+		if _, _, err := run(t, "", "open", "--key", "the wrong key", strings.TrimSpace(stdout)); err == nil {
+			t.Errorf("expected secret not to be opened with the wrong key")
+		}
+
+		if pt, _, err := run(t, "", "open", "--key", "a key", strings.TrimSpace(stdout)); err != nil {
+			t.Errorf("opening secret: %v", err)
+		} else if pt != "a secret" {
+			t.Errorf("wanted 'a secret', got %q", pt)
+		}
+	})
+
+	t.Run("sends and saves attachments", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "notes.txt")
+		if err := os.WriteFile(path, []byte("some notes"), 0o600); err != nil {
+			t.Fatalf("writing attachment: %v", err)
+		}
+
+		send := func() string {
+			stdout, _, err := run(t, "a secret", "send", "--server", server, "--key", "a key", "--attach", path)
+			if err != nil {
+				t.Fatalf("sending secret: %v", err)
+			}
+
+			return strings.TrimSpace(stdout)
+		}
+
+		dir := t.TempDir()
+		if _, stderr, err := run(t, "", "open", "--key", "a key", "--attachments-dir", dir, send()); err != nil {
+			t.Errorf("opening secret: %v", err)
+		} else if !strings.Contains(stderr, "saved attachment: ") {
+			t.Errorf("expected saved attachment in stderr %q", stderr)
+		}
+
+		if b, err := os.ReadFile(filepath.Join(dir, "notes.txt")); err != nil || string(b) != "some notes" {
+			t.Errorf("expected attachment to be saved, got %q %v", b, err)
+		}
+
+		if _, stderr, err := run(t, "", "open", "--key", "a key", send()); err != nil {
+			t.Errorf("opening secret: %v", err)
+		} else if !strings.Contains(stderr, "1 attachments were not saved") {
+			t.Errorf("expected unsaved attachments to be reported in stderr %q", stderr)
+		}
+	})
+
+	t.Run("sends secrets for organisations", func(t *testing.T) {
+		_, err := app.CreateOrganisation(shareasecret.Organisation{
+			ID:             "acme",
+			Name:           "Acme",
+			IPRestrictions: []string{"127.0.0.1/32"},
+		})
+		if err != nil {
+			t.Fatalf("creating organisation: %v", err)
+		}
+
+		if _, _, err := run(t, "a secret", "send", "--server", server, "--organisation", "acme"); err != nil {
+			t.Errorf("sending secret: %v", err)
+		}
+
+		if _, _, err := run(t, "a secret", "send", "--server", server, "--organisation", "unknown"); err == nil {
+			t.Errorf("expected secret not to be sent for an unknown organisation")
+		}
+	})
+
+	t.Run("sends secrets with api tokens", func(t *testing.T) {
+		_, token, err := app.CreateAPIToken("cli", []string{shareasecret.APITokenScopeCreate}, 0)
+		if err != nil {
+			t.Fatalf("creating token: %v", err)
+		}
+
+		if _, _, err := run(t, "a secret", "send", "--server", server, "--token", token); err != nil {
+			t.Errorf("sending secret: %v", err)
+		}
+
+		if _, _, err := run(t, "a secret", "send", "--server", server, "--token", "not a token"); err == nil {
+			t.Errorf("expected secret not to be sent with an invalid token")
+		}
+	})
+}
+
+func TestRunToken(t *testing.T) {
+	_, app := newTestServer(t, nil)
+
+	t.Run("rejects invalid usage", func(t *testing.T) {
+		for _, args := range [][]string{{}, {"unknown"}, {"create", "--ttl", "forever"}, {"revoke"}, {"revoke", "a", "b"}} {
+			if _, _, err := runAdmin(RunToken, app, args...); !errors.Is(err, errUsage) {
+				t.Errorf("expected usage error for %v, got %v", args, err)
+			}
+		}
+	})
+
+	t.Run("rejects tokens without a name", func(t *testing.T) {
+		if _, _, err := runAdmin(RunToken, app, "create"); err == nil || errors.Is(err, errUsage) {
+			t.Errorf("expected token without a name to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("creates, lists and revokes tokens", func(t *testing.T) {
+		stdout, stderr, err := runAdmin(RunToken, app, "create", "--name", "deploys", "--scopes", "create,read")
+		if err != nil {
+			t.Fatalf("creating token: %v", err)
+		} else if strings.TrimSpace(stdout) == "" {
+			t.Errorf("expected token in stdout")
+		}
+
+		_, id, ok := strings.Cut(strings.SplitN(stderr, "\n", 2)[0], "token id: ")
+		if !ok {
+			t.Fatalf("expected token id in stderr %q", stderr)
+		}
+
+		if stdout, _, err := runAdmin(RunToken, app, "list"); err != nil {
+			t.Errorf("listing tokens: %v", err)
+		} else if !strings.Contains(stdout, "deploys") || !strings.Contains(stdout, "create,read") || !strings.Contains(stdout, "active") {
+			t.Errorf("expected token in list %q", stdout)
+		}
+
+		if _, _, err := runAdmin(RunToken, app, "revoke", id); err != nil {
+			t.Errorf("revoking token: %v", err)
+		}
+
+		if _, _, err := runAdmin(RunToken, app, "revoke", id); err == nil {
+			t.Errorf("expected revoked token not to be revoked again")
+		}
+	})
+}
+
+func TestRunAccount(t *testing.T) {
+	_, app := newTestServer(t, func(config *shareasecret.Configuration) {
+		config.Passkeys.Enabled = true
+		config.Passkeys.RelyingPartyName = "shareasecret"
+	})
+
+	t.Run("rejects invalid usage", func(t *testing.T) {
+		for _, args := range [][]string{{}, {"unknown"}, {"invite", "--ttl", "forever"}, {"remove"}} {
+			if _, _, err := runAdmin(RunAccount, app, args...); !errors.Is(err, errUsage) {
+				t.Errorf("expected usage error for %v, got %v", args, err)
+			}
+		}
+	})
+
+	t.Run("invites, lists and removes accounts", func(t *testing.T) {
+		stdout, stderr, err := runAdmin(RunAccount, app, "invite", "--name", "Ada")
+		if err != nil {
+			t.Fatalf("inviting account: %v", err)
+		} else if !strings.Contains(stdout, "http") {
+			t.Errorf("expected enrolment link in stdout %q", stdout)
+		}
+
+		_, id, ok := strings.Cut(strings.SplitN(stderr, "\n", 2)[0], "account id: ")
+		if !ok {
+			t.Fatalf("expected account id in stderr %q", stderr)
+		}
+
+		if stdout, _, err := runAdmin(RunAccount, app, "list"); err != nil {
+			t.Errorf("listing accounts: %v", err)
+		} else if !strings.Contains(stdout, "Ada") || !strings.Contains(stdout, "invited") {
+			t.Errorf("expected account in list %q", stdout)
+		}
+
+		if _, _, err := runAdmin(RunAccount, app, "remove", id); err != nil {
+			t.Errorf("removing account: %v", err)
+		}
+
+		if _, _, err := runAdmin(RunAccount, app, "remove", id); err == nil {
+			t.Errorf("expected removed account not to be removed again")
+		}
+	})
+
+	t.Run("rejects invites when passkeys are disabled", func(t *testing.T) {
+		_, app := newTestServer(t, nil)
+
+		if _, _, err := runAdmin(RunAccount, app, "invite", "--name", "Ada"); err == nil || errors.Is(err, errUsage) {
+			t.Errorf("expected invite to be rejected, got %v", err)
+		}
+	})
+}
+
+func TestRunOrganisation(t *testing.T) {
+	_, app := newTestServer(t, nil)
+
+	t.Run("rejects invalid usage", func(t *testing.T) {
+		cases := [][]string{
+			{},
+			{"unknown"},
+			{"create", "--max-views", "many"},
+			{"remove"},
+			{"members"},
+			{"add-member", "--admin=maybe"},
+		}
+
+		for _, args := range cases {
+			if _, _, err := runAdmin(RunOrganisation, app, args...); !errors.Is(err, errUsage) {
+				t.Errorf("expected usage error for %v, got %v", args, err)
+			}
+		}
+	})
+
+	t.Run("rejects invalid organisations", func(t *testing.T) {
+		if _, _, err := runAdmin(RunOrganisation, app, "create", "--id", "Not Valid", "--name", "Acme"); err == nil {
+			t.Errorf("expected invalid organisation to be rejected")
+		}
+
+		if _, _, err := runAdmin(RunOrganisation, app, "update", "--id", "unknown", "--name", "Acme"); err == nil {
+			t.Errorf("expected unknown organisation not to be updated")
+		}
+	})
+
+	t.Run("creates, updates, lists and removes organisations", func(t *testing.T) {
+		if _, _, err := runAdmin(RunOrganisation, app, "create", "--id", "acme", "--name", "Acme", "--max-views", "3"); err != nil {
+			t.Fatalf("creating organisation: %v", err)
+		}
+
+		if _, _, err := runAdmin(RunOrganisation, app, "create", "--id", "acme", "--name", "Acme"); err == nil {
+			t.Errorf("expected existing organisation not to be created again")
+		}
+
+		// only the flags that are passed are updated
+		if _, _, err := runAdmin(RunOrganisation, app, "update", "--id", "acme", "--max-ttl", "1h"); err != nil {
+			t.Errorf("updating organisation: %v", err)
+		}
+
+		if stdout, _, err := runAdmin(RunOrganisation, app, "list"); err != nil {
+			t.Errorf("listing organisations: %v", err)
+		} else if !strings.Contains(stdout, "Acme") || !strings.Contains(stdout, "1h0m0s") || !strings.Contains(stdout, "3") {
+			t.Errorf("expected updated organisation in list %q", stdout)
+		}
+
+		if _, _, err := runAdmin(RunOrganisation, app, "remove", "acme"); err != nil {
+			t.Errorf("removing organisation: %v", err)
+		}
+
+		if _, _, err := runAdmin(RunOrganisation, app, "remove", "acme"); err == nil {
+			t.Errorf("expected removed organisation not to be removed again")
+		}
+	})
+
+	t.Run("adds, lists and removes members", func(t *testing.T) {
+		if _, _, err := runAdmin(RunOrganisation, app, "create", "--id", "initech", "--name", "Initech"); err != nil {
+			t.Fatalf("creating organisation: %v", err)
+		}
+
+		if _, _, err := runAdmin(RunOrganisation, app, "add-member", "--org", "unknown", "--email", "ada@example.com"); err == nil {
+			t.Errorf("expected member not to be added to an unknown organisation")
+		}
+
+		if _, _, err := runAdmin(RunOrganisation, app, "add-member", "--org", "initech", "--email", "ada@example.com", "--admin"); err != nil {
+			t.Errorf("adding member: %v", err)
+		}
+
+		if stdout, _, err := runAdmin(RunOrganisation, app, "members", "initech"); err != nil {
+			t.Errorf("listing members: %v", err)
+		} else if !strings.Contains(stdout, "ada@example.com") || !strings.Contains(stdout, shareasecret.OrganisationRoleAdmin) {
+			t.Errorf("expected admin in members %q", stdout)
+		}
+
+		if _, _, err := runAdmin(RunOrganisation, app, "remove-member", "--org", "initech", "--email", "ada@example.com"); err != nil {
+			t.Errorf("removing member: %v", err)
+		}
+
+		if _, _, err := runAdmin(RunOrganisation, app, "remove-member", "--org", "initech", "--email", "ada@example.com"); err == nil {
+			t.Errorf("expected removed member not to be removed again")
+		}
+	})
+}
+
+// run runs a client command with the given stdin, returning what was written to stdout and stderr. The environment
+// variables the command's flags default to are cleared.
+func run(t *testing.T, stdin string, args ...string) (string, string, error) {
+	for _, name := range []string{"SHAREASECRET_SERVER_URL", "SHAREASECRET_KEY", "SHAREASECRET_TOKEN", "SHAREASECRET_ORGANISATION"} {
+		t.Setenv(name, "")
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := Run(args, strings.NewReader(stdin), &stdout, &stderr)
+
+	return stdout.String(), stderr.String(), err
+}
+
+// runAdmin runs one of the management commands against the application, returning what was written to stdout and
+// stderr
+func runAdmin(
+	command func(args []string, app *shareasecret.Application, stdout io.Writer, stderr io.Writer) error,
+	app *shareasecret.Application,
+	args ...string,
+) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	err := command(args, app, &stdout, &stderr)
+
+	return stdout.String(), stderr.String(), err
+}
+
+// newTestServer boots a shareasecret server backed by a temporary database, returning its URL and the application
+// serving requests. The configuration can optionally be modified before the server is booted.
+func newTestServer(t *testing.T, configure func(config *shareasecret.Configuration)) (string, *shareasecret.Application) {
+	srv := httptest.NewUnstartedServer(nil)
+
+	config := &shareasecret.Configuration{}
+	config.Database.Path = filepath.Join(t.TempDir(), "shareasecret_test.db")
+	config.Server.BaseUrl = "http://" + srv.Listener.Addr().String()
+	if configure != nil {
+		configure(config)
+	}
+
+	a, err := shareasecret.NewApplication(config, os.DirFS("../../web/"))
+	if err != nil {
+		t.Fatalf("creating application: %v", err)
+	}
+
+	srv.Config.Handler = a
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	return srv.URL, a
+}
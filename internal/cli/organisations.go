@@ -0,0 +1,251 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lsymds/shareasecret/internal/shareasecret"
+)
+
+// RunOrganisation executes the organisation management command named by the first argument. Like the token commands,
+// these operate on the server's database directly and so must be run with the same configuration as the server.
+func RunOrganisation(args []string, app *shareasecret.Application, stdout io.Writer, stderr io.Writer) error {
+	if len(args) == 0 {
+		organisationUsage(stderr)
+		return errUsage
+	}
+
+	switch args[0] {
+	case "create":
+		return saveOrganisation(args[1:], app, stdout, stderr, true)
+	case "update":
+		return saveOrganisation(args[1:], app, stdout, stderr, false)
+	case "list":
+		return listOrganisations(app, stdout)
+	case "remove":
+		return removeOrganisation(args[1:], app, stdout, stderr)
+	case "members":
+		return listOrganisationMembers(args[1:], app, stdout, stderr)
+	case "add-member":
+		return changeOrganisationMember(args[1:], app, stdout, stderr, true)
+	case "remove-member":
+		return changeOrganisationMember(args[1:], app, stdout, stderr, false)
+	default:
+		organisationUsage(stderr)
+		return errUsage
+	}
+}
+
+// organisationUsage writes the usage of the organisation management commands to w
+func organisationUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage:")
+	fmt.Fprintln(w, "  shareasecret org create --id id --name name [--ip-restrictions list] [--max-ttl duration] [--max-views n]")
+	fmt.Fprintln(w, "                          [--brand-message message] [--brand-colour #rrggbb]")
+	fmt.Fprintln(w, "  shareasecret org update --id id [--name name] [--ip-restrictions list] [--max-ttl duration] [--max-views n]")
+	fmt.Fprintln(w, "                          [--brand-message message] [--brand-colour #rrggbb]")
+	fmt.Fprintln(w, "  shareasecret org list")
+	fmt.Fprintln(w, "  shareasecret org remove <id>")
+	fmt.Fprintln(w, "  shareasecret org members <id>")
+	fmt.Fprintln(w, "  shareasecret org add-member --org id (--email email | --account id) [--admin]")
+	fmt.Fprintln(w, "  shareasecret org remove-member --org id (--email email | --account id)")
+}
+
+// saveOrganisation creates an organisation or, when not creating, updates the values of an existing one that were
+// passed as flags
+func saveOrganisation(args []string, app *shareasecret.Application, stdout io.Writer, stderr io.Writer, create bool) error {
+	fs := flag.NewFlagSet("org", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	id := fs.String("id", "", "identifier of the organisation, used in the URL of its page (/o/{id})")
+	name := fs.String("name", "", "name of the organisation")
+	ipRestrictions := fs.String("ip-restrictions", "", "comma separated list of IP addresses and CIDRs that can create the organisation's secrets")
+	maxTTL := fs.Duration("max-ttl", 0, "maximum duration until the organisation's secrets expire, or 0 for no limit")
+	maxViews := fs.Int("max-views", 0, "maximum number of times the organisation's secrets can be viewed, or 0 for no limit")
+	brandMessage := fs.String("brand-message", "", "message displayed on the organisation's page")
+	brandColour := fs.String("brand-colour", "", "hexadecimal colour (i.e. #1d4ed8) used on the organisation's pages")
+
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	o := shareasecret.Organisation{ID: *id}
+	if !create {
+		var err error
+		if o, err = app.OrganisationByID(*id); errors.Is(err, shareasecret.ErrOrganisationNotFound) {
+			return fmt.Errorf("organisation %s does not exist or has been removed", *id)
+		} else if err != nil {
+			return fmt.Errorf("retrieving organisation: %w", err)
+		}
+	}
+
+	// only the flags that were passed replace the values of an existing organisation
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "name":
+			o.Name = *name
+		case "ip-restrictions":
+			o.IPRestrictions = strings.Split(*ipRestrictions, ",")
+		case "max-ttl":
+			o.MaxTTL = *maxTTL
+		case "max-views":
+			o.MaxViews = *maxViews
+		case "brand-message":
+			o.BrandMessage = *brandMessage
+		case "brand-colour":
+			o.BrandColour = *brandColour
+		}
+	})
+
+	if create {
+		if _, err := app.CreateOrganisation(o); err != nil {
+			return fmt.Errorf("creating organisation: %w", err)
+		}
+
+		fmt.Fprintf(stdout, "created organisation %s\n", o.ID)
+	} else {
+		if err := app.UpdateOrganisation(o); err != nil {
+			return fmt.Errorf("updating organisation: %w", err)
+		}
+
+		fmt.Fprintf(stdout, "updated organisation %s\n", o.ID)
+	}
+
+	return nil
+}
+
+// listOrganisations writes a table describing every organisation to stdout
+func listOrganisations(app *shareasecret.Application, stdout io.Writer) error {
+	organisations, err := app.Organisations()
+	if err != nil {
+		return fmt.Errorf("listing organisations: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tIP RESTRICTIONS\tMAX TTL\tMAX VIEWS\tCREATED")
+
+	for _, o := range organisations {
+		maxTTL, maxViews := "unlimited", "unlimited"
+		if o.MaxTTL > 0 {
+			maxTTL = o.MaxTTL.String()
+		}
+		if o.MaxViews > 0 {
+			maxViews = strconv.Itoa(o.MaxViews)
+		}
+
+		ipRestrictions := strings.Join(o.IPRestrictions, ",")
+		if ipRestrictions == "" {
+			ipRestrictions = "none"
+		}
+
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%s\t%s\n",
+			o.ID,
+			o.Name,
+			ipRestrictions,
+			maxTTL,
+			maxViews,
+			formatTime(o.CreatedAt, "never"),
+		)
+	}
+
+	return tw.Flush()
+}
+
+// removeOrganisation removes the organisation with the given identifier
+func removeOrganisation(args []string, app *shareasecret.Application, stdout io.Writer, stderr io.Writer) error {
+	if len(args) != 1 {
+		organisationUsage(stderr)
+		return errUsage
+	}
+
+	err := app.RemoveOrganisation(args[0])
+	if errors.Is(err, shareasecret.ErrOrganisationNotFound) {
+		return fmt.Errorf("organisation %s does not exist or has already been removed", args[0])
+	} else if err != nil {
+		return fmt.Errorf("removing organisation: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "removed organisation %s\n", args[0])
+
+	return nil
+}
+
+// listOrganisationMembers writes a table describing every member of the organisation with the given identifier to
+// stdout
+func listOrganisationMembers(args []string, app *shareasecret.Application, stdout io.Writer, stderr io.Writer) error {
+	if len(args) != 1 {
+		organisationUsage(stderr)
+		return errUsage
+	}
+
+	members, err := app.OrganisationMembers(args[0])
+	if err != nil {
+		return fmt.Errorf("listing members: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "EMAIL\tACCOUNT\tROLE\tADDED")
+
+	for _, m := range members {
+		email, account := m.Email, m.AccountID
+		if email == "" {
+			email = "-"
+		}
+		if account == "" {
+			account = "-"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", email, account, m.Role, formatTime(m.CreatedAt, "never"))
+	}
+
+	return tw.Flush()
+}
+
+// changeOrganisationMember adds a member to (or changes their role in) an organisation or, when not adding, removes
+// them from it
+func changeOrganisationMember(args []string, app *shareasecret.Application, stdout io.Writer, stderr io.Writer, add bool) error {
+	fs := flag.NewFlagSet("org member", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	org := fs.String("org", "", "identifier of the organisation")
+	email := fs.String("email", "", "email address of a member that signs in via OpenID Connect")
+	account := fs.String("account", "", "identifier of the account of a member that signs in with a passkey")
+	admin := fs.Bool("admin", false, "permit the member to see and delete every secret the organisation owns")
+
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	m := shareasecret.OrganisationMember{Email: *email, AccountID: *account, Role: shareasecret.OrganisationRoleMember}
+	if *admin {
+		m.Role = shareasecret.OrganisationRoleAdmin
+	}
+
+	who := *email + *account
+
+	if add {
+		err := app.AddOrganisationMember(*org, m)
+		if errors.Is(err, shareasecret.ErrOrganisationNotFound) {
+			return fmt.Errorf("organisation %s does not exist or has been removed", *org)
+		} else if err != nil {
+			return fmt.Errorf("adding member: %w", err)
+		}
+
+		fmt.Fprintf(stdout, "added %s to organisation %s as %s\n", who, *org, m.Role)
+	} else {
+		err := app.RemoveOrganisationMember(*org, m)
+		if errors.Is(err, shareasecret.ErrOrganisationMemberNotFound) {
+			return fmt.Errorf("%s is not a member of organisation %s", who, *org)
+		} else if err != nil {
+			return fmt.Errorf("removing member: %w", err)
+		}
+
+		fmt.Fprintf(stdout, "removed %s from organisation %s\n", who, *org)
+	}
+
+	return nil
+}
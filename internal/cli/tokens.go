@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lsymds/shareasecret/internal/shareasecret"
+)
+
+// RunToken executes the API token management command named by the first argument. Unlike the client commands, these
+// operate on the server's database directly and so must be run with the same configuration as the server.
+func RunToken(args []string, app *shareasecret.Application, stdout io.Writer, stderr io.Writer) error {
+	if len(args) == 0 {
+		tokenUsage(stderr)
+		return errUsage
+	}
+
+	switch args[0] {
+	case "create":
+		return createToken(args[1:], app, stdout, stderr)
+	case "list":
+		return listTokens(app, stdout)
+	case "revoke":
+		return revokeToken(args[1:], app, stdout, stderr)
+	default:
+		tokenUsage(stderr)
+		return errUsage
+	}
+}
+
+// tokenUsage writes the usage of the token management commands to w
+func tokenUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage:")
+	fmt.Fprintln(w, "  shareasecret token create --name name [--scopes create,manage,read] [--ttl duration]")
+	fmt.Fprintln(w, "  shareasecret token list")
+	fmt.Fprintln(w, "  shareasecret token revoke <id>")
+}
+
+// createToken creates an API token, writing the token itself to stdout
+func createToken(args []string, app *shareasecret.Application, stdout io.Writer, stderr io.Writer) error {
+	fs := flag.NewFlagSet("token create", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	name := fs.String("name", "", "name describing what the token is used for")
+	scopes := fs.String("scopes", shareasecret.APITokenScopeCreate, "comma separated list of scopes out of create, manage and read")
+	ttl := fs.Duration("ttl", 90*24*time.Hour, "duration until the token expires, or 0 to never expire")
+
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	t, token, err := app.CreateAPIToken(*name, strings.Split(*scopes, ","), *ttl)
+	if err != nil {
+		return fmt.Errorf("creating token: %w", err)
+	}
+
+	fmt.Fprintln(stdout, token)
+	fmt.Fprintf(stderr, "token id: %s\n", t.ID)
+	if !t.ExpiresAt.IsZero() {
+		fmt.Fprintf(stderr, "expires at: %s\n", t.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// listTokens writes a table describing every API token to stdout
+func listTokens(app *shareasecret.Application, stdout io.Writer) error {
+	tokens, err := app.APITokens()
+	if err != nil {
+		return fmt.Errorf("listing tokens: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSCOPES\tSTATUS\tEXPIRES\tLAST USED")
+
+	for _, t := range tokens {
+		status := "active"
+		if !t.RevokedAt.IsZero() {
+			status = "revoked"
+		} else if !t.ExpiresAt.IsZero() && t.ExpiresAt.Before(time.Now()) {
+			status = "expired"
+		}
+
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%s\t%s\n",
+			t.ID,
+			t.Name,
+			strings.Join(t.Scopes, ","),
+			status,
+			formatTime(t.ExpiresAt, "never"),
+			formatTime(t.LastUsedAt, "never"),
+		)
+	}
+
+	return tw.Flush()
+}
+
+// revokeToken revokes the API token with the given identifier
+func revokeToken(args []string, app *shareasecret.Application, stdout io.Writer, stderr io.Writer) error {
+	if len(args) != 1 {
+		tokenUsage(stderr)
+		return errUsage
+	}
+
+	err := app.RevokeAPIToken(args[0])
+	if errors.Is(err, shareasecret.ErrAPITokenNotFound) {
+		return fmt.Errorf("token %s does not exist or has already been revoked", args[0])
+	} else if err != nil {
+		return fmt.Errorf("revoking token: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "revoked token %s\n", args[0])
+
+	return nil
+}
+
+// formatTime formats t in UTC, or returns the fallback if it is zero
+func formatTime(t time.Time, fallback string) string {
+	if t.IsZero() {
+		return fallback
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
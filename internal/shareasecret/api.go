@@ -0,0 +1,864 @@
+package shareasecret
+
+import (
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// apiCreateSecretRequest is the request body accepted by the [handleAPICreateSecret] handler
+type apiCreateSecretRequest struct {
+	EncryptedSecret string `json:"encryptedSecret"`
+	TTL             int    `json:"ttl"`
+	MaxViews        int    `json:"maxViews"`
+
+	// ProofOfWork is only required if the server has been configured to require it
+	ProofOfWork *apiProofOfWork `json:"proofOfWork"`
+
+	// Invite is the token from an invite link, and is only required if the requester's IP address is not allowed to
+	// create secrets
+	Invite string `json:"invite"`
+
+	// Organisation is the identifier of the organisation that will own the secret, if it is being created for one
+	Organisation string `json:"organisation"`
+
+	// Verifier is the verifier of the secret's encryption key, and is only set if viewers must prove they know the key
+	// before the encrypted secret is released to them
+	Verifier string `json:"verifier"`
+
+	// Attachments are files attached to the secret, encrypted with its key
+	Attachments []apiAttachment `json:"attachments"`
+
+	// Upload is the identifier of a complete upload containing the encrypted secret and its attachments, which are
+	// then not set in the request itself
+	Upload string `json:"upload"`
+}
+
+// apiAttachment is a file attached to a secret, encrypted with the secret's key
+type apiAttachment struct {
+	// Name is the name of the file, encrypted into the same envelope format as a secret
+	Name string `json:"name"`
+
+	// Content is the iv followed by the encrypted content of the file, unpadded base64url encoded
+	Content string `json:"content"`
+}
+
+// apiProofOfWork contains the solution to a challenge issued by the [handleAPIChallenge] handler
+type apiProofOfWork struct {
+	Challenge string `json:"challenge"`
+	Solution  string `json:"solution"`
+}
+
+// apiChallengeResponse is the response body returned by the [handleAPIChallenge] handler
+type apiChallengeResponse struct {
+	Challenge  string    `json:"challenge"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// apiCreateSecretResponse is the response body returned by the [handleAPICreateSecret] handler
+type apiCreateSecretResponse struct {
+	AccessID      string `json:"accessId"`
+	ManagementID  string `json:"managementId"`
+	ViewSecretURL string `json:"viewSecretUrl"`
+	ManagementURL string `json:"managementUrl"`
+}
+
+// apiCreateInviteRequest is the request body accepted by the [handleAPICreateInvite] handler
+type apiCreateInviteRequest struct {
+	Label     string `json:"label"`
+	TTL       int    `json:"ttl"`
+	SingleUse bool   `json:"singleUse"`
+}
+
+// apiCreateInviteResponse is the response body returned by the [handleAPICreateInvite] handler
+type apiCreateInviteResponse struct {
+	InviteID  string    `json:"inviteId"`
+	InviteURL string    `json:"inviteUrl"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// apiInvite identifies the invite a secret was created via
+type apiInvite struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+// apiCreateSecretViewResponse is the response body returned by the [handleAPICreateSecretView] handler
+type apiCreateSecretViewResponse struct {
+	ViewingKey string `json:"viewingKey"`
+}
+
+// apiAccessSecretResponse is the response body returned by the [handleAPIAccessSecret] handler
+type apiAccessSecretResponse struct {
+	EncryptedSecret string          `json:"encryptedSecret"`
+	Attachments     []apiAttachment `json:"attachments,omitempty"`
+	FinalView       bool            `json:"finalView"`
+}
+
+// apiKeyChallengeResponse is the response body returned by the [handleAPISecretKeyChallenge] handler
+type apiKeyChallengeResponse struct {
+	Iterations        int    `json:"iterations"`
+	Salt              string `json:"salt"`
+	AttemptsRemaining int    `json:"attemptsRemaining"`
+}
+
+// apiVerifySecretViewRequest is the request body accepted by the [handleAPIVerifySecretView] handler
+type apiVerifySecretViewRequest struct {
+	Verifier string `json:"verifier"`
+}
+
+// apiManageSecretResponse is the response body returned by the [handleAPIManageSecret] handler
+type apiManageSecretResponse struct {
+	AccessID      string     `json:"accessId"`
+	ViewSecretURL string     `json:"viewSecretUrl"`
+	TTL           int        `json:"ttl"`
+	MaxViews      int        `json:"maxViews"`
+	Views         int        `json:"views"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	ExpiresAt     time.Time  `json:"expiresAt"`
+	Invite        *apiInvite `json:"invite,omitempty"`
+	KeyInURL      bool       `json:"keyInUrl,omitempty"`
+	Attachments   int        `json:"attachments,omitempty"`
+
+	// MaxKeyAttempts and FailedKeyAttempts are only included if the secret is protected by a key verifier
+	MaxKeyAttempts    int `json:"maxKeyAttempts,omitempty"`
+	FailedKeyAttempts int `json:"failedKeyAttempts,omitempty"`
+
+	// Status, DeletedAt and DeletionReason are only included when listing the secrets created with an API token
+	Status         string     `json:"status,omitempty"`
+	DeletedAt      *time.Time `json:"deletedAt,omitempty"`
+	DeletionReason string     `json:"deletionReason,omitempty"`
+}
+
+// apiSecretsResponse is the response body returned by the [handleAPISecrets] handler
+type apiSecretsResponse struct {
+	Secrets []apiManageSecretResponse `json:"secrets"`
+
+	// NextPage is only set if there are more secrets to retrieve
+	NextPage int `json:"nextPage,omitempty"`
+}
+
+// apiDeleteSecretsRequest is the request body accepted by the [handleAPIDeleteSecrets] handler
+type apiDeleteSecretsRequest struct {
+	AccessIDs []string `json:"accessIds"`
+
+	// All deletes every active secret created with the API token, and cannot be combined with AccessIDs
+	All bool `json:"all"`
+}
+
+// apiDeleteSecretsResponse is the response body returned by the [handleAPIDeleteSecrets] handler
+type apiDeleteSecretsResponse struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// apiErrorResponse is the response body returned by any API handler that fails
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// apiError describes why an API request failed. Code is a stable, machine readable identifier whereas Message is
+// intended to be read by humans.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// mapAPIRoutes maps all HTTP routes for the versioned JSON API.
+func (a *Application) mapAPIRoutes() {
+	a.router.HandleFunc("GET /api/v1/openapi.json", a.handleAPIOpenAPIDocument)
+
+	a.router.HandleFunc("GET /api/v1/challenge", a.rateLimited("", a.handleAPIChallenge))
+	a.router.HandleFunc("POST /api/v1/invites", a.rateLimited(rateLimitBucketCreate, a.handleAPICreateInvite))
+	a.router.HandleFunc("GET /api/v1/secrets", a.rateLimited("", a.handleAPISecrets))
+	a.router.HandleFunc("POST /api/v1/secrets", a.rateLimited(rateLimitBucketCreate, a.handleAPICreateSecret))
+	a.router.HandleFunc("DELETE /api/v1/secrets/{accessID}", a.rateLimited("", a.handleAPIDeleteSecretByAccessID))
+	a.router.HandleFunc("POST /api/v1/secrets/delete", a.rateLimited("", a.handleAPIDeleteSecrets))
+	a.router.HandleFunc("POST /api/v1/secrets/{accessID}/views", a.rateLimited(rateLimitBucketView, a.handleAPICreateSecretView))
+	a.router.HandleFunc("GET /api/v1/secrets/{accessID}/views/{viewingKey}", a.rateLimited("", a.handleAPIAccessSecret))
+	a.router.HandleFunc("GET /api/v1/secrets/{accessID}/views/{viewingKey}/challenge", a.rateLimited("", a.handleAPISecretKeyChallenge))
+	a.router.HandleFunc("POST /api/v1/secrets/{accessID}/views/{viewingKey}/verify", a.rateLimited("", a.handleAPIVerifySecretView))
+	a.router.HandleFunc("GET /api/v1/manage/{managementID}", a.rateLimited("", a.handleAPIManageSecret))
+	a.router.HandleFunc("DELETE /api/v1/manage/{managementID}", a.rateLimited("", a.handleAPIDeleteSecret))
+
+	a.router.HandleFunc("OPTIONS /api/v1/uploads", a.handleAPIUploadOptions)
+	a.router.HandleFunc("POST /api/v1/uploads", a.rateLimited("", tusResumable(a.handleAPICreateUpload)))
+	a.router.HandleFunc("HEAD /api/v1/uploads/{uploadID}", a.rateLimited("", tusResumable(a.handleAPIUpload)))
+	a.router.HandleFunc("PATCH /api/v1/uploads/{uploadID}", a.rateLimited("", tusResumable(a.handleAPIAppendUpload)))
+	a.router.HandleFunc("DELETE /api/v1/uploads/{uploadID}", a.rateLimited("", tusResumable(a.handleAPIDeleteUpload)))
+}
+
+// handleAPIOpenAPIDocument serves the OpenAPI document describing the versioned JSON API
+func (a *Application) handleAPIOpenAPIDocument(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
+
+// handleAPICreateSecret validates and persists a secret, and is the API equivalent of the [handleCreateSecret]
+// handler
+func (a *Application) handleAPICreateSecret(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.policy.maxRequestSize())
+
+	var req apiCreateSecretRequest
+	var mbe *http.MaxBytesError
+	if err := json.NewDecoder(r.Body).Decode(&req); errors.As(err, &mbe) {
+		apiErr(w, http.StatusRequestEntityTooLarge, "request_too_large", errRequestTooLarge.Error())
+		return
+	} else if err != nil {
+		apiErr(w, http.StatusBadRequest, "invalid_request", "Unable to parse request body. Please try again.")
+		return
+	}
+
+	s := newSecret{
+		cipherText: req.EncryptedSecret,
+		ttl:        req.TTL,
+		maxViews:   req.MaxViews,
+		verifier:   req.Verifier,
+		policy:     a.policy,
+	}
+
+	token, err := a.apiTokenFromRequest(r)
+	if errors.Is(err, errInvalidAPIToken) {
+		apiUnauthorized(w)
+		return
+	} else if err != nil {
+		l.Err(err).Msg("retrieving api token")
+		apiInternalServerError(w)
+		return
+	}
+
+	// secrets created for an organisation can only be created by its members (or from the IP addresses it permits).
+	// Secrets created via an invite are attributed to it regardless of who created them, and those created with an API
+	// token are attributed to the token. Otherwise the requester must be signed in or permitted to create secrets by
+	// their IP address.
+	if req.Organisation != "" {
+		var ok bool
+		if s.organisation, s.creator, ok = a.requesterCanCreateOrganisationSecret(r, req.Organisation); !ok {
+			apiErr(w, http.StatusForbidden, "forbidden", errOrganisationForbidden.Error())
+			return
+		}
+	} else if req.Invite != "" {
+		var ok bool
+		if s.inviteID, ok = a.inviteCanCreateSecret(r, req.Invite); !ok {
+			apiErr(w, http.StatusForbidden, "forbidden", errInvalidInvite.Error())
+			return
+		}
+	} else if token != nil {
+		if !token.HasScope(APITokenScopeCreate) {
+			apiErr(w, http.StatusForbidden, "forbidden", "This API token is not permitted to create secrets.")
+			return
+		}
+
+		s.apiTokenID = token.ID
+	} else {
+		var ok bool
+		if s.creator, ok = a.requesterCanCreateSecret(r); !ok {
+			apiErr(w, http.StatusForbidden, "forbidden", "You are not permitted to create secrets.")
+			return
+		}
+	}
+
+	var ve validationError
+
+	pow := apiProofOfWork{}
+	if req.ProofOfWork != nil {
+		pow = *req.ProofOfWork
+	}
+
+	s.challenge, err = a.verifyProofOfWork(pow.Challenge, pow.Solution)
+	if errors.As(err, &ve) {
+		apiErr(w, http.StatusBadRequest, "proof_of_work_failed", ve.Error())
+		return
+	} else if err != nil {
+		l.Err(err).Msg("verifying proof of work")
+		apiInternalServerError(w)
+		return
+	}
+
+	if req.Upload != "" {
+		if req.EncryptedSecret != "" || len(req.Attachments) > 0 {
+			apiErr(w, http.StatusBadRequest, "validation_failed", errUploadAndSecret.Error())
+			return
+		}
+
+		s.uploadID = req.Upload
+		s.cipherText, s.attachments, err = a.store.uploadedSecret(req.Upload)
+	} else {
+		names := make([]string, len(req.Attachments))
+		contents := make([]string, len(req.Attachments))
+		for i, at := range req.Attachments {
+			names[i], contents[i] = at.Name, at.Content
+		}
+
+		s.attachments, err = decodeAttachments(names, contents)
+	}
+
+	if errors.As(err, &ve) {
+		apiErr(w, http.StatusBadRequest, "validation_failed", ve.Error())
+		return
+	} else if err != nil {
+		l.Err(err).Msg("retrieving upload")
+		apiInternalServerError(w)
+		return
+	}
+
+	created, err := a.store.createSecret(s)
+	if errors.Is(err, errInvalidInvite) {
+		apiErr(w, http.StatusForbidden, "forbidden", errInvalidInvite.Error())
+		return
+	} else if errors.Is(err, errProofOfWorkInvalid) {
+		apiErr(w, http.StatusBadRequest, "proof_of_work_failed", errProofOfWorkInvalid.Error())
+		return
+	} else if errors.As(err, &ve) {
+		apiErr(w, http.StatusBadRequest, "validation_failed", ve.Error())
+		return
+	} else if err != nil {
+		l.Err(err).Msg("creating secret")
+		apiInternalServerError(w)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/manage/%s", created.managementID))
+	writeJSON(
+		w,
+		http.StatusCreated,
+		apiCreateSecretResponse{
+			AccessID:      created.accessID,
+			ManagementID:  created.managementID,
+			ViewSecretURL: fmt.Sprintf("%s/secret/%s", a.baseURL, created.accessID),
+			ManagementURL: fmt.Sprintf("%s/manage-secret/%s", a.baseURL, created.managementID),
+		},
+	)
+}
+
+// handleAPICreateInvite creates an invite link that permits whoever holds it to create secrets, and is the API
+// equivalent of the [handleCreateInvite] handler
+func (a *Application) handleAPICreateInvite(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+
+	if !a.requesterCanInvite(r) {
+		apiErr(w, http.StatusForbidden, "forbidden", "You are not permitted to create invites.")
+		return
+	}
+
+	var req apiCreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiErr(w, http.StatusBadRequest, "invalid_request", "Unable to parse request body. Please try again.")
+		return
+	}
+
+	i, err := a.db.createInvite(newInvite{label: req.Label, ttl: req.TTL, singleUse: req.SingleUse})
+
+	var ve validationError
+	if errors.As(err, &ve) {
+		apiErr(w, http.StatusBadRequest, "validation_failed", ve.Error())
+		return
+	} else if err != nil {
+		l.Err(err).Msg("creating invite")
+		apiInternalServerError(w)
+		return
+	}
+
+	writeJSON(
+		w,
+		http.StatusCreated,
+		apiCreateInviteResponse{InviteID: i.id, InviteURL: a.inviteURL(i), ExpiresAt: i.expiresAt.UTC()},
+	)
+}
+
+// handleAPIChallenge issues a proof of work challenge that must be solved before a secret can be created, if the
+// server has been configured to require one
+func (a *Application) handleAPIChallenge(w http.ResponseWriter, r *http.Request) {
+	if !a.pow.enabled() {
+		apiErr(w, http.StatusNotFound, "not_found", "This server does not require a proof of work to create secrets.")
+		return
+	}
+
+	c, err := a.pow.issue()
+	if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("issuing proof of work challenge")
+		apiInternalServerError(w)
+		return
+	}
+
+	writeJSON(
+		w,
+		http.StatusOK,
+		apiChallengeResponse{Challenge: c.token, Difficulty: c.difficulty, ExpiresAt: c.expiresAt.UTC()},
+	)
+}
+
+// handleAPICreateSecretView creates a 'view' of a secret, and is the API equivalent of the [handleCreateSecretView]
+// handler
+func (a *Application) handleAPICreateSecretView(w http.ResponseWriter, r *http.Request) {
+	accessID := r.PathValue("accessID")
+
+	l := zerolog.Ctx(r.Context()).
+		With().
+		Str("access_id", accessID).
+		Logger()
+
+	key, err := a.store.createSecretView(accessID)
+
+	if errors.Is(err, errSecretNotFound) {
+		a.recordFailedLookup(r)
+		apiErr(w, http.StatusNotFound, "not_found", "Secret does not exist or has been deleted.")
+		return
+	} else if errors.Is(err, errNoViewsRemaining) {
+		apiErr(
+			w,
+			http.StatusConflict,
+			"no_views_remaining",
+			"Every remaining view of this secret has been reserved by a viewing key that has not yet been used.",
+		)
+		return
+	} else if err != nil {
+		l.Err(err).Msg("creating secret view")
+		apiInternalServerError(w)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, apiCreateSecretViewResponse{ViewingKey: key})
+}
+
+// handleAPIAccessSecret uses a viewing key to retrieve the encrypted secret, and is the API equivalent of the
+// [handleAccessSecret] handler
+func (a *Application) handleAPIAccessSecret(w http.ResponseWriter, r *http.Request) {
+	accessID := r.PathValue("accessID")
+	viewingKey := r.PathValue("viewingKey")
+
+	l := zerolog.
+		Ctx(r.Context()).
+		With().
+		Str("access_id", accessID).
+		Str("viewing_key", viewingKey).
+		Logger()
+
+	secret, err := a.store.viewSecret(accessID, viewingKey, "")
+
+	if errors.Is(err, errVerificationRequired) {
+		apiErr(
+			w,
+			http.StatusForbidden,
+			"verification_required",
+			"This secret is protected by a key verifier. Verify its encryption key to retrieve it.",
+		)
+		return
+	} else if errors.Is(err, errSecretNotFound) {
+		a.recordFailedLookup(r)
+		apiErr(
+			w,
+			http.StatusNotFound,
+			"not_found",
+			"Secret does not exist, has been deleted, or the unique viewing key you attempted to use has been used before.",
+		)
+		return
+	} else if errors.Is(err, errViewingKeyExpired) {
+		apiErr(
+			w,
+			http.StatusGone,
+			"viewing_key_expired",
+			"The viewing key has expired as it was not used soon enough after it was created. Create a new one to view the secret.",
+		)
+		return
+	} else if err != nil {
+		l.Err(err).Msg("viewing secret")
+		apiInternalServerError(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIAccessSecretResponse(secret))
+}
+
+// handleAPISecretKeyChallenge retrieves what is needed to derive the verifier of the encryption key of a secret
+// protected by one, without using the viewing key
+func (a *Application) handleAPISecretKeyChallenge(w http.ResponseWriter, r *http.Request) {
+	accessID := r.PathValue("accessID")
+	viewingKey := r.PathValue("viewingKey")
+
+	l := zerolog.
+		Ctx(r.Context()).
+		With().
+		Str("access_id", accessID).
+		Str("viewing_key", viewingKey).
+		Logger()
+
+	challenge, err := a.store.secretKeyChallenge(accessID, viewingKey)
+
+	if errors.Is(err, errVerificationNotRequired) {
+		apiErr(w, http.StatusBadRequest, "verification_not_required", "This secret is not protected by a key verifier.")
+		return
+	} else if errors.Is(err, errSecretNotFound) {
+		a.recordFailedLookup(r)
+		apiErr(
+			w,
+			http.StatusNotFound,
+			"not_found",
+			"Secret does not exist, has been deleted, or the unique viewing key you attempted to use has been used before.",
+		)
+		return
+	} else if errors.Is(err, errViewingKeyExpired) {
+		apiErr(
+			w,
+			http.StatusGone,
+			"viewing_key_expired",
+			"The viewing key has expired as it was not used soon enough after it was created. Create a new one to view the secret.",
+		)
+		return
+	} else if err != nil {
+		l.Err(err).Msg("retrieving key challenge")
+		apiInternalServerError(w)
+		return
+	}
+
+	writeJSON(
+		w,
+		http.StatusOK,
+		apiKeyChallengeResponse{
+			Iterations:        challenge.iterations,
+			Salt:              challenge.salt,
+			AttemptsRemaining: challenge.attemptsRemaining,
+		},
+	)
+}
+
+// handleAPIVerifySecretView uses a viewing key to retrieve the encrypted secret of a secret protected by a key
+// verifier, providing the verifier in the request matches. Each verifier that does not match is counted, and the
+// secret is deleted once too many have been tried.
+func (a *Application) handleAPIVerifySecretView(w http.ResponseWriter, r *http.Request) {
+	accessID := r.PathValue("accessID")
+	viewingKey := r.PathValue("viewingKey")
+
+	l := zerolog.
+		Ctx(r.Context()).
+		With().
+		Str("access_id", accessID).
+		Str("viewing_key", viewingKey).
+		Logger()
+
+	var req apiVerifySecretViewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Verifier == "" {
+		apiErr(w, http.StatusBadRequest, "invalid_request", "Unable to parse request body. Please try again.")
+		return
+	}
+
+	secret, err := a.store.viewSecret(accessID, viewingKey, req.Verifier)
+
+	var ike incorrectKeyError
+	var ve validationError
+	if errors.As(err, &ike) {
+		a.recordFailedLookup(r)
+		l.Info().Int("attempts_remaining", ike.attemptsRemaining).Msg("incorrect key verifier")
+
+		code := "incorrect_key"
+		if ike.attemptsRemaining == 0 {
+			code = "maximum_key_attempts_hit"
+		}
+
+		apiErr(w, http.StatusForbidden, code, ike.Error())
+		return
+	} else if errors.As(err, &ve) {
+		apiErr(w, http.StatusBadRequest, "validation_failed", ve.Error())
+		return
+	} else if errors.Is(err, errSecretNotFound) {
+		a.recordFailedLookup(r)
+		apiErr(
+			w,
+			http.StatusNotFound,
+			"not_found",
+			"Secret does not exist, has been deleted, or the unique viewing key you attempted to use has been used before.",
+		)
+		return
+	} else if errors.Is(err, errViewingKeyExpired) {
+		apiErr(
+			w,
+			http.StatusGone,
+			"viewing_key_expired",
+			"The viewing key has expired as it was not used soon enough after it was created. Create a new one to view the secret.",
+		)
+		return
+	} else if err != nil {
+		l.Err(err).Msg("viewing secret")
+		apiInternalServerError(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIAccessSecretResponse(secret))
+}
+
+// handleAPIManageSecret retrieves the metadata of a secret, and is the API equivalent of the [handleManageSecret]
+// handler
+func (a *Application) handleAPIManageSecret(w http.ResponseWriter, r *http.Request) {
+	managementID := r.PathValue("managementID")
+
+	l := zerolog.
+		Ctx(r.Context()).
+		With().
+		Str("management_id", managementID).
+		Logger()
+
+	secret, err := a.store.secretByManagementID(managementID)
+
+	if errors.Is(err, errSecretNotFound) {
+		a.recordFailedLookup(r)
+		apiErr(w, http.StatusNotFound, "not_found", "Secret does not exist or has been deleted.")
+		return
+	} else if err != nil {
+		l.Err(err).Msg("retrieving secret")
+		apiInternalServerError(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.apiManageSecretResponse(secret))
+}
+
+// handleAPISecrets retrieves a page of the metadata of the secrets created with the API token the request is
+// authorised with, optionally filtered by their state (active, by default)
+func (a *Application) handleAPISecrets(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+
+	token, ok := a.apiTokenWithScope(w, r, APITokenScopeRead)
+	if !ok {
+		return
+	}
+
+	state, err := parseSecretState(r.URL.Query().Get("state"), secretStateActive)
+	if err != nil {
+		apiErr(w, http.StatusBadRequest, "validation_failed", err.Error())
+		return
+	}
+
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		if page, err = strconv.Atoi(p); err != nil || page < 1 {
+			apiErr(w, http.StatusBadRequest, "validation_failed", "The page must be a number greater than 0.")
+			return
+		}
+	}
+
+	secrets, more, err := a.store.secretsByCreator(secretCreator{apiTokenID: token.ID}, state, page)
+	if err != nil {
+		l.Err(err).Str("api_token_id", token.ID).Msg("retrieving secrets")
+		apiInternalServerError(w)
+		return
+	}
+
+	res := apiSecretsResponse{Secrets: []apiManageSecretResponse{}}
+	for _, s := range secrets {
+		res.Secrets = append(res.Secrets, a.apiCreatorSecretResponse(s))
+	}
+
+	if more {
+		res.NextPage = page + 1
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleAPIDeleteSecrets deletes several (or all active) secrets created with the API token the request is authorised
+// with at once, such as when responding to an incident
+func (a *Application) handleAPIDeleteSecrets(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+
+	token, ok := a.apiTokenWithScope(w, r, APITokenScopeManage)
+	if !ok {
+		return
+	}
+
+	var req apiDeleteSecretsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiErr(w, http.StatusBadRequest, "invalid_request", "Unable to parse request body. Please try again.")
+		return
+	}
+
+	if req.All == (len(req.AccessIDs) != 0) {
+		apiErr(w, http.StatusBadRequest, "validation_failed", "Either accessIds or all must be set.")
+		return
+	}
+
+	creator := secretCreator{apiTokenID: token.ID}
+
+	var deleted int64
+	var err error
+	if req.All {
+		deleted, err = a.store.deleteAllSecretsByCreator(creator)
+	} else {
+		deleted, err = a.store.deleteSecretsByCreator(creator, req.AccessIDs)
+	}
+
+	var ve validationError
+	if errors.As(err, &ve) {
+		apiErr(w, http.StatusBadRequest, "validation_failed", ve.Error())
+		return
+	} else if err != nil {
+		l.Err(err).Str("api_token_id", token.ID).Msg("deleting secrets")
+		apiInternalServerError(w)
+		return
+	}
+
+	l.Info().Str("api_token_id", token.ID).Int64("deleted_secrets", deleted).Msg("bulk deleted secrets")
+
+	writeJSON(w, http.StatusOK, apiDeleteSecretsResponse{Deleted: deleted})
+}
+
+// handleAPIDeleteSecretByAccessID deletes a secret created with the API token the request is authorised with
+func (a *Application) handleAPIDeleteSecretByAccessID(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+	accessID := r.PathValue("accessID")
+
+	token, ok := a.apiTokenWithScope(w, r, APITokenScopeManage)
+	if !ok {
+		return
+	}
+
+	deleted, err := a.store.deleteSecretsByCreator(secretCreator{apiTokenID: token.ID}, []string{accessID})
+
+	if err != nil {
+		l.Err(err).Str("access_id", accessID).Msg("deleting secret")
+		apiInternalServerError(w)
+		return
+	} else if deleted == 0 {
+		apiErr(w, http.StatusNotFound, "not_found", "Secret does not exist, has been deleted or was not created with this API token.")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newAPIAccessSecretResponse maps a viewed secret to its API representation
+func newAPIAccessSecretResponse(secret viewedSecret) apiAccessSecretResponse {
+	res := apiAccessSecretResponse{EncryptedSecret: secret.cipherText, FinalView: secret.finalView}
+
+	for _, at := range secret.attachments {
+		res.Attachments = append(
+			res.Attachments,
+			apiAttachment{Name: at.encryptedName, Content: base64.RawURLEncoding.EncodeToString(at.content)},
+		)
+	}
+
+	return res
+}
+
+// apiManageSecretResponse maps the information about a secret visible to its creator to its API representation
+func (a *Application) apiManageSecretResponse(secret managedSecret) apiManageSecretResponse {
+	res := apiManageSecretResponse{
+		AccessID:      secret.accessID,
+		ViewSecretURL: fmt.Sprintf("%s/secret/%s", a.baseURL, secret.accessID),
+		TTL:           secret.ttl,
+		MaxViews:      secret.maximumViews,
+		Views:         secret.views,
+		CreatedAt:     secret.createdAt.UTC(),
+		ExpiresAt:     secret.expiresAt.UTC(),
+		KeyInURL:      secret.keyInURL,
+		Attachments:   secret.attachments,
+	}
+
+	if secret.invite != nil {
+		res.Invite = &apiInvite{ID: secret.invite.id, Label: secret.invite.label}
+	}
+
+	if secret.keyAttempts != nil {
+		res.MaxKeyAttempts = secret.keyAttempts.maximum
+		res.FailedKeyAttempts = secret.keyAttempts.failed
+	}
+
+	return res
+}
+
+// apiCreatorSecretResponse maps the information about a secret listed to its creator to its API representation
+func (a *Application) apiCreatorSecretResponse(secret creatorSecret) apiManageSecretResponse {
+	res := apiManageSecretResponse{
+		AccessID:       secret.accessID,
+		ViewSecretURL:  fmt.Sprintf("%s/secret/%s", a.baseURL, secret.accessID),
+		TTL:            secret.ttl,
+		MaxViews:       secret.maximumViews,
+		Views:          secret.views,
+		CreatedAt:      secret.createdAt.UTC(),
+		ExpiresAt:      secret.expiresAt.UTC(),
+		Status:         string(secret.state()),
+		DeletionReason: secret.deletionReason,
+	}
+
+	if !secret.deletedAt.IsZero() {
+		deletedAt := secret.deletedAt.UTC()
+		res.DeletedAt = &deletedAt
+	}
+
+	return res
+}
+
+// apiTokenWithScope retrieves the API token the request is authorised with, writing an error response and returning
+// false if there isn't one or it has not been granted the scope
+func (a *Application) apiTokenWithScope(w http.ResponseWriter, r *http.Request, scope string) (*APIToken, bool) {
+	token, err := a.apiTokenFromRequest(r)
+	if errors.Is(err, errInvalidAPIToken) || (err == nil && token == nil) {
+		apiUnauthorized(w)
+		return nil, false
+	} else if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("retrieving api token")
+		apiInternalServerError(w)
+		return nil, false
+	}
+
+	if !token.HasScope(scope) {
+		apiErr(w, http.StatusForbidden, "forbidden", fmt.Sprintf("This API token has not been granted the %s scope.", scope))
+		return nil, false
+	}
+
+	return token, true
+}
+
+// handleAPIDeleteSecret deletes a secret, and is the API equivalent of the [handleDeleteSecret] handler
+func (a *Application) handleAPIDeleteSecret(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+	managementID := r.PathValue("managementID")
+
+	err := a.store.deleteSecret(managementID)
+
+	if errors.Is(err, errSecretNotFound) {
+		a.recordFailedLookup(r)
+		apiErr(w, http.StatusNotFound, "not_found", "Secret does not exist or has been deleted.")
+		return
+	} else if err != nil {
+		l.Err(err).Str("management_id", managementID).Msg("deleting secret")
+		apiInternalServerError(w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON sets the status code of the response and writes the value to the body as JSON
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
+
+// apiErr sets the status code of the response and writes a structured error to the body
+func apiErr(w http.ResponseWriter, statusCode int, code string, msg string) {
+	writeJSON(w, statusCode, apiErrorResponse{Error: apiError{Code: code, Message: msg}})
+}
+
+// apiUnauthorized sets the status code of the response to 401 and writes a structured error to the body
+func apiUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	apiErr(w, http.StatusUnauthorized, "unauthorized", "The API token is invalid, has expired or has been revoked.")
+}
+
+// apiInternalServerError sets the status code of the response to 500 and writes a structured error to the body
+func apiInternalServerError(w http.ResponseWriter) {
+	apiErr(w, http.StatusInternalServerError, "internal_error", "Something went wrong. Please try again.")
+}
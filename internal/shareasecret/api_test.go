@@ -0,0 +1,304 @@
+package shareasecret
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPISecretCreation(t *testing.T) {
+	t.Run("forbidden if not valid requesting ip", func(t *testing.T) {
+		r := apiRequest(
+			t,
+			"POST",
+			app.handleAPICreateSecret,
+			`{"encryptedSecret":"`+testEncryptedSecret+`","ttl":30,"maxViews":1}`,
+			func(r *http.Request) { r.Header.Del("X-Forwarded-For") },
+		)
+
+		if r.statusCode != 403 {
+			t.Errorf("wanted 403 status code, got %v", r.statusCode)
+		} else if e := apiErrorCode(t, r); e != "forbidden" {
+			t.Errorf("wanted forbidden error code, got %v", e)
+		}
+	})
+
+	t.Run("bad request for unparseable body", func(t *testing.T) {
+		r := apiRequest(t, "POST", app.handleAPICreateSecret, `{"encryptedSecret":`, emptyRequestConfigurer)
+
+		if r.statusCode != 400 {
+			t.Errorf("wanted 400 status code, got %v", r.statusCode)
+		} else if e := apiErrorCode(t, r); e != "invalid_request" {
+			t.Errorf("wanted invalid_request error code, got %v", e)
+		}
+	})
+
+	t.Run("bad request for invalid ciphertext", func(t *testing.T) {
+		r := apiRequest(
+			t,
+			"POST",
+			app.handleAPICreateSecret,
+			`{"encryptedSecret":"a","ttl":30,"maxViews":1}`,
+			emptyRequestConfigurer,
+		)
+
+		if r.statusCode != 400 {
+			t.Errorf("wanted 400 status code, got %v", r.statusCode)
+		} else if e := apiErrorCode(t, r); e != "validation_failed" {
+			t.Errorf("wanted validation_failed error code, got %v", e)
+		} else if !strings.Contains(r.body, "format is invalid") {
+			t.Errorf("wanted 'format is invalid' in body, got %v", r.body)
+		}
+	})
+
+	t.Run("creates the secret", func(t *testing.T) {
+		r := apiRequest(
+			t,
+			"POST",
+			app.handleAPICreateSecret,
+			`{"encryptedSecret":"`+testEncryptedSecret+`","ttl":30,"maxViews":1}`,
+			emptyRequestConfigurer,
+		)
+		if r.statusCode != 201 {
+			t.Fatalf("wanted 201 status code, got %v", r.statusCode)
+		}
+
+		var res apiCreateSecretResponse
+		if err := json.Unmarshal([]byte(r.body), &res); err != nil {
+			t.Fatalf("unmarshalling response: %v", err)
+		}
+
+		if h := r.headers.Get("Location"); h != "/api/v1/manage/"+res.ManagementID {
+			t.Errorf("expected Location header to point to management resource, got %v", h)
+		} else if res.ViewSecretURL != app.baseURL+"/secret/"+res.AccessID {
+			t.Errorf("unexpected view secret url %v", res.ViewSecretURL)
+		}
+
+		var rc int
+
+		err := app.db.db.QueryRow(
+			"SELECT COUNT(1) FROM secrets WHERE access_id = ? AND management_id = ?",
+			res.AccessID,
+			res.ManagementID,
+		).Scan(&rc)
+
+		if err != nil {
+			t.Errorf("querying for secret: %v", err)
+		} else if rc != 1 {
+			t.Errorf("expected 1 secret, got %v", rc)
+		}
+	})
+}
+
+func TestAPISecretAccess(t *testing.T) {
+	t.Run("not found if secret has been deleted", func(t *testing.T) {
+		accessID, _ := createSecret(t, time.Now(), deletionReasonUserDeleted)
+
+		r := apiRequest(t, "POST", app.handleAPICreateSecretView, "", func(r *http.Request) {
+			r.SetPathValue("accessID", accessID)
+		})
+
+		if r.statusCode != 404 {
+			t.Errorf("expected 404 status code, got %v", r.statusCode)
+		} else if e := apiErrorCode(t, r); e != "not_found" {
+			t.Errorf("wanted not_found error code, got %v", e)
+		}
+	})
+
+	t.Run("conflict if every remaining view has been reserved", func(t *testing.T) {
+		accessID, _ := createSecret(t, time.Time{}, "")
+
+		for _, expected := range []int{201, 409} {
+			r := apiRequest(t, "POST", app.handleAPICreateSecretView, "", func(r *http.Request) {
+				r.SetPathValue("accessID", accessID)
+			})
+
+			if r.statusCode != expected {
+				t.Errorf("expected %v status code, got %v", expected, r.statusCode)
+			} else if expected == 409 && apiErrorCode(t, r) != "no_views_remaining" {
+				t.Errorf("wanted no_views_remaining error code, got %v", r.body)
+			}
+		}
+	})
+
+	t.Run("returns cipher text and deletes secret when maximum views is reached", func(t *testing.T) {
+		accessID, _ := createSecret(t, time.Time{}, "")
+
+		r := apiRequest(t, "POST", app.handleAPICreateSecretView, "", func(r *http.Request) {
+			r.SetPathValue("accessID", accessID)
+		})
+		if r.statusCode != 201 {
+			t.Fatalf("expected 201 status code, got %v", r.statusCode)
+		}
+
+		var view apiCreateSecretViewResponse
+		if err := json.Unmarshal([]byte(r.body), &view); err != nil {
+			t.Fatalf("unmarshalling response: %v", err)
+		}
+
+		r = apiRequest(t, "GET", app.handleAPIAccessSecret, "", func(r *http.Request) {
+			r.SetPathValue("accessID", accessID)
+			r.SetPathValue("viewingKey", view.ViewingKey)
+		})
+		if r.statusCode != 200 {
+			t.Fatalf("expected 200 status code, got %v", r.statusCode)
+		}
+
+		var res apiAccessSecretResponse
+		if err := json.Unmarshal([]byte(r.body), &res); err != nil {
+			t.Fatalf("unmarshalling response: %v", err)
+		}
+
+		if res.EncryptedSecret != testEncryptedSecret {
+			t.Errorf("expected encrypted secret to be %v, got %v", testEncryptedSecret, res.EncryptedSecret)
+		} else if !res.FinalView {
+			t.Errorf("expected view to be the final view")
+		}
+
+		var deletionReason sql.NullString
+
+		err := app.db.db.QueryRow("SELECT deletion_reason FROM secrets WHERE access_id = ?", accessID).Scan(&deletionReason)
+		if err != nil {
+			t.Errorf("querying secret: %v", err)
+		} else if deletionReason.String != deletionReasonMaximumViewCountHit {
+			t.Errorf("expected deletion reason to be maximum_view_count_hit, got %v", deletionReason.String)
+		}
+	})
+
+	t.Run("gone if viewing key has expired", func(t *testing.T) {
+		accessID, _ := createSecret(t, time.Time{}, "")
+		viewingKey := createExpiredViewingKey(t, accessID)
+
+		r := apiRequest(t, "GET", app.handleAPIAccessSecret, "", func(r *http.Request) {
+			r.SetPathValue("accessID", accessID)
+			r.SetPathValue("viewingKey", viewingKey)
+		})
+
+		if r.statusCode != 410 {
+			t.Errorf("expected 410 status code, got %v", r.statusCode)
+		} else if apiErrorCode(t, r) != "viewing_key_expired" {
+			t.Errorf("wanted viewing_key_expired error code, got %v", r.body)
+		}
+	})
+
+	t.Run("not found if viewing key has been used already", func(t *testing.T) {
+		accessID, _ := createSecret(t, time.Time{}, "")
+		viewingKey, _ := secureID(8)
+
+		r := apiRequest(t, "GET", app.handleAPIAccessSecret, "", func(r *http.Request) {
+			r.SetPathValue("accessID", accessID)
+			r.SetPathValue("viewingKey", viewingKey)
+		})
+
+		if r.statusCode != 404 {
+			t.Errorf("expected 404 status code, got %v", r.statusCode)
+		}
+	})
+}
+
+func TestAPISecretManagement(t *testing.T) {
+	t.Run("returns the secret's metadata", func(t *testing.T) {
+		accessID, managementID := createSecret(t, time.Time{}, "")
+
+		r := apiRequest(t, "GET", app.handleAPIManageSecret, "", func(r *http.Request) {
+			r.SetPathValue("managementID", managementID)
+		})
+		if r.statusCode != 200 {
+			t.Fatalf("expected 200 status code, got %v", r.statusCode)
+		}
+
+		var res apiManageSecretResponse
+		if err := json.Unmarshal([]byte(r.body), &res); err != nil {
+			t.Fatalf("unmarshalling response: %v", err)
+		}
+
+		if res.AccessID != accessID {
+			t.Errorf("expected access id %v, got %v", accessID, res.AccessID)
+		} else if res.MaxViews != 1 || res.TTL != 30 || res.Views != 0 {
+			t.Errorf("unexpected metadata %+v", res)
+		} else if res.ExpiresAt.Sub(res.CreatedAt) != 30*time.Minute {
+			t.Errorf("expected secret to expire 30 minutes after creation, got %v", res.ExpiresAt.Sub(res.CreatedAt))
+		}
+	})
+
+	t.Run("deletes a secret", func(t *testing.T) {
+		accessID, managementID := createSecret(t, time.Time{}, "")
+
+		r := apiRequest(t, "DELETE", app.handleAPIDeleteSecret, "", func(r *http.Request) {
+			r.SetPathValue("managementID", managementID)
+		})
+		if r.statusCode != 204 {
+			t.Errorf("expected 204 status code, got %v", r.statusCode)
+		}
+
+		var deletionReason sql.NullString
+
+		err := app.db.db.QueryRow("SELECT deletion_reason FROM secrets WHERE access_id = ?", accessID).Scan(&deletionReason)
+		if err != nil {
+			t.Errorf("querying secret: %v", err)
+		} else if deletionReason.String != deletionReasonUserDeleted {
+			t.Errorf("expected deletion reason to be user_deleted, got %v", deletionReason.String)
+		}
+
+		r = apiRequest(t, "DELETE", app.handleAPIDeleteSecret, "", func(r *http.Request) {
+			r.SetPathValue("managementID", managementID)
+		})
+		if r.statusCode != 404 {
+			t.Errorf("expected 404 status code when deleting twice, got %v", r.statusCode)
+		}
+	})
+}
+
+func TestAPIOpenAPIDocument(t *testing.T) {
+	r := apiRequest(t, "GET", app.handleAPIOpenAPIDocument, "", emptyRequestConfigurer)
+
+	var doc map[string]any
+	if r.statusCode != 200 {
+		t.Errorf("expected 200 status code, got %v", r.statusCode)
+	} else if err := json.Unmarshal([]byte(r.body), &doc); err != nil {
+		t.Errorf("expected document to be valid JSON: %v", err)
+	}
+}
+
+// apiRequest calls the API handler with a JSON body, returning a simplified, already-read version of the response
+func apiRequest(t *testing.T, method string, endpoint http.HandlerFunc, body string, rc func(r *http.Request)) consumedResponse {
+	recorder := httptest.NewRecorder()
+
+	r, err := http.NewRequest(method, "anything", strings.NewReader(body))
+	if err != nil {
+		t.Error()
+	}
+	r.RemoteAddr = testProxyAddr
+	r.Header.Add("X-Forwarded-For", "127.0.0.1")
+	r.Header.Add("Content-Type", "application/json")
+	rc(r)
+
+	endpoint.ServeHTTP(recorder, r)
+
+	b, err := io.ReadAll(recorder.Body)
+	if err != nil {
+		t.Errorf("reading body from endpoint (%v): %v", endpoint, err)
+	}
+
+	return consumedResponse{
+		statusCode: recorder.Code,
+		body:       string(b),
+		headers:    recorder.Header(),
+		cookies:    recorder.Result().Cookies(),
+	}
+}
+
+// apiErrorCode extracts the error code from an API error response
+func apiErrorCode(t *testing.T, r consumedResponse) string {
+	var res apiErrorResponse
+	if err := json.Unmarshal([]byte(r.body), &res); err != nil {
+		t.Errorf("unmarshalling error response: %v", err)
+	}
+
+	return res.Error.Code
+}
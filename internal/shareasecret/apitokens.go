@@ -0,0 +1,304 @@
+package shareasecret
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// API token scopes, which determine what a token can be used for
+const (
+	// APITokenScopeCreate permits the creation of secrets, regardless of any IP address or sign in restrictions
+	APITokenScopeCreate = "create"
+
+	// APITokenScopeManage permits the deletion of secrets created with the token
+	APITokenScopeManage = "manage"
+
+	// APITokenScopeRead permits the retrieval of the metadata of secrets created with the token
+	APITokenScopeRead = "read"
+)
+
+// apiTokenPrefix prefixes every API token, making them easy to identify (and to scan for) if they are leaked
+const apiTokenPrefix = "sas_"
+
+// apiTokenLastUsedInterval is how often the time an API token was last used is updated, so that not every request made
+// with it writes to the database
+const apiTokenLastUsedInterval = time.Minute
+
+const (
+	errInvalidAPITokenName   = validationError("API token names must be between 1 and 200 characters.")
+	errInvalidAPITokenScopes = validationError("API tokens must have at least one scope out of create, manage and read.")
+	errInvalidAPITokenTTL    = validationError("API tokens cannot expire in the past.")
+)
+
+var (
+	// errInvalidAPIToken is returned when a bearer token does not exist, has expired or has been revoked
+	errInvalidAPIToken = errors.New("invalid api token")
+
+	// ErrAPITokenNotFound is returned when revoking an API token that does not exist or has already been revoked
+	ErrAPITokenNotFound = errors.New("api token not found")
+)
+
+// APIToken is a named bearer token that machines use to authenticate with the API. Only a hash of the token itself is
+// stored.
+type APIToken struct {
+	ID     string
+	Name   string
+	Scopes []string
+
+	// ExpiresAt is zero if the token never expires
+	ExpiresAt  time.Time
+	RevokedAt  time.Time
+	LastUsedAt time.Time
+	CreatedAt  time.Time
+}
+
+// HasScope identifies whether the token has been granted the scope
+func (t APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+// CreateAPIToken creates an API token with the given name and scopes that expires after the TTL (or never, if the TTL
+// is zero). The token itself is returned alongside its details and cannot be retrieved again.
+func (a *Application) CreateAPIToken(name string, scopes []string, ttl time.Duration) (APIToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 200 {
+		return APIToken{}, "", errInvalidAPITokenName
+	}
+
+	if len(scopes) == 0 {
+		return APIToken{}, "", errInvalidAPITokenScopes
+	}
+
+	for _, s := range scopes {
+		if s != APITokenScopeCreate && s != APITokenScopeManage && s != APITokenScopeRead {
+			return APIToken{}, "", errInvalidAPITokenScopes
+		}
+	}
+
+	if ttl < 0 {
+		return APIToken{}, "", errInvalidAPITokenTTL
+	}
+
+	id, err := secureID(8)
+	if err != nil {
+		return APIToken{}, "", fmt.Errorf("generating token id: %w", err)
+	}
+
+	secret, err := secureID(32)
+	if err != nil {
+		return APIToken{}, "", fmt.Errorf("generating token: %w", err)
+	}
+
+	scopes = slices.Clone(scopes)
+	slices.Sort(scopes)
+
+	now := time.Now()
+	t := APIToken{ID: id, Name: name, Scopes: slices.Compact(scopes), CreatedAt: now}
+
+	var expiresAt sql.NullInt64
+	if ttl > 0 {
+		t.ExpiresAt = now.Add(ttl)
+		expiresAt = sql.NullInt64{Valid: true, Int64: t.ExpiresAt.UnixMilli()}
+	}
+
+	token := apiTokenPrefix + secret
+
+	_, err = a.db.db.Exec(
+		`
+			INSERT INTO
+				api_tokens (id, name, token_hash, scopes, expires_at, created_at)
+			VALUES
+				(?, ?, ?, ?, ?, ?)
+		`,
+		t.ID,
+		t.Name,
+		hashToken(token),
+		strings.Join(t.Scopes, " "),
+		expiresAt,
+		now.UnixMilli(),
+	)
+	if err != nil {
+		return APIToken{}, "", fmt.Errorf("inserting api token: %w", err)
+	}
+
+	return t, token, nil
+}
+
+// RevokeAPIToken revokes an API token so that it can no longer be used, returning [ErrAPITokenNotFound] if there was
+// nothing to revoke. Secrets created with the token are left as they are.
+func (a *Application) RevokeAPIToken(id string) error {
+	rs, err := a.db.db.Exec(
+		"UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL",
+		time.Now().UnixMilli(),
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("revoking api token: %w", err)
+	}
+
+	if rc, err := rs.RowsAffected(); err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	} else if rc == 0 {
+		return ErrAPITokenNotFound
+	}
+
+	return nil
+}
+
+// APITokens retrieves every API token, including those that have expired or been revoked, ordered by when they were
+// created
+func (a *Application) APITokens() ([]APIToken, error) {
+	rows, err := a.db.reader.Query(
+		`
+			SELECT
+				id,
+				name,
+				scopes,
+				expires_at,
+				revoked_at,
+				last_used_at,
+				created_at
+			FROM
+				api_tokens
+			ORDER BY
+				created_at
+		`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying api tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+// apiTokenByToken retrieves the usable API token matching the bearer token, recording that it has been used if it
+// wasn't already within the last [apiTokenLastUsedInterval]. If no such token exists, has expired or has been revoked
+// [errInvalidAPIToken] is returned.
+func (d *database) apiTokenByToken(token string) (APIToken, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return APIToken{}, errInvalidAPIToken
+	}
+
+	now := time.Now()
+
+	t, err := scanAPIToken(d.reader.QueryRow(
+		`
+			SELECT
+				id,
+				name,
+				scopes,
+				expires_at,
+				revoked_at,
+				last_used_at,
+				created_at
+			FROM
+				api_tokens
+			WHERE
+				token_hash = ? AND
+				revoked_at IS NULL AND
+				(expires_at IS NULL OR expires_at > ?)
+		`,
+		hashToken(token),
+		now.UnixMilli(),
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIToken{}, errInvalidAPIToken
+	} else if err != nil {
+		return APIToken{}, err
+	}
+
+	if t.LastUsedAt.After(now.Add(-apiTokenLastUsedInterval)) {
+		return t, nil
+	}
+
+	// concurrent requests made with the token only update it once
+	_, err = d.db.Exec(
+		"UPDATE api_tokens SET last_used_at = ?1 WHERE id = ?2 AND (last_used_at IS NULL OR last_used_at <= ?3)",
+		now.UnixMilli(),
+		t.ID,
+		now.Add(-apiTokenLastUsedInterval).UnixMilli(),
+	)
+	if err != nil {
+		return APIToken{}, fmt.Errorf("recording api token use: %w", err)
+	}
+
+	t.LastUsedAt = time.UnixMilli(now.UnixMilli())
+
+	return t, nil
+}
+
+// scanAPIToken scans a row containing all of an API token's columns other than its hash
+func scanAPIToken(row interface{ Scan(...any) error }) (APIToken, error) {
+	var t APIToken
+	var scopes string
+	var expiresAt, revokedAt, lastUsedAt sql.NullInt64
+	var createdAt int64
+
+	if err := row.Scan(&t.ID, &t.Name, &scopes, &expiresAt, &revokedAt, &lastUsedAt, &createdAt); err != nil {
+		return APIToken{}, err
+	}
+
+	t.Scopes = strings.Fields(scopes)
+	t.CreatedAt = time.UnixMilli(createdAt)
+
+	if expiresAt.Valid {
+		t.ExpiresAt = time.UnixMilli(expiresAt.Int64)
+	}
+
+	if revokedAt.Valid {
+		t.RevokedAt = time.UnixMilli(revokedAt.Int64)
+	}
+
+	if lastUsedAt.Valid {
+		t.LastUsedAt = time.UnixMilli(lastUsedAt.Int64)
+	}
+
+	return t, nil
+}
+
+// hashToken hashes a token (such as an API token or enrolment link) for storage. Tokens are long and random, so a
+// fast, unsalted hash is sufficient.
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(h[:])
+}
+
+// apiTokenFromRequest retrieves the API token the request was authorised with via the Authorization header, returning
+// nil if the header is not set and [errInvalidAPIToken] if the token is not usable
+func (a *Application) apiTokenFromRequest(r *http.Request) (*APIToken, error) {
+	h := r.Header.Get("Authorization")
+	if h == "" {
+		return nil, nil
+	}
+
+	scheme, token, ok := strings.Cut(h, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, errInvalidAPIToken
+	}
+
+	t, err := a.db.apiTokenByToken(strings.TrimSpace(token))
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
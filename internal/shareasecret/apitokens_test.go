@@ -0,0 +1,238 @@
+package shareasecret
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPITokenManagement(t *testing.T) {
+	t.Run("rejects invalid tokens", func(t *testing.T) {
+		cases := map[string]struct {
+			name   string
+			scopes []string
+			ttl    time.Duration
+		}{
+			"no name":       {name: " ", scopes: []string{APITokenScopeCreate}},
+			"no scopes":     {name: "ci"},
+			"unknown scope": {name: "ci", scopes: []string{"admin"}},
+			"negative ttl":  {name: "ci", scopes: []string{APITokenScopeCreate}, ttl: -1 * time.Hour},
+		}
+
+		for n, c := range cases {
+			var ve validationError
+			if _, _, err := app.CreateAPIToken(c.name, c.scopes, c.ttl); !errors.As(err, &ve) {
+				t.Errorf("%v: expected validation error, got %v", n, err)
+			}
+		}
+	})
+
+	t.Run("creates, lists and revokes tokens", func(t *testing.T) {
+		created, token, err := app.CreateAPIToken("ci", []string{APITokenScopeRead, APITokenScopeCreate}, time.Hour)
+		if err != nil {
+			t.Fatalf("creating token: %v", err)
+		}
+
+		if !strings.HasPrefix(token, apiTokenPrefix) {
+			t.Errorf("expected token to be prefixed, got %v", token)
+		}
+
+		tokens, err := app.APITokens()
+		if err != nil {
+			t.Fatalf("listing tokens: %v", err)
+		}
+
+		found := false
+		for _, lt := range tokens {
+			if lt.ID == created.ID {
+				found = lt.Name == "ci" && strings.Join(lt.Scopes, ",") == "create,read" && lt.RevokedAt.IsZero()
+			}
+		}
+		if !found {
+			t.Errorf("expected created token to be listed")
+		}
+
+		if err := app.RevokeAPIToken(created.ID); err != nil {
+			t.Errorf("revoking token: %v", err)
+		}
+
+		if err := app.RevokeAPIToken(created.ID); !errors.Is(err, ErrAPITokenNotFound) {
+			t.Errorf("expected ErrAPITokenNotFound revoking twice, got %v", err)
+		}
+	})
+
+	t.Run("only the hash of the token is stored", func(t *testing.T) {
+		created, token := createAPIToken(t, APITokenScopeCreate)
+
+		var hash string
+		if err := app.db.db.QueryRow("SELECT token_hash FROM api_tokens WHERE id = ?", created.ID).Scan(&hash); err != nil {
+			t.Fatalf("retrieving hash: %v", err)
+		}
+
+		if hash == token || hash != hashToken(token) {
+			t.Errorf("expected hashed token to be stored, got %v", hash)
+		}
+	})
+
+	t.Run("records when tokens were last used at most once a minute", func(t *testing.T) {
+		created, token := createAPIToken(t, APITokenScopeCreate)
+
+		if used, err := app.db.apiTokenByToken(token); err != nil || used.LastUsedAt.IsZero() {
+			t.Fatalf("expected first use to be recorded, got %v %v", used.LastUsedAt, err)
+		}
+
+		for _, ago := range []time.Duration{30 * time.Second, 2 * time.Minute} {
+			lastUsedAt := time.Now().Add(-ago).UnixMilli()
+			app.db.db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", lastUsedAt, created.ID)
+
+			used, err := app.db.apiTokenByToken(token)
+			if err != nil {
+				t.Fatalf("retrieving token: %v", err)
+			}
+
+			var stored int64
+			app.db.db.QueryRow("SELECT last_used_at FROM api_tokens WHERE id = ?", created.ID).Scan(&stored)
+
+			if updated := stored != lastUsedAt; updated != (ago >= apiTokenLastUsedInterval) || stored != used.LastUsedAt.UnixMilli() {
+				t.Errorf("%v: unexpected last use %v (was %v, returned %v)", ago, stored, lastUsedAt, used.LastUsedAt.UnixMilli())
+			}
+		}
+	})
+}
+
+func TestAPISecretCreationWithToken(t *testing.T) {
+	body := `{"encryptedSecret":"` + testEncryptedSecret + `","ttl":30,"maxViews":1}`
+
+	t.Run("creates a secret from an outside ip and records the token", func(t *testing.T) {
+		created, token := createAPIToken(t, APITokenScopeCreate)
+
+		r := apiRequest(t, "POST", app.handleAPICreateSecret, body, withBearer(token))
+		if r.statusCode != http.StatusCreated {
+			t.Fatalf("expected 201 status code, got %v", r.statusCode)
+		}
+
+		var res apiCreateSecretResponse
+		if err := json.Unmarshal([]byte(r.body), &res); err != nil {
+			t.Fatalf("unmarshalling response: %v", err)
+		}
+
+		var tokenID string
+		if err := app.db.db.QueryRow("SELECT api_token_id FROM secrets WHERE access_id = ?", res.AccessID).Scan(&tokenID); err != nil {
+			t.Fatalf("retrieving token id: %v", err)
+		} else if tokenID != created.ID {
+			t.Errorf("expected token id %v to be recorded, got %v", created.ID, tokenID)
+		}
+	})
+
+	t.Run("forbidden without the create scope", func(t *testing.T) {
+		_, token := createAPIToken(t, APITokenScopeRead)
+
+		if r := apiRequest(t, "POST", app.handleAPICreateSecret, body, withBearer(token)); r.statusCode != http.StatusForbidden {
+			t.Errorf("expected 403 status code, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("unauthorized with unusable tokens", func(t *testing.T) {
+		revoked, revokedToken := createAPIToken(t, APITokenScopeCreate)
+		app.RevokeAPIToken(revoked.ID)
+
+		expired, expiredToken := createAPIToken(t, APITokenScopeCreate)
+		app.db.db.Exec("UPDATE api_tokens SET expires_at = ? WHERE id = ?", time.Now().Add(-1*time.Minute).UnixMilli(), expired.ID)
+
+		for n, token := range map[string]string{"unknown": "sas_abc", "revoked": revokedToken, "expired": expiredToken} {
+			r := apiRequest(t, "POST", app.handleAPICreateSecret, body, withBearer(token))
+
+			if r.statusCode != http.StatusUnauthorized {
+				t.Errorf("%v: expected 401 status code, got %v", n, r.statusCode)
+			} else if e := apiErrorCode(t, r); e != "unauthorized" {
+				t.Errorf("%v: expected unauthorized error code, got %v", n, e)
+			}
+		}
+	})
+}
+
+func TestAPISecretManagementWithToken(t *testing.T) {
+	_, token := createAPIToken(t, APITokenScopeCreate, APITokenScopeRead, APITokenScopeManage)
+	_, otherToken := createAPIToken(t, APITokenScopeCreate, APITokenScopeRead, APITokenScopeManage)
+
+	r := apiRequest(t, "POST", app.handleAPICreateSecret, `{"encryptedSecret":"`+testEncryptedSecret+`","ttl":30,"maxViews":1}`, withBearer(token))
+
+	var created apiCreateSecretResponse
+	if err := json.Unmarshal([]byte(r.body), &created); err != nil {
+		t.Fatalf("unmarshalling response: %v", err)
+	}
+
+	t.Run("lists secrets created with the token", func(t *testing.T) {
+		r := apiRequest(t, "GET", app.handleAPISecrets, "", withBearer(token))
+
+		var res apiSecretsResponse
+		if err := json.Unmarshal([]byte(r.body), &res); err != nil {
+			t.Fatalf("unmarshalling response: %v", err)
+		}
+
+		if len(res.Secrets) != 1 || res.Secrets[0].AccessID != created.AccessID {
+			t.Errorf("expected the created secret to be listed, got %+v", res.Secrets)
+		}
+
+		r = apiRequest(t, "GET", app.handleAPISecrets, "", withBearer(otherToken))
+		if err := json.Unmarshal([]byte(r.body), &res); err != nil {
+			t.Fatalf("unmarshalling response: %v", err)
+		} else if len(res.Secrets) != 0 {
+			t.Errorf("expected no secrets for another token, got %+v", res.Secrets)
+		}
+	})
+
+	t.Run("listing requires a token with the read scope", func(t *testing.T) {
+		if r := apiRequest(t, "GET", app.handleAPISecrets, "", emptyRequestConfigurer); r.statusCode != http.StatusUnauthorized {
+			t.Errorf("expected 401 status code, got %v", r.statusCode)
+		}
+
+		_, createOnly := createAPIToken(t, APITokenScopeCreate)
+		if r := apiRequest(t, "GET", app.handleAPISecrets, "", withBearer(createOnly)); r.statusCode != http.StatusForbidden {
+			t.Errorf("expected 403 status code, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("secrets can only be deleted by the token that created them", func(t *testing.T) {
+		withAccessID := func(token string) func(r *http.Request) {
+			return func(r *http.Request) {
+				withBearer(token)(r)
+				r.SetPathValue("accessID", created.AccessID)
+			}
+		}
+
+		if r := apiRequest(t, "DELETE", app.handleAPIDeleteSecretByAccessID, "", withAccessID(otherToken)); r.statusCode != http.StatusNotFound {
+			t.Errorf("expected 404 status code, got %v", r.statusCode)
+		}
+
+		if r := apiRequest(t, "DELETE", app.handleAPIDeleteSecretByAccessID, "", withAccessID(token)); r.statusCode != http.StatusNoContent {
+			t.Errorf("expected 204 status code, got %v", r.statusCode)
+		}
+
+		if err := app.db.secretExists(created.AccessID); !errors.Is(err, errSecretNotFound) {
+			t.Errorf("expected secret to be deleted, got %v", err)
+		}
+	})
+}
+
+// createAPIToken creates an API token with the given scopes that expires in an hour
+func createAPIToken(t *testing.T, scopes ...string) (APIToken, string) {
+	created, token, err := app.CreateAPIToken("test", scopes, time.Hour)
+	if err != nil {
+		t.Fatalf("creating api token: %v", err)
+	}
+
+	return created, token
+}
+
+// withBearer configures the request to come from an IP address that is not permitted to create secrets, authorised
+// with the API token
+func withBearer(token string) func(r *http.Request) {
+	return func(r *http.Request) {
+		outsideRequester(r)
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
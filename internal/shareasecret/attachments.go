@@ -0,0 +1,298 @@
+package shareasecret
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lsymds/shareasecret/pkg/secretcrypto"
+)
+
+const (
+	errInvalidAttachment   = validationError("Attachment format is invalid. Please try again.")
+	errAttachmentsTooLarge = validationError("Attachments are too large. Please remove some and try again.")
+	errRequestTooLarge     = validationError("Secret and its attachments are too large. Please shorten them and try again.")
+)
+
+// maxAttachmentNameSize is the largest encrypted attachment name (an envelope) that is accepted, which comfortably
+// fits any file name
+const maxAttachmentNameSize = 1024
+
+// attachment is a file attached to a secret, encrypted with the secret's key by its creator
+type attachment struct {
+	// encryptedName is the name of the file in the same envelope format as a secret
+	encryptedName string
+
+	// content is the iv the file's content was encrypted with followed by the ciphertext
+	content []byte
+}
+
+// encodedContent returns the content of the attachment unpadded base64url encoded, as it is sent to viewers
+func (a attachment) encodedContent() string {
+	return base64.RawURLEncoding.EncodeToString(a.content)
+}
+
+// attachmentStore stores the encrypted contents of attachments by the identifier of the attachment they belong to.
+// Their names are always stored in the database alongside the secret.
+type attachmentStore interface {
+	put(id string, content []byte) error
+	get(id string) ([]byte, error)
+	delete(id string) error
+}
+
+// databaseAttachmentStore stores the contents of attachments as BLOBs in the database
+type databaseAttachmentStore struct {
+	db *conn
+
+	// reader is used to retrieve contents, which may be whilst a transaction is using the writer
+	reader *conn
+}
+
+func (s *databaseAttachmentStore) put(id string, content []byte) error {
+	_, err := s.db.Exec("INSERT INTO attachment_contents (attachment_id, content) VALUES (?, ?)", id, content)
+	return err
+}
+
+func (s *databaseAttachmentStore) get(id string) ([]byte, error) {
+	var content []byte
+	err := s.reader.QueryRow("SELECT content FROM attachment_contents WHERE attachment_id = ?", id).Scan(&content)
+
+	return content, err
+}
+
+func (s *databaseAttachmentStore) delete(id string) error {
+	_, err := s.db.Exec("DELETE FROM attachment_contents WHERE attachment_id = ?", id)
+	return err
+}
+
+// fileAttachmentStore stores the contents of attachments as files (named after their attachment) in a directory on the
+// local filesystem
+type fileAttachmentStore struct {
+	dir string
+}
+
+// newFileAttachmentStore creates a [fileAttachmentStore], creating its directory if it does not exist
+func newFileAttachmentStore(dir string) (*fileAttachmentStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating attachment directory: %w", err)
+	}
+
+	return &fileAttachmentStore{dir: dir}, nil
+}
+
+func (s *fileAttachmentStore) put(id string, content []byte) error {
+	return os.WriteFile(filepath.Join(s.dir, id), content, 0o600)
+}
+
+func (s *fileAttachmentStore) get(id string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.dir, id))
+}
+
+func (s *fileAttachmentStore) delete(id string) error {
+	if err := os.Remove(filepath.Join(s.dir, id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// decodeAttachments pairs the encrypted names of attachments with their unpadded base64url encoded contents, returning
+// [errInvalidAttachment] if they cannot be
+func decodeAttachments(names []string, contents []string) ([]attachment, error) {
+	if len(names) != len(contents) {
+		return nil, errInvalidAttachment
+	}
+
+	attachments := make([]attachment, 0, len(names))
+	for i := range names {
+		content, err := base64.RawURLEncoding.Strict().DecodeString(contents[i])
+		if err != nil {
+			return nil, errInvalidAttachment
+		}
+
+		attachments = append(attachments, attachment{encryptedName: names[i], content: content})
+	}
+
+	return attachments, nil
+}
+
+// validateAttachments ensures every attachment was encrypted with the key of the secret whose envelope is given,
+// returning [errInvalidAttachment] if not
+func validateAttachments(secret secretcrypto.Envelope, attachments []attachment) error {
+	for _, a := range attachments {
+		if len(a.encryptedName) > maxAttachmentNameSize {
+			return errInvalidAttachment
+		}
+
+		if _, err := secretcrypto.ParseAttachment(secret, a.encryptedName, a.content); err != nil {
+			return errInvalidAttachment
+		}
+	}
+
+	return nil
+}
+
+// attachmentsSize returns the total size of the encrypted contents of the attachments
+func attachmentsSize(attachments []attachment) int {
+	size := 0
+	for _, a := range attachments {
+		size += len(a.content)
+	}
+
+	return size
+}
+
+// storeAttachments stores the contents of the attachments, returning the identifiers generated for them. If any cannot
+// be stored, those that were are deleted.
+func (d *database) storeAttachments(attachments []attachment) ([]string, error) {
+	ids := make([]string, 0, len(attachments))
+
+	for _, a := range attachments {
+		id, err := secureID(16)
+		if err == nil {
+			err = d.attachments.put(id, a.content)
+		}
+
+		if err != nil {
+			d.deleteAttachmentContents(ids)
+			return nil, fmt.Errorf("storing attachment: %w", err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// deleteAttachmentContents deletes the stored contents of the attachments, returning the first error encountered but
+// attempting to delete every one regardless
+func (d *database) deleteAttachmentContents(ids []string) error {
+	var firstErr error
+	for _, id := range ids {
+		if err := d.attachments.delete(id); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// insertAttachments records the attachments of a secret (whose contents have been stored under the given identifiers)
+// within the transaction
+func insertAttachments(tx *transaction, secretID int64, ids []string, attachments []attachment, now time.Time) error {
+	for i, a := range attachments {
+		if _, err := tx.Exec(
+			`
+				INSERT INTO
+					secret_attachments (id, secret_id, position, encrypted_name, size, created_at)
+				VALUES
+					(?, ?, ?, ?, ?, ?)
+			`,
+			ids[i],
+			secretID,
+			i,
+			a.encryptedName,
+			len(a.content),
+			now.UnixMilli(),
+		); err != nil {
+			return fmt.Errorf("inserting attachment: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// secretAttachments retrieves the attachments of a secret (including their contents) within the transaction, in the
+// order they were attached
+func (d *database) secretAttachments(tx *transaction, secretID int64) ([]attachment, error) {
+	rows, err := tx.Query(
+		"SELECT id, encrypted_name FROM secret_attachments WHERE secret_id = ? ORDER BY position",
+		secretID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying attachments: %w", err)
+	}
+
+	defer rows.Close()
+
+	ids := []string{}
+	attachments := []attachment{}
+	for rows.Next() {
+		var id string
+		var a attachment
+		if err := rows.Scan(&id, &a.encryptedName); err != nil {
+			return nil, fmt.Errorf("scanning attachment: %w", err)
+		}
+
+		ids = append(ids, id)
+		attachments = append(attachments, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading attachments: %w", err)
+	}
+
+	for i, id := range ids {
+		if attachments[i].content, err = d.attachments.get(id); err != nil {
+			return nil, fmt.Errorf("retrieving attachment: %w", err)
+		}
+	}
+
+	return attachments, nil
+}
+
+// destroyDeletedAttachments destroys the contents and records of every attachment belonging to a secret that has been
+// deleted, returning the number destroyed. Every path that deletes a secret calls it once the deletion is committed,
+// and the expired secrets job calls it to catch any that were missed.
+func (d *database) destroyDeletedAttachments() (int, error) {
+	rows, err := d.reader.Query(
+		`
+			SELECT
+				a.id
+			FROM
+				secret_attachments a
+				INNER JOIN secrets s ON s.id = a.secret_id
+			WHERE
+				s.deleted_at IS NOT NULL
+		`,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("querying deleted attachments: %w", err)
+	}
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scanning deleted attachment: %w", err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("reading deleted attachments: %w", err)
+	}
+
+	// the record of an attachment is only removed once its contents have been, so that contents that fail to be
+	// destroyed are tried again the next time
+	destroyed := 0
+	for _, id := range ids {
+		if err := d.attachments.delete(id); err != nil {
+			return destroyed, fmt.Errorf("destroying attachment contents: %w", err)
+		}
+
+		if _, err := d.db.Exec("DELETE FROM secret_attachments WHERE id = ?", id); err != nil {
+			return destroyed, fmt.Errorf("deleting attachment: %w", err)
+		}
+
+		destroyed++
+	}
+
+	return destroyed, nil
+}
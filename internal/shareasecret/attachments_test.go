@@ -0,0 +1,236 @@
+package shareasecret
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lsymds/shareasecret/pkg/secretcrypto"
+)
+
+func TestAttachments(t *testing.T) {
+	k, err := secretcrypto.DeriveKey(testEncryptedSecret, "empty plaintext")
+	if err != nil {
+		t.Fatalf("deriving key: %v", err)
+	}
+
+	name, content, _ := k.EncryptAttachment("kubeconfig", []byte("apiVersion: v1"))
+	attachmentJSON := `{"name":"` + name + `","content":"` + base64.RawURLEncoding.EncodeToString(content) + `"}`
+
+	policy, _ := newCreationPolicy(&Configuration{})
+	policy.maxSecretSize = 1024
+	policy.maxAttachments = 2
+	policy.maxAttachmentSize = 1024
+	defer useTestPolicy(policy)()
+
+	t.Run("rejects attachments outside of the policy or not encrypted with the secret's key", func(t *testing.T) {
+		bigName, bigContent, _ := k.EncryptAttachment("big", make([]byte, 1024))
+		bigJSON := `{"name":"` + bigName + `","content":"` + base64.RawURLEncoding.EncodeToString(bigContent) + `"}`
+
+		cases := map[string]string{
+			"too many":          attachmentsRequest(testEncryptedSecret, attachmentJSON, attachmentJSON, attachmentJSON),
+			"too large":         attachmentsRequest(testEncryptedSecret, bigJSON),
+			"different key":     attachmentsRequest(testKeyInURLEncryptedSecret, attachmentJSON),
+			"malformed content": attachmentsRequest(testEncryptedSecret, `{"name":"`+name+`","content":"not base64!"}`),
+			"truncated content": attachmentsRequest(testEncryptedSecret, `{"name":"`+name+`","content":"AAECAwQF"}`),
+		}
+
+		for n, body := range cases {
+			r := apiRequest(t, "POST", app.handleAPICreateSecret, body, emptyRequestConfigurer)
+			if r.statusCode != 400 || apiErrorCode(t, r) != "validation_failed" {
+				t.Errorf("%v: expected validation_failed error, got %v %v", n, r.statusCode, r.body)
+			}
+		}
+	})
+
+	t.Run("rejects attachments when they are not permitted", func(t *testing.T) {
+		disabled, _ := newCreationPolicy(&Configuration{})
+		defer useTestPolicy(disabled)()
+
+		r := apiRequest(t, "POST", app.handleAPICreateSecret, attachmentsRequest(testEncryptedSecret, attachmentJSON), emptyRequestConfigurer)
+		if r.statusCode != 400 || !strings.Contains(r.body, "Files cannot be attached to secrets.") {
+			t.Errorf("expected validation error, got %v %v", r.statusCode, r.body)
+		}
+	})
+
+	t.Run("rejects requests larger than the policy permits", func(t *testing.T) {
+		padding := strings.Repeat("A", 100*1024)
+
+		r := apiRequest(t, "POST", app.handleAPICreateSecret, attachmentsRequest(testEncryptedSecret+padding), emptyRequestConfigurer)
+		if r.statusCode != 413 || apiErrorCode(t, r) != "request_too_large" {
+			t.Errorf("expected request_too_large error, got %v %v", r.statusCode, r.body)
+		}
+	})
+
+	t.Run("releases attachments with the secret and destroys them on its final view", func(t *testing.T) {
+		accessID := createSecretWithAttachments(t, attachmentJSON)
+		ids := attachmentIDs(t, accessID)
+		if len(ids) != 1 {
+			t.Fatalf("expected 1 attachment, got %v", len(ids))
+		}
+
+		viewingKey, _ := app.db.createSecretView(accessID)
+		r := apiRequest(t, "GET", app.handleAPIAccessSecret, "", withViewingKey(accessID, viewingKey))
+
+		var accessed apiAccessSecretResponse
+		if err := json.Unmarshal([]byte(r.body), &accessed); err != nil || r.statusCode != 200 {
+			t.Fatalf("expected secret, got %v %v", r.statusCode, r.body)
+		} else if len(accessed.Attachments) != 1 || !accessed.FinalView {
+			t.Fatalf("unexpected response %+v", accessed)
+		}
+
+		encryptedContent, _ := base64.RawURLEncoding.DecodeString(accessed.Attachments[0].Content)
+		if n, c, err := k.DecryptAttachment(accessed.Attachments[0].Name, encryptedContent); err != nil {
+			t.Errorf("decrypting attachment: %v", err)
+		} else if n != "kubeconfig" || string(c) != "apiVersion: v1" {
+			t.Errorf("unexpected attachment %v %v", n, string(c))
+		}
+
+		assertAttachmentsDestroyed(t, accessID, ids)
+	})
+
+	t.Run("destroys attachments when the secret is deleted", func(t *testing.T) {
+		accessID := createSecretWithAttachments(t, attachmentJSON, attachmentJSON)
+		ids := attachmentIDs(t, accessID)
+
+		if err := app.db.deleteSecretWhere("access_id = ?", accessID); err != nil {
+			t.Fatalf("deleting secret: %v", err)
+		}
+
+		assertAttachmentsDestroyed(t, accessID, ids)
+	})
+
+	t.Run("destroys attachments when the secret expires", func(t *testing.T) {
+		accessID := createSecretWithAttachments(t, attachmentJSON)
+		ids := attachmentIDs(t, accessID)
+		expireSecret(t, accessID)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		app.RunDeleteExpiredSecretsJob(ctx)
+
+		until(t, func() bool { return len(attachmentIDs(t, accessID)) == 0 }, 10, 5*time.Millisecond)
+		assertAttachmentsDestroyed(t, accessID, ids)
+	})
+
+	t.Run("web form accepts attachments that the view page embeds", func(t *testing.T) {
+		body := url.Values{
+			"ttl":               {"30"},
+			"maxViews":          {"2"},
+			"encryptedSecret":   {testEncryptedSecret},
+			"attachmentName":    {name},
+			"attachmentContent": {base64.RawURLEncoding.EncodeToString(content)},
+		}
+
+		r := post(t, app.handleCreateSecret, body.Encode(), emptyRequestConfigurer)
+		if r.statusCode != 201 {
+			t.Fatalf("expected 201 status code, got %v %v", r.statusCode, r.body)
+		}
+
+		managementID := strings.TrimPrefix(r.headers.Get("Location"), "/manage-secret/")
+		secret, _ := app.db.secretByManagementID(managementID)
+		if secret.attachments != 1 {
+			t.Errorf("expected 1 attachment, got %v", secret.attachments)
+		}
+
+		viewingKey, _ := app.db.createSecretView(secret.accessID)
+		r = get(t, app.handleAccessSecret, withViewingKey(secret.accessID, viewingKey))
+
+		if r.statusCode != 200 || !strings.Contains(r.body, `data-name="`+name+`"`) {
+			t.Errorf("expected attachment in body, got %v", r.statusCode)
+		}
+	})
+}
+
+func TestFileAttachmentStore(t *testing.T) {
+	s, err := newFileAttachmentStore(filepath.Join(t.TempDir(), "attachments"))
+	if err != nil {
+		t.Fatalf("creating store: %v", err)
+	}
+
+	if err := s.put("abc", []byte("content")); err != nil {
+		t.Fatalf("putting content: %v", err)
+	}
+
+	if info, err := os.Stat(filepath.Join(s.dir, "abc")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected content to only be readable by its owner, got %v %v", info, err)
+	}
+
+	if c, err := s.get("abc"); err != nil || string(c) != "content" {
+		t.Errorf("expected content, got %v %v", string(c), err)
+	}
+
+	if err := s.delete("abc"); err != nil {
+		t.Errorf("deleting content: %v", err)
+	}
+
+	if _, err := s.get("abc"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected content to have been deleted, got %v", err)
+	}
+
+	if err := s.delete("abc"); err != nil {
+		t.Errorf("expected deleting missing content to succeed, got %v", err)
+	}
+}
+
+// attachmentsRequest returns the body of a request to create a single view secret with the attachments (as JSON)
+func attachmentsRequest(encryptedSecret string, attachments ...string) string {
+	return `{"encryptedSecret":"` + encryptedSecret + `","ttl":30,"maxViews":1,"attachments":[` +
+		strings.Join(attachments, ",") + `]}`
+}
+
+// createSecretWithAttachments creates a single view secret (testEncryptedSecret) with the attachments (as JSON) via the
+// API, returning its access identifier
+func createSecretWithAttachments(t *testing.T, attachments ...string) string {
+	r := apiRequest(t, "POST", app.handleAPICreateSecret, attachmentsRequest(testEncryptedSecret, attachments...), emptyRequestConfigurer)
+
+	var created apiCreateSecretResponse
+	if err := json.Unmarshal([]byte(r.body), &created); err != nil || r.statusCode != 201 {
+		t.Fatalf("expected secret to be created, got %v %v", r.statusCode, r.body)
+	}
+
+	return created.AccessID
+}
+
+// attachmentIDs returns the identifiers of the attachments recorded against a secret
+func attachmentIDs(t *testing.T, accessID string) []string {
+	rows, err := app.db.db.Query(
+		"SELECT a.id FROM secret_attachments a INNER JOIN secrets s ON s.id = a.secret_id WHERE s.access_id = ?",
+		accessID,
+	)
+	if err != nil {
+		t.Fatalf("querying attachments: %v", err)
+	}
+
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// assertAttachmentsDestroyed ensures neither the records nor the contents of a secret's attachments remain
+func assertAttachmentsDestroyed(t *testing.T, accessID string, ids []string) {
+	if remaining := attachmentIDs(t, accessID); len(remaining) != 0 {
+		t.Errorf("expected attachment records to be deleted, got %v", remaining)
+	}
+
+	for _, id := range ids {
+		if _, err := app.db.attachments.get(id); err == nil {
+			t.Errorf("expected contents of attachment %v to be destroyed", id)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package shareasecret
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// sessionCookieName is the name of the cookie containing the signed in user's session
+	sessionCookieName = "shareasecret_session"
+
+	// loginCookieName is the name of the cookie containing the state of a sign in that is in progress
+	loginCookieName = "shareasecret_login"
+)
+
+// identity identifies a signed in user that is permitted to create secrets, whether they signed in via OpenID Connect
+// or with a passkey
+type identity struct {
+	// Subject is only set for users signed in via OpenID Connect
+	Subject string `json:"sub,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Name    string `json:"name,omitempty"`
+
+	// EmailVerified is set if the OpenID Connect provider has verified that the user owns their email address
+	EmailVerified bool `json:"ev,omitempty"`
+
+	// AccountID is only set for users signed in with a passkey
+	AccountID string `json:"acc,omitempty"`
+}
+
+// displayName returns the most human friendly identifier of the user
+func (i identity) displayName() string {
+	if i.Email != "" {
+		return i.Email
+	}
+
+	if i.Name != "" {
+		return i.Name
+	}
+
+	return i.Subject
+}
+
+// creator returns the creator that the secrets created by the user are attributed to
+func (i identity) creator() secretCreator {
+	if i.AccountID != "" {
+		return secretCreator{accountID: i.AccountID}
+	}
+
+	return secretCreator{subject: i.Subject}
+}
+
+// session is stored, signed, in the session cookie of a signed in user
+type session struct {
+	identity
+	ExpiresAt int64 `json:"exp"`
+}
+
+// login is stored, signed, in the login cookie whilst the user signs in with the OpenID Connect provider
+type login struct {
+	State     string `json:"state"`
+	Nonce     string `json:"nonce"`
+	Verifier  string `json:"verifier"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// handleLogin redirects the visitor to the OpenID Connect provider to sign in
+func (a *Application) handleLogin(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+
+	state, errS := secureID(16)
+	nonce, errN := secureID(16)
+	verifier, errV := secureID(32)
+	if err := errors.Join(errS, errN, errV); err != nil {
+		l.Err(err).Msg("generating login state")
+		redirectToOopsPage(w, r)
+		return
+	}
+
+	u, err := a.oidc.authCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		l.Err(err).Msg("creating authorization url")
+		redirectToOopsPage(w, r)
+		return
+	}
+
+	a.setSignedCookie(
+		w,
+		loginCookieName,
+		login{State: state, Nonce: nonce, Verifier: verifier, ExpiresAt: time.Now().Add(10 * time.Minute).Unix()},
+		10*time.Minute,
+	)
+
+	http.Redirect(w, r, u, http.StatusSeeOther)
+}
+
+// handleAuthCallback completes a sign in once the OpenID Connect provider has redirected the visitor back, starting a
+// session if they are permitted to create secrets
+func (a *Application) handleAuthCallback(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+
+	var lg login
+	ok := a.signedCookie(r, loginCookieName, &lg)
+	a.clearCookie(w, loginCookieName)
+
+	if !ok || lg.ExpiresAt <= time.Now().Unix() || r.URL.Query().Get("state") != lg.State {
+		setFlashErr("Your sign in has expired or is invalid. Please try again.", w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if e := r.URL.Query().Get("error"); e != "" {
+		l.Warn().Str("error", e).Msg("sign in failed at provider")
+		setFlashErr("Unable to sign you in. Please try again.", w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	user, err := a.oidc.exchange(r.Context(), r.URL.Query().Get("code"), lg.Verifier, lg.Nonce)
+	if errors.Is(err, errNotAuthorised) {
+		setFlashErr("You are not permitted to create secrets on this shareasecret instance.", w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		l.Err(err).Msg("completing sign in")
+		setFlashErr("Unable to sign you in. Please try again.", w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	a.startSession(w, user, a.config.OIDC.SessionDuration)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// startSession signs the user in for the given duration
+func (a *Application) startSession(w http.ResponseWriter, user identity, duration time.Duration) {
+	a.setSignedCookie(
+		w,
+		sessionCookieName,
+		session{identity: user, ExpiresAt: time.Now().Add(duration).Unix()},
+		duration,
+	)
+}
+
+// handleLogout ends the visitor's session
+func (a *Application) handleLogout(w http.ResponseWriter, r *http.Request) {
+	a.clearCookie(w, sessionCookieName)
+	setFlashSuccess("You have been signed out.", w)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// signInEnabled identifies whether users can sign in, either via OpenID Connect or with a passkey
+func (a *Application) signInEnabled() bool {
+	return a.oidc != nil || a.passkeys != nil
+}
+
+// signedInUser returns the identity of the signed in user, or nil if there isn't one. The accounts of users signed in
+// with a passkey are checked on every request, so that removing an account ends its sessions.
+func (a *Application) signedInUser(r *http.Request) *identity {
+	var s session
+	if !a.signedCookie(r, sessionCookieName, &s) || s.ExpiresAt <= time.Now().Unix() {
+		return nil
+	}
+
+	if s.AccountID == "" && a.oidc == nil {
+		return nil
+	}
+
+	if s.AccountID != "" && (a.passkeys == nil || !a.db.accountActive(s.AccountID)) {
+		return nil
+	}
+
+	return &s.identity
+}
+
+// setSignedCookie sets a HTTP only cookie containing the signed JSON representation of v
+func (a *Application) setSignedCookie(w http.ResponseWriter, name string, v any, maxAge time.Duration) {
+	b, _ := json.Marshal(v)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    a.signer.signed(name, base64.RawURLEncoding.EncodeToString(b)),
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(a.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// signedCookie verifies the signature of a cookie set by [setSignedCookie], decoding its value into v
+func (a *Application) signedCookie(r *http.Request, name string, v any) bool {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return false
+	}
+
+	payload, ok := a.signer.verify(name, c.Value)
+	if !ok {
+		return false
+	}
+
+	return decodeSegment(payload, v) == nil
+}
+
+// clearCookie expires a cookie within the client's browser
+func (a *Application) clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(1, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(a.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
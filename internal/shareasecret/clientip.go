@@ -0,0 +1,115 @@
+package shareasecret
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	// clientIPHeaderXForwardedFor sources client IPs from the X-Forwarded-For header
+	clientIPHeaderXForwardedFor = "X-Forwarded-For"
+
+	// clientIPHeaderXRealIP sources client IPs from the X-Real-IP header
+	clientIPHeaderXRealIP = "X-Real-IP"
+
+	// clientIPHeaderForwarded sources client IPs from the RFC 7239 Forwarded header
+	clientIPHeaderForwarded = "Forwarded"
+)
+
+// clientIPResolver identifies the IP address of the client that made a request, only trusting the contents of proxy
+// headers if the request was received from a trusted proxy
+type clientIPResolver struct {
+	trustedProxies []net.IPNet
+	header         string
+}
+
+// resolve returns the IP address of the client that made the request, or nil if it cannot be identified
+//
+// If the request was made by a trusted proxy the hops recorded in the configured header are walked from right (the
+// hop closest to the application) to left, and the first hop that is not itself a trusted proxy is returned. In all
+// other cases the address of the connection's remote end is returned.
+func (c *clientIPResolver) resolve(r *http.Request) net.IP {
+	ip := parseIP(r.RemoteAddr)
+	if ip == nil || !c.trusted(ip) {
+		return ip
+	}
+
+	hops := c.hops(r)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseIP(hops[i])
+
+		// a hop that cannot be parsed was not written by a trusted proxy, so the closest trusted hop is the best that
+		// can be done
+		if hop == nil {
+			return ip
+		}
+
+		ip = hop
+		if !c.trusted(ip) {
+			return ip
+		}
+	}
+
+	return ip
+}
+
+// hops returns the addresses recorded by proxies in the configured header, in the order they were added
+func (c *clientIPResolver) hops(r *http.Request) []string {
+	hops := []string{}
+
+	switch c.header {
+	case clientIPHeaderXRealIP:
+		if v := strings.TrimSpace(r.Header.Get(clientIPHeaderXRealIP)); v != "" {
+			hops = append(hops, v)
+		}
+	case clientIPHeaderForwarded:
+		for _, h := range r.Header.Values(clientIPHeaderForwarded) {
+			for _, element := range strings.Split(h, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+					if ok && strings.EqualFold(k, "for") {
+						hops = append(hops, strings.Trim(v, `"`))
+					}
+				}
+			}
+		}
+	default:
+		for _, h := range r.Header.Values(clientIPHeaderXForwardedFor) {
+			for _, v := range strings.Split(h, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					hops = append(hops, v)
+				}
+			}
+		}
+	}
+
+	return hops
+}
+
+// trusted identifies whether the IP address belongs to a trusted proxy
+func (c *clientIPResolver) trusted(ip net.IP) bool {
+	for _, n := range c.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseIP parses an IP address that may optionally include a port and, for IPv6 addresses, square brackets (i.e.
+// 192.168.1.1:1234 or [fe80::1]:1234)
+func parseIP(v string) net.IP {
+	if host, _, err := net.SplitHostPort(v); err == nil {
+		v = host
+	}
+
+	return net.ParseIP(strings.Trim(v, "[]"))
+}
+
+// clientIP returns the IP address of the client that made the request, or nil if it cannot be identified. It is the
+// only way in which client IP addresses should be identified.
+func (a *Application) clientIP(r *http.Request) net.IP {
+	return a.clientIPs.resolve(r)
+}
@@ -0,0 +1,131 @@
+package shareasecret
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	trusted, err := parseNetworks("test", "10.0.0.0/8,fd00::/8,192.168.1.1")
+	if err != nil {
+		t.Fatalf("parsing networks: %v", err)
+	}
+
+	cases := []struct {
+		name       string
+		header     string
+		remoteAddr string
+		headers    map[string][]string
+		want       string
+	}{
+		{
+			name:       "uses remote address of direct connections",
+			header:     clientIPHeaderXForwardedFor,
+			remoteAddr: "203.0.113.5:1234",
+			want:       "203.0.113.5",
+		},
+		{
+			name:       "ignores headers from untrusted remote addresses",
+			header:     clientIPHeaderXForwardedFor,
+			remoteAddr: "203.0.113.5:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"127.0.0.1"}},
+			want:       "203.0.113.5",
+		},
+		{
+			name:       "uses remote address of trusted proxy if no header is present",
+			header:     clientIPHeaderXForwardedFor,
+			remoteAddr: "10.0.0.1:1234",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "picks right-most untrusted X-Forwarded-For hop",
+			header:     clientIPHeaderXForwardedFor,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"127.0.0.1, 198.51.100.7", "10.0.0.2"}},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "picks left-most X-Forwarded-For hop if all are trusted",
+			header:     clientIPHeaderXForwardedFor,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"192.168.1.1, 10.0.0.2"}},
+			want:       "192.168.1.1",
+		},
+		{
+			name:       "stops at unparseable X-Forwarded-For hops",
+			header:     clientIPHeaderXForwardedFor,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.7, garbage, 10.0.0.2"}},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "ignores X-Forwarded-For if another header is configured",
+			header:     clientIPHeaderXRealIP,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.7"}},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "uses X-Real-IP",
+			header:     clientIPHeaderXRealIP,
+			remoteAddr: "[fd00::1]:1234",
+			headers:    map[string][]string{"X-Real-IP": {"2001:db8::1"}},
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "picks right-most untrusted Forwarded hop",
+			header:     clientIPHeaderForwarded,
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string][]string{
+				"Forwarded": {`for=198.51.100.1;proto=https, for="[2001:db8::7]:4711"`, "by=10.0.0.1;For=10.0.0.3"},
+			},
+			want: "2001:db8::7",
+		},
+		{
+			name:       "stops at obfuscated Forwarded hops",
+			header:     clientIPHeaderForwarded,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {"for=198.51.100.1, for=_hidden, for=10.0.0.3"}},
+			want:       "10.0.0.3",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r, _ := http.NewRequest("GET", "/", nil)
+			r.RemoteAddr = c.remoteAddr
+			for k, vs := range c.headers {
+				for _, v := range vs {
+					r.Header.Add(k, v)
+				}
+			}
+
+			resolver := &clientIPResolver{trustedProxies: trusted, header: c.header}
+			if got := resolver.resolve(r); !got.Equal(net.ParseIP(c.want)) {
+				t.Errorf("wanted %v, got %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestRequestingIPCanCreateSecret(t *testing.T) {
+	t.Run("ignores spoofed X-Forwarded-For header on direct connections", func(t *testing.T) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "203.0.113.5:1234"
+		r.Header.Add("X-Forwarded-For", "127.0.0.1")
+
+		if app.requestingIPCanCreateSecret(r) {
+			t.Errorf("did not expect spoofed request to be able to create secrets")
+		}
+	})
+
+	t.Run("permits direct connections from allowed addresses", func(t *testing.T) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "127.0.0.1:1234"
+
+		if !app.requestingIPCanCreateSecret(r) {
+			t.Errorf("expected direct connection to be able to create secrets")
+		}
+	})
+}
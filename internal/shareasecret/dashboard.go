@@ -0,0 +1,343 @@
+package shareasecret
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// secretsPageSize is the number of secrets listed per page of a creator's secrets
+const secretsPageSize = 25
+
+// maximumBulkDeletions is the maximum number of secrets that can be deleted by identifier in a single request. Deleting
+// every active secret is not subject to it.
+const maximumBulkDeletions = 100
+
+const (
+	errInvalidSecretState   = validationError("The secret state must be one of all, active, expired, viewed or deleted.")
+	errTooManyBulkDeletions = validationError("At most 100 secrets can be deleted at once. Delete every active secret instead.")
+)
+
+// secretState is used to filter a creator's secrets by what has happened to them
+type secretState string
+
+const (
+	secretStateAll     secretState = "all"
+	secretStateActive  secretState = "active"
+	secretStateExpired secretState = "expired"
+	secretStateViewed  secretState = "viewed"
+	secretStateDeleted secretState = "deleted"
+)
+
+// parseSecretState parses a secret state, returning [errInvalidSecretState] if it is not recognised and the fallback
+// if it is empty
+func parseSecretState(v string, fallback secretState) (secretState, error) {
+	switch s := secretState(v); s {
+	case "":
+		return fallback, nil
+	case secretStateAll, secretStateActive, secretStateExpired, secretStateViewed, secretStateDeleted:
+		return s, nil
+	default:
+		return "", errInvalidSecretState
+	}
+}
+
+// condition returns the SQL condition secrets in the state match
+func (s secretState) condition(now time.Time) (string, []any) {
+	switch s {
+	case secretStateActive:
+		return "deleted_at IS NULL AND expires_at > ?", []any{now.UnixMilli()}
+	case secretStateExpired:
+		return "(deletion_reason = ? OR (deleted_at IS NULL AND expires_at <= ?))", []any{deletionReasonExpired, now.UnixMilli()}
+	case secretStateViewed:
+		return "deletion_reason = ?", []any{deletionReasonMaximumViewCountHit}
+	case secretStateDeleted:
+		return "deletion_reason IN (?, ?)", []any{deletionReasonUserDeleted, deletionReasonMaximumKeyAttemptsHit}
+	default:
+		return "1 = 1", nil
+	}
+}
+
+// secretCreator identifies who created (or owns) a secret, so that they can find and delete the secrets they created
+type secretCreator struct {
+	// subject is the subject of a user signed in via OpenID Connect
+	subject string
+
+	// accountID is the identifier of the account of a user signed in with a passkey
+	accountID string
+
+	// apiTokenID is the identifier of an API token
+	apiTokenID string
+
+	// organisationID is the identifier of the organisation that owns secrets, regardless of who created them
+	organisationID string
+}
+
+// condition returns the SQL condition secrets created by the creator match
+func (c secretCreator) condition() (string, any) {
+	if c.organisationID != "" {
+		return "organisation_id = ?", c.organisationID
+	}
+
+	if c.apiTokenID != "" {
+		return "api_token_id = ?", c.apiTokenID
+	}
+
+	if c.accountID != "" {
+		return "creator_account_id = ?", c.accountID
+	}
+
+	return "creator_subject = ?", c.subject
+}
+
+// creatorSecret contains the information about a secret listed to its creator, including what has happened to it
+type creatorSecret struct {
+	accessID       string
+	managementID   string
+	ttl            int
+	maximumViews   int
+	views          int
+	createdAt      time.Time
+	expiresAt      time.Time
+	deletedAt      time.Time
+	deletionReason string
+}
+
+// state returns the state the secret is in
+func (s creatorSecret) state() secretState {
+	switch s.deletionReason {
+	case deletionReasonExpired:
+		return secretStateExpired
+	case deletionReasonMaximumViewCountHit:
+		return secretStateViewed
+	case deletionReasonUserDeleted, deletionReasonMaximumKeyAttemptsHit:
+		return secretStateDeleted
+	}
+
+	if !s.expiresAt.After(time.Now()) {
+		return secretStateExpired
+	}
+
+	return secretStateActive
+}
+
+// secretsByCreator retrieves a page (starting from 1) of the secrets created by the creator that are in the given
+// state, newest first. Whether there are more pages is also returned.
+func (d *database) secretsByCreator(c secretCreator, state secretState, page int) ([]creatorSecret, bool, error) {
+	creatorCondition, creatorArg := c.condition()
+	stateCondition, stateArgs := state.condition(time.Now())
+
+	args := append([]any{creatorArg}, stateArgs...)
+	args = append(args, secretsPageSize+1, (max(page, 1)-1)*secretsPageSize)
+
+	rows, err := d.reader.Query(
+		fmt.Sprintf(
+			`
+				SELECT
+					access_id,
+					management_id,
+					ttl,
+					maximum_views,
+					views,
+					created_at,
+					expires_at,
+					deleted_at,
+					deletion_reason
+				FROM
+					secrets
+				WHERE
+					%s AND
+					%s
+				ORDER BY
+					created_at DESC,
+					id DESC
+				LIMIT ? OFFSET ?
+			`,
+			creatorCondition,
+			stateCondition,
+		),
+		args...,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	secrets := []creatorSecret{}
+	for rows.Next() {
+		var s creatorSecret
+		var createdAt, expiresAt int64
+		var deletedAt sql.NullInt64
+		var deletionReason sql.NullString
+
+		err := rows.Scan(
+			&s.accessID,
+			&s.managementID,
+			&s.ttl,
+			&s.maximumViews,
+			&s.views,
+			&createdAt,
+			&expiresAt,
+			&deletedAt,
+			&deletionReason,
+		)
+		if err != nil {
+			return nil, false, err
+		}
+
+		s.createdAt = time.UnixMilli(createdAt)
+		s.expiresAt = time.UnixMilli(expiresAt)
+		s.deletionReason = deletionReason.String
+		if deletedAt.Valid {
+			s.deletedAt = time.UnixMilli(deletedAt.Int64)
+		}
+
+		secrets = append(secrets, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if len(secrets) > secretsPageSize {
+		return secrets[:secretsPageSize], true, nil
+	}
+
+	return secrets, false, nil
+}
+
+// deleteSecretsByCreator deletes the secrets with the given access identifiers that were created by the creator,
+// returning the number of secrets that were deleted. Secrets that have already been deleted are ignored.
+func (d *database) deleteSecretsByCreator(c secretCreator, accessIDs []string) (int64, error) {
+	if len(accessIDs) == 0 {
+		return 0, nil
+	}
+
+	if len(accessIDs) > maximumBulkDeletions {
+		return 0, errTooManyBulkDeletions
+	}
+
+	condition, arg := c.condition()
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(accessIDs)), ", ")
+
+	args := []any{arg}
+	for _, id := range accessIDs {
+		args = append(args, id)
+	}
+
+	return d.deleteSecretsWhere(fmt.Sprintf("%s AND access_id IN (%s)", condition, placeholders), args...)
+}
+
+// deleteAllSecretsByCreator deletes every active secret created by the creator, returning the number of secrets that
+// were deleted
+func (d *database) deleteAllSecretsByCreator(c secretCreator) (int64, error) {
+	condition, arg := c.condition()
+
+	return d.deleteSecretsWhere(condition+" AND expires_at > ?", arg, time.Now().UnixMilli())
+}
+
+// handleMySecrets renders the list of secrets created by the signed in user
+func (a *Application) handleMySecrets(w http.ResponseWriter, r *http.Request) {
+	user := a.signedInUser(r)
+	if user == nil {
+		setFlashErr("Sign in to see the secrets you have created.", w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	a.renderSecrets(w, r, user.creator(), mySecretsPage{user: user})
+}
+
+// handleDeleteMySecrets deletes the selected secrets created by the signed in user, or all of their active secrets,
+// returning them to the list of their secrets
+func (a *Application) handleDeleteMySecrets(w http.ResponseWriter, r *http.Request) {
+	user := a.signedInUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	a.deleteSecrets(w, r, user.creator(), mySecretsPage{user: user})
+}
+
+// renderSecrets renders the page of the creator's secrets requested, filtered by the requested state
+func (a *Application) renderSecrets(w http.ResponseWriter, r *http.Request, c secretCreator, p mySecretsPage) {
+	state, err := parseSecretState(r.URL.Query().Get("state"), secretStateAll)
+	if err != nil {
+		state = secretStateAll
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	secrets, more, err := a.store.secretsByCreator(c, state, page)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("retrieving secrets")
+		redirectToOopsPage(w, r)
+		return
+	}
+
+	p.state, p.page, p.more, p.secrets = state, page, more, secrets
+
+	pageMySecrets(p, notificationsFromRequest(r, w)).Render(r.Context(), w)
+}
+
+// deleteSecrets deletes the selected secrets of the creator, or all of their active secrets, returning the signed in
+// user to the page listing them
+func (a *Application) deleteSecrets(w http.ResponseWriter, r *http.Request, c secretCreator, p mySecretsPage) {
+	l := zerolog.Ctx(r.Context())
+
+	if err := r.ParseForm(); err != nil {
+		setFlashErr("Unable to parse request form. Please try again.", w)
+		http.Redirect(w, r, p.basePath(), http.StatusSeeOther)
+		return
+	}
+
+	back := p.basePath()
+	if state, err := parseSecretState(r.Form.Get("state"), ""); err == nil && state != "" {
+		back += "?" + url.Values{"state": {string(state)}}.Encode()
+	}
+
+	var deleted int64
+	var err error
+	if r.Form.Get("all") != "" {
+		deleted, err = a.store.deleteAllSecretsByCreator(c)
+	} else {
+		deleted, err = a.store.deleteSecretsByCreator(c, r.Form["accessID"])
+	}
+
+	var ve validationError
+	if errors.As(err, &ve) {
+		setFlashErr(ve.Error(), w)
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		l.Err(err).Msg("deleting secrets")
+		redirectToOopsPage(w, r)
+		return
+	}
+
+	l.Info().
+		Str("creator_subject", p.user.Subject).
+		Str("creator_account_id", p.user.AccountID).
+		Str("organisation_id", c.organisationID).
+		Int64("deleted_secrets", deleted).
+		Msg("bulk deleted secrets")
+
+	if deleted == 1 {
+		setFlashSuccess("Deleted 1 secret.", w)
+	} else {
+		setFlashSuccess(fmt.Sprintf("Deleted %d secrets.", deleted), w)
+	}
+
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
@@ -0,0 +1,278 @@
+package shareasecret
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreatorSecrets(t *testing.T) {
+	creator := secretCreator{subject: "dashboard-filters"}
+
+	active := createSecretBy(t, creator.subject, time.Time{}, "")
+	expired := createSecretBy(t, creator.subject, time.Time{}, "")
+	expireSecret(t, expired)
+	viewed := createSecretBy(t, creator.subject, time.Now(), deletionReasonMaximumViewCountHit)
+	deleted := createSecretBy(t, creator.subject, time.Now(), deletionReasonUserDeleted)
+	createSecretBy(t, "someone-else", time.Time{}, "")
+
+	t.Run("filters secrets by state", func(t *testing.T) {
+		cases := map[secretState][]string{
+			secretStateAll:     {deleted, viewed, expired, active},
+			secretStateActive:  {active},
+			secretStateExpired: {expired},
+			secretStateViewed:  {viewed},
+			secretStateDeleted: {deleted},
+		}
+
+		for state, expected := range cases {
+			secrets, more, err := app.db.secretsByCreator(creator, state, 1)
+			if err != nil {
+				t.Fatalf("%v: retrieving secrets: %v", state, err)
+			}
+
+			ids := []string{}
+			for _, s := range secrets {
+				ids = append(ids, s.accessID)
+
+				if s.state() != state && state != secretStateAll {
+					t.Errorf("%v: expected secret to be in the state, got %v", state, s.state())
+				}
+			}
+
+			if more || strings.Join(ids, ",") != strings.Join(expected, ",") {
+				t.Errorf("%v: expected %v, got %v (more: %v)", state, expected, ids, more)
+			}
+		}
+	})
+
+	t.Run("paginates secrets", func(t *testing.T) {
+		paginated := secretCreator{subject: "dashboard-pagination"}
+		for i := 0; i < secretsPageSize+1; i++ {
+			createSecretBy(t, paginated.subject, time.Time{}, "")
+		}
+
+		secrets, more, err := app.db.secretsByCreator(paginated, secretStateAll, 1)
+		if err != nil || len(secrets) != secretsPageSize || !more {
+			t.Errorf("expected a full first page with more, got %v %v %v", len(secrets), more, err)
+		}
+
+		secrets, more, err = app.db.secretsByCreator(paginated, secretStateAll, 2)
+		if err != nil || len(secrets) != 1 || more {
+			t.Errorf("expected a single secret on the last page, got %v %v %v", len(secrets), more, err)
+		}
+	})
+
+	t.Run("only deletes the creator's secrets", func(t *testing.T) {
+		mine := createSecretBy(t, "dashboard-deletion", time.Time{}, "")
+		theirs := createSecretBy(t, "someone-else", time.Time{}, "")
+
+		n, err := app.db.deleteSecretsByCreator(secretCreator{subject: "dashboard-deletion"}, []string{mine, theirs})
+		if err != nil || n != 1 {
+			t.Errorf("expected one secret to be deleted, got %v %v", n, err)
+		}
+
+		if err := app.db.secretExists(theirs); err != nil {
+			t.Errorf("expected other creator's secret to exist, got %v", err)
+		}
+	})
+
+	t.Run("limits the number of secrets deleted by identifier", func(t *testing.T) {
+		ids := make([]string, maximumBulkDeletions+1)
+		for i := range ids {
+			ids[i] = fmt.Sprintf("id-%d", i)
+		}
+
+		if _, err := app.db.deleteSecretsByCreator(creator, ids); !errors.Is(err, errTooManyBulkDeletions) {
+			t.Errorf("expected errTooManyBulkDeletions, got %v", err)
+		}
+	})
+}
+
+func TestMySecrets(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer useTestOIDCProvider(issuer)()
+
+	cookies := signIn(t, issuer, map[string]any{"sub": "dashboard-carol", "email": "carol@example.com", "email_verified": true})
+
+	signedIn := func(query string) func(r *http.Request) {
+		return func(r *http.Request) {
+			outsideRequester(r)
+			withCookies(cookies)(r)
+			r.URL, _ = url.Parse("/my-secrets?" + query)
+		}
+	}
+
+	t.Run("requires the user to be signed in", func(t *testing.T) {
+		if r := get(t, app.handleMySecrets, outsideRequester); !responseIsRedirectTo(r, "/") {
+			t.Errorf("expected redirect to home page, got %v", r.statusCode)
+		}
+
+		if r := post(t, app.handleDeleteMySecrets, "all=true", outsideRequester); !responseIsRedirectTo(r, "/") {
+			t.Errorf("expected redirect to home page, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("lists the user's secrets", func(t *testing.T) {
+		accessID := createSecretBy(t, "dashboard-carol", time.Time{}, "")
+		var managementID string
+		app.db.db.QueryRow("SELECT management_id FROM secrets WHERE access_id = ?", accessID).Scan(&managementID)
+
+		other := createSecretBy(t, "someone-else", time.Time{}, "")
+
+		r := get(t, app.handleMySecrets, signedIn("state=active"))
+		if r.statusCode != http.StatusOK {
+			t.Fatalf("expected 200 status code, got %v", r.statusCode)
+		}
+
+		if !strings.Contains(r.body, accessID) || !strings.Contains(r.body, "/manage-secret/"+managementID) {
+			t.Errorf("expected secret to be listed with a management link")
+		} else if strings.Contains(r.body, other) {
+			t.Errorf("expected other creator's secret not to be listed")
+		}
+	})
+
+	t.Run("deletes the selected secrets", func(t *testing.T) {
+		first := createSecretBy(t, "dashboard-carol", time.Time{}, "")
+		second := createSecretBy(t, "dashboard-carol", time.Time{}, "")
+		kept := createSecretBy(t, "dashboard-carol", time.Time{}, "")
+
+		r := post(t, app.handleDeleteMySecrets, "state=active&accessID="+first+"&accessID="+second, signedIn(""))
+		if !responseIsRedirectTo(r, "/my-secrets?state=active") {
+			t.Fatalf("expected redirect to my secrets, got %v %v", r.statusCode, r.headers.Get("Location"))
+		} else if cookie(r.cookies, "flash_success") == nil {
+			t.Errorf("expected success flash message")
+		}
+
+		for _, id := range []string{first, second} {
+			if err := app.db.secretExists(id); !errors.Is(err, errSecretNotFound) {
+				t.Errorf("expected secret to be deleted, got %v", err)
+			}
+		}
+
+		if err := app.db.secretExists(kept); err != nil {
+			t.Errorf("expected unselected secret to exist, got %v", err)
+		}
+	})
+
+	t.Run("deletes every active secret", func(t *testing.T) {
+		mine := createSecretBy(t, "dashboard-carol", time.Time{}, "")
+		expired := createSecretBy(t, "dashboard-carol", time.Time{}, "")
+		expireSecret(t, expired)
+		theirs := createSecretBy(t, "someone-else", time.Time{}, "")
+
+		if r := post(t, app.handleDeleteMySecrets, "all=true", signedIn("")); !responseIsRedirectTo(r, "/my-secrets") {
+			t.Fatalf("expected redirect to my secrets, got %v", r.statusCode)
+		}
+
+		if err := app.db.secretExists(mine); !errors.Is(err, errSecretNotFound) {
+			t.Errorf("expected secret to be deleted, got %v", err)
+		}
+
+		var reason *string
+		app.db.db.QueryRow("SELECT deletion_reason FROM secrets WHERE access_id = ?", expired).Scan(&reason)
+		if reason != nil {
+			t.Errorf("expected expired secret to be left for the expiry job, got %v", *reason)
+		}
+
+		if err := app.db.secretExists(theirs); err != nil {
+			t.Errorf("expected other creator's secret to exist, got %v", err)
+		}
+	})
+}
+
+func TestAPISecretsFilteringAndBulkDeletion(t *testing.T) {
+	created, token := createAPIToken(t, APITokenScopeRead, APITokenScopeManage)
+	creator := secretCreator{apiTokenID: created.ID}
+
+	active := createSecretByToken(t, created.ID)
+	expired := createSecretByToken(t, created.ID)
+	expireSecret(t, expired)
+
+	withQuery := func(query string) func(r *http.Request) {
+		return func(r *http.Request) {
+			withBearer(token)(r)
+			r.URL, _ = url.Parse("/api/v1/secrets?" + query)
+		}
+	}
+
+	t.Run("filters secrets by state", func(t *testing.T) {
+		r := apiRequest(t, "GET", app.handleAPISecrets, "", withQuery("state=expired"))
+
+		var res apiSecretsResponse
+		if err := json.Unmarshal([]byte(r.body), &res); err != nil {
+			t.Fatalf("unmarshalling response: %v", err)
+		}
+
+		if len(res.Secrets) != 1 || res.Secrets[0].AccessID != expired || res.Secrets[0].Status != "expired" {
+			t.Errorf("expected the expired secret to be listed, got %+v", res.Secrets)
+		}
+	})
+
+	t.Run("rejects invalid states and pages", func(t *testing.T) {
+		for _, q := range []string{"state=unknown", "page=0", "page=abc"} {
+			r := apiRequest(t, "GET", app.handleAPISecrets, "", withQuery(q))
+
+			if r.statusCode != http.StatusBadRequest || apiErrorCode(t, r) != "validation_failed" {
+				t.Errorf("%v: expected validation failure, got %v", q, r.statusCode)
+			}
+		}
+	})
+
+	t.Run("rejects ambiguous bulk deletions", func(t *testing.T) {
+		for _, body := range []string{`{}`, `{"all":true,"accessIds":["abc"]}`} {
+			r := apiRequest(t, "POST", app.handleAPIDeleteSecrets, body, withBearer(token))
+
+			if r.statusCode != http.StatusBadRequest || apiErrorCode(t, r) != "validation_failed" {
+				t.Errorf("%v: expected validation failure, got %v", body, r.statusCode)
+			}
+		}
+	})
+
+	t.Run("deletes every active secret", func(t *testing.T) {
+		r := apiRequest(t, "POST", app.handleAPIDeleteSecrets, `{"all":true}`, withBearer(token))
+
+		var res apiDeleteSecretsResponse
+		if err := json.Unmarshal([]byte(r.body), &res); err != nil {
+			t.Fatalf("unmarshalling response: %v", err)
+		}
+
+		if r.statusCode != http.StatusOK || res.Deleted != 1 {
+			t.Errorf("expected one secret to be deleted, got %v %+v", r.statusCode, res)
+		}
+
+		secrets, _, _ := app.db.secretsByCreator(creator, secretStateDeleted, 1)
+		if len(secrets) != 1 || secrets[0].accessID != active {
+			t.Errorf("expected the active secret to be deleted, got %+v", secrets)
+		}
+	})
+}
+
+// createSecretBy creates a secret instance in the database as if it was created by the signed in user with the given
+// subject, returning its access identifier
+func createSecretBy(t *testing.T, subject string, deletedAt time.Time, deletionReason string) string {
+	accessID, _ := createSecret(t, deletedAt, deletionReason)
+
+	if _, err := app.db.db.Exec("UPDATE secrets SET creator_subject = ? WHERE access_id = ?", subject, accessID); err != nil {
+		t.Errorf("setting creator: %v", err)
+	}
+
+	return accessID
+}
+
+// createSecretByToken creates a secret instance in the database as if it was created with the API token, returning its
+// access identifier
+func createSecretByToken(t *testing.T, apiTokenID string) string {
+	accessID, _ := createSecret(t, time.Time{}, "")
+
+	if _, err := app.db.db.Exec("UPDATE secrets SET api_token_id = ? WHERE access_id = ?", apiTokenID, accessID); err != nil {
+		t.Errorf("setting api token: %v", err)
+	}
+
+	return accessID
+}
@@ -0,0 +1,169 @@
+package shareasecret
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	errInvalidInvite      = validationError("This invite link is invalid, has expired or has already been used.")
+	errInvalidInviteTTL   = validationError("Unable to parse the time until the invite expires.")
+	errInvalidInviteLabel = validationError("Invite labels cannot be longer than 200 characters.")
+)
+
+// newInvite contains the user provided values required to create an invite
+type newInvite struct {
+	label     string
+	ttl       int
+	singleUse bool
+}
+
+// validate ensures the new invite is structurally valid, returning a [validationError] if not
+func (i newInvite) validate() error {
+	if i.ttl <= 0 {
+		return errInvalidInviteTTL
+	}
+
+	if len(i.label) > 200 {
+		return errInvalidInviteLabel
+	}
+
+	return nil
+}
+
+// invite permits whoever holds a link to it to create secrets, regardless of any IP address restrictions
+type invite struct {
+	id        string
+	label     string
+	singleUse bool
+	expiresAt time.Time
+}
+
+// createInvite validates and persists an invite, generating a cryptographically random, 96 bit identifier for it
+func (d *database) createInvite(i newInvite) (invite, error) {
+	if err := i.validate(); err != nil {
+		return invite{}, err
+	}
+
+	id, err := secureID(12)
+	if err != nil {
+		return invite{}, fmt.Errorf("generating invite id: %w", err)
+	}
+
+	now := time.Now()
+	created := invite{
+		id:        id,
+		label:     strings.TrimSpace(i.label),
+		singleUse: i.singleUse,
+		expiresAt: now.Add(time.Duration(i.ttl) * time.Minute),
+	}
+
+	_, err = d.db.Exec(
+		`
+			INSERT INTO
+				invites (id, label, single_use, expires_at, created_at)
+			VALUES
+				(?, ?, ?, ?, ?)
+		`,
+		created.id,
+		created.label,
+		created.singleUse,
+		created.expiresAt.UnixMilli(),
+		now.UnixMilli(),
+	)
+	if err != nil {
+		return invite{}, fmt.Errorf("inserting invite: %w", err)
+	}
+
+	return created, nil
+}
+
+// inviteUsable identifies whether an invite exists, has not expired and, if it can only be used once, has not been
+// used, returning [errInvalidInvite] if not
+func (d *database) inviteUsable(id string) error {
+	var c int
+	err := d.reader.QueryRow(
+		`
+			SELECT
+				1
+			FROM
+				invites
+			WHERE
+				id = ? AND
+				expires_at > ? AND
+				(NOT single_use OR used_at IS NULL)
+		`,
+		id,
+		time.Now().UnixMilli(),
+	).Scan(&c)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return errInvalidInvite
+	}
+
+	return err
+}
+
+// useInvite records the use of an invite as part of a transaction, returning [errInvalidInvite] if it is not usable
+func useInvite(tx *transaction, id string, now time.Time) error {
+	rs, err := tx.Exec(
+		`
+			UPDATE
+				invites
+			SET
+				used_at = ?1
+			WHERE
+				id = ?2 AND
+				expires_at > ?1 AND
+				(NOT single_use OR used_at IS NULL)
+		`,
+		now.UnixMilli(),
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("using invite: %w", err)
+	}
+
+	if rc, err := rs.RowsAffected(); err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	} else if rc == 0 {
+		return errInvalidInvite
+	}
+
+	return nil
+}
+
+// inviteToken returns the signed token that is used in an invite's link. The expiry is included so that expired links
+// can be rejected without a trip to the database.
+func (a *Application) inviteToken(i invite) string {
+	return a.signer.signed("invite", fmt.Sprintf("%s.%d", i.id, i.expiresAt.UnixMilli()))
+}
+
+// inviteURL returns the link that is handed to the people being invited to create secrets
+func (a *Application) inviteURL(i invite) string {
+	return fmt.Sprintf("%s/invite/%s", a.baseURL, a.inviteToken(i))
+}
+
+// inviteIDFromToken verifies the signature and expiry of an invite token, returning the invite's identifier if they
+// are valid
+func (a *Application) inviteIDFromToken(token string) (string, bool) {
+	payload, ok := a.signer.verify("invite", token)
+	if !ok {
+		return "", false
+	}
+
+	id, expiresAt, ok := strings.Cut(payload, ".")
+	if !ok {
+		return "", false
+	}
+
+	if e, err := strconv.ParseInt(expiresAt, 10, 64); err != nil || e <= time.Now().UnixMilli() {
+		return "", false
+	}
+
+	return id, true
+}
@@ -0,0 +1,233 @@
+package shareasecret
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInviteTokens(t *testing.T) {
+	i := createInvite(t, false)
+
+	t.Run("accepts valid tokens", func(t *testing.T) {
+		if id, ok := app.inviteIDFromToken(app.inviteToken(i)); !ok || id != i.id {
+			t.Errorf("expected token to be accepted, got %v %v", id, ok)
+		}
+	})
+
+	t.Run("rejects tampered tokens", func(t *testing.T) {
+		token := app.inviteToken(i)
+
+		// the first character of the token is replaced with a different one
+		tampered := "a" + token[1:]
+		if token[0] == 'a' {
+			tampered = "b" + token[1:]
+		}
+
+		if _, ok := app.inviteIDFromToken(tampered); ok {
+			t.Errorf("expected tampered token to be rejected")
+		}
+	})
+
+	t.Run("rejects expired tokens", func(t *testing.T) {
+		expired := i
+		expired.expiresAt = time.Now().Add(-1 * time.Minute)
+
+		if _, ok := app.inviteIDFromToken(app.inviteToken(expired)); ok {
+			t.Errorf("expected expired token to be rejected")
+		}
+	})
+}
+
+func TestInviteCreation(t *testing.T) {
+	t.Run("redirects back to home page if not valid requesting ip", func(t *testing.T) {
+		r := post(t, app.handleCreateInvite, "label=acme&ttl=60&singleUse=true", outsideRequester)
+
+		if !responseIsRedirectTo(r, "/") {
+			t.Errorf("expected redirect to home page, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("index page includes invite form for valid requesting ip", func(t *testing.T) {
+		if r := get(t, app.handleGetIndex, emptyRequestConfigurer); !strings.Contains(r.body, `action="/invite"`) {
+			t.Errorf("expected invite form in body")
+		}
+	})
+
+	t.Run("creates the invite and displays its link", func(t *testing.T) {
+		r := post(t, app.handleCreateInvite, "label=acme&ttl=60&singleUse=true", emptyRequestConfigurer)
+
+		if r.statusCode != http.StatusOK {
+			t.Errorf("expected 200 status code, got %v", r.statusCode)
+		} else if !strings.Contains(r.body, app.baseURL+"/invite/") {
+			t.Errorf("expected invite url in body")
+		}
+	})
+
+	t.Run("api creates the invite", func(t *testing.T) {
+		r := apiRequest(
+			t,
+			"POST",
+			app.handleAPICreateInvite,
+			`{"label":"acme","ttl":60,"singleUse":true}`,
+			emptyRequestConfigurer,
+		)
+		if r.statusCode != http.StatusCreated {
+			t.Fatalf("expected 201 status code, got %v", r.statusCode)
+		}
+
+		var res apiCreateInviteResponse
+		if err := json.Unmarshal([]byte(r.body), &res); err != nil {
+			t.Fatalf("unmarshalling response: %v", err)
+		}
+
+		if !strings.HasPrefix(res.InviteURL, app.baseURL+"/invite/") {
+			t.Errorf("unexpected invite url %v", res.InviteURL)
+		}
+	})
+
+	t.Run("api forbidden if not valid requesting ip", func(t *testing.T) {
+		r := apiRequest(t, "POST", app.handleAPICreateInvite, `{"ttl":60}`, outsideRequester)
+
+		if r.statusCode != http.StatusForbidden {
+			t.Errorf("expected 403 status code, got %v", r.statusCode)
+		}
+	})
+}
+
+func TestSecretCreationViaInvite(t *testing.T) {
+	t.Run("invite page shows the create secret form", func(t *testing.T) {
+		token := app.inviteToken(createInvite(t, true))
+
+		r := get(t, app.handleGetInvite, func(r *http.Request) {
+			outsideRequester(r)
+			r.SetPathValue("token", token)
+		})
+
+		if r.statusCode != http.StatusOK {
+			t.Errorf("expected 200 status code, got %v", r.statusCode)
+		} else if !strings.Contains(r.body, `data-invite="`+token+`"`) {
+			t.Errorf("expected invite token in body")
+		}
+	})
+
+	t.Run("invite page redirects home if invite is invalid", func(t *testing.T) {
+		r := get(t, app.handleGetInvite, func(r *http.Request) {
+			outsideRequester(r)
+			r.SetPathValue("token", "abc.123.def")
+		})
+
+		if !responseIsRedirectTo(r, "/") {
+			t.Errorf("expected redirect to home page, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("creates a secret from an outside ip and attributes it to the invite", func(t *testing.T) {
+		i := createInvite(t, true)
+
+		r := post(t, app.handleCreateSecret, secretFormViaInvite(app.inviteToken(i)), outsideRequester)
+		if r.statusCode != http.StatusCreated {
+			t.Fatalf("expected 201 status code, got %v", r.statusCode)
+		}
+
+		managementID := strings.TrimPrefix(r.headers.Get("Location"), "/manage-secret/")
+
+		r = get(t, app.handleManageSecret, func(r *http.Request) { r.SetPathValue("managementID", managementID) })
+		if !strings.Contains(r.body, i.id) || !strings.Contains(r.body, "acme") {
+			t.Errorf("expected invite to be displayed on the management page")
+		}
+
+		r = apiRequest(t, "GET", app.handleAPIManageSecret, "", func(r *http.Request) {
+			r.SetPathValue("managementID", managementID)
+		})
+
+		var res apiManageSecretResponse
+		if err := json.Unmarshal([]byte(r.body), &res); err != nil {
+			t.Errorf("unmarshalling response: %v", err)
+		} else if res.Invite == nil || res.Invite.ID != i.id || res.Invite.Label != "acme" {
+			t.Errorf("expected invite in api response, got %+v", res.Invite)
+		}
+	})
+
+	t.Run("single use invites can only be used once", func(t *testing.T) {
+		token := app.inviteToken(createInvite(t, true))
+
+		if r := post(t, app.handleCreateSecret, secretFormViaInvite(token), outsideRequester); r.statusCode != http.StatusCreated {
+			t.Fatalf("expected 201 status code, got %v", r.statusCode)
+		}
+
+		if r := post(t, app.handleCreateSecret, secretFormViaInvite(token), outsideRequester); r.statusCode != http.StatusBadRequest {
+			t.Errorf("expected 400 status code, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("reusable invites can be used more than once", func(t *testing.T) {
+		token := app.inviteToken(createInvite(t, false))
+
+		for n := 0; n < 2; n++ {
+			if r := post(t, app.handleCreateSecret, secretFormViaInvite(token), outsideRequester); r.statusCode != http.StatusCreated {
+				t.Errorf("expected 201 status code, got %v", r.statusCode)
+			}
+		}
+	})
+
+	t.Run("invites are not used up by invalid secrets", func(t *testing.T) {
+		token := app.inviteToken(createInvite(t, true))
+
+		form := url.Values{}
+		form.Set("encryptedSecret", "a")
+		form.Set("ttl", "30")
+		form.Set("maxViews", "1")
+		form.Set("invite", token)
+
+		if r := post(t, app.handleCreateSecret, form.Encode(), outsideRequester); r.statusCode != http.StatusBadRequest {
+			t.Fatalf("expected 400 status code, got %v", r.statusCode)
+		}
+
+		if r := post(t, app.handleCreateSecret, secretFormViaInvite(token), outsideRequester); r.statusCode != http.StatusCreated {
+			t.Errorf("expected 201 status code, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("api creates a secret from an outside ip", func(t *testing.T) {
+		token := app.inviteToken(createInvite(t, true))
+		body := `{"encryptedSecret":"` + testEncryptedSecret + `","ttl":30,"maxViews":1,"invite":"` + token + `"}`
+
+		if r := apiRequest(t, "POST", app.handleAPICreateSecret, body, outsideRequester); r.statusCode != http.StatusCreated {
+			t.Errorf("expected 201 status code, got %v", r.statusCode)
+		}
+
+		if r := apiRequest(t, "POST", app.handleAPICreateSecret, body, outsideRequester); r.statusCode != http.StatusForbidden {
+			t.Errorf("expected 403 status code reusing a single use invite, got %v", r.statusCode)
+		}
+	})
+}
+
+// createInvite creates an invite labelled acme that expires in an hour
+func createInvite(t *testing.T, singleUse bool) invite {
+	i, err := app.db.createInvite(newInvite{label: "acme", ttl: 60, singleUse: singleUse})
+	if err != nil {
+		t.Fatalf("creating invite: %v", err)
+	}
+
+	return i
+}
+
+// secretFormViaInvite returns the encoded form used to create a valid secret via an invite
+func secretFormViaInvite(token string) string {
+	form := url.Values{}
+	form.Set("encryptedSecret", testEncryptedSecret)
+	form.Set("ttl", "30")
+	form.Set("maxViews", "1")
+	form.Set("invite", token)
+
+	return form.Encode()
+}
+
+// outsideRequester configures the request to come from an IP address that is not permitted to create secrets
+func outsideRequester(r *http.Request) {
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+}
@@ -1,6 +1,7 @@
 package shareasecret
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -8,36 +9,47 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-// RunDeleteExpiredSecretsJob runs a background job that identifies expired secrets and removes them accordingly
-func (a *Application) RunDeleteExpiredSecretsJob() {
-	runJobInBackground(
+// RunDeleteExpiredSecretsJob runs a background job that identifies expired secrets and removes them accordingly. The
+// job stops once the context is cancelled.
+func (a *Application) RunDeleteExpiredSecretsJob(ctx context.Context) {
+	a.runLeasedJobInBackground(
+		ctx,
 		"delete_expired_secrets",
 		func(l zerolog.Logger) error {
-			rows, err := a.db.db.Exec(
-				`
-					UPDATE
-						secrets
-					SET
-						deleted_at = ?1,
-						deletion_reason = ?2,
-						cipher_text = NULL
-					WHERE
-						(created_at + (ttl * 60 * 1000)) <= ?1 AND
-						deleted_at IS NULL
-				`,
-				time.Now().UnixMilli(),
-				deletionReasonExpired,
-			)
+			c, err := a.store.deleteExpiredSecrets(time.Now())
 			if err != nil {
 				return err
 			}
 
-			c, err := rows.RowsAffected()
+			l.Info().Int64("deleted_secrets", c).Msg("deleted expired secrets")
+
+			// this also destroys the attachments of secrets deleted by other means, should that have failed at the time
+			destroyed, err := a.db.destroyDeletedAttachments()
 			if err != nil {
 				return err
 			}
 
-			l.Info().Int64("deleted_secrets", c).Msg("deleted expired secrets")
+			l.Info().Int("destroyed_attachments", destroyed).Msg("destroyed attachments of deleted secrets")
+
+			return nil
+		},
+		1*time.Minute,
+	)
+}
+
+// RunDeleteExpiredViewingKeysJob runs a background job that removes viewing keys that expired before they were used,
+// releasing the views reserved for them. The job stops once the context is cancelled.
+func (a *Application) RunDeleteExpiredViewingKeysJob(ctx context.Context) {
+	a.runLeasedJobInBackground(
+		ctx,
+		"delete_expired_viewing_keys",
+		func(l zerolog.Logger) error {
+			c, err := a.store.deleteExpiredViewingKeys(time.Now())
+			if err != nil {
+				return err
+			}
+
+			l.Info().Int64("deleted_viewing_keys", c).Msg("deleted expired viewing keys")
 
 			return nil
 		},
@@ -45,15 +57,41 @@ func (a *Application) RunDeleteExpiredSecretsJob() {
 	)
 }
 
+// runLeasedJobInBackground runs a job in the background like [Application.runJobInBackground], but only runs it whilst
+// this instance holds the job's lease, so that instances sharing a database don't run it at the same time. The lease
+// outlives a run so that it is renewed by the next one, and another instance takes over should this one stop.
+func (a *Application) runLeasedJobInBackground(ctx context.Context, name string, f func(l zerolog.Logger) error, every time.Duration) {
+	a.runJobInBackground(
+		ctx,
+		name,
+		func(l zerolog.Logger) error {
+			if acquired, err := a.store.acquireJobLease(name, a.instanceID, every*3); err != nil {
+				return fmt.Errorf("acquiring lease: %w", err)
+			} else if !acquired {
+				l.Debug().Msg("job leased by another instance")
+				return nil
+			}
+
+			return f(l)
+		},
+		every,
+	)
+}
+
 // runJobInBackground runs the given function in a coroutine, recovering from any panics and repeating continuously,
-// pausing for the specified duration after every run
-func runJobInBackground(name string, f func(l zerolog.Logger) error, every time.Duration) {
+// pausing for the specified duration after every run. The coroutine exits once the context is cancelled, which
+// [Application.Close] waits for.
+func (a *Application) runJobInBackground(ctx context.Context, name string, f func(l zerolog.Logger) error, every time.Duration) {
+	a.jobs.Add(1)
+
 	go func() {
+		defer a.jobs.Done()
+
+		l := log.With().Str("job_name", name).Logger()
+
 		for {
 			// this is annoying, but the only way to recover and carry on
 			func() {
-				l := log.With().Str("job_name", name).Logger()
-
 				defer func() {
 					if err := recover(); err != nil {
 						l.Err(fmt.Errorf("recover: %v", err)).Msg("recover")
@@ -67,9 +105,47 @@ func runJobInBackground(name string, f func(l zerolog.Logger) error, every time.
 				}
 
 				l.Debug().Msg("executed job")
-
-				<-time.After(every)
 			}()
+
+			select {
+			case <-ctx.Done():
+				l.Debug().Msg("stopped job")
+				return
+			case <-time.After(every):
+			}
 		}
 	}()
 }
+
+// acquireJobLease acquires the lease on a job for the holder if nobody holds it or their lease has expired, or renews
+// it if the holder already holds it, returning whether the holder now holds it
+func (d *database) acquireJobLease(name string, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+
+	rs, err := d.db.Exec(
+		`
+			INSERT INTO job_leases (name, holder, expires_at)
+			VALUES (?1, ?2, ?3)
+			ON CONFLICT (name) DO UPDATE SET
+				holder = excluded.holder,
+				expires_at = excluded.expires_at
+			WHERE
+				job_leases.holder = ?2 OR
+				job_leases.expires_at <= ?4
+		`,
+		name,
+		holder,
+		now.Add(ttl).UnixMilli(),
+		now.UnixMilli(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("upserting lease: %w", err)
+	}
+
+	rc, err := rs.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("rows affected: %w", err)
+	}
+
+	return rc > 0, nil
+}
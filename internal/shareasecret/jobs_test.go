@@ -1,40 +1,182 @@
 package shareasecret
 
 import (
+	"context"
 	"database/sql"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/rs/zerolog"
 )
 
 func TestDeleteExpiredSecretsJob(t *testing.T) {
 	t.Run("deletes secret that should have expired", func(t *testing.T) {
 		accessID, _ := createSecret(t, time.Time{}, "")
+		expireSecret(t, accessID)
 
-		_, err := app.db.db.Exec(
-			"UPDATE secrets SET ttl = 1, created_at = ? WHERE access_id = ?",
-			time.Now().Add(-2*time.Minute),
-			accessID,
-		)
-		if err != nil {
-			t.Errorf("updating secret TTL: %v", err)
-		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
 
-		app.RunDeleteExpiredSecretsJob()
+		app.RunDeleteExpiredSecretsJob(ctx)
 
 		until(
 			t,
 			func() bool {
 				var deletedAt sql.NullInt64
+				var deletionReason sql.NullString
 
-				err := app.db.db.QueryRow("SELECT deleted_at FROM secrets WHERE access_id = ?", accessID).Scan(&deletedAt)
+				err := app.db.db.
+					QueryRow("SELECT deleted_at, deletion_reason FROM secrets WHERE access_id = ?", accessID).
+					Scan(&deletedAt, &deletionReason)
 				if err != nil {
 					t.Errorf("querying secret: %v", err)
 				}
 
-				return deletedAt.Valid
+				return deletedAt.Valid && deletionReason.String == deletionReasonExpired
 			},
 			10,
 			5*time.Millisecond,
 		)
 	})
 }
+
+func TestDeleteExpiredViewingKeysJob(t *testing.T) {
+	t.Run("deletes expired viewing keys and releases their views", func(t *testing.T) {
+		accessID, _ := createSecret(t, time.Time{}, "")
+		createExpiredViewingKey(t, accessID)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		app.RunDeleteExpiredViewingKeysJob(ctx)
+
+		until(
+			t,
+			func() bool {
+				var keys int
+				var reservedViews int
+
+				err := app.db.db.
+					QueryRow(
+						`
+							SELECT
+								(SELECT COUNT(1) FROM secret_views v WHERE v.secret_id = s.id),
+								s.reserved_views
+							FROM
+								secrets s
+							WHERE
+								s.access_id = ?
+						`,
+						accessID,
+					).
+					Scan(&keys, &reservedViews)
+				if err != nil {
+					t.Errorf("querying secret: %v", err)
+				}
+
+				return keys == 0 && reservedViews == 0
+			},
+			10,
+			5*time.Millisecond,
+		)
+	})
+}
+
+func TestRunJobInBackground(t *testing.T) {
+	t.Run("stops running once the context is cancelled", func(t *testing.T) {
+		a := &Application{}
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var runs atomic.Int32
+
+		a.runJobInBackground(
+			ctx,
+			"test",
+			func(l zerolog.Logger) error {
+				runs.Add(1)
+				return nil
+			},
+			time.Millisecond,
+		)
+
+		until(t, func() bool { return runs.Load() > 1 }, 10, 5*time.Millisecond)
+
+		cancel()
+
+		stopped := make(chan struct{})
+		go func() {
+			a.jobs.Wait()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			t.Fatalf("expected job to stop once context was cancelled")
+		}
+
+		r := runs.Load()
+		<-time.After(10 * time.Millisecond)
+
+		if runs.Load() != r {
+			t.Errorf("expected job not to run after being stopped")
+		}
+	})
+
+	t.Run("carries on running after a panic", func(t *testing.T) {
+		a := &Application{}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var runs atomic.Int32
+
+		a.runJobInBackground(
+			ctx,
+			"test",
+			func(l zerolog.Logger) error {
+				runs.Add(1)
+				panic("woops")
+			},
+			time.Millisecond,
+		)
+
+		until(t, func() bool { return runs.Load() > 1 }, 10, 5*time.Millisecond)
+	})
+}
+
+func TestRunLeasedJobInBackground(t *testing.T) {
+	t.Run("only runs the job whilst the instance holds its lease", func(t *testing.T) {
+		a := &Application{store: app.db, instanceID: "instance"}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		name, _ := secureID(8)
+		if ok, err := app.db.acquireJobLease(name, "other", time.Minute); err != nil || !ok {
+			t.Fatalf("expected lease to be acquired, got %v %v", ok, err)
+		}
+
+		var runs atomic.Int32
+
+		a.runLeasedJobInBackground(
+			ctx,
+			name,
+			func(l zerolog.Logger) error {
+				runs.Add(1)
+				return nil
+			},
+			time.Millisecond,
+		)
+
+		<-time.After(20 * time.Millisecond)
+
+		if runs.Load() != 0 {
+			t.Fatalf("expected job not to run whilst another instance holds its lease")
+		}
+
+		// the other instance's lease lapses
+		app.db.acquireJobLease(name, "other", -time.Minute)
+
+		until(t, func() bool { return runs.Load() > 1 }, 10, 5*time.Millisecond)
+	})
+}
@@ -0,0 +1,165 @@
+-- the schema matches that of the SQLite migrations up to and including 0011-Uploads.sql, from which point the two
+-- sets of migrations are kept in step
+
+CREATE TABLE invites (
+    id         TEXT NOT NULL PRIMARY KEY,
+    label      TEXT NOT NULL,
+    single_use BOOLEAN NOT NULL,
+    expires_at BIGINT NOT NULL,
+    used_at    BIGINT NULL,
+    created_at BIGINT NOT NULL
+);
+
+CREATE TABLE api_tokens (
+    id           TEXT NOT NULL PRIMARY KEY,
+    name         TEXT NOT NULL,
+    token_hash   TEXT NOT NULL,
+    scopes       TEXT NOT NULL,
+    expires_at   BIGINT NULL,
+    revoked_at   BIGINT NULL,
+    last_used_at BIGINT NULL,
+    created_at   BIGINT NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_api_tokens_token_hash ON api_tokens (token_hash);
+
+CREATE TABLE accounts (
+    id                   TEXT NOT NULL PRIMARY KEY,
+    name                 TEXT NOT NULL,
+    enrolment_token_hash TEXT NULL,
+    enrolment_expires_at BIGINT NULL,
+    enrolled_at          BIGINT NULL,
+    removed_at           BIGINT NULL,
+    created_at           BIGINT NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_accounts_enrolment_token_hash ON accounts (enrolment_token_hash) WHERE enrolment_token_hash IS NOT NULL;
+
+CREATE TABLE passkeys (
+    id           TEXT NOT NULL PRIMARY KEY,
+    account_id   TEXT NOT NULL REFERENCES accounts (id),
+    public_key   BYTEA NOT NULL,
+    sign_count   BIGINT NOT NULL,
+    last_used_at BIGINT NULL,
+    created_at   BIGINT NOT NULL
+);
+
+CREATE INDEX idx_passkeys_account_id ON passkeys (account_id);
+
+CREATE TABLE organisations (
+    id              TEXT NOT NULL PRIMARY KEY,
+    name            TEXT NOT NULL,
+    ip_restrictions TEXT NOT NULL,
+    maximum_ttl     BIGINT NULL,
+    maximum_views   BIGINT NULL,
+    brand_message   TEXT NOT NULL,
+    brand_colour    TEXT NOT NULL,
+    removed_at      BIGINT NULL,
+    created_at      BIGINT NOT NULL
+);
+
+CREATE TABLE organisation_members (
+    id              BIGSERIAL NOT NULL PRIMARY KEY,
+    organisation_id TEXT NOT NULL REFERENCES organisations (id),
+    email           TEXT NULL,
+    account_id      TEXT NULL REFERENCES accounts (id),
+    role            TEXT NOT NULL,
+    created_at      BIGINT NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_organisation_members_email ON organisation_members (organisation_id, email) WHERE email IS NOT NULL;
+CREATE UNIQUE INDEX idx_organisation_members_account_id ON organisation_members (organisation_id, account_id) WHERE account_id IS NOT NULL;
+
+CREATE TABLE secrets (
+    id                   BIGSERIAL NOT NULL PRIMARY KEY,
+    access_id            TEXT NOT NULL,
+    management_id        TEXT NOT NULL,
+    cipher_text          TEXT NULL,
+    ttl                  BIGINT NOT NULL,
+    maximum_views        BIGINT NOT NULL DEFAULT(0),
+    deleted_at           BIGINT NULL,
+    deletion_reason      TEXT NULL,
+    created_at           BIGINT NOT NULL,
+    expires_at           BIGINT NOT NULL DEFAULT(0),
+    invite_id            TEXT NULL REFERENCES invites (id),
+    creator_subject      TEXT NULL,
+    creator_email        TEXT NULL,
+    api_token_id         TEXT NULL REFERENCES api_tokens (id),
+    creator_account_id   TEXT NULL REFERENCES accounts (id),
+    organisation_id      TEXT NULL REFERENCES organisations (id),
+    verifier_hash        TEXT NULL,
+    maximum_key_attempts BIGINT NULL,
+    failed_key_attempts  BIGINT NOT NULL DEFAULT(0)
+);
+
+CREATE INDEX idx_secrets_access_id_deleted_at ON secrets (access_id, deleted_at);
+CREATE INDEX idx_secrets_management_id_deleted_at ON secrets (management_id, deleted_at);
+CREATE INDEX idx_secrets_alive_expires_at ON secrets (expires_at) WHERE deleted_at IS NULL;
+CREATE INDEX idx_secrets_invite_id ON secrets (invite_id);
+CREATE INDEX idx_secrets_api_token_id ON secrets (api_token_id) WHERE api_token_id IS NOT NULL;
+CREATE INDEX idx_secrets_creator_account_id ON secrets (creator_account_id) WHERE creator_account_id IS NOT NULL;
+CREATE INDEX idx_secrets_organisation_id ON secrets (organisation_id) WHERE organisation_id IS NOT NULL;
+
+CREATE TABLE secret_views (
+    id          BIGSERIAL NOT NULL PRIMARY KEY,
+    secret_id   BIGINT NOT NULL REFERENCES secrets (id),
+    viewing_key TEXT NOT NULL,
+    viewed_at   BIGINT NULL,
+    created_at  BIGINT NOT NULL
+);
+
+CREATE INDEX idx_secret_views_secret_id ON secret_views (secret_id);
+CREATE INDEX idx_secret_views_secret_id_viewing_key_viewed_at ON secret_views (secret_id, viewing_key, viewed_at);
+CREATE INDEX idx_secret_views_secret_id_viewed_at ON secret_views (secret_id, viewed_at);
+
+CREATE TABLE secret_attachments (
+    id             TEXT NOT NULL PRIMARY KEY,
+    secret_id      BIGINT NOT NULL REFERENCES secrets (id),
+    position       BIGINT NOT NULL,
+    encrypted_name TEXT NOT NULL,
+    size           BIGINT NOT NULL,
+    created_at     BIGINT NOT NULL
+);
+
+CREATE INDEX idx_secret_attachments_secret_id ON secret_attachments (secret_id);
+
+CREATE TABLE attachment_contents (
+    attachment_id TEXT NOT NULL PRIMARY KEY,
+    content       BYTEA NOT NULL
+);
+
+CREATE TABLE rate_limits (
+    bucket       TEXT NOT NULL,
+    client_ip    TEXT NOT NULL,
+    tokens       DOUBLE PRECISION NOT NULL,
+    updated_at   BIGINT NOT NULL,
+    locked_until BIGINT NULL,
+
+    PRIMARY KEY (bucket, client_ip)
+);
+
+CREATE INDEX idx_rate_limits_updated_at ON rate_limits (updated_at);
+
+CREATE TABLE spent_challenges (
+    nonce      TEXT NOT NULL PRIMARY KEY,
+    expires_at BIGINT NOT NULL
+);
+
+CREATE INDEX idx_spent_challenges_expires_at ON spent_challenges (expires_at);
+
+CREATE TABLE uploads (
+    id            TEXT NOT NULL PRIMARY KEY,
+    length        BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT(0),
+    created_at    BIGINT NOT NULL,
+    expires_at    BIGINT NOT NULL
+);
+
+CREATE INDEX idx_uploads_expires_at ON uploads (expires_at);
+
+CREATE TABLE upload_chunks (
+    upload_id     TEXT NOT NULL REFERENCES uploads (id),
+    upload_offset BIGINT NOT NULL,
+    content       BYTEA NOT NULL,
+    PRIMARY KEY (upload_id, upload_offset)
+);
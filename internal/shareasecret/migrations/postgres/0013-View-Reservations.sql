@@ -0,0 +1,6 @@
+ALTER TABLE secrets ADD COLUMN views BIGINT NOT NULL DEFAULT(0);
+ALTER TABLE secrets ADD COLUMN reserved_views BIGINT NOT NULL DEFAULT(0);
+
+UPDATE secrets SET
+    views = (SELECT COUNT(1) FROM secret_views v WHERE v.secret_id = secrets.id AND v.viewed_at IS NOT NULL),
+    reserved_views = (SELECT COUNT(1) FROM secret_views v WHERE v.secret_id = secrets.id);
@@ -0,0 +1 @@
+CREATE INDEX idx_secrets_created_at ON secrets (created_at);
@@ -0,0 +1 @@
+CREATE INDEX idx_secrets_creator_subject ON secrets (creator_subject, created_at) WHERE creator_subject IS NOT NULL;
@@ -0,0 +1,7 @@
+ALTER TABLE secrets ADD COLUMN expires_at NUMBER NOT NULL DEFAULT(0);
+
+UPDATE secrets SET expires_at = created_at + (ttl * 60 * 1000);
+
+DROP INDEX idx_secrets_alive_created_at_ttl_deleted_at;
+
+CREATE INDEX idx_secrets_alive_expires_at ON secrets (expires_at) WHERE deleted_at IS NULL;
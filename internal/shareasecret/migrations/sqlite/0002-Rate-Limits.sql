@@ -0,0 +1,11 @@
+CREATE TABLE rate_limits (
+    bucket       TEXT NOT NULL,
+    client_ip    TEXT NOT NULL,
+    tokens       REAL NOT NULL,
+    updated_at   NUMBER NOT NULL,
+    locked_until NUMBER NULL,
+
+    PRIMARY KEY (bucket, client_ip)
+);
+
+CREATE INDEX idx_rate_limits_updated_at ON rate_limits (updated_at);
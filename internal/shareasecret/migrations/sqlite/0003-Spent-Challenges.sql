@@ -0,0 +1,6 @@
+CREATE TABLE spent_challenges (
+    nonce      TEXT NOT NULL PRIMARY KEY,
+    expires_at NUMBER NOT NULL
+);
+
+CREATE INDEX idx_spent_challenges_expires_at ON spent_challenges (expires_at);
@@ -0,0 +1,12 @@
+CREATE TABLE invites (
+    id         TEXT NOT NULL PRIMARY KEY,
+    label      TEXT NOT NULL,
+    single_use NUMBER NOT NULL,
+    expires_at NUMBER NOT NULL,
+    used_at    NUMBER NULL,
+    created_at NUMBER NOT NULL
+);
+
+ALTER TABLE secrets ADD COLUMN invite_id TEXT NULL REFERENCES invites (id);
+
+CREATE INDEX idx_secrets_invite_id ON secrets (invite_id);
@@ -0,0 +1,2 @@
+ALTER TABLE secrets ADD COLUMN creator_subject TEXT NULL;
+ALTER TABLE secrets ADD COLUMN creator_email TEXT NULL;
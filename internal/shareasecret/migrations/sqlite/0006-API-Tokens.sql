@@ -0,0 +1,16 @@
+CREATE TABLE api_tokens (
+    id           TEXT NOT NULL PRIMARY KEY,
+    name         TEXT NOT NULL,
+    token_hash   TEXT NOT NULL,
+    scopes       TEXT NOT NULL,
+    expires_at   NUMBER NULL,
+    revoked_at   NUMBER NULL,
+    last_used_at NUMBER NULL,
+    created_at   NUMBER NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_api_tokens_token_hash ON api_tokens (token_hash);
+
+ALTER TABLE secrets ADD COLUMN api_token_id TEXT NULL REFERENCES api_tokens (id);
+
+CREATE INDEX idx_secrets_api_token_id ON secrets (api_token_id) WHERE api_token_id IS NOT NULL;
@@ -0,0 +1,26 @@
+CREATE TABLE accounts (
+    id                   TEXT NOT NULL PRIMARY KEY,
+    name                 TEXT NOT NULL,
+    enrolment_token_hash TEXT NULL,
+    enrolment_expires_at NUMBER NULL,
+    enrolled_at          NUMBER NULL,
+    removed_at           NUMBER NULL,
+    created_at           NUMBER NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_accounts_enrolment_token_hash ON accounts (enrolment_token_hash) WHERE enrolment_token_hash IS NOT NULL;
+
+CREATE TABLE passkeys (
+    id           TEXT NOT NULL PRIMARY KEY,
+    account_id   TEXT NOT NULL REFERENCES accounts (id),
+    public_key   BLOB NOT NULL,
+    sign_count   NUMBER NOT NULL,
+    last_used_at NUMBER NULL,
+    created_at   NUMBER NOT NULL
+);
+
+CREATE INDEX idx_passkeys_account_id ON passkeys (account_id);
+
+ALTER TABLE secrets ADD COLUMN creator_account_id TEXT NULL REFERENCES accounts (id);
+
+CREATE INDEX idx_secrets_creator_account_id ON secrets (creator_account_id) WHERE creator_account_id IS NOT NULL;
@@ -0,0 +1,27 @@
+CREATE TABLE organisations (
+    id              TEXT NOT NULL PRIMARY KEY,
+    name            TEXT NOT NULL,
+    ip_restrictions TEXT NOT NULL,
+    maximum_ttl     NUMBER NULL,
+    maximum_views   NUMBER NULL,
+    brand_message   TEXT NOT NULL,
+    brand_colour    TEXT NOT NULL,
+    removed_at      NUMBER NULL,
+    created_at      NUMBER NOT NULL
+);
+
+CREATE TABLE organisation_members (
+    id              INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    organisation_id TEXT NOT NULL REFERENCES organisations (id),
+    email           TEXT NULL,
+    account_id      TEXT NULL REFERENCES accounts (id),
+    role            TEXT NOT NULL,
+    created_at      NUMBER NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_organisation_members_email ON organisation_members (organisation_id, email) WHERE email IS NOT NULL;
+CREATE UNIQUE INDEX idx_organisation_members_account_id ON organisation_members (organisation_id, account_id) WHERE account_id IS NOT NULL;
+
+ALTER TABLE secrets ADD COLUMN organisation_id TEXT NULL REFERENCES organisations (id);
+
+CREATE INDEX idx_secrets_organisation_id ON secrets (organisation_id) WHERE organisation_id IS NOT NULL;
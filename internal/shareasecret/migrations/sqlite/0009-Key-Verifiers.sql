@@ -0,0 +1,3 @@
+ALTER TABLE secrets ADD COLUMN verifier_hash TEXT NULL;
+ALTER TABLE secrets ADD COLUMN maximum_key_attempts NUMBER NULL;
+ALTER TABLE secrets ADD COLUMN failed_key_attempts NUMBER NOT NULL DEFAULT(0);
@@ -0,0 +1,15 @@
+CREATE TABLE secret_attachments (
+    id             TEXT NOT NULL PRIMARY KEY,
+    secret_id      INTEGER NOT NULL REFERENCES secrets (id),
+    position       NUMBER NOT NULL,
+    encrypted_name TEXT NOT NULL,
+    size           NUMBER NOT NULL,
+    created_at     NUMBER NOT NULL
+);
+
+CREATE INDEX idx_secret_attachments_secret_id ON secret_attachments (secret_id);
+
+CREATE TABLE attachment_contents (
+    attachment_id TEXT NOT NULL PRIMARY KEY,
+    content       BLOB NOT NULL
+);
@@ -0,0 +1,16 @@
+CREATE TABLE uploads (
+    id            TEXT NOT NULL PRIMARY KEY,
+    length        NUMBER NOT NULL,
+    upload_offset NUMBER NOT NULL DEFAULT(0),
+    created_at    NUMBER NOT NULL,
+    expires_at    NUMBER NOT NULL
+);
+
+CREATE INDEX idx_uploads_expires_at ON uploads (expires_at);
+
+CREATE TABLE upload_chunks (
+    upload_id     TEXT NOT NULL REFERENCES uploads (id),
+    upload_offset NUMBER NOT NULL,
+    content       BLOB NOT NULL,
+    PRIMARY KEY (upload_id, upload_offset)
+);
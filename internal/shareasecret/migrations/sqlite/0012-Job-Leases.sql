@@ -0,0 +1,5 @@
+CREATE TABLE job_leases (
+    name       TEXT NOT NULL PRIMARY KEY,
+    holder     TEXT NOT NULL,
+    expires_at NUMBER NOT NULL
+);
@@ -0,0 +1,6 @@
+ALTER TABLE secret_views ADD COLUMN expires_at NUMBER NOT NULL DEFAULT(0);
+
+-- keys created before viewing keys could expire are given the default time to live from when they were created
+UPDATE secret_views SET expires_at = created_at + 600000;
+
+CREATE INDEX idx_secret_views_unused_expires_at ON secret_views (expires_at) WHERE viewed_at IS NULL;
@@ -0,0 +1,393 @@
+package shareasecret
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errNotAuthorised is returned when a user has successfully signed in, but is not permitted to create secrets
+var errNotAuthorised = errors.New("not authorised")
+
+// oidcProvider signs users in via an OpenID Connect provider using the authorization code flow with PKCE, and decides
+// whether they are permitted to create secrets based on the claims in their ID token
+type oidcProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+
+	// allowedEmailDomains and allowedGroups restrict who can create secrets. Users need only match one of them, and
+	// if neither are set everyone that can sign in is permitted.
+	allowedEmailDomains []string
+	allowedGroups       []string
+	groupsClaim         string
+
+	httpClient *http.Client
+
+	mu            sync.Mutex
+	metadata      *oidcMetadata
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// oidcMetadata is the subset of the provider's discovery document that is used
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// discover retrieves (and caches) the provider's discovery document
+func (p *oidcProvider) discover(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var m oidcMetadata
+	if err := p.getJSON(ctx, strings.TrimRight(p.issuer, "/")+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, fmt.Errorf("retrieving discovery document: %w", err)
+	}
+
+	if m.Issuer != p.issuer {
+		return nil, fmt.Errorf("discovery document issuer (%v) does not match configured issuer", m.Issuer)
+	}
+
+	p.metadata = &m
+
+	return p.metadata, nil
+}
+
+// authCodeURL returns the URL users are redirected to in order to sign in
+func (p *oidcProvider) authCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.clientID)
+	q.Set("redirect_uri", p.redirectURL)
+	q.Set("scope", strings.Join(p.scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return m.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// exchange exchanges an authorization code for the user's verified identity, returning [errNotAuthorised] if they are
+// not permitted to create secrets
+func (p *oidcProvider) exchange(ctx context.Context, code string, verifier string, nonce string) (identity, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return identity{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	form.Set("client_id", p.clientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return identity{}, fmt.Errorf("creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	res, err := p.httpClient.Do(req)
+	if err != nil {
+		return identity{}, fmt.Errorf("requesting token: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return identity{}, fmt.Errorf("requesting token: unexpected status code %d", res.StatusCode)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return identity{}, fmt.Errorf("decoding token response: %w", err)
+	} else if token.IDToken == "" {
+		return identity{}, errors.New("token response did not contain an id token")
+	}
+
+	claims, err := p.verifyIDToken(ctx, token.IDToken, nonce)
+	if err != nil {
+		return identity{}, fmt.Errorf("verifying id token: %w", err)
+	}
+
+	return p.authorise(claims)
+}
+
+// verifyIDToken verifies the signature and standard claims of an ID token, returning all of its claims
+func (p *oidcProvider) verifyIDToken(ctx context.Context, raw string, nonce string) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("decoding header: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decoding signature: %w", err)
+	}
+
+	key, err := p.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifySignature(header.Algorithm, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("decoding claims: %w", err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != p.issuer {
+		return nil, fmt.Errorf("unexpected issuer %v", iss)
+	}
+
+	if !audienceContains(claims["aud"], p.clientID) {
+		return nil, errors.New("token was not issued for this client")
+	}
+
+	if exp, _ := claims["exp"].(float64); time.Now().Unix() >= int64(exp) {
+		return nil, errors.New("token has expired")
+	}
+
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("unexpected nonce")
+	}
+
+	return claims, nil
+}
+
+// authorise decides whether the user identified by the claims is permitted to create secrets, returning
+// [errNotAuthorised] if not
+func (p *oidcProvider) authorise(claims map[string]any) (identity, error) {
+	user := identity{}
+	user.Subject, _ = claims["sub"].(string)
+	user.Email, _ = claims["email"].(string)
+	user.Name, _ = claims["name"].(string)
+
+	// unverified email addresses could belong to anyone, and providers that leave out the claim make no promise either
+	verified, _ := claims["email_verified"].(bool)
+	user.EmailVerified = user.Email != "" && verified
+
+	if user.Subject == "" {
+		return identity{}, errors.New("token does not identify a subject")
+	}
+
+	if len(p.allowedEmailDomains) == 0 && len(p.allowedGroups) == 0 {
+		return user, nil
+	}
+
+	if user.EmailVerified {
+		_, domain, _ := strings.Cut(user.Email, "@")
+		for _, d := range p.allowedEmailDomains {
+			if strings.EqualFold(d, domain) {
+				return user, nil
+			}
+		}
+	}
+
+	groups, _ := claims[p.groupsClaim].([]any)
+	for _, g := range groups {
+		if g, ok := g.(string); ok && slices.Contains(p.allowedGroups, g) {
+			return user, nil
+		}
+	}
+
+	return identity{}, errNotAuthorised
+}
+
+// key retrieves the provider's public key with the given identifier, refreshing the cached keys at most once a minute
+// so that rotated keys are picked up
+func (p *oidcProvider) key(ctx context.Context, id string) (crypto.PublicKey, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.keys[id]; ok {
+		return k, nil
+	}
+
+	if time.Since(p.keysFetchedAt) < time.Minute {
+		return nil, fmt.Errorf("unknown key %v", id)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			KeyID string `json:"kid"`
+			Type  string `json:"kty"`
+			Use   string `json:"use"`
+			N     string `json:"n"`
+			E     string `json:"e"`
+			Curve string `json:"crv"`
+			X     string `json:"x"`
+			Y     string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, m.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("retrieving keys: %w", err)
+	}
+
+	p.keys = map[string]crypto.PublicKey{}
+	p.keysFetchedAt = time.Now()
+
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch k.Type {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN == nil && errE == nil {
+				p.keys[k.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			}
+		case "EC":
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX == nil && errY == nil && k.Curve == "P-256" {
+				p.keys[k.KeyID] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			}
+		}
+	}
+
+	if k, ok := p.keys[id]; ok {
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("unknown key %v", id)
+}
+
+// getJSON retrieves and decodes a JSON document
+func (p *oidcProvider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// verifySignature verifies a JWS signature made with one of the algorithms OpenID Connect providers commonly use
+func verifySignature(algorithm string, key crypto.PublicKey, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if algorithm != "RS256" {
+			break
+		}
+
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("invalid signature")
+		}
+
+		return nil
+	case *ecdsa.PublicKey:
+		if algorithm != "ES256" || len(signature) != 64 {
+			break
+		}
+
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return errors.New("invalid signature")
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("unsupported algorithm %v", algorithm)
+}
+
+// decodeSegment decodes a base64 encoded JSON segment of a JWT
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// audienceContains identifies whether a token's aud claim (which can be a string or an array) contains the client
+func audienceContains(aud any, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []any:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
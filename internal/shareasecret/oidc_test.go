@@ -0,0 +1,391 @@
+package shareasecret
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOIDCSignIn(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer useTestOIDCProvider(issuer)()
+
+	t.Run("login redirects to the provider using pkce", func(t *testing.T) {
+		r := get(t, app.handleLogin, outsideRequester)
+		if r.statusCode != http.StatusSeeOther {
+			t.Fatalf("expected 303 status code, got %v", r.statusCode)
+		}
+
+		u, _ := url.Parse(r.headers.Get("Location"))
+		if !strings.HasPrefix(u.String(), issuer.server.URL+"/authorize") {
+			t.Errorf("expected redirect to the provider, got %v", u)
+		} else if u.Query().Get("code_challenge_method") != "S256" || u.Query().Get("code_challenge") == "" {
+			t.Errorf("expected pkce code challenge, got %v", u.RawQuery)
+		} else if u.Query().Get("redirect_uri") != app.baseURL+"/auth/callback" {
+			t.Errorf("unexpected redirect uri %v", u.Query().Get("redirect_uri"))
+		}
+	})
+
+	t.Run("signs in users from an allowed email domain", func(t *testing.T) {
+		cookies := signIn(t, issuer, map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": true})
+
+		r := get(t, app.handleGetIndex, withCookies(cookies))
+		if !strings.Contains(r.body, "alice@example.com") || !strings.Contains(r.body, `action="/auth/logout"`) {
+			t.Errorf("expected signed in user on index page")
+		}
+	})
+
+	t.Run("signs in users in an allowed group", func(t *testing.T) {
+		cookies := signIn(t, issuer, map[string]any{"sub": "bob", "email": "bob@elsewhere.com", "groups": []string{"secret-senders"}})
+
+		if r := get(t, app.handleGetIndex, withCookies(cookies)); !strings.Contains(r.body, "bob@elsewhere.com") {
+			t.Errorf("expected signed in user on index page")
+		}
+	})
+
+	t.Run("rejects users that are not allowed", func(t *testing.T) {
+		for _, claims := range []map[string]any{
+			{"sub": "mallory", "email": "mallory@elsewhere.com", "groups": []string{"everyone"}},
+			{"sub": "mallory", "email": "mallory@example.com", "email_verified": false},
+			{"sub": "mallory", "email": "mallory@example.com"},
+		} {
+			r := completeSignIn(t, issuer, claims)
+
+			if !responseIsRedirectTo(r, "/") {
+				t.Errorf("expected redirect to home page, got %v", r.statusCode)
+			} else if cookie(r.cookies, sessionCookieName) != nil {
+				t.Errorf("expected no session to be started")
+			} else if cookie(r.cookies, "flash_err") == nil {
+				t.Errorf("expected error flash message")
+			}
+		}
+	})
+
+	t.Run("rejects callbacks with an unexpected state", func(t *testing.T) {
+		login := get(t, app.handleLogin, outsideRequester)
+
+		r := get(t, app.handleAuthCallback, func(r *http.Request) {
+			outsideRequester(r)
+			withCookies(login.cookies)(r)
+			r.URL, _ = url.Parse("/auth/callback?code=abc&state=wrong")
+		})
+
+		if !responseIsRedirectTo(r, "/") || cookie(r.cookies, sessionCookieName) != nil {
+			t.Errorf("expected sign in to be rejected")
+		}
+	})
+
+	t.Run("rejects tampered sessions", func(t *testing.T) {
+		cookies := signIn(t, issuer, map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": true})
+
+		s := cookie(cookies, sessionCookieName)
+		s.Value = "a" + s.Value[1:]
+
+		if r := get(t, app.handleGetIndex, withCookies(cookies)); strings.Contains(r.body, "alice@example.com") {
+			t.Errorf("expected tampered session to be rejected")
+		}
+	})
+
+	t.Run("rejects expired sessions", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		app.setSignedCookie(
+			rec,
+			sessionCookieName,
+			session{identity: identity{Subject: "alice"}, ExpiresAt: time.Now().Add(-1 * time.Minute).Unix()},
+			time.Hour,
+		)
+
+		r := httptest.NewRequest("GET", "/", nil)
+		withCookies(rec.Result().Cookies())(r)
+
+		if app.signedInUser(r) != nil {
+			t.Errorf("expected expired session to be rejected")
+		}
+	})
+
+	t.Run("logout ends the session", func(t *testing.T) {
+		r := post(t, app.handleLogout, "", emptyRequestConfigurer)
+
+		if c := cookie(r.cookies, sessionCookieName); c == nil || c.MaxAge >= 0 {
+			t.Errorf("expected session cookie to be cleared")
+		}
+	})
+}
+
+func TestSecretCreationWithOIDC(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer useTestOIDCProvider(issuer)()
+
+	cookies := signIn(t, issuer, map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": true})
+
+	t.Run("index page offers sign in to outside ips", func(t *testing.T) {
+		if r := get(t, app.handleGetIndex, outsideRequester); !strings.Contains(r.body, `href="/auth/login"`) {
+			t.Errorf("expected sign in link in body")
+		}
+	})
+
+	t.Run("signed in users can create secrets from outside ips and are recorded as the creator", func(t *testing.T) {
+		r := post(t, app.handleCreateSecret, "encryptedSecret="+testEncryptedSecret+"&ttl=30&maxViews=1", func(r *http.Request) {
+			outsideRequester(r)
+			withCookies(cookies)(r)
+		})
+		if r.statusCode != http.StatusCreated {
+			t.Fatalf("expected 201 status code, got %v", r.statusCode)
+		}
+
+		var subject, email string
+		err := app.db.db.QueryRow(
+			"SELECT creator_subject, creator_email FROM secrets WHERE management_id = ?",
+			strings.TrimPrefix(r.headers.Get("Location"), "/manage-secret/"),
+		).Scan(&subject, &email)
+		if err != nil {
+			t.Fatalf("retrieving creator: %v", err)
+		}
+
+		if subject != "alice" || email != "alice@example.com" {
+			t.Errorf("expected alice to be recorded as the creator, got %v %v", subject, email)
+		}
+	})
+
+	t.Run("api accepts signed in users", func(t *testing.T) {
+		r := apiRequest(t, "POST", app.handleAPICreateSecret, `{"encryptedSecret":"`+testEncryptedSecret+`","ttl":30,"maxViews":1}`, func(r *http.Request) {
+			outsideRequester(r)
+			withCookies(cookies)(r)
+		})
+
+		if r.statusCode != http.StatusCreated {
+			t.Errorf("expected 201 status code, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("outside ips that are not signed in cannot create secrets", func(t *testing.T) {
+		r := post(t, app.handleCreateSecret, "encryptedSecret="+testEncryptedSecret+"&ttl=30&maxViews=1", outsideRequester)
+
+		if !responseIsRedirectTo(r, "/") {
+			t.Errorf("expected redirect to home page, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("allowed ips can still create secrets without signing in", func(t *testing.T) {
+		r := post(t, app.handleCreateSecret, "encryptedSecret="+testEncryptedSecret+"&ttl=30&maxViews=1", emptyRequestConfigurer)
+
+		if r.statusCode != http.StatusCreated {
+			t.Errorf("expected 201 status code, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("nobody can create secrets without signing in when no ips are allowed", func(t *testing.T) {
+		cidrs := app.config.SecretCreationRestrictions.IPAddresses.CIDRs
+		defer func() { app.config.SecretCreationRestrictions.IPAddresses.CIDRs = cidrs }()
+
+		app.config.SecretCreationRestrictions.IPAddresses.CIDRs = nil
+
+		r := post(t, app.handleCreateSecret, "encryptedSecret="+testEncryptedSecret+"&ttl=30&maxViews=1", emptyRequestConfigurer)
+		if !responseIsRedirectTo(r, "/") {
+			t.Errorf("expected redirect to home page, got %v", r.statusCode)
+		}
+
+		r = post(t, app.handleCreateSecret, "encryptedSecret="+testEncryptedSecret+"&ttl=30&maxViews=1", withCookies(cookies))
+		if r.statusCode != http.StatusCreated {
+			t.Errorf("expected 201 status code, got %v", r.statusCode)
+		}
+	})
+}
+
+// mockIssuer is an in-process OpenID Connect provider that issues RS256 signed ID tokens containing whatever claims
+// the test asks for
+type mockIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	claims map[string]any
+	codes  map[string]mockAuthorization
+}
+
+// mockAuthorization is what the mock issuer remembers about an authorization code until it is exchanged
+type mockAuthorization struct {
+	nonce     string
+	challenge string
+	claims    map[string]any
+}
+
+// newMockIssuer starts a mock OpenID Connect provider that is shut down once the test completes
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	m := &mockIssuer{key: key, codes: map[string]mockAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, oidcMetadata{
+			Issuer:                m.server.URL,
+			AuthorizationEndpoint: m.server.URL + "/authorize",
+			TokenEndpoint:         m.server.URL + "/token",
+			JWKSURI:               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		code, _ := secureID(16)
+
+		m.mu.Lock()
+		m.codes[code] = mockAuthorization{nonce: q.Get("nonce"), challenge: q.Get("code_challenge"), claims: m.claims}
+		m.mu.Unlock()
+
+		http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		m.mu.Lock()
+		a, ok := m.codes[r.Form.Get("code")]
+		delete(m.codes, r.Form.Get("code"))
+		m.mu.Unlock()
+
+		verifier := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != a.challenge {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := map[string]any{
+			"iss":   m.server.URL,
+			"aud":   r.Form.Get("client_id"),
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": a.nonce,
+		}
+		for k, v := range a.claims {
+			claims[k] = v
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"id_token": m.sign(claims)})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"keys": []map[string]string{{
+				"kid": "test",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+			}},
+		})
+	})
+
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+
+	return m
+}
+
+// sign creates an RS256 signed JWT containing the claims
+func (m *mockIssuer) sign(claims map[string]any) string {
+	h, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// useTestOIDCProvider configures the application to sign users in via the mock issuer, allowing users with an
+// example.com email address or in the secret-senders group to create secrets. The returned function restores the
+// application's previous configuration.
+func useTestOIDCProvider(m *mockIssuer) func() {
+	provider, duration := app.oidc, app.config.OIDC.SessionDuration
+
+	app.oidc = &oidcProvider{
+		issuer:              m.server.URL,
+		clientID:            "shareasecret",
+		redirectURL:         app.baseURL + "/auth/callback",
+		scopes:              []string{"openid", "email"},
+		allowedEmailDomains: []string{"example.com"},
+		allowedGroups:       []string{"secret-senders"},
+		groupsClaim:         "groups",
+		httpClient:          m.server.Client(),
+	}
+	app.config.OIDC.SessionDuration = time.Hour
+
+	return func() {
+		app.oidc, app.config.OIDC.SessionDuration = provider, duration
+	}
+}
+
+// completeSignIn signs in to the mock issuer as a user with the given claims, returning the response of the callback
+func completeSignIn(t *testing.T, m *mockIssuer, claims map[string]any) consumedResponse {
+	m.mu.Lock()
+	m.claims = claims
+	m.mu.Unlock()
+
+	login := get(t, app.handleLogin, outsideRequester)
+
+	client := m.server.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	res, err := client.Get(login.headers.Get("Location"))
+	if err != nil {
+		t.Fatalf("authorizing: %v", err)
+	}
+	res.Body.Close()
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parsing callback url: %v", err)
+	}
+
+	return get(t, app.handleAuthCallback, func(r *http.Request) {
+		outsideRequester(r)
+		withCookies(login.cookies)(r)
+		r.URL = callback
+	})
+}
+
+// signIn signs in as a user with the given claims, returning the cookies containing their session
+func signIn(t *testing.T, m *mockIssuer, claims map[string]any) []*http.Cookie {
+	r := completeSignIn(t, m, claims)
+
+	if cookie(r.cookies, sessionCookieName) == nil {
+		t.Fatalf("expected session cookie to be set")
+	}
+
+	return r.cookies
+}
+
+// withCookies returns a request configurer that adds the (non-expired) cookies to the request
+func withCookies(cookies []*http.Cookie) func(r *http.Request) {
+	return func(r *http.Request) {
+		for _, c := range cookies {
+			if c.MaxAge >= 0 {
+				r.AddCookie(c)
+			}
+		}
+	}
+}
+
+// cookie finds the cookie with the given name, or returns nil if it does not exist
+func cookie(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, c := range cookies {
+		if c.Name == name {
+			return c
+		}
+	}
+
+	return nil
+}
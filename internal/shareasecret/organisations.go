@@ -0,0 +1,690 @@
+package shareasecret
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Organisation member roles, which determine what a member can do
+const (
+	// OrganisationRoleMember permits the creation of secrets owned by the organisation
+	OrganisationRoleMember = "member"
+
+	// OrganisationRoleAdmin permits the creation of secrets owned by the organisation, and the listing and deletion of
+	// every secret it owns
+	OrganisationRoleAdmin = "admin"
+)
+
+const (
+	errInvalidOrganisationID      = validationError("Organisation identifiers must be between 1 and 40 lowercase letters, numbers or hyphens.")
+	errInvalidOrganisationName    = validationError("Organisation names must be between 1 and 200 characters.")
+	errInvalidOrganisationLimits  = validationError("Organisation TTL limits must be at least 1 minute, and view limits cannot be negative.")
+	errInvalidBrandColour         = validationError("Brand colours must be hexadecimal colours such as #1d4ed8.")
+	errInvalidBrandMessage        = validationError("Brand messages must be at most 500 characters.")
+	errOrganisationExists         = validationError("An organisation with that identifier already exists.")
+	errInvalidOrganisationMember  = validationError("Organisation members must be identified by either an email address or an account identifier.")
+	errInvalidOrganisationRole    = validationError("Organisation roles must be either member or admin.")
+	errOrganisationAccountMissing = validationError("The account does not exist or has been removed.")
+	errOrganisationForbidden      = validationError("The organisation does not exist or you are not permitted to create secrets for it.")
+)
+
+var (
+	// ErrOrganisationNotFound is returned when an organisation does not exist or has been removed
+	ErrOrganisationNotFound = errors.New("organisation not found")
+
+	// ErrOrganisationMemberNotFound is returned when removing a member from an organisation they are not a member of
+	ErrOrganisationMemberNotFound = errors.New("organisation member not found")
+)
+
+// organisationIDPattern matches valid organisation identifiers, which are used in URLs
+var organisationIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,39}$`)
+
+// brandColourPattern matches valid brand colours. They are written into a stylesheet, so nothing else is permitted.
+var brandColourPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// Organisation is a team that owns the secrets created for it, with its own rules on who can create them and what
+// they can be created with. Each organisation has its own (branded) page at /o/{id}.
+type Organisation struct {
+	ID   string
+	Name string
+
+	// IPRestrictions are the IP addresses and CIDRs permitted to create the organisation's secrets without signing in
+	// as one of its members
+	IPRestrictions []string
+
+	// MaxTTL and MaxViews limit the secrets created for the organisation, and are zero if unlimited
+	MaxTTL   time.Duration
+	MaxViews int
+
+	// BrandMessage is displayed on the organisation's page and BrandColour replaces the primary colour of its pages.
+	// Either can be empty.
+	BrandMessage string
+	BrandColour  string
+
+	CreatedAt time.Time
+}
+
+// validate ensures the organisation is valid, normalising its IP restrictions, returning a [validationError] if not
+func (o *Organisation) validate() error {
+	if !organisationIDPattern.MatchString(o.ID) {
+		return errInvalidOrganisationID
+	}
+
+	o.Name = strings.TrimSpace(o.Name)
+	if o.Name == "" || len(o.Name) > 200 {
+		return errInvalidOrganisationName
+	}
+
+	if (o.MaxTTL != 0 && o.MaxTTL < time.Minute) || o.MaxTTL < 0 || o.MaxViews < 0 {
+		return errInvalidOrganisationLimits
+	}
+
+	if o.BrandColour != "" && !brandColourPattern.MatchString(o.BrandColour) {
+		return errInvalidBrandColour
+	}
+
+	o.BrandMessage = strings.TrimSpace(o.BrandMessage)
+	if len(o.BrandMessage) > 500 {
+		return errInvalidBrandMessage
+	}
+
+	restrictions := []string{}
+	for _, v := range o.IPRestrictions {
+		networks, err := parseNetworks("IP restrictions", v)
+		if err != nil {
+			return validationError(fmt.Sprintf("Invalid IP address or CIDR (%v) in IP restrictions.", strings.TrimSpace(v)))
+		}
+
+		for _, nw := range networks {
+			restrictions = append(restrictions, nw.String())
+		}
+	}
+
+	o.IPRestrictions = restrictions
+
+	return nil
+}
+
+// permitsIP identifies whether the IP address is permitted to create the organisation's secrets without signing in
+func (o Organisation) permitsIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, v := range o.IPRestrictions {
+		if _, nw, err := net.ParseCIDR(v); err == nil && nw.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// permitsSecret ensures a secret with the given TTL (in minutes) and maximum views is within the organisation's limits,
+// returning a [validationError] if not
+func (o Organisation) permitsSecret(ttl int, maxViews int) error {
+	if o.MaxTTL > 0 && time.Duration(ttl)*time.Minute > o.MaxTTL {
+		return validationError(
+			fmt.Sprintf("Secrets created for %s must expire within %d minutes.", o.Name, int(o.MaxTTL/time.Minute)),
+		)
+	}
+
+	if o.MaxViews > 0 && (maxViews == 0 || maxViews > o.MaxViews) {
+		return validationError(fmt.Sprintf("Secrets created for %s can be viewed at most %d times.", o.Name, o.MaxViews))
+	}
+
+	return nil
+}
+
+// OrganisationMember is somebody permitted to create an organisation's secrets
+type OrganisationMember struct {
+	// Email identifies members that sign in via OpenID Connect, and only matches verified email addresses. AccountID
+	// identifies members that sign in with a passkey. Only one of them is set.
+	Email     string
+	AccountID string
+	Role      string
+	CreatedAt time.Time
+}
+
+// validate ensures the member is valid, normalising their email address, returning a [validationError] if not
+func (m *OrganisationMember) validate() error {
+	m.Email = strings.ToLower(strings.TrimSpace(m.Email))
+	m.AccountID = strings.TrimSpace(m.AccountID)
+
+	if (m.Email == "") == (m.AccountID == "") || (m.Email != "" && !strings.Contains(m.Email, "@")) {
+		return errInvalidOrganisationMember
+	}
+
+	if m.Role != OrganisationRoleMember && m.Role != OrganisationRoleAdmin {
+		return errInvalidOrganisationRole
+	}
+
+	return nil
+}
+
+// CreateOrganisation creates an organisation, returning it once its values have been normalised
+func (a *Application) CreateOrganisation(o Organisation) (Organisation, error) {
+	if err := o.validate(); err != nil {
+		return Organisation{}, err
+	}
+
+	o.CreatedAt = time.Now()
+
+	// removed organisations keep their identifiers, as the secrets they own still reference them
+	var exists int
+	if err := a.db.reader.QueryRow("SELECT COUNT(1) FROM organisations WHERE id = ?", o.ID).Scan(&exists); err != nil {
+		return Organisation{}, fmt.Errorf("checking organisation: %w", err)
+	} else if exists != 0 {
+		return Organisation{}, errOrganisationExists
+	}
+
+	_, err := a.db.db.Exec(
+		`
+			INSERT INTO
+				organisations (id, name, ip_restrictions, maximum_ttl, maximum_views, brand_message, brand_colour, created_at)
+			VALUES
+				(?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+		o.ID,
+		o.Name,
+		strings.Join(o.IPRestrictions, ","),
+		sql.NullInt64{Valid: o.MaxTTL > 0, Int64: int64(o.MaxTTL / time.Minute)},
+		sql.NullInt64{Valid: o.MaxViews > 0, Int64: int64(o.MaxViews)},
+		o.BrandMessage,
+		o.BrandColour,
+		o.CreatedAt.UnixMilli(),
+	)
+	if err != nil {
+		return Organisation{}, fmt.Errorf("inserting organisation: %w", err)
+	}
+
+	return o, nil
+}
+
+// UpdateOrganisation replaces the rules and branding of an existing organisation, returning [ErrOrganisationNotFound]
+// if it does not exist or has been removed. Secrets that have already been created are left as they are.
+func (a *Application) UpdateOrganisation(o Organisation) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+
+	rs, err := a.db.db.Exec(
+		`
+			UPDATE
+				organisations
+			SET
+				name = ?,
+				ip_restrictions = ?,
+				maximum_ttl = ?,
+				maximum_views = ?,
+				brand_message = ?,
+				brand_colour = ?
+			WHERE
+				id = ? AND
+				removed_at IS NULL
+		`,
+		o.Name,
+		strings.Join(o.IPRestrictions, ","),
+		sql.NullInt64{Valid: o.MaxTTL > 0, Int64: int64(o.MaxTTL / time.Minute)},
+		sql.NullInt64{Valid: o.MaxViews > 0, Int64: int64(o.MaxViews)},
+		o.BrandMessage,
+		o.BrandColour,
+		o.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("updating organisation: %w", err)
+	}
+
+	if rc, err := rs.RowsAffected(); err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	} else if rc == 0 {
+		return ErrOrganisationNotFound
+	}
+
+	return nil
+}
+
+// OrganisationByID retrieves the organisation with the given identifier, returning [ErrOrganisationNotFound] if it
+// does not exist or has been removed
+func (a *Application) OrganisationByID(id string) (Organisation, error) {
+	return a.db.organisationByID(id)
+}
+
+// Organisations retrieves every organisation that has not been removed, ordered by their identifiers
+func (a *Application) Organisations() ([]Organisation, error) {
+	rows, err := a.db.reader.Query(
+		`
+			SELECT
+				id,
+				name,
+				ip_restrictions,
+				maximum_ttl,
+				maximum_views,
+				brand_message,
+				brand_colour,
+				created_at
+			FROM
+				organisations
+			WHERE
+				removed_at IS NULL
+			ORDER BY
+				id
+		`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying organisations: %w", err)
+	}
+	defer rows.Close()
+
+	organisations := []Organisation{}
+	for rows.Next() {
+		o, err := scanOrganisation(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		organisations = append(organisations, o)
+	}
+
+	return organisations, rows.Err()
+}
+
+// RemoveOrganisation removes an organisation and its members. Secrets owned by the organisation are left as they are,
+// so can still be viewed and managed by those holding their links. [ErrOrganisationNotFound] is returned if there was
+// nothing to remove.
+func (a *Application) RemoveOrganisation(id string) error {
+	tx, err := a.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	rs, err := tx.Exec(
+		"UPDATE organisations SET removed_at = ? WHERE id = ? AND removed_at IS NULL",
+		time.Now().UnixMilli(),
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("removing organisation: %w", err)
+	}
+
+	if rc, err := rs.RowsAffected(); err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	} else if rc == 0 {
+		return ErrOrganisationNotFound
+	}
+
+	if _, err := tx.Exec("DELETE FROM organisation_members WHERE organisation_id = ?", id); err != nil {
+		return fmt.Errorf("deleting members: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// AddOrganisationMember adds a member to an organisation, changing their role if they are already a member
+func (a *Application) AddOrganisationMember(organisationID string, m OrganisationMember) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	if _, err := a.db.organisationByID(organisationID); err != nil {
+		return err
+	}
+
+	if m.AccountID != "" && !a.db.accountActive(m.AccountID) {
+		return errOrganisationAccountMissing
+	}
+
+	tx, err := a.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	condition, arg := m.condition()
+
+	rs, err := tx.Exec("UPDATE organisation_members SET role = ? WHERE organisation_id = ? AND "+condition, m.Role, organisationID, arg)
+	if err != nil {
+		return fmt.Errorf("updating member: %w", err)
+	}
+
+	if rc, err := rs.RowsAffected(); err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	} else if rc == 0 {
+		_, err := tx.Exec(
+			`
+				INSERT INTO
+					organisation_members (organisation_id, email, account_id, role, created_at)
+				VALUES
+					(?, ?, ?, ?, ?)
+			`,
+			organisationID,
+			sql.NullString{Valid: m.Email != "", String: m.Email},
+			sql.NullString{Valid: m.AccountID != "", String: m.AccountID},
+			m.Role,
+			time.Now().UnixMilli(),
+		)
+		if err != nil {
+			return fmt.Errorf("inserting member: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RemoveOrganisationMember removes a member from an organisation, returning [ErrOrganisationMemberNotFound] if they
+// were not a member of it
+func (a *Application) RemoveOrganisationMember(organisationID string, m OrganisationMember) error {
+	m.Role = OrganisationRoleMember
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	condition, arg := m.condition()
+
+	rs, err := a.db.db.Exec("DELETE FROM organisation_members WHERE organisation_id = ? AND "+condition, organisationID, arg)
+	if err != nil {
+		return fmt.Errorf("removing member: %w", err)
+	}
+
+	if rc, err := rs.RowsAffected(); err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	} else if rc == 0 {
+		return ErrOrganisationMemberNotFound
+	}
+
+	return nil
+}
+
+// OrganisationMembers retrieves every member of an organisation, ordered by when they were added
+func (a *Application) OrganisationMembers(organisationID string) ([]OrganisationMember, error) {
+	rows, err := a.db.reader.Query(
+		`
+			SELECT
+				email,
+				account_id,
+				role,
+				created_at
+			FROM
+				organisation_members
+			WHERE
+				organisation_id = ?
+			ORDER BY
+				created_at,
+				id
+		`,
+		organisationID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying members: %w", err)
+	}
+	defer rows.Close()
+
+	members := []OrganisationMember{}
+	for rows.Next() {
+		var m OrganisationMember
+		var email, accountID sql.NullString
+		var createdAt int64
+
+		if err := rows.Scan(&email, &accountID, &m.Role, &createdAt); err != nil {
+			return nil, err
+		}
+
+		m.Email = email.String
+		m.AccountID = accountID.String
+		m.CreatedAt = time.UnixMilli(createdAt)
+
+		members = append(members, m)
+	}
+
+	return members, rows.Err()
+}
+
+// condition returns the SQL condition the member's row matches
+func (m OrganisationMember) condition() (string, any) {
+	if m.AccountID != "" {
+		return "account_id = ?", m.AccountID
+	}
+
+	return "email = ?", m.Email
+}
+
+// organisationByID retrieves the organisation with the given identifier, returning [ErrOrganisationNotFound] if it
+// does not exist or has been removed
+func (d *database) organisationByID(id string) (Organisation, error) {
+	o, err := scanOrganisation(d.reader.QueryRow(
+		`
+			SELECT
+				id,
+				name,
+				ip_restrictions,
+				maximum_ttl,
+				maximum_views,
+				brand_message,
+				brand_colour,
+				created_at
+			FROM
+				organisations
+			WHERE
+				id = ? AND
+				removed_at IS NULL
+		`,
+		id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Organisation{}, ErrOrganisationNotFound
+	}
+
+	return o, err
+}
+
+// scanOrganisation scans a row containing all of an organisation's columns other than when it was removed
+func scanOrganisation(row interface{ Scan(...any) error }) (Organisation, error) {
+	var o Organisation
+	var ipRestrictions string
+	var maxTTL, maxViews sql.NullInt64
+	var createdAt int64
+
+	err := row.Scan(&o.ID, &o.Name, &ipRestrictions, &maxTTL, &maxViews, &o.BrandMessage, &o.BrandColour, &createdAt)
+	if err != nil {
+		return Organisation{}, err
+	}
+
+	o.IPRestrictions = splitList(ipRestrictions, ",")
+	o.MaxTTL = time.Duration(maxTTL.Int64) * time.Minute
+	o.MaxViews = int(maxViews.Int64)
+	o.CreatedAt = time.UnixMilli(createdAt)
+
+	return o, nil
+}
+
+// organisationRole retrieves the role the user has in the organisation, or an empty string if they are not a member.
+// Users signed in via OpenID Connect are matched by their email address, but only if it has been verified.
+func (d *database) organisationRole(organisationID string, user identity) (string, error) {
+	var email sql.NullString
+	if user.AccountID == "" && user.EmailVerified {
+		email = sql.NullString{Valid: true, String: strings.ToLower(user.Email)}
+	}
+
+	accountID := sql.NullString{Valid: user.AccountID != "", String: user.AccountID}
+
+	var role string
+	err := d.reader.QueryRow(
+		`
+			SELECT
+				role
+			FROM
+				organisation_members
+			WHERE
+				organisation_id = ? AND
+				(email = ? OR account_id = ?)
+			ORDER BY
+				role = 'admin' DESC
+			LIMIT 1
+		`,
+		organisationID,
+		email,
+		accountID,
+	).Scan(&role)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+
+	return role, err
+}
+
+// requesterCanCreateOrganisationSecret identifies whether the requester is permitted to create secrets owned by the
+// organisation with the given identifier, either by being signed in as one of its members or by their IP address.
+// The organisation is returned alongside the signed in user (if there is one) so that the secrets they create are
+// attributed to both. Organisations that do not exist are treated the same as those the requester cannot use.
+func (a *Application) requesterCanCreateOrganisationSecret(r *http.Request, id string) (*Organisation, *identity, bool) {
+	l := zerolog.Ctx(r.Context()).With().Str("organisation_id", id).Logger()
+
+	org, err := a.db.organisationByID(id)
+	if err != nil {
+		if !errors.Is(err, ErrOrganisationNotFound) {
+			l.Err(err).Msg("retrieving organisation")
+		}
+
+		return nil, nil, false
+	}
+
+	user := a.signedInUser(r)
+	if user != nil {
+		role, err := a.db.organisationRole(org.ID, *user)
+		if err != nil {
+			l.Err(err).Msg("retrieving organisation role")
+			return nil, nil, false
+		} else if role != "" {
+			return &org, user, true
+		}
+	}
+
+	return &org, user, org.permitsIP(a.clientIP(r))
+}
+
+// organisationAdmin retrieves the organisation named in the request's path and the signed in user, provided they are
+// one of its administrators. Otherwise the visitor is redirected and false is returned.
+func (a *Application) organisationAdmin(w http.ResponseWriter, r *http.Request) (Organisation, *identity, bool) {
+	org, err := a.db.organisationByID(r.PathValue("organisation"))
+	if errors.Is(err, ErrOrganisationNotFound) {
+		setFlashErr("Organisation does not exist.", w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return Organisation{}, nil, false
+	} else if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("retrieving organisation")
+		redirectToOopsPage(w, r)
+		return Organisation{}, nil, false
+	}
+
+	user := a.signedInUser(r)
+	if user == nil {
+		setFlashErr(fmt.Sprintf("Sign in to see the secrets owned by %s.", org.Name), w)
+		http.Redirect(w, r, "/o/"+org.ID, http.StatusSeeOther)
+		return Organisation{}, nil, false
+	}
+
+	role, err := a.db.organisationRole(org.ID, *user)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Str("organisation_id", org.ID).Msg("retrieving organisation role")
+		redirectToOopsPage(w, r)
+		return Organisation{}, nil, false
+	} else if role != OrganisationRoleAdmin {
+		setFlashErr(fmt.Sprintf("Only administrators of %s can see the secrets it owns.", org.Name), w)
+		http.Redirect(w, r, "/o/"+org.ID, http.StatusSeeOther)
+		return Organisation{}, nil, false
+	}
+
+	return org, user, true
+}
+
+// handleGetOrganisation renders the (branded) root page of an organisation, where those permitted to can create
+// secrets owned by it
+func (a *Application) handleGetOrganisation(w http.ResponseWriter, r *http.Request) {
+	org, user, canCreate := a.requesterCanCreateOrganisationSecret(r, r.PathValue("organisation"))
+	if org == nil {
+		setFlashErr("Organisation does not exist.", w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	p := indexPage{
+		restricted:        !canCreate,
+		signInAvailable:   a.oidc != nil,
+		passkeysAvailable: a.passkeys != nil,
+		user:              user,
+		organisation:      org,
+		policy:            a.policy,
+	}
+	if canCreate {
+		p.challenge = a.issueChallenge(r)
+	}
+
+	if user != nil {
+		role, err := a.db.organisationRole(org.ID, *user)
+		if err != nil {
+			zerolog.Ctx(r.Context()).Err(err).Str("organisation_id", org.ID).Msg("retrieving organisation role")
+		}
+
+		p.organisationAdmin = role == OrganisationRoleAdmin
+	}
+
+	pageIndex(notificationsFromRequest(r, w), p).Render(r.Context(), w)
+}
+
+// handleOrganisationTheme serves the stylesheet that applies an organisation's brand colour to its pages. Inline
+// styles are not permitted by the content security policy, hence it being served separately.
+func (a *Application) handleOrganisationTheme(w http.ResponseWriter, r *http.Request) {
+	org, err := a.db.organisationByID(r.PathValue("organisation"))
+	if errors.Is(err, ErrOrganisationNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("retrieving organisation")
+		internalServerError(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if org.BrandColour != "" {
+		fmt.Fprintf(
+			w,
+			":root, [data-theme=light] {\n  --pico-primary: %[1]s;\n  --pico-primary-background: %[1]s;\n  --pico-primary-border: %[1]s;\n  --pico-primary-hover: %[1]s;\n  --pico-primary-hover-background: %[1]s;\n  --pico-primary-hover-border: %[1]s;\n}\n",
+			org.BrandColour,
+		)
+	}
+}
+
+// handleOrganisationSecrets renders the list of secrets owned by an organisation to one of its administrators
+func (a *Application) handleOrganisationSecrets(w http.ResponseWriter, r *http.Request) {
+	org, user, ok := a.organisationAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	a.renderSecrets(w, r, secretCreator{organisationID: org.ID}, mySecretsPage{user: user, organisation: &org})
+}
+
+// handleDeleteOrganisationSecrets deletes the selected secrets owned by an organisation, or all of its active secrets,
+// on behalf of one of its administrators
+func (a *Application) handleDeleteOrganisationSecrets(w http.ResponseWriter, r *http.Request) {
+	org, user, ok := a.organisationAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	a.deleteSecrets(w, r, secretCreator{organisationID: org.ID}, mySecretsPage{user: user, organisation: &org})
+}
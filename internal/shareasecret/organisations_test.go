@@ -0,0 +1,356 @@
+package shareasecret
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateOrganisation(t *testing.T) {
+	t.Run("validates organisations", func(t *testing.T) {
+		cases := map[string]Organisation{
+			"invalid id":             {ID: "Not A Slug", Name: "Finance"},
+			"empty name":             {ID: "org-empty-name", Name: " "},
+			"negative view limit":    {ID: "org-negative", Name: "Finance", MaxViews: -1},
+			"sub minute ttl limit":   {ID: "org-seconds", Name: "Finance", MaxTTL: time.Second},
+			"invalid brand colour":   {ID: "org-colour", Name: "Finance", BrandColour: "red; background: url(x)"},
+			"invalid ip restriction": {ID: "org-ip", Name: "Finance", IPRestrictions: []string{"10.0.0.0/33"}},
+		}
+
+		for n, o := range cases {
+			var ve validationError
+			if _, err := app.CreateOrganisation(o); !errors.As(err, &ve) {
+				t.Errorf("%v: expected validation error, got %v", n, err)
+			}
+		}
+	})
+
+	t.Run("normalises ip restrictions", func(t *testing.T) {
+		o, err := app.CreateOrganisation(Organisation{
+			ID:             "org-normalised",
+			Name:           "Finance",
+			IPRestrictions: []string{" 10.1.0.0/16", "192.0.2.4"},
+		})
+		if err != nil {
+			t.Fatalf("creating organisation: %v", err)
+		}
+
+		if strings.Join(o.IPRestrictions, ",") != "10.1.0.0/16,192.0.2.4/32" {
+			t.Errorf("unexpected ip restrictions %v", o.IPRestrictions)
+		}
+	})
+
+	t.Run("identifiers cannot be reused", func(t *testing.T) {
+		createOrganisation(t, Organisation{ID: "org-reused", Name: "Finance"})
+
+		if err := app.RemoveOrganisation("org-reused"); err != nil {
+			t.Fatalf("removing organisation: %v", err)
+		}
+
+		if _, err := app.CreateOrganisation(Organisation{ID: "org-reused", Name: "Finance"}); !errors.Is(err, errOrganisationExists) {
+			t.Errorf("expected errOrganisationExists, got %v", err)
+		}
+	})
+}
+
+func TestOrganisationSecretCreation(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer useTestOIDCProvider(issuer)()
+
+	org := createOrganisation(t, Organisation{
+		ID:             "org-creation",
+		Name:           "Engineering",
+		IPRestrictions: []string{"198.51.100.0/24"},
+		MaxTTL:         3 * time.Hour,
+		MaxViews:       2,
+	})
+	addMember(t, org.ID, OrganisationMember{Email: "Erin@example.com", Role: OrganisationRoleMember})
+
+	member := signIn(t, issuer, map[string]any{"sub": "org-erin", "email": "erin@example.com", "email_verified": true})
+	outsider := signIn(t, issuer, map[string]any{"sub": "org-frank", "email": "frank@example.com", "email_verified": true})
+	unverified := signIn(t, issuer, map[string]any{"sub": "org-impostor", "email": "erin@example.com", "email_verified": false, "groups": []any{"secret-senders"}})
+	unclaimed := signIn(t, issuer, map[string]any{"sub": "org-unclaimed", "email": "erin@example.com", "groups": []any{"secret-senders"}})
+
+	form := "encryptedSecret=" + testEncryptedSecret + "&ttl=60&maxViews=1&organisation=" + org.ID
+
+	t.Run("members can create the organisation's secrets", func(t *testing.T) {
+		r := post(t, app.handleCreateSecret, form, func(r *http.Request) {
+			outsideRequester(r)
+			withCookies(member)(r)
+		})
+		if r.statusCode != http.StatusCreated {
+			t.Fatalf("expected 201 status code, got %v: %v", r.statusCode, r.body)
+		}
+
+		var organisationID, creatorSubject string
+		err := app.db.db.QueryRow(
+			"SELECT organisation_id, creator_subject FROM secrets WHERE management_id = ?",
+			strings.TrimPrefix(r.headers.Get("Location"), "/manage-secret/"),
+		).Scan(&organisationID, &creatorSubject)
+		if err != nil || organisationID != org.ID || creatorSubject != "org-erin" {
+			t.Errorf("expected secret to be owned by the organisation, got %v %v %v", organisationID, creatorSubject, err)
+		}
+	})
+
+	t.Run("others cannot create the organisation's secrets", func(t *testing.T) {
+		cases := map[string][]*http.Cookie{
+			"a non-member":         outsider,
+			"an unverified email":  unverified,
+			"an unclaimed email":   unclaimed,
+			"an anonymous visitor": nil,
+		}
+
+		for n, cookies := range cases {
+			r := post(t, app.handleCreateSecret, form, func(r *http.Request) {
+				outsideRequester(r)
+				withCookies(cookies)(r)
+			})
+			if r.statusCode != http.StatusBadRequest || r.body != errOrganisationForbidden.Error() {
+				t.Errorf("%v: expected to be forbidden, got %v %v", n, r.statusCode, r.body)
+			}
+		}
+	})
+
+	t.Run("permitted ip addresses can create the organisation's secrets", func(t *testing.T) {
+		r := post(t, app.handleCreateSecret, form, func(r *http.Request) {
+			r.Header.Set("X-Forwarded-For", "198.51.100.7")
+		})
+		if r.statusCode != http.StatusCreated {
+			t.Errorf("expected 201 status code, got %v: %v", r.statusCode, r.body)
+		}
+	})
+
+	t.Run("instance restrictions do not permit the organisation's secrets to be created", func(t *testing.T) {
+		r := post(t, app.handleCreateSecret, form, emptyRequestConfigurer)
+		if r.statusCode != http.StatusBadRequest {
+			t.Errorf("expected 400 status code, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("enforces the organisation's limits", func(t *testing.T) {
+		cases := map[string]string{
+			"ttl":       "encryptedSecret=" + testEncryptedSecret + "&ttl=720&maxViews=1",
+			"max views": "encryptedSecret=" + testEncryptedSecret + "&ttl=60&maxViews=3",
+			"unlimited": "encryptedSecret=" + testEncryptedSecret + "&ttl=60&maxViews=0",
+		}
+
+		for n, body := range cases {
+			r := post(t, app.handleCreateSecret, body+"&organisation="+org.ID, withCookies(member))
+			if r.statusCode != http.StatusBadRequest || !strings.Contains(r.body, "Engineering") {
+				t.Errorf("%v: expected the limit to be enforced, got %v %v", n, r.statusCode, r.body)
+			}
+		}
+	})
+
+	t.Run("removed organisations cannot be used", func(t *testing.T) {
+		removed := createOrganisation(t, Organisation{ID: "org-creation-removed", Name: "Removed"})
+		addMember(t, removed.ID, OrganisationMember{Email: "erin@example.com", Role: OrganisationRoleAdmin})
+
+		if err := app.RemoveOrganisation(removed.ID); err != nil {
+			t.Fatalf("removing organisation: %v", err)
+		}
+
+		r := post(t, app.handleCreateSecret, "encryptedSecret="+testEncryptedSecret+"&ttl=60&maxViews=1&organisation="+removed.ID, withCookies(member))
+		if r.statusCode != http.StatusBadRequest {
+			t.Errorf("expected 400 status code, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("creates the organisation's secrets via the api", func(t *testing.T) {
+		body := `{"encryptedSecret": "` + testEncryptedSecret + `", "ttl": 60, "maxViews": 1, "organisation": "org-creation"}`
+
+		if r := apiRequest(t, "POST", app.handleAPICreateSecret, body, withCookies(member)); r.statusCode != http.StatusCreated {
+			t.Errorf("expected 201 status code, got %v: %v", r.statusCode, r.body)
+		}
+
+		r := apiRequest(t, "POST", app.handleAPICreateSecret, body, func(r *http.Request) {
+			outsideRequester(r)
+			withCookies(outsider)(r)
+		})
+		if r.statusCode != http.StatusForbidden {
+			t.Errorf("expected 403 status code, got %v", r.statusCode)
+		}
+	})
+}
+
+func TestOrganisationPages(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer useTestOIDCProvider(issuer)()
+
+	org := createOrganisation(t, Organisation{
+		ID:           "org-pages",
+		Name:         "Legal",
+		MaxTTL:       90 * time.Minute,
+		BrandMessage: "Share contracts securely.",
+		BrandColour:  "#1d4ed8",
+	})
+	other := createOrganisation(t, Organisation{ID: "org-pages-other", Name: "Sales"})
+
+	addMember(t, org.ID, OrganisationMember{Email: "grace@example.com", Role: OrganisationRoleAdmin})
+	addMember(t, org.ID, OrganisationMember{Email: "heidi@example.com", Role: OrganisationRoleMember})
+	addMember(t, other.ID, OrganisationMember{Email: "grace@example.com", Role: OrganisationRoleMember})
+
+	admin := signIn(t, issuer, map[string]any{"sub": "org-grace", "email": "grace@example.com", "email_verified": true})
+	member := signIn(t, issuer, map[string]any{"sub": "org-heidi", "email": "heidi@example.com", "email_verified": true})
+
+	ours := createSecretFor(t, org.ID)
+	theirs := createSecretFor(t, other.ID)
+
+	t.Run("renders the organisation's branded page", func(t *testing.T) {
+		r := get(t, app.handleGetOrganisation, func(r *http.Request) {
+			withPathValue("organisation", org.ID)(r)
+			withCookies(member)(r)
+		})
+
+		for _, expected := range []string{"Share contracts securely.", "/o/org-pages/theme.css", `data-organisation="org-pages"`, `value="60"`} {
+			if !strings.Contains(r.body, expected) {
+				t.Errorf("expected body to contain %v", expected)
+			}
+		}
+
+		if strings.Contains(r.body, `value="180"`) || strings.Contains(r.body, "/o/org-pages/secrets") {
+			t.Errorf("expected ttls over the limit and the administrator link to be hidden")
+		}
+	})
+
+	t.Run("serves the organisation's theme", func(t *testing.T) {
+		r := get(t, app.handleOrganisationTheme, withPathValue("organisation", org.ID))
+		if !strings.Contains(r.body, "--pico-primary: #1d4ed8;") || r.headers.Get("Content-Type") != "text/css; charset=utf-8" {
+			t.Errorf("unexpected theme %v", r.body)
+		}
+	})
+
+	t.Run("administrators see the organisation's secrets", func(t *testing.T) {
+		r := get(t, app.handleOrganisationSecrets, func(r *http.Request) {
+			withPathValue("organisation", org.ID)(r)
+			withCookies(admin)(r)
+		})
+
+		if r.statusCode != http.StatusOK || !strings.Contains(r.body, ours) || strings.Contains(r.body, theirs) {
+			t.Errorf("expected only the organisation's secrets to be listed, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("members and non-members cannot see the organisation's secrets", func(t *testing.T) {
+		cases := map[string]func(r *http.Request){
+			"member":        withCookies(member),
+			"anonymous":     emptyRequestConfigurer,
+			"other members": withPathValue("organisation", other.ID),
+		}
+
+		for n, configure := range cases {
+			r := get(t, app.handleOrganisationSecrets, func(r *http.Request) {
+				withPathValue("organisation", org.ID)(r)
+				if n == "other members" {
+					withCookies(admin)(r)
+				}
+				configure(r)
+			})
+
+			if r.statusCode != http.StatusSeeOther || strings.Contains(r.body, ours) || strings.Contains(r.body, theirs) {
+				t.Errorf("%v: expected to be redirected, got %v", n, r.statusCode)
+			}
+		}
+	})
+
+	t.Run("administrators only delete the organisation's secrets", func(t *testing.T) {
+		r := post(t, app.handleDeleteOrganisationSecrets, "accessID="+ours+"&accessID="+theirs, func(r *http.Request) {
+			withPathValue("organisation", org.ID)(r)
+			withCookies(admin)(r)
+		})
+		if !responseIsRedirectTo(r, "/o/org-pages/secrets") {
+			t.Fatalf("expected redirect to the organisation's secrets, got %v", r.headers.Get("Location"))
+		}
+
+		if err := app.db.secretExists(ours); !errors.Is(err, errSecretNotFound) {
+			t.Errorf("expected the organisation's secret to be deleted, got %v", err)
+		}
+
+		if err := app.db.secretExists(theirs); err != nil {
+			t.Errorf("expected another organisation's secret to remain, got %v", err)
+		}
+	})
+}
+
+func TestOrganisationMembers(t *testing.T) {
+	org := createOrganisation(t, Organisation{ID: "org-members", Name: "Support"})
+
+	t.Run("validates members", func(t *testing.T) {
+		cases := map[string]OrganisationMember{
+			"neither identifier": {Role: OrganisationRoleMember},
+			"both identifiers":   {Email: "a@example.com", AccountID: "abc", Role: OrganisationRoleMember},
+			"invalid role":       {Email: "a@example.com", Role: "owner"},
+			"missing account":    {AccountID: "does-not-exist", Role: OrganisationRoleMember},
+		}
+
+		for n, m := range cases {
+			var ve validationError
+			if err := app.AddOrganisationMember(org.ID, m); !errors.As(err, &ve) {
+				t.Errorf("%v: expected validation error, got %v", n, err)
+			}
+		}
+
+		if err := app.AddOrganisationMember("org-missing", OrganisationMember{Email: "a@example.com", Role: OrganisationRoleMember}); !errors.Is(err, ErrOrganisationNotFound) {
+			t.Errorf("expected ErrOrganisationNotFound, got %v", err)
+		}
+	})
+
+	t.Run("changes the role of existing members", func(t *testing.T) {
+		addMember(t, org.ID, OrganisationMember{Email: "ivan@example.com", Role: OrganisationRoleMember})
+		addMember(t, org.ID, OrganisationMember{Email: "IVAN@example.com", Role: OrganisationRoleAdmin})
+
+		members, err := app.OrganisationMembers(org.ID)
+		if err != nil || len(members) != 1 || members[0].Role != OrganisationRoleAdmin {
+			t.Errorf("expected a single administrator, got %+v %v", members, err)
+		}
+
+		role, _ := app.db.organisationRole(org.ID, identity{Subject: "ivan", Email: "Ivan@Example.com", EmailVerified: true})
+		if role != OrganisationRoleAdmin {
+			t.Errorf("expected ivan to be an administrator, got %v", role)
+		}
+	})
+
+	t.Run("removes members", func(t *testing.T) {
+		m := OrganisationMember{Email: "judy@example.com", Role: OrganisationRoleMember}
+		addMember(t, org.ID, m)
+
+		if err := app.RemoveOrganisationMember(org.ID, m); err != nil {
+			t.Fatalf("removing member: %v", err)
+		}
+
+		if err := app.RemoveOrganisationMember(org.ID, m); !errors.Is(err, ErrOrganisationMemberNotFound) {
+			t.Errorf("expected ErrOrganisationMemberNotFound, got %v", err)
+		}
+	})
+}
+
+// createOrganisation creates an organisation, failing the test if it cannot be
+func createOrganisation(t *testing.T, o Organisation) Organisation {
+	o, err := app.CreateOrganisation(o)
+	if err != nil {
+		t.Fatalf("creating organisation: %v", err)
+	}
+
+	return o
+}
+
+// addMember adds a member to the organisation, failing the test if they cannot be
+func addMember(t *testing.T, organisationID string, m OrganisationMember) {
+	if err := app.AddOrganisationMember(organisationID, m); err != nil {
+		t.Fatalf("adding member: %v", err)
+	}
+}
+
+// createSecretFor creates a secret instance in the database owned by the organisation, returning its access
+// identifier
+func createSecretFor(t *testing.T, organisationID string) string {
+	accessID, _ := createSecret(t, time.Time{}, "")
+
+	if _, err := app.db.db.Exec("UPDATE secrets SET organisation_id = ? WHERE access_id = ?", organisationID, accessID); err != nil {
+		t.Errorf("setting organisation: %v", err)
+	}
+
+	return accessID
+}
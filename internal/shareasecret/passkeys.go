@@ -0,0 +1,583 @@
+package shareasecret
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// ceremonyCookieName is the name of the cookie containing the challenge of a passkey registration or sign in that is
+// in progress
+const ceremonyCookieName = "shareasecret_passkey"
+
+// ceremonyDuration is how long visitors have to complete a passkey registration or sign in once it has begun
+const ceremonyDuration = 5 * time.Minute
+
+const (
+	errInvalidAccountName  = validationError("Account names must be between 1 and 200 characters.")
+	errInvalidEnrolmentTTL = validationError("Enrolment links must expire in the future.")
+	errInvalidEnrolment    = validationError("This enrolment link is invalid, has expired or has already been used.")
+	errPasskeyNotVerified  = validationError("Unable to verify your passkey. Please try again.")
+)
+
+var (
+	// ErrAccountNotFound is returned when removing an account that does not exist or has already been removed
+	ErrAccountNotFound = errors.New("account not found")
+
+	// ErrPasskeysDisabled is returned when inviting an account whilst passkeys are disabled, as the enrolment link
+	// would not work
+	ErrPasskeysDisabled = errors.New("passkeys are not enabled")
+
+	// errPasskeyNotFound is returned when a passkey does not exist or belongs to an account that has been removed
+	errPasskeyNotFound = errors.New("passkey not found")
+)
+
+// Account is a person who signs in with a passkey to create secrets. Accounts are created by inviting someone to
+// enrol, and can only be used once they have registered a passkey via the enrolment link.
+type Account struct {
+	ID   string
+	Name string
+
+	// EnrolledAt is zero until a passkey has been registered, before which EnrolmentExpiresAt is set
+	EnrolledAt         time.Time
+	EnrolmentExpiresAt time.Time
+	LastSignedInAt     time.Time
+	CreatedAt          time.Time
+}
+
+// ceremony is stored, signed, in the ceremony cookie whilst a visitor registers or signs in with a passkey
+type ceremony struct {
+	Challenge string `json:"challenge"`
+
+	// AccountID is only set when registering a passkey, and is the account being enrolled
+	AccountID string `json:"acc,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// storedPasskey is a registered passkey alongside the account it belongs to
+type storedPasskey struct {
+	webauthnCredential
+	account identity
+}
+
+// InviteAccount creates an account for someone with the given name, returning the link they must visit within the TTL
+// to register a passkey for it. The link cannot be retrieved again.
+func (a *Application) InviteAccount(name string, ttl time.Duration) (Account, string, error) {
+	if a.passkeys == nil {
+		return Account{}, "", ErrPasskeysDisabled
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 200 {
+		return Account{}, "", errInvalidAccountName
+	}
+
+	if ttl <= 0 {
+		return Account{}, "", errInvalidEnrolmentTTL
+	}
+
+	id, err := secureID(8)
+	if err != nil {
+		return Account{}, "", fmt.Errorf("generating account id: %w", err)
+	}
+
+	token, err := secureID(24)
+	if err != nil {
+		return Account{}, "", fmt.Errorf("generating enrolment token: %w", err)
+	}
+
+	now := time.Now()
+	acc := Account{ID: id, Name: name, EnrolmentExpiresAt: now.Add(ttl), CreatedAt: now}
+
+	_, err = a.db.db.Exec(
+		`
+			INSERT INTO
+				accounts (id, name, enrolment_token_hash, enrolment_expires_at, created_at)
+			VALUES
+				(?, ?, ?, ?, ?)
+		`,
+		acc.ID,
+		acc.Name,
+		hashToken(token),
+		acc.EnrolmentExpiresAt.UnixMilli(),
+		now.UnixMilli(),
+	)
+	if err != nil {
+		return Account{}, "", fmt.Errorf("inserting account: %w", err)
+	}
+
+	return acc, a.baseURL + "/passkeys/enrol/" + token, nil
+}
+
+// Accounts retrieves every account that has not been removed, ordered by when they were created
+func (a *Application) Accounts() ([]Account, error) {
+	rows, err := a.db.reader.Query(
+		`
+			SELECT
+				a.id,
+				a.name,
+				a.enrolled_at,
+				a.enrolment_expires_at,
+				(SELECT MAX(p.last_used_at) FROM passkeys p WHERE p.account_id = a.id),
+				a.created_at
+			FROM
+				accounts a
+			WHERE
+				a.removed_at IS NULL
+			ORDER BY
+				a.created_at
+		`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying accounts: %w", err)
+	}
+	defer rows.Close()
+
+	accounts := []Account{}
+	for rows.Next() {
+		var acc Account
+		var enrolledAt, enrolmentExpiresAt, lastSignedInAt sql.NullInt64
+		var createdAt int64
+
+		if err := rows.Scan(&acc.ID, &acc.Name, &enrolledAt, &enrolmentExpiresAt, &lastSignedInAt, &createdAt); err != nil {
+			return nil, err
+		}
+
+		acc.CreatedAt = time.UnixMilli(createdAt)
+		if enrolledAt.Valid {
+			acc.EnrolledAt = time.UnixMilli(enrolledAt.Int64)
+		}
+		if enrolmentExpiresAt.Valid {
+			acc.EnrolmentExpiresAt = time.UnixMilli(enrolmentExpiresAt.Int64)
+		}
+		if lastSignedInAt.Valid {
+			acc.LastSignedInAt = time.UnixMilli(lastSignedInAt.Int64)
+		}
+
+		accounts = append(accounts, acc)
+	}
+
+	return accounts, rows.Err()
+}
+
+// RemoveAccount removes an account and deletes its passkeys, ending any sessions it has. Secrets created by the account
+// are left as they are. [ErrAccountNotFound] is returned if there was nothing to remove.
+func (a *Application) RemoveAccount(id string) error {
+	tx, err := a.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	rs, err := tx.Exec(
+		`
+			UPDATE
+				accounts
+			SET
+				removed_at = ?,
+				enrolment_token_hash = NULL
+			WHERE
+				id = ? AND
+				removed_at IS NULL
+		`,
+		time.Now().UnixMilli(),
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("removing account: %w", err)
+	}
+
+	if rc, err := rs.RowsAffected(); err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	} else if rc == 0 {
+		return ErrAccountNotFound
+	}
+
+	if _, err := tx.Exec("DELETE FROM passkeys WHERE account_id = ?", id); err != nil {
+		return fmt.Errorf("deleting passkeys: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// accountByEnrolmentToken retrieves the account that the enrolment link with the given token is for, returning
+// [errInvalidEnrolment] if it does not exist, has expired or has already been used
+func (d *database) accountByEnrolmentToken(token string) (identity, error) {
+	var acc identity
+	err := d.reader.QueryRow(
+		`
+			SELECT
+				id,
+				name
+			FROM
+				accounts
+			WHERE
+				enrolment_token_hash = ? AND
+				enrolment_expires_at > ? AND
+				removed_at IS NULL
+		`,
+		hashToken(token),
+		time.Now().UnixMilli(),
+	).Scan(&acc.AccountID, &acc.Name)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return identity{}, errInvalidEnrolment
+	}
+
+	return acc, err
+}
+
+// enrolPasskey registers a passkey for the account the enrolment link with the given token is for, using up the link
+// in the process. [errInvalidEnrolment] is returned if the link is no longer usable.
+func (d *database) enrolPasskey(token string, accountID string, c webauthnCredential) error {
+	now := time.Now().UnixMilli()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	rs, err := tx.Exec(
+		`
+			UPDATE
+				accounts
+			SET
+				enrolled_at = ?1,
+				enrolment_token_hash = NULL,
+				enrolment_expires_at = NULL
+			WHERE
+				id = ?2 AND
+				enrolment_token_hash = ?3 AND
+				enrolment_expires_at > ?1 AND
+				removed_at IS NULL
+		`,
+		now,
+		accountID,
+		hashToken(token),
+	)
+	if err != nil {
+		return fmt.Errorf("enrolling account: %w", err)
+	}
+
+	if rc, err := rs.RowsAffected(); err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	} else if rc == 0 {
+		return errInvalidEnrolment
+	}
+
+	_, err = tx.Exec(
+		`
+			INSERT INTO
+				passkeys (id, account_id, public_key, sign_count, created_at)
+			VALUES
+				(?, ?, ?, ?, ?)
+		`,
+		base64.RawURLEncoding.EncodeToString(c.id),
+		accountID,
+		c.publicKey,
+		c.signCount,
+		now,
+	)
+	if err != nil {
+		return fmt.Errorf("inserting passkey: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// passkeyByID retrieves a passkey using its (base64 URL encoded) credential identifier, returning
+// [errPasskeyNotFound] if it does not exist or its account has been removed
+func (d *database) passkeyByID(id string) (storedPasskey, error) {
+	var p storedPasskey
+	var signCount int64
+
+	err := d.reader.QueryRow(
+		`
+			SELECT
+				p.public_key,
+				p.sign_count,
+				a.id,
+				a.name
+			FROM
+				passkeys p
+				INNER JOIN accounts a ON a.id = p.account_id
+			WHERE
+				p.id = ? AND
+				a.removed_at IS NULL
+		`,
+		id,
+	).Scan(&p.publicKey, &signCount, &p.account.AccountID, &p.account.Name)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return storedPasskey{}, errPasskeyNotFound
+	} else if err != nil {
+		return storedPasskey{}, err
+	}
+
+	p.id, err = base64.RawURLEncoding.DecodeString(id)
+	p.signCount = uint32(signCount)
+
+	return p, err
+}
+
+// usePasskey records the use of a passkey to sign in, returning [errPasskeyNotFound] if it has been used (or removed)
+// since it was retrieved
+func (d *database) usePasskey(p storedPasskey, signCount uint32) error {
+	rs, err := d.db.Exec(
+		"UPDATE passkeys SET sign_count = ?, last_used_at = ? WHERE id = ? AND sign_count = ?",
+		signCount,
+		time.Now().UnixMilli(),
+		base64.RawURLEncoding.EncodeToString(p.id),
+		p.signCount,
+	)
+	if err != nil {
+		return fmt.Errorf("updating passkey: %w", err)
+	}
+
+	if rc, err := rs.RowsAffected(); err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	} else if rc == 0 {
+		return errPasskeyNotFound
+	}
+
+	return nil
+}
+
+// spendCeremony records that the challenge of a passkey ceremony has been used, returning false if it already had
+// been. Spent challenges are kept alongside those of proofs of work until they expire.
+func (d *database) spendCeremony(c ceremony) (bool, error) {
+	rs, err := d.db.Exec(
+		"INSERT INTO spent_challenges (nonce, expires_at) VALUES (?, ?) ON CONFLICT (nonce) DO NOTHING",
+		"passkey:"+c.Challenge,
+		c.ExpiresAt*1000,
+	)
+	if err != nil {
+		return false, fmt.Errorf("spending challenge: %w", err)
+	}
+
+	rc, err := rs.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("rows affected: %w", err)
+	}
+
+	return rc != 0, nil
+}
+
+// accountActive identifies whether an account exists and has not been removed
+func (d *database) accountActive(id string) bool {
+	var c int
+	err := d.reader.QueryRow("SELECT 1 FROM accounts WHERE id = ? AND removed_at IS NULL", id).Scan(&c)
+
+	return err == nil
+}
+
+// handleGetEnrolment renders the page used by someone invited to create an account to register their passkey
+func (a *Application) handleGetEnrolment(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+
+	acc, err := a.db.accountByEnrolmentToken(r.PathValue("token"))
+	if errors.Is(err, errInvalidEnrolment) {
+		a.recordFailedLookup(r)
+		setFlashErr(errInvalidEnrolment.Error(), w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		l.Err(err).Msg("retrieving enrolment")
+		redirectToOopsPage(w, r)
+		return
+	}
+
+	c, ok := a.beginCeremony(w, r, acc.AccountID)
+	if !ok {
+		return
+	}
+
+	pagePasskeyEnrolment(
+		passkeyPage{
+			relyingParty: a.passkeys,
+			challenge:    c.Challenge,
+			user:         &acc,
+			action:       "/passkeys/enrol/" + r.PathValue("token"),
+		},
+		notificationsFromRequest(r, w),
+	).Render(r.Context(), w)
+}
+
+// handleEnrol registers the passkey created by someone invited to create an account, signing them in
+func (a *Application) handleEnrol(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+	token := r.PathValue("token")
+
+	c, ok := a.endCeremony(w, r)
+	if !ok {
+		return
+	}
+
+	acc, err := a.db.accountByEnrolmentToken(token)
+	if errors.Is(err, errInvalidEnrolment) || (err == nil && acc.AccountID != c.AccountID) {
+		a.recordFailedLookup(r)
+		setFlashErr(errInvalidEnrolment.Error(), w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		l.Err(err).Msg("retrieving enrolment")
+		redirectToOopsPage(w, r)
+		return
+	}
+
+	clientDataJSON, errC := base64.RawURLEncoding.DecodeString(r.Form.Get("clientDataJSON"))
+	attestationObject, errA := base64.RawURLEncoding.DecodeString(r.Form.Get("attestationObject"))
+
+	credential, err := a.passkeys.verifyRegistration(c.Challenge, clientDataJSON, attestationObject)
+	if err := errors.Join(errC, errA, err); err != nil {
+		l.Warn().Err(err).Str("account_id", acc.AccountID).Msg("verifying passkey registration")
+		setFlashErr(errPasskeyNotVerified.Error(), w)
+		http.Redirect(w, r, "/passkeys/enrol/"+token, http.StatusSeeOther)
+		return
+	}
+
+	if err := a.db.enrolPasskey(token, acc.AccountID, credential); errors.Is(err, errInvalidEnrolment) {
+		setFlashErr(errInvalidEnrolment.Error(), w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		l.Err(err).Str("account_id", acc.AccountID).Msg("enrolling passkey")
+		redirectToOopsPage(w, r)
+		return
+	}
+
+	l.Info().Str("account_id", acc.AccountID).Msg("enrolled account")
+
+	a.startSession(w, acc, a.config.Passkeys.SessionDuration)
+	setFlashSuccess("Your passkey has been created and you are now signed in.", w)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleGetPasskeyLogin renders the page used to sign in with a passkey
+func (a *Application) handleGetPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	c, ok := a.beginCeremony(w, r, "")
+	if !ok {
+		return
+	}
+
+	pagePasskeyLogin(
+		passkeyPage{relyingParty: a.passkeys, challenge: c.Challenge, action: "/passkeys/login"},
+		notificationsFromRequest(r, w),
+	).Render(r.Context(), w)
+}
+
+// handlePasskeyLogin verifies the assertion made by a passkey, signing in the account it belongs to
+func (a *Application) handlePasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+
+	c, ok := a.endCeremony(w, r)
+	if !ok {
+		return
+	}
+
+	p, err := a.db.passkeyByID(r.Form.Get("credentialId"))
+	if errors.Is(err, errPasskeyNotFound) {
+		a.recordFailedLookup(r)
+		setFlashErr("This passkey is not registered with this shareasecret instance.", w)
+		http.Redirect(w, r, "/passkeys/login", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		l.Err(err).Msg("retrieving passkey")
+		redirectToOopsPage(w, r)
+		return
+	}
+
+	clientDataJSON, errC := base64.RawURLEncoding.DecodeString(r.Form.Get("clientDataJSON"))
+	authenticatorData, errA := base64.RawURLEncoding.DecodeString(r.Form.Get("authenticatorData"))
+	signature, errS := base64.RawURLEncoding.DecodeString(r.Form.Get("signature"))
+	userHandle, errU := base64.RawURLEncoding.DecodeString(r.Form.Get("userHandle"))
+
+	signCount, err := a.passkeys.verifyAssertion(c.Challenge, p.webauthnCredential, clientDataJSON, authenticatorData, signature)
+	if err == nil && len(userHandle) != 0 && string(userHandle) != p.account.AccountID {
+		err = errors.New("user handle does not match account")
+	}
+
+	if err := errors.Join(errC, errA, errS, errU, err); err != nil {
+		l.Warn().Err(err).Str("account_id", p.account.AccountID).Msg("verifying passkey assertion")
+		setFlashErr(errPasskeyNotVerified.Error(), w)
+		http.Redirect(w, r, "/passkeys/login", http.StatusSeeOther)
+		return
+	}
+
+	if err := a.db.usePasskey(p, signCount); errors.Is(err, errPasskeyNotFound) {
+		setFlashErr(errPasskeyNotVerified.Error(), w)
+		http.Redirect(w, r, "/passkeys/login", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		l.Err(err).Str("account_id", p.account.AccountID).Msg("recording passkey use")
+		redirectToOopsPage(w, r)
+		return
+	}
+
+	a.startSession(w, p.account, a.config.Passkeys.SessionDuration)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// beginCeremony issues the challenge for a passkey registration (for the given account) or sign in, storing it in the
+// ceremony cookie so that it can be verified once the browser responds
+func (a *Application) beginCeremony(w http.ResponseWriter, r *http.Request, accountID string) (ceremony, bool) {
+	challenge, err := secureID(32)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("generating passkey challenge")
+		redirectToOopsPage(w, r)
+		return ceremony{}, false
+	}
+
+	c := ceremony{
+		Challenge: base64.RawURLEncoding.EncodeToString([]byte(challenge)),
+		AccountID: accountID,
+		ExpiresAt: time.Now().Add(ceremonyDuration).Unix(),
+	}
+
+	a.setSignedCookie(w, ceremonyCookieName, c, ceremonyDuration)
+
+	return c, true
+}
+
+// endCeremony parses the form submitted once the browser has responded to a passkey registration or sign in,
+// retrieving the challenge it was issued. The challenge can only be used once, and the visitor is returned to where
+// they started if it has expired or been used.
+func (a *Application) endCeremony(w http.ResponseWriter, r *http.Request) (ceremony, bool) {
+	var c ceremony
+	ok := a.signedCookie(r, ceremonyCookieName, &c)
+	a.clearCookie(w, ceremonyCookieName)
+
+	if err := r.ParseForm(); err != nil {
+		setFlashErr("Unable to parse request form. Please try again.", w)
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return ceremony{}, false
+	}
+
+	if ok && c.ExpiresAt > time.Now().Unix() {
+		spent, err := a.db.spendCeremony(c)
+		if err != nil {
+			zerolog.Ctx(r.Context()).Err(err).Msg("spending passkey challenge")
+			redirectToOopsPage(w, r)
+			return ceremony{}, false
+		}
+
+		ok = spent
+	}
+
+	if !ok || c.ExpiresAt <= time.Now().Unix() {
+		setFlashErr("Your passkey request has expired. Please try again.", w)
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return ceremony{}, false
+	}
+
+	return c, true
+}
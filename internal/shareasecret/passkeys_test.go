@@ -0,0 +1,451 @@
+package shareasecret
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPasskeyEnrolment(t *testing.T) {
+	defer useTestPasskeys()()
+
+	t.Run("rejects invalid invites", func(t *testing.T) {
+		var ve validationError
+		if _, _, err := app.InviteAccount(" ", time.Hour); !errors.As(err, &ve) {
+			t.Errorf("expected validation error for an empty name, got %v", err)
+		}
+
+		if _, _, err := app.InviteAccount("dana", 0); !errors.As(err, &ve) {
+			t.Errorf("expected validation error for a zero ttl, got %v", err)
+		}
+
+		passkeys := app.passkeys
+		app.passkeys = nil
+		defer func() { app.passkeys = passkeys }()
+
+		if _, _, err := app.InviteAccount("dana", time.Hour); !errors.Is(err, ErrPasskeysDisabled) {
+			t.Errorf("expected ErrPasskeysDisabled, got %v", err)
+		}
+	})
+
+	t.Run("enrols an invited account, signing it in", func(t *testing.T) {
+		authenticator := newSoftwareAuthenticator(t)
+		acc, cookies := enrol(t, authenticator, "dana")
+
+		user := app.signedInUser(requestWithCookies(cookies))
+		if user == nil || user.AccountID != acc.ID || user.Name != "dana" {
+			t.Fatalf("expected dana to be signed in, got %+v", user)
+		}
+
+		accounts, _ := app.Accounts()
+		for _, a := range accounts {
+			if a.ID == acc.ID && a.EnrolledAt.IsZero() {
+				t.Errorf("expected account to be enrolled")
+			}
+		}
+	})
+
+	t.Run("enrolment links can only be used once", func(t *testing.T) {
+		_, link, _ := app.InviteAccount("erin", time.Hour)
+		enrolWithLink(t, newSoftwareAuthenticator(t), link)
+
+		r := get(t, app.handleGetEnrolment, withPathValue("token", path.Base(link)))
+		if !responseIsRedirectTo(r, "/") || cookie(r.cookies, "flash_err") == nil {
+			t.Errorf("expected used enrolment link to be rejected")
+		}
+	})
+
+	t.Run("rejects registrations that cannot be verified", func(t *testing.T) {
+		cases := map[string]func(s *softwareAuthenticator){
+			"another origin":          func(s *softwareAuthenticator) { s.origin = "https://evil.example" },
+			"another relying party":   func(s *softwareAuthenticator) { s.rpID = "evil.example" },
+			"another challenge":       func(s *softwareAuthenticator) { s.challenge = "abc" },
+			"another ceremony":        func(s *softwareAuthenticator) { s.ceremony = "webauthn.get" },
+			"an absent user":          func(s *softwareAuthenticator) { s.flags &^= authenticatorFlagUserPresent },
+			"an unsupported key type": func(s *softwareAuthenticator) { s.algorithm = -8 },
+		}
+
+		for n, configure := range cases {
+			_, link, _ := app.InviteAccount("frank", time.Hour)
+			token := path.Base(link)
+
+			authenticator := newSoftwareAuthenticator(t)
+			configure(authenticator)
+
+			page := get(t, app.handleGetEnrolment, withPathValue("token", token))
+			r := post(t, app.handleEnrol, authenticator.create(challengeFromPage(t, page)).Encode(), func(r *http.Request) {
+				withPathValue("token", token)(r)
+				withCookies(page.cookies)(r)
+			})
+
+			if cookie(r.cookies, sessionCookieName) != nil || cookie(r.cookies, "flash_err") == nil {
+				t.Errorf("%v: expected registration to be rejected", n)
+			}
+
+			if _, err := app.db.accountByEnrolmentToken(token); err != nil {
+				t.Errorf("%v: expected enrolment link to remain usable, got %v", n, err)
+			}
+		}
+	})
+
+	t.Run("rejects registrations without a ceremony", func(t *testing.T) {
+		_, link, _ := app.InviteAccount("grace", time.Hour)
+
+		r := post(t, app.handleEnrol, newSoftwareAuthenticator(t).create("abc").Encode(), withPathValue("token", path.Base(link)))
+		if cookie(r.cookies, sessionCookieName) != nil || cookie(r.cookies, "flash_err") == nil {
+			t.Errorf("expected registration to be rejected")
+		}
+	})
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	defer useTestPasskeys()()
+
+	authenticator := newSoftwareAuthenticator(t)
+	acc, _ := enrol(t, authenticator, "heidi")
+
+	t.Run("signs in with a registered passkey", func(t *testing.T) {
+		cookies := signInWithPasskey(t, authenticator)
+
+		if user := app.signedInUser(requestWithCookies(cookies)); user == nil || user.AccountID != acc.ID {
+			t.Errorf("expected heidi to be signed in, got %+v", user)
+		}
+	})
+
+	t.Run("signed in accounts can create secrets from outside ips", func(t *testing.T) {
+		cookies := signInWithPasskey(t, authenticator)
+
+		r := post(t, app.handleCreateSecret, "encryptedSecret="+testEncryptedSecret+"&ttl=30&maxViews=1", func(r *http.Request) {
+			outsideRequester(r)
+			withCookies(cookies)(r)
+		})
+		if r.statusCode != http.StatusCreated {
+			t.Fatalf("expected 201 status code, got %v", r.statusCode)
+		}
+
+		secrets, _, err := app.db.secretsByCreator(secretCreator{accountID: acc.ID}, secretStateActive, 1)
+		if err != nil || len(secrets) != 1 {
+			t.Errorf("expected the secret to be attributed to the account, got %v %v", len(secrets), err)
+		}
+	})
+
+	t.Run("rejects assertions that cannot be verified", func(t *testing.T) {
+		impostor := newSoftwareAuthenticator(t)
+		impostor.credentialID = authenticator.credentialID
+
+		cases := map[string]*softwareAuthenticator{
+			"unknown passkey": newSoftwareAuthenticator(t),
+			"another key":     impostor,
+			"cloned passkey":  {key: authenticator.key, credentialID: authenticator.credentialID, rpID: authenticator.rpID, origin: authenticator.origin, flags: authenticator.flags},
+		}
+
+		for n, a := range cases {
+			page := get(t, app.handleGetPasskeyLogin, emptyRequestConfigurer)
+			r := post(t, app.handlePasskeyLogin, a.get(challengeFromPage(t, page)).Encode(), withCookies(page.cookies))
+
+			if cookie(r.cookies, sessionCookieName) != nil || cookie(r.cookies, "flash_err") == nil {
+				t.Errorf("%v: expected sign in to be rejected", n)
+			}
+		}
+	})
+
+	t.Run("challenges can only be used once", func(t *testing.T) {
+		page := get(t, app.handleGetPasskeyLogin, emptyRequestConfigurer)
+		challenge := challengeFromPage(t, page)
+
+		if r := post(t, app.handlePasskeyLogin, authenticator.get(challenge).Encode(), withCookies(page.cookies)); cookie(r.cookies, sessionCookieName) == nil {
+			t.Fatalf("expected to be signed in")
+		}
+
+		// the ceremony cookie is cleared once used, but a replayed request could still include it
+		if r := post(t, app.handlePasskeyLogin, authenticator.get(challenge).Encode(), withCookies(page.cookies)); cookie(r.cookies, sessionCookieName) != nil {
+			t.Errorf("expected reused challenge to be rejected")
+		}
+	})
+
+	t.Run("removing an account ends its sessions", func(t *testing.T) {
+		cookies := signInWithPasskey(t, authenticator)
+
+		if err := app.RemoveAccount(acc.ID); err != nil {
+			t.Fatalf("removing account: %v", err)
+		}
+
+		if app.signedInUser(requestWithCookies(cookies)) != nil {
+			t.Errorf("expected session of removed account to end")
+		}
+
+		page := get(t, app.handleGetPasskeyLogin, emptyRequestConfigurer)
+		r := post(t, app.handlePasskeyLogin, authenticator.get(challengeFromPage(t, page)).Encode(), withCookies(page.cookies))
+		if cookie(r.cookies, sessionCookieName) != nil {
+			t.Errorf("expected removed account to be unable to sign in")
+		}
+
+		if err := app.RemoveAccount(acc.ID); !errors.Is(err, ErrAccountNotFound) {
+			t.Errorf("expected ErrAccountNotFound removing twice, got %v", err)
+		}
+	})
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	t.Run("decodes authenticator data types", func(t *testing.T) {
+		v, n, err := decodeCBOR([]byte{0xa2, 0x01, 0x02, 0x20, 0x43, 'a', 'b', 'c'}, 0)
+		if err != nil || n != 8 {
+			t.Fatalf("decoding: %v (%v bytes)", err, n)
+		}
+
+		m := v.(map[any]any)
+		if m[int64(1)] != int64(2) || string(m[int64(-1)].([]byte)) != "abc" {
+			t.Errorf("unexpected value %v", m)
+		}
+	})
+
+	t.Run("rejects truncated and unsupported input", func(t *testing.T) {
+		for _, b := range [][]byte{{}, {0x43, 'a'}, {0xa1, 0x01}, {0x9f}, {0xc1, 0x00}, {0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}} {
+			if _, _, err := decodeCBOR(b, 0); err == nil {
+				t.Errorf("expected error decoding %x", b)
+			}
+		}
+	})
+}
+
+// softwareAuthenticator is a passkey authenticator implemented in software, which registers and signs in with a P-256
+// key. Its fields can be changed to produce responses that should be rejected.
+type softwareAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	signCount    uint32
+
+	rpID      string
+	origin    string
+	flags     byte
+	algorithm int
+
+	// challenge and ceremony override those of the client data when set
+	challenge string
+	ceremony  string
+}
+
+// newSoftwareAuthenticator creates an authenticator for the test application's origin
+func newSoftwareAuthenticator(t *testing.T) *softwareAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	return &softwareAuthenticator{
+		key:          key,
+		credentialID: id,
+		rpID:         "127.0.0.1",
+		origin:       app.baseURL,
+		flags:        authenticatorFlagUserPresent | 0x04,
+		algorithm:    coseAlgorithmES256,
+	}
+}
+
+// create responds to a request to register a passkey, returning the form fields the browser would submit
+func (s *softwareAuthenticator) create(challenge string) url.Values {
+	s.signCount++
+
+	x, y := make([]byte, 32), make([]byte, 32)
+	s.key.X.FillBytes(x)
+	s.key.Y.FillBytes(y)
+
+	// a COSE EC2 key: {1: 2, 3: alg, -1: 1, -2: x, -3: y}
+	publicKey := []byte{0xa5, 0x01, 0x02, 0x03}
+	publicKey = append(publicKey, cborInt(s.algorithm)...)
+	publicKey = append(publicKey, 0x20, 0x01, 0x21)
+	publicKey = append(append(publicKey, cborBytes(x)...), 0x22)
+	publicKey = append(publicKey, cborBytes(y)...)
+
+	authData := s.authenticatorData(authenticatorFlagAttestedCredentialData)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(s.credentialID)))
+	authData = append(append(authData, s.credentialID...), publicKey...)
+
+	// {"fmt": "none", "attStmt": {}, "authData": authData}
+	attestation := []byte{0xa3}
+	attestation = append(append(attestation, cborText("fmt")...), cborText("none")...)
+	attestation = append(append(attestation, cborText("attStmt")...), 0xa0)
+	attestation = append(append(attestation, cborText("authData")...), cborBytes(authData)...)
+
+	return url.Values{
+		"clientDataJSON":    {base64.RawURLEncoding.EncodeToString(s.clientData("webauthn.create", challenge))},
+		"attestationObject": {base64.RawURLEncoding.EncodeToString(attestation)},
+	}
+}
+
+// get responds to a request to sign in with a passkey, returning the form fields the browser would submit
+func (s *softwareAuthenticator) get(challenge string) url.Values {
+	s.signCount++
+
+	authData := s.authenticatorData(0)
+	clientData := s.clientData("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+
+	signature, _ := ecdsa.SignASN1(rand.Reader, s.key, digest[:])
+
+	return url.Values{
+		"credentialId":      {base64.RawURLEncoding.EncodeToString(s.credentialID)},
+		"clientDataJSON":    {base64.RawURLEncoding.EncodeToString(clientData)},
+		"authenticatorData": {base64.RawURLEncoding.EncodeToString(authData)},
+		"signature":         {base64.RawURLEncoding.EncodeToString(signature)},
+	}
+}
+
+// clientData returns the client data a browser would collect for the ceremony
+func (s *softwareAuthenticator) clientData(ceremony string, challenge string) []byte {
+	if s.ceremony != "" {
+		ceremony = s.ceremony
+	}
+
+	if s.challenge != "" {
+		challenge = s.challenge
+	}
+
+	b, _ := json.Marshal(map[string]any{"type": ceremony, "challenge": challenge, "origin": s.origin})
+
+	return b
+}
+
+// authenticatorData returns the fixed length part of the authenticator data
+func (s *softwareAuthenticator) authenticatorData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(s.rpID))
+
+	b := append(rpIDHash[:], s.flags|flags)
+
+	return binary.BigEndian.AppendUint32(b, s.signCount)
+}
+
+// cborInt encodes a small integer as CBOR
+func cborInt(v int) []byte {
+	if v >= 0 {
+		return cborHead(0, v)
+	}
+
+	return cborHead(1, -1-v)
+}
+
+// cborBytes encodes a byte string as CBOR
+func cborBytes(b []byte) []byte {
+	return append(cborHead(2, len(b)), b...)
+}
+
+// cborText encodes a text string as CBOR
+func cborText(s string) []byte {
+	return append(cborHead(3, len(s)), s...)
+}
+
+// cborHead encodes the head of a CBOR data item with an argument of up to 65535
+func cborHead(major byte, v int) []byte {
+	switch {
+	case v < 24:
+		return []byte{major<<5 | byte(v)}
+	case v < 256:
+		return []byte{major<<5 | 24, byte(v)}
+	default:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(v))
+	}
+}
+
+// useTestPasskeys enables passkey accounts for the test application. The returned function disables them again.
+func useTestPasskeys() func() {
+	passkeys, duration := app.passkeys, app.config.Passkeys.SessionDuration
+
+	app.passkeys, _ = newRelyingParty(app.baseURL, "shareasecret")
+	app.config.Passkeys.SessionDuration = time.Hour
+
+	return func() {
+		app.passkeys, app.config.Passkeys.SessionDuration = passkeys, duration
+	}
+}
+
+// enrol invites an account with the given name and registers the authenticator's passkey for it, returning the
+// account and the cookies containing its session
+func enrol(t *testing.T, s *softwareAuthenticator, name string) (Account, []*http.Cookie) {
+	acc, link, err := app.InviteAccount(name, time.Hour)
+	if err != nil {
+		t.Fatalf("inviting account: %v", err)
+	}
+
+	return acc, enrolWithLink(t, s, link)
+}
+
+// enrolWithLink registers the authenticator's passkey using an enrolment link, returning the cookies containing the
+// enrolled account's session
+func enrolWithLink(t *testing.T, s *softwareAuthenticator, link string) []*http.Cookie {
+	token := path.Base(link)
+
+	page := get(t, app.handleGetEnrolment, withPathValue("token", token))
+	if page.statusCode != http.StatusOK {
+		t.Fatalf("expected 200 status code, got %v", page.statusCode)
+	}
+
+	r := post(t, app.handleEnrol, s.create(challengeFromPage(t, page)).Encode(), func(r *http.Request) {
+		outsideRequester(r)
+		withPathValue("token", token)(r)
+		withCookies(page.cookies)(r)
+	})
+
+	if !responseIsRedirectTo(r, "/") || cookie(r.cookies, sessionCookieName) == nil {
+		t.Fatalf("expected enrolment to sign the account in, got %v", r.statusCode)
+	}
+
+	return r.cookies
+}
+
+// signInWithPasskey signs in with the authenticator's passkey, returning the cookies containing the session
+func signInWithPasskey(t *testing.T, s *softwareAuthenticator) []*http.Cookie {
+	page := get(t, app.handleGetPasskeyLogin, outsideRequester)
+
+	r := post(t, app.handlePasskeyLogin, s.get(challengeFromPage(t, page)).Encode(), func(r *http.Request) {
+		outsideRequester(r)
+		withCookies(page.cookies)(r)
+	})
+
+	if !responseIsRedirectTo(r, "/") || cookie(r.cookies, sessionCookieName) == nil {
+		t.Fatalf("expected to be signed in, got %v %v", r.statusCode, r.headers.Get("Location"))
+	}
+
+	return r.cookies
+}
+
+// challengeFromPage extracts the passkey challenge from a rendered enrolment or sign in page
+func challengeFromPage(t *testing.T, page consumedResponse) string {
+	m := regexp.MustCompile(`data-challenge="([^"]+)"`).FindStringSubmatch(page.body)
+	if m == nil {
+		t.Fatalf("expected challenge in body")
+	}
+
+	return m[1]
+}
+
+// withPathValue returns a request configurer that sets a path value of the request
+func withPathValue(name string, value string) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.SetPathValue(name, value)
+	}
+}
+
+// requestWithCookies creates a request containing the (non-expired) cookies
+func requestWithCookies(cookies []*http.Cookie) *http.Request {
+	r, _ := http.NewRequest("GET", "/", strings.NewReader(""))
+	withCookies(cookies)(r)
+
+	return r
+}
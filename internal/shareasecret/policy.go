@@ -0,0 +1,262 @@
+package shareasecret
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errSecretTooLarge is returned when a secret's ciphertext exceeds the largest size permitted by the creation policy
+const errSecretTooLarge = validationError("Secret is too large. Please shorten it and try again.")
+
+// defaultMaxKeyAttempts is the number of incorrect encryption keys that can be tried against a secret protected by a key
+// verifier before it is deleted, when the creation policy does not configure it
+const defaultMaxKeyAttempts = 5
+
+// defaultMaxAttachmentSize is the largest total size, in bytes, of a secret's encrypted attachments when the creation
+// policy does not configure it
+const defaultMaxAttachmentSize = 10 * 1024 * 1024
+
+// unlimitedSecretSize is the size, in bytes, that requests to create secrets allow for a secret's ciphertext when the
+// creation policy doesn't limit the size of secrets, so that the size of requests is always limited
+const unlimitedSecretSize = 16 * 1024 * 1024
+
+// requestSizeAllowance is the size, in bytes, allowed for everything in a request to create a secret other than its
+// ciphertext and attachments
+const requestSizeAllowance = 64 * 1024
+
+// defaultSecretTTLs are the TTLs secrets can be created with when the creation policy does not configure them
+var defaultSecretTTLs = []time.Duration{
+	30 * time.Minute,
+	time.Hour,
+	3 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+	72 * time.Hour,
+	168 * time.Hour,
+}
+
+// ttlOption is one of the TTLs a secret can be created with
+type ttlOption struct {
+	minutes int
+	label   string
+}
+
+// creationPolicy contains the limits every secret is created within. Both the index page and the creation of secrets
+// use it, so what is offered and what is accepted cannot differ.
+type creationPolicy struct {
+	ttls           []ttlOption
+	defaultTTL     int
+	minViews       int
+	maxViews       int
+	unlimitedViews bool
+	maxSecretSize  int
+	maxKeyAttempts int
+
+	// maxAttachments is the most files that can be attached to a secret, and is 0 if attachments are not permitted
+	maxAttachments    int
+	maxAttachmentSize int
+}
+
+// newCreationPolicy creates the creation policy from the configuration, returning an error if it cannot be satisfied
+func newCreationPolicy(c *Configuration) (*creationPolicy, error) {
+	ttls := c.Policy.TTLs
+	if len(ttls) == 0 {
+		ttls = defaultSecretTTLs
+	}
+
+	p := &creationPolicy{
+		minViews:       max(c.Policy.MinViews, 1),
+		maxViews:       c.Policy.MaxViews,
+		unlimitedViews: c.Policy.AllowUnlimitedViews && c.Policy.MaxViews == 0,
+		maxSecretSize:  c.Policy.MaxSecretSize,
+		maxKeyAttempts: c.Policy.MaxKeyAttempts,
+
+		maxAttachments:    c.Policy.MaxAttachments,
+		maxAttachmentSize: c.Policy.MaxAttachmentSize,
+	}
+
+	if p.maxKeyAttempts <= 0 {
+		p.maxKeyAttempts = defaultMaxKeyAttempts
+	}
+
+	if p.maxAttachmentSize <= 0 {
+		p.maxAttachmentSize = defaultMaxAttachmentSize
+	}
+
+	seen := map[int]bool{}
+	for _, d := range ttls {
+		if d < time.Minute || d%time.Minute != 0 {
+			return nil, fmt.Errorf("policy TTL (%v) must be a whole number of minutes", d)
+		}
+
+		minutes := int(d / time.Minute)
+		if (c.Policy.MaxTTL > 0 && d > c.Policy.MaxTTL) || seen[minutes] {
+			continue
+		}
+
+		seen[minutes] = true
+		p.ttls = append(p.ttls, ttlOption{minutes, ttlLabel(minutes)})
+	}
+
+	if len(p.ttls) == 0 {
+		return nil, fmt.Errorf("no policy TTLs are within the maximum TTL (%v)", c.Policy.MaxTTL)
+	}
+
+	sort.Slice(p.ttls, func(i, j int) bool { return p.ttls[i].minutes < p.ttls[j].minutes })
+
+	p.defaultTTL = p.ttls[0].minutes
+	if c.Policy.DefaultTTL > 0 {
+		p.defaultTTL = int(c.Policy.DefaultTTL / time.Minute)
+		if !seen[p.defaultTTL] || c.Policy.DefaultTTL%time.Minute != 0 {
+			return nil, fmt.Errorf("policy default TTL (%v) is not one of the permitted TTLs", c.Policy.DefaultTTL)
+		}
+	}
+
+	if p.maxViews > 0 && p.minViews > p.maxViews {
+		return nil, fmt.Errorf("policy minimum views (%v) exceeds the maximum views (%v)", p.minViews, p.maxViews)
+	}
+
+	return p, nil
+}
+
+// ttlOptions returns the TTLs that secrets can be created with, which are further limited for an organisation's
+// secrets. If none of the TTLs are within an organisation's limit, its limit is the only TTL permitted.
+func (p *creationPolicy) ttlOptions(o *Organisation) []ttlOption {
+	if o == nil || o.MaxTTL == 0 {
+		return p.ttls
+	}
+
+	limit := int(o.MaxTTL / time.Minute)
+
+	options := []ttlOption{}
+	for _, t := range p.ttls {
+		if t.minutes <= limit {
+			options = append(options, t)
+		}
+	}
+
+	if len(options) == 0 {
+		options = append(options, ttlOption{limit, ttlLabel(limit)})
+	}
+
+	return options
+}
+
+// defaultTTLOption returns the TTL selected by default when creating a secret, which is the largest TTL permitted if the
+// configured default is not
+func (p *creationPolicy) defaultTTLOption(o *Organisation) int {
+	options := p.ttlOptions(o)
+
+	for _, t := range options {
+		if t.minutes == p.defaultTTL {
+			return t.minutes
+		}
+	}
+
+	return options[len(options)-1].minutes
+}
+
+// viewLimits returns the minimum and maximum (or 0 if there is no maximum) number of views a secret can be created
+// with, and whether it can instead be viewed an unlimited number of times
+func (p *creationPolicy) viewLimits(o *Organisation) (minimum int, maximum int, unlimited bool) {
+	minimum, maximum = p.minViews, p.maxViews
+
+	if o != nil && o.MaxViews > 0 && (maximum == 0 || o.MaxViews < maximum) {
+		maximum = o.MaxViews
+	}
+
+	return minimum, maximum, p.unlimitedViews && maximum == 0
+}
+
+// permits ensures the secret's TTL and views are within the policy's limits, returning a [validationError] if not. The
+// size of the secret is checked by [newSecret.validate] before anything else.
+func (p *creationPolicy) permits(s newSecret) error {
+	permittedTTL := false
+	for _, t := range p.ttlOptions(s.organisation) {
+		permittedTTL = permittedTTL || t.minutes == s.ttl
+	}
+
+	if !permittedTTL {
+		return validationError("The TTL (time to live) for the secret is not one of those permitted.")
+	}
+
+	minimum, maximum, unlimited := p.viewLimits(s.organisation)
+
+	switch {
+	case s.maxViews == 0 && !unlimited && maximum == 0:
+		return validationError("Secrets cannot be viewed an unlimited number of times.")
+	case (s.maxViews == 0 && !unlimited) || (maximum > 0 && s.maxViews > maximum):
+		return validationError(fmt.Sprintf("Secrets can be viewed at most %d times.", maximum))
+	case s.maxViews > 0 && s.maxViews < minimum:
+		return validationError(fmt.Sprintf("Secrets must be viewable at least %d times.", minimum))
+	}
+
+	return nil
+}
+
+// permitsAttachments ensures the number and total size of a secret's attachments are within the policy's limits,
+// returning a [validationError] if not. Like the size of the secret, it is checked before anything is decoded.
+func (p *creationPolicy) permitsAttachments(attachments []attachment) error {
+	switch {
+	case len(attachments) == 0:
+		return nil
+	case p.maxAttachments == 0:
+		return validationError("Files cannot be attached to secrets.")
+	case len(attachments) > p.maxAttachments:
+		return validationError(fmt.Sprintf("At most %d files can be attached to a secret.", p.maxAttachments))
+	case attachmentsSize(attachments) > p.maxAttachmentSize:
+		return errAttachmentsTooLarge
+	}
+
+	return nil
+}
+
+// maxRequestSize returns the largest request body a secret can be created with: its ciphertext, the encoded names and
+// contents of its attachments and an allowance for everything else. Requests are limited to [unlimitedSecretSize] if
+// the size of secrets is not.
+func (p *creationPolicy) maxRequestSize() int64 {
+	secret := p.maxSecretSize
+	if secret == 0 {
+		secret = unlimitedSecretSize
+	}
+
+	attachments := 0
+	if p.maxAttachments > 0 {
+		attachments = base64.RawURLEncoding.EncodedLen(p.maxAttachmentSize) + p.maxAttachments*maxAttachmentNameSize
+	}
+
+	return int64(secret + attachments + requestSizeAllowance)
+}
+
+// ttlLabel returns a human readable description of a TTL (in minutes), i.e. 3 Hours
+func ttlLabel(minutes int) string {
+	n, unit := minutes, "Minute"
+
+	switch {
+	case minutes%1440 == 0:
+		n, unit = minutes/1440, "Day"
+	case minutes%60 == 0:
+		n, unit = minutes/60, "Hour"
+	}
+
+	if n != 1 {
+		unit += "s"
+	}
+
+	return fmt.Sprintf("%d %s", n, unit)
+}
+
+// sizeLabel returns a human readable description of a size in bytes, i.e. 10 MB
+func sizeLabel(bytes int) string {
+	switch {
+	case bytes >= 1024*1024:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(bytes)/(1024*1024)), ".0") + " MB"
+	case bytes >= 1024:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(bytes)/1024), ".0") + " KB"
+	default:
+		return fmt.Sprintf("%d bytes", bytes)
+	}
+}
@@ -0,0 +1,184 @@
+package shareasecret
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewCreationPolicy(t *testing.T) {
+	t.Run("defaults to the standard ttls", func(t *testing.T) {
+		p, err := newCreationPolicy(&Configuration{})
+		if err != nil {
+			t.Fatalf("creating policy: %v", err)
+		}
+
+		if len(p.ttls) != len(defaultSecretTTLs) || p.defaultTTL != 30 || p.ttls[6].label != "7 Days" {
+			t.Errorf("unexpected ttls %+v (default %v)", p.ttls, p.defaultTTL)
+		}
+	})
+
+	t.Run("sorts and limits ttls", func(t *testing.T) {
+		c := &Configuration{}
+		c.Policy.TTLs = []time.Duration{48 * time.Hour, 90 * time.Minute, 15 * time.Minute, 90 * time.Minute}
+		c.Policy.MaxTTL = 24 * time.Hour
+		c.Policy.DefaultTTL = 90 * time.Minute
+
+		p, err := newCreationPolicy(c)
+		if err != nil {
+			t.Fatalf("creating policy: %v", err)
+		}
+
+		if len(p.ttls) != 2 || p.ttls[0].label != "15 Minutes" || p.ttls[1].label != "90 Minutes" || p.defaultTTL != 90 {
+			t.Errorf("unexpected ttls %+v (default %v)", p.ttls, p.defaultTTL)
+		}
+	})
+
+	t.Run("rejects policies that cannot be satisfied", func(t *testing.T) {
+		cases := map[string]func(c *Configuration){
+			"partial minute ttl":       func(c *Configuration) { c.Policy.TTLs = []time.Duration{90 * time.Second} },
+			"no ttls within maximum":   func(c *Configuration) { c.Policy.MaxTTL = 10 * time.Minute },
+			"default is not permitted": func(c *Configuration) { c.Policy.DefaultTTL = 2 * time.Hour },
+			"default exceeds maximum": func(c *Configuration) {
+				c.Policy.MaxTTL = time.Hour
+				c.Policy.DefaultTTL = 3 * time.Hour
+			},
+			"minimum views exceed maximum": func(c *Configuration) {
+				c.Policy.MinViews = 5
+				c.Policy.MaxViews = 2
+			},
+		}
+
+		for n, configure := range cases {
+			c := &Configuration{}
+			configure(c)
+
+			if _, err := newCreationPolicy(c); err == nil {
+				t.Errorf("%v: expected error", n)
+			}
+		}
+	})
+}
+
+func TestCreationPolicyEnforcement(t *testing.T) {
+	c := &Configuration{}
+	c.Policy.TTLs = []time.Duration{time.Hour, 24 * time.Hour}
+	c.Policy.DefaultTTL = 24 * time.Hour
+	c.Policy.MinViews = 2
+	c.Policy.MaxViews = 5
+	c.Policy.AllowUnlimitedViews = true
+	c.Policy.MaxSecretSize = 100
+
+	policy, err := newCreationPolicy(c)
+	if err != nil {
+		t.Fatalf("creating policy: %v", err)
+	}
+
+	defer useTestPolicy(policy)()
+
+	t.Run("rejects secrets outside of the policy", func(t *testing.T) {
+		cases := map[string]struct {
+			body     string
+			expected string
+		}{
+			"zero ttl":           {"ttl=0&encryptedSecret=" + testEncryptedSecret + "&maxViews=2", "not one of those permitted"},
+			"negative ttl":       {"ttl=-60&encryptedSecret=" + testEncryptedSecret + "&maxViews=2", "not one of those permitted"},
+			"unoffered ttl":      {"ttl=30&encryptedSecret=" + testEncryptedSecret + "&maxViews=2", "not one of those permitted"},
+			"too few views":      {"ttl=60&encryptedSecret=" + testEncryptedSecret + "&maxViews=1", "at least 2 times"},
+			"too many views":     {"ttl=60&encryptedSecret=" + testEncryptedSecret + "&maxViews=6", "at most 5 times"},
+			"unlimited views":    {"ttl=60&encryptedSecret=" + testEncryptedSecret + "&maxViews=0", "at most 5 times"},
+			"oversized secret":   {"ttl=60&encryptedSecret=" + strings.Repeat("a", 101) + "&maxViews=2", "too large"},
+			"api unoffered ttl":  {`{"encryptedSecret": "` + testEncryptedSecret + `", "ttl": 45, "maxViews": 2}`, "not one of those permitted"},
+			"api too many views": {`{"encryptedSecret": "` + testEncryptedSecret + `", "ttl": 60, "maxViews": 9}`, "at most 5 times"},
+		}
+
+		for n, tc := range cases {
+			var r consumedResponse
+			if strings.HasPrefix(n, "api") {
+				r = apiRequest(t, "POST", app.handleAPICreateSecret, tc.body, emptyRequestConfigurer)
+			} else {
+				r = post(t, app.handleCreateSecret, tc.body, emptyRequestConfigurer)
+			}
+
+			if r.statusCode != http.StatusBadRequest || !strings.Contains(r.body, tc.expected) {
+				t.Errorf("%v: expected %v, got %v %v", n, tc.expected, r.statusCode, r.body)
+			}
+		}
+	})
+
+	t.Run("creates secrets within the policy", func(t *testing.T) {
+		if r := post(t, app.handleCreateSecret, "ttl=1440&encryptedSecret="+testEncryptedSecret+"&maxViews=5", emptyRequestConfigurer); r.statusCode != http.StatusCreated {
+			t.Errorf("expected 201 status code, got %v %v", r.statusCode, r.body)
+		}
+	})
+
+	t.Run("renders the policy", func(t *testing.T) {
+		r := get(t, app.handleGetIndex, emptyRequestConfigurer)
+
+		for _, expected := range []string{
+			`<option value="60">1 Hour</option>`,
+			`<option value="1440" selected>1 Day</option>`,
+			"Maximum Views (2 to 5):",
+			`min="2" max="5"`,
+			`data-max-secret-size="100"`,
+		} {
+			if !strings.Contains(r.body, expected) {
+				t.Errorf("expected body to contain %v", expected)
+			}
+		}
+
+		if strings.Contains(r.body, `value="30"`) || strings.Contains(r.body, "Infinite") {
+			t.Errorf("expected only the policy's ttls and views to be offered")
+		}
+	})
+
+	t.Run("combines the policy with an organisation's limits", func(t *testing.T) {
+		org := &Organisation{Name: "Research", MaxTTL: 3 * time.Hour, MaxViews: 3}
+
+		if ttls := policy.ttlOptions(org); len(ttls) != 1 || ttls[0].minutes != 60 || policy.defaultTTLOption(org) != 60 {
+			t.Errorf("unexpected ttls %+v", ttls)
+		}
+
+		if minimum, maximum, unlimited := policy.viewLimits(org); minimum != 2 || maximum != 3 || unlimited {
+			t.Errorf("unexpected view limits %v %v %v", minimum, maximum, unlimited)
+		}
+	})
+}
+
+func TestCreationPolicyRequestSize(t *testing.T) {
+	policy, err := newCreationPolicy(&Configuration{})
+	if err != nil {
+		t.Fatalf("creating policy: %v", err)
+	}
+
+	defer useTestPolicy(policy)()
+
+	t.Run("limits requests when the size of secrets is not limited", func(t *testing.T) {
+		n := policy.maxRequestSize()
+		if n <= unlimitedSecretSize {
+			t.Fatalf("expected requests to allow for an unlimited secret, got %v", n)
+		}
+
+		r := apiRequest(t, "POST", app.handleAPICreateSecret, `{"encryptedSecret": "`+strings.Repeat("a", int(n))+`"}`, emptyRequestConfigurer)
+		if r.statusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected 413 status code, got %v", r.statusCode)
+		}
+
+		r = uploadRequest(t, "POST", app.handleAPICreateUpload, "", nil, "Upload-Length", strconv.FormatInt(n+1, 10))
+		if r.statusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected 413 status code, got %v", r.statusCode)
+		}
+	})
+}
+
+// useTestPolicy replaces the application's creation policy, returning a function that restores it
+func useTestPolicy(p *creationPolicy) func() {
+	policy := app.policy
+	app.policy = p
+
+	return func() {
+		app.policy = policy
+	}
+}
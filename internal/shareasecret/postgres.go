@@ -0,0 +1,33 @@
+package shareasecret
+
+import (
+	"database/sql"
+
+	_ "github.com/lib/pq"
+)
+
+// postgresDialect is the dialect of PostgreSQL databases
+var postgresDialect = dialect{
+	driver:     "postgres",
+	migrations: "migrations/postgres",
+	// the key is arbitrary, but must be the same for every instance
+	lock:                 "SELECT pg_advisory_xact_lock(8994)",
+	numberedPlaceholders: true,
+}
+
+// newPostgresDatabase creates a PostgreSQL connection pool from a connection URL (or DSN) and then runs any applicable
+// migrations. PostgreSQL handles concurrent writers itself, so the one pool is used for both reads and writes.
+func newPostgresDatabase(url string) (*database, error) {
+	con, err := sql.Open(postgresDialect.driver, url)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openDatabase(postgresDialect, con, con)
+	if err != nil {
+		con.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -0,0 +1,255 @@
+package shareasecret
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	errProofOfWorkRequired = validationError("A proof of work solution is required. Please refresh the page and try again.")
+	errProofOfWorkInvalid  = validationError("The proof of work solution is invalid. Please refresh the page and try again.")
+	errProofOfWorkExpired  = validationError("The proof of work challenge has expired. Please try again.")
+)
+
+// maximumProofOfWorkDifficulty is the highest difficulty (in leading zero bits) that can be configured. Anything
+// higher would take a browser hours to solve.
+const maximumProofOfWorkDifficulty = 32
+
+// proofOfWork issues and verifies hashcash style challenges that must be solved before a secret can be created. A
+// challenge is solved by finding a solution such that the SHA-256 hash of "{challenge}:{solution}" begins with at
+// least the challenge's difficulty in zero bits.
+//
+// Challenges are signed rather than stored so that issuing them costs nothing, and are only recorded once they have
+// been spent (along with the creation of the secret they were solved for) to prevent a single solution from being used
+// more than once.
+type proofOfWork struct {
+	db             *database
+	signer         *signer
+	difficulty     int
+	maxDifficulty  int
+	scaleThreshold int
+	ttl            time.Duration
+}
+
+// challenge is a signed, time bound proof of work challenge
+type challenge struct {
+	token      string
+	difficulty int
+	expiresAt  time.Time
+}
+
+// solvedChallenge is a challenge whose solution has been verified, which is spent when the secret it was solved for is
+// created
+type solvedChallenge struct {
+	nonce     string
+	expiresAt int64
+}
+
+// enabled identifies whether a proof of work is required to create secrets
+func (p *proofOfWork) enabled() bool {
+	return p.difficulty > 0
+}
+
+// currentDifficulty returns the difficulty of newly issued challenges. Once more than the scale threshold of secrets
+// have been created in the last minute, the difficulty increases by one bit (doubling the work required) every time
+// the number of secrets created doubles.
+func (p *proofOfWork) currentDifficulty() (int, error) {
+	if p.scaleThreshold <= 0 || p.maxDifficulty <= p.difficulty {
+		return p.difficulty, nil
+	}
+
+	var created int
+	err := p.db.reader.QueryRow(
+		"SELECT COUNT(1) FROM secrets WHERE created_at > ?",
+		time.Now().Add(-1*time.Minute).UnixMilli(),
+	).Scan(&created)
+	if err != nil {
+		return 0, fmt.Errorf("counting recently created secrets: %w", err)
+	}
+
+	if created < p.scaleThreshold {
+		return p.difficulty, nil
+	}
+
+	d := p.difficulty + 1 + int(math.Log2(float64(created)/float64(p.scaleThreshold)))
+
+	return min(d, p.maxDifficulty), nil
+}
+
+// issue creates a new challenge at the current difficulty
+func (p *proofOfWork) issue() (challenge, error) {
+	difficulty, err := p.currentDifficulty()
+	if err != nil {
+		return challenge{}, err
+	}
+
+	nonce, err := secureID(16)
+	if err != nil {
+		return challenge{}, fmt.Errorf("generating nonce: %w", err)
+	}
+
+	expiresAt := time.Now().Add(p.ttl)
+	payload := fmt.Sprintf("%d.%d.%s", difficulty, expiresAt.UnixMilli(), nonce)
+
+	return challenge{
+		token:      p.signer.signed("proof_of_work", payload),
+		difficulty: difficulty,
+		expiresAt:  expiresAt,
+	}, nil
+}
+
+// verify ensures the solution solves a challenge issued by this server that has neither expired nor been spent
+// before, returning a [validationError] if it does not. The challenge is only spent by [spendChallenge], so that it
+// isn't used up by a request that is otherwise invalid.
+func (p *proofOfWork) verify(token string, solution string) (solvedChallenge, error) {
+	if token == "" || solution == "" {
+		return solvedChallenge{}, errProofOfWorkRequired
+	}
+
+	// solutions are counters, so anything longer than this is somebody messing around
+	if len(solution) > 32 {
+		return solvedChallenge{}, errProofOfWorkInvalid
+	}
+
+	payload, ok := p.signer.verify("proof_of_work", token)
+	if !ok {
+		return solvedChallenge{}, errProofOfWorkInvalid
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 {
+		return solvedChallenge{}, errProofOfWorkInvalid
+	}
+
+	difficulty, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return solvedChallenge{}, errProofOfWorkInvalid
+	}
+
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return solvedChallenge{}, errProofOfWorkInvalid
+	}
+
+	now := time.Now().UnixMilli()
+	if expiresAt <= now {
+		return solvedChallenge{}, errProofOfWorkExpired
+	}
+
+	if leadingZeroBits(sha256.Sum256([]byte(token+":"+solution))) < difficulty {
+		return solvedChallenge{}, errProofOfWorkInvalid
+	}
+
+	// challenges that have already been spent are rejected early, though it is spending them that guarantees they
+	// can't be used twice
+	var spent int
+	err = p.db.reader.QueryRow("SELECT COUNT(1) FROM spent_challenges WHERE nonce = ?", parts[2]).Scan(&spent)
+	if err != nil {
+		return solvedChallenge{}, fmt.Errorf("checking challenge: %w", err)
+	} else if spent != 0 {
+		return solvedChallenge{}, errProofOfWorkInvalid
+	}
+
+	return solvedChallenge{nonce: parts[2], expiresAt: expiresAt}, nil
+}
+
+// spendChallenge records that a solved challenge has been spent as part of a transaction, ensuring it can't be used
+// again until after it has expired (at which point it would be rejected anyway). Returns [errProofOfWorkInvalid] if it
+// has already been spent.
+func spendChallenge(tx *transaction, c solvedChallenge) error {
+	rs, err := tx.Exec(
+		"INSERT INTO spent_challenges (nonce, expires_at) VALUES (?, ?) ON CONFLICT (nonce) DO NOTHING",
+		c.nonce,
+		c.expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("spending challenge: %w", err)
+	}
+
+	if rc, err := rs.RowsAffected(); err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	} else if rc == 0 {
+		return errProofOfWorkInvalid
+	}
+
+	return nil
+}
+
+// leadingZeroBits counts the number of zero bits at the start of a hash
+func leadingZeroBits(hash [sha256.Size]byte) int {
+	n := 0
+	for _, b := range hash {
+		n += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+
+	return n
+}
+
+// verifyProofOfWork ensures the request contains a valid solution to a challenge if a proof of work is required to
+// create secrets, returning a [validationError] if it does not. The challenge is returned to be spent when the secret
+// is created, and is nil if no proof of work is required.
+func (a *Application) verifyProofOfWork(token string, solution string) (*solvedChallenge, error) {
+	if !a.pow.enabled() {
+		return nil, nil
+	}
+
+	c, err := a.pow.verify(token, solution)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// issueChallenge issues a challenge for the request if a proof of work is required to create secrets, returning nil
+// if one isn't or it could not be issued
+func (a *Application) issueChallenge(r *http.Request) *challenge {
+	if !a.pow.enabled() {
+		return nil
+	}
+
+	c, err := a.pow.issue()
+	if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("issuing proof of work challenge")
+		return nil
+	}
+
+	return &c
+}
+
+// RunDeleteSpentChallengesJob runs a background job that removes the record of proof of work challenges that have
+// been spent and have since expired. The job stops once the context is cancelled.
+func (a *Application) RunDeleteSpentChallengesJob(ctx context.Context) {
+	a.runLeasedJobInBackground(
+		ctx,
+		"delete_spent_challenges",
+		func(l zerolog.Logger) error {
+			rows, err := a.db.db.Exec("DELETE FROM spent_challenges WHERE expires_at <= ?", time.Now().UnixMilli())
+			if err != nil {
+				return err
+			}
+
+			c, err := rows.RowsAffected()
+			if err != nil {
+				return err
+			}
+
+			l.Info().Int64("deleted_challenges", c).Msg("deleted spent challenges")
+
+			return nil
+		},
+		10*time.Minute,
+	)
+}
@@ -0,0 +1,238 @@
+package shareasecret
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProofOfWork(t *testing.T) {
+	p := newTestProofOfWork(8)
+
+	t.Run("accepts a solved challenge until it is spent", func(t *testing.T) {
+		c, err := p.issue()
+		if err != nil {
+			t.Fatalf("issuing challenge: %v", err)
+		}
+
+		solution := solveChallenge(c)
+
+		solved, err := p.verify(c.token, solution)
+		if err != nil {
+			t.Fatalf("expected solution to be accepted, got %v", err)
+		}
+
+		if _, err := p.verify(c.token, solution); err != nil {
+			t.Errorf("expected unspent challenge to be accepted again, got %v", err)
+		}
+
+		for i, expected := range []error{nil, errProofOfWorkInvalid} {
+			tx, _ := app.db.db.Begin()
+			if err := spendChallenge(tx, solved); err != expected {
+				t.Errorf("%v: expected %v spending challenge, got %v", i, expected, err)
+			}
+			tx.Commit()
+		}
+
+		if _, err := p.verify(c.token, solution); err != errProofOfWorkInvalid {
+			t.Errorf("expected spent challenge to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("rejects invalid solutions", func(t *testing.T) {
+		c, _ := p.issue()
+
+		// find a solution that does not meet the difficulty
+		for i := 0; ; i++ {
+			if s := strconv.Itoa(i); leadingZeroBits(sha256.Sum256([]byte(c.token+":"+s))) < c.difficulty {
+				if _, err := p.verify(c.token, s); err != errProofOfWorkInvalid {
+					t.Errorf("expected invalid solution to be rejected, got %v", err)
+				}
+
+				break
+			}
+		}
+
+		if _, err := p.verify(c.token, ""); err != errProofOfWorkRequired {
+			t.Errorf("expected missing solution to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("rejects tampered challenges", func(t *testing.T) {
+		c, _ := p.issue()
+
+		// lower the difficulty to zero, which any solution satisfies
+		c.token = "0" + c.token[strings.Index(c.token, "."):]
+		c.difficulty = 0
+
+		if _, err := p.verify(c.token, solveChallenge(c)); err != errProofOfWorkInvalid {
+			t.Errorf("expected tampered challenge to be rejected, got %v", err)
+		}
+
+		c, _ = p.issue()
+		if _, err := newTestProofOfWork(8).verify(c.token, solveChallenge(c)); err != errProofOfWorkInvalid {
+			t.Errorf("expected challenge signed with a different key to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("rejects expired challenges", func(t *testing.T) {
+		expired := newTestProofOfWork(8)
+		expired.signer = p.signer
+		expired.ttl = -1 * time.Minute
+
+		c, _ := expired.issue()
+		if _, err := p.verify(c.token, solveChallenge(c)); err != errProofOfWorkExpired {
+			t.Errorf("expected expired challenge to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("scales difficulty under load", func(t *testing.T) {
+		scaled := newTestProofOfWork(8)
+		scaled.maxDifficulty = 12
+
+		var created int
+		app.db.db.QueryRow(
+			"SELECT COUNT(1) FROM secrets WHERE created_at > ?",
+			time.Now().Add(-1*time.Minute).UnixMilli(),
+		).Scan(&created)
+
+		for created < 4 {
+			createSecret(t, time.Time{}, "")
+			created++
+		}
+
+		scaled.scaleThreshold = created + 1
+		if d, _ := scaled.currentDifficulty(); d != 8 {
+			t.Errorf("expected base difficulty under the threshold, got %v", d)
+		}
+
+		scaled.scaleThreshold = created / 4
+		if d, _ := scaled.currentDifficulty(); d < 11 || d > 12 {
+			t.Errorf("expected difficulty to increase by a bit for every doubling over the threshold, got %v", d)
+		}
+
+		scaled.maxDifficulty = 10
+		if d, _ := scaled.currentDifficulty(); d != 10 {
+			t.Errorf("expected difficulty to be capped at the maximum, got %v", d)
+		}
+	})
+}
+
+func TestSecretCreationProofOfWork(t *testing.T) {
+	pow := app.pow
+	defer func() { app.pow = pow }()
+
+	app.pow = newTestProofOfWork(8)
+
+	t.Run("index page includes a challenge", func(t *testing.T) {
+		r := get(t, app.handleGetIndex, emptyRequestConfigurer)
+
+		if !strings.Contains(r.body, "data-pow-challenge=") {
+			t.Errorf("expected challenge in body")
+		}
+	})
+
+	t.Run("bad request without a solution", func(t *testing.T) {
+		r := post(t, app.handleCreateSecret, "encryptedSecret="+testEncryptedSecret+"&ttl=30&maxViews=1", emptyRequestConfigurer)
+
+		if r.statusCode != http.StatusBadRequest {
+			t.Errorf("expected 400 status code, got %v", r.statusCode)
+		} else if !strings.Contains(r.body, "proof of work") {
+			t.Errorf("expected 'proof of work' in body, got %v", r.body)
+		}
+	})
+
+	t.Run("verifies the solution before retrieving an upload", func(t *testing.T) {
+		r := post(t, app.handleCreateSecret, "upload=unknown&ttl=30&maxViews=1", emptyRequestConfigurer)
+
+		if r.statusCode != http.StatusBadRequest || !strings.Contains(r.body, "proof of work") {
+			t.Errorf("expected proof of work to be required, got %v %v", r.statusCode, r.body)
+		}
+
+		r = apiRequest(t, "POST", app.handleAPICreateSecret, `{"upload":"unknown","ttl":30,"maxViews":1}`, emptyRequestConfigurer)
+		if r.statusCode != http.StatusBadRequest || apiErrorCode(t, r) != "proof_of_work_failed" {
+			t.Errorf("expected proof_of_work_failed error, got %v %v", r.statusCode, r.body)
+		}
+	})
+
+	t.Run("does not spend the challenge of an invalid secret", func(t *testing.T) {
+		c, _ := app.pow.issue()
+
+		form := url.Values{}
+		form.Set("encryptedSecret", "not a secret")
+		form.Set("ttl", "30")
+		form.Set("maxViews", "1")
+		form.Set("powChallenge", c.token)
+		form.Set("powSolution", solveChallenge(c))
+
+		if r := post(t, app.handleCreateSecret, form.Encode(), emptyRequestConfigurer); r.statusCode != http.StatusBadRequest {
+			t.Fatalf("expected 400 status code, got %v", r.statusCode)
+		}
+
+		form.Set("encryptedSecret", testEncryptedSecret)
+		if r := post(t, app.handleCreateSecret, form.Encode(), emptyRequestConfigurer); r.statusCode != http.StatusCreated {
+			t.Errorf("expected challenge to be usable once the secret is valid, got %v %v", r.statusCode, r.body)
+		}
+
+		if r := post(t, app.handleCreateSecret, form.Encode(), emptyRequestConfigurer); r.statusCode != http.StatusBadRequest {
+			t.Errorf("expected spent challenge to be rejected, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("creates the secret with a solution", func(t *testing.T) {
+		c, _ := app.pow.issue()
+
+		form := url.Values{}
+		form.Set("encryptedSecret", testEncryptedSecret)
+		form.Set("ttl", "30")
+		form.Set("maxViews", "1")
+		form.Set("powChallenge", c.token)
+		form.Set("powSolution", solveChallenge(c))
+
+		if r := post(t, app.handleCreateSecret, form.Encode(), emptyRequestConfigurer); r.statusCode != http.StatusCreated {
+			t.Errorf("expected 201 status code, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("api rejects requests without a solution", func(t *testing.T) {
+		r := apiRequest(
+			t,
+			"POST",
+			app.handleAPICreateSecret,
+			`{"encryptedSecret":"`+testEncryptedSecret+`","ttl":30,"maxViews":1}`,
+			emptyRequestConfigurer,
+		)
+
+		if r.statusCode != http.StatusBadRequest {
+			t.Errorf("expected 400 status code, got %v", r.statusCode)
+		} else if e := apiErrorCode(t, r); e != "proof_of_work_failed" {
+			t.Errorf("expected proof_of_work_failed error code, got %v", e)
+		}
+	})
+}
+
+// newTestProofOfWork creates a proof of work that requires challenges of the given difficulty, signed with a random key
+func newTestProofOfWork(difficulty int) *proofOfWork {
+	key, _ := secureID(32)
+
+	return &proofOfWork{
+		db:            app.db,
+		signer:        &signer{key: []byte(key)},
+		difficulty:    difficulty,
+		maxDifficulty: difficulty,
+		ttl:           time.Minute,
+	}
+}
+
+// solveChallenge finds a solution to the challenge
+func solveChallenge(c challenge) string {
+	for i := 0; ; i++ {
+		if s := strconv.Itoa(i); leadingZeroBits(sha256.Sum256([]byte(c.token+":"+s))) >= c.difficulty {
+			return s
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package shareasecret
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// rateLimitBucketCreate limits the creation of secrets
+	rateLimitBucketCreate = "create"
+
+	// rateLimitBucketView limits the viewing of secret interstitials and the creation of viewing keys
+	rateLimitBucketView = "view"
+
+	// rateLimitBucketFailedLookups limits the number of lookups of secrets that do not exist (i.e. guessed access
+	// identifiers, viewing keys or management identifiers). Exhausting it results in a lockout.
+	rateLimitBucketFailedLookups = "failed_lookups"
+)
+
+// RateLimit describes a token bucket that permits bursts of up to Requests requests, and refills at a rate of
+// Requests requests every Per. A zero value disables the rate limit.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// enabled identifies whether the rate limit should be enforced
+func (r RateLimit) enabled() bool {
+	return r.Requests > 0 && r.Per > 0
+}
+
+// tokensPerMilli returns the rate at which the token bucket refills
+func (r RateLimit) tokensPerMilli() float64 {
+	return float64(r.Requests) / float64(r.Per.Milliseconds())
+}
+
+// parseRateLimit parses a rate limit in the form requests/duration (i.e. 20/1m), with an empty value or 0 disabling
+// the rate limit
+func parseRateLimit(name string, v string) (RateLimit, error) {
+	v = strings.TrimSpace(v)
+	if v == "" || v == "0" {
+		return RateLimit{}, nil
+	}
+
+	requests, per, ok := strings.Cut(v, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("invalid rate limit (%v) in %v, expected requests/duration i.e. 20/1m", v, name)
+	}
+
+	r, err := strconv.Atoi(requests)
+	if err != nil || r < 0 {
+		return RateLimit{}, fmt.Errorf("invalid number of requests (%v) in %v", requests, name)
+	}
+
+	d, err := time.ParseDuration(per)
+	if err != nil || d < time.Millisecond {
+		return RateLimit{}, fmt.Errorf("invalid duration (%v) in %v", per, name)
+	}
+
+	return RateLimit{Requests: r, Per: d}, nil
+}
+
+// rateLimiter enforces per client IP token bucket rate limits, persisting their state in the database so that it
+// survives restarts
+type rateLimiter struct {
+	db       *database
+	limits   map[string]RateLimit
+	lockout  time.Duration
+	clientIP func(r *http.Request) string
+}
+
+// take takes a token from the client's bucket, returning whether the request is permitted and, if it isn't, how long
+// the client must wait before trying again
+func (l *rateLimiter) take(bucket string, client string) (bool, time.Duration, error) {
+	limit := l.limits[bucket]
+	if !limit.enabled() {
+		return true, 0, nil
+	}
+
+	now := time.Now().UnixMilli()
+
+	// refill and take a token from the bucket in a single statement so that concurrent requests can't both take the
+	// last token. The parameters are cast where they're first used as they would otherwise be inferred to be integers
+	// by some databases.
+	var tokens float64
+	err := l.db.db.QueryRow(
+		`
+			INSERT INTO rate_limits (bucket, client_ip, tokens, updated_at)
+			VALUES (?1, ?2, CAST(?3 AS DOUBLE PRECISION) - 1, ?4)
+			ON CONFLICT (bucket, client_ip) DO UPDATE SET
+				tokens = CASE
+					WHEN rate_limits.tokens + ((?4 - rate_limits.updated_at) * CAST(?5 AS DOUBLE PRECISION)) < ?3
+					THEN rate_limits.tokens + ((?4 - rate_limits.updated_at) * ?5)
+					ELSE ?3
+				END - 1,
+				updated_at = ?4
+			WHERE
+				rate_limits.tokens + ((?4 - rate_limits.updated_at) * ?5) >= 1 AND
+				(rate_limits.locked_until IS NULL OR rate_limits.locked_until <= ?4)
+			RETURNING tokens
+		`,
+		bucket,
+		client,
+		float64(limit.Requests),
+		now,
+		limit.tokensPerMilli(),
+	).Scan(&tokens)
+
+	if err == nil {
+		return true, 0, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return false, 0, fmt.Errorf("taking token: %w", err)
+	}
+
+	retryAfter, err := l.retryAfter(bucket, client, now)
+	return false, retryAfter, err
+}
+
+// retryAfter calculates how long the client must wait until a token is available in its bucket
+func (l *rateLimiter) retryAfter(bucket string, client string, now int64) (time.Duration, error) {
+	limit := l.limits[bucket]
+
+	var tokens float64
+	var updatedAt int64
+	var lockedUntil sql.NullInt64
+
+	err := l.db.reader.QueryRow(
+		"SELECT tokens, updated_at, locked_until FROM rate_limits WHERE bucket = ? AND client_ip = ?",
+		bucket,
+		client,
+	).Scan(&tokens, &updatedAt, &lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("retrieving bucket: %w", err)
+	}
+
+	if lockedUntil.Valid && lockedUntil.Int64 > now {
+		return time.Duration(lockedUntil.Int64-now) * time.Millisecond, nil
+	}
+
+	available := math.Min(float64(limit.Requests), tokens+(float64(now-updatedAt)*limit.tokensPerMilli()))
+	millis := math.Ceil((1 - available) / limit.tokensPerMilli())
+
+	return time.Duration(math.Max(millis, 0)) * time.Millisecond, nil
+}
+
+// lockedOut identifies whether the client has been locked out due to too many failed lookups, returning how long the
+// lockout will last for
+func (l *rateLimiter) lockedOut(client string) (bool, time.Duration, error) {
+	if !l.limits[rateLimitBucketFailedLookups].enabled() {
+		return false, 0, nil
+	}
+
+	now := time.Now().UnixMilli()
+
+	var lockedUntil int64
+	err := l.db.reader.QueryRow(
+		"SELECT locked_until FROM rate_limits WHERE bucket = ? AND client_ip = ? AND locked_until > ?",
+		rateLimitBucketFailedLookups,
+		client,
+		now,
+	).Scan(&lockedUntil)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, 0, nil
+	} else if err != nil {
+		return false, 0, fmt.Errorf("retrieving lockout: %w", err)
+	}
+
+	return true, time.Duration(lockedUntil-now) * time.Millisecond, nil
+}
+
+// recordFailedLookup takes a token from the client's failed lookups bucket, locking the client out if the bucket is
+// empty
+func (l *rateLimiter) recordFailedLookup(client string) error {
+	permitted, _, err := l.take(rateLimitBucketFailedLookups, client)
+	if err != nil || permitted {
+		return err
+	}
+
+	_, err = l.db.db.Exec(
+		"UPDATE rate_limits SET locked_until = ? WHERE bucket = ? AND client_ip = ? AND (locked_until IS NULL OR locked_until <= ?)",
+		time.Now().Add(l.lockout).UnixMilli(),
+		rateLimitBucketFailedLookups,
+		client,
+		time.Now().UnixMilli(),
+	)
+	if err != nil {
+		return fmt.Errorf("locking out client: %w", err)
+	}
+
+	return nil
+}
+
+// rateLimited wraps a handler, only calling it if the client has a token available in the given bucket and has not
+// been locked out due to too many failed lookups. An empty bucket only checks for lockouts.
+func (a *Application) rateLimited(bucket string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := zerolog.Ctx(r.Context())
+		client := a.limiter.clientIP(r)
+
+		lockedOut, retryAfter, err := a.limiter.lockedOut(client)
+		if err != nil {
+			l.Err(err).Msg("checking lockout")
+		} else if lockedOut {
+			tooManyRequests(w, r, retryAfter)
+			return
+		}
+
+		if bucket != "" {
+			permitted, retryAfter, err := a.limiter.take(bucket, client)
+			if err != nil {
+				l.Err(err).Str("bucket", bucket).Msg("taking rate limit token")
+			} else if !permitted {
+				tooManyRequests(w, r, retryAfter)
+				return
+			}
+		}
+
+		h(w, r)
+	}
+}
+
+// recordFailedLookup records that the request looked up a secret that does not exist, counting towards a lockout of
+// the client
+func (a *Application) recordFailedLookup(r *http.Request) {
+	if err := a.limiter.recordFailedLookup(a.limiter.clientIP(r)); err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("recording failed lookup")
+	}
+}
+
+// RunDeleteStaleRateLimitsJob runs a background job that removes the state of rate limits that have long since been
+// refilled. The job stops once the context is cancelled.
+func (a *Application) RunDeleteStaleRateLimitsJob(ctx context.Context) {
+	a.runLeasedJobInBackground(
+		ctx,
+		"delete_stale_rate_limits",
+		func(l zerolog.Logger) error {
+			now := time.Now()
+
+			rows, err := a.db.db.Exec(
+				`
+					DELETE FROM
+						rate_limits
+					WHERE
+						updated_at <= ? AND
+						(locked_until IS NULL OR locked_until <= ?)
+				`,
+				now.Add(-24*time.Hour).UnixMilli(),
+				now.UnixMilli(),
+			)
+			if err != nil {
+				return err
+			}
+
+			c, err := rows.RowsAffected()
+			if err != nil {
+				return err
+			}
+
+			l.Info().Int64("deleted_rate_limits", c).Msg("deleted stale rate limits")
+
+			return nil
+		},
+		1*time.Hour,
+	)
+}
+
+// tooManyRequests sets the status code of the response to 429 and renders a page (or, for API requests, a structured
+// error) informing the client how long they must wait
+func tooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		apiErr(
+			w,
+			http.StatusTooManyRequests,
+			"rate_limited",
+			fmt.Sprintf("Too many requests. Please try again in %d seconds.", seconds),
+		)
+		return
+	}
+
+	w.WriteHeader(http.StatusTooManyRequests)
+	pageTooManyRequests(seconds).Render(r.Context(), w)
+}
@@ -0,0 +1,219 @@
+package shareasecret
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("permits bursts up to the limit", func(t *testing.T) {
+		l := newTestRateLimiter(RateLimit{Requests: 3, Per: time.Hour})
+		client := uniqueClient(t)
+
+		for i := 0; i < 3; i++ {
+			if permitted, _, err := l.take(rateLimitBucketCreate, client); err != nil {
+				t.Fatalf("taking token: %v", err)
+			} else if !permitted {
+				t.Fatalf("expected request %v to be permitted", i+1)
+			}
+		}
+
+		permitted, retryAfter, err := l.take(rateLimitBucketCreate, client)
+		if err != nil {
+			t.Fatalf("taking token: %v", err)
+		} else if permitted {
+			t.Errorf("expected request to be rate limited")
+		} else if retryAfter <= 0 || retryAfter > 20*time.Minute {
+			t.Errorf("expected retry after to be at most the time taken to refill one token, got %v", retryAfter)
+		}
+
+		if permitted, _, _ := l.take(rateLimitBucketView, client); !permitted {
+			t.Errorf("expected buckets to be independent")
+		}
+	})
+
+	t.Run("refills over time", func(t *testing.T) {
+		l := newTestRateLimiter(RateLimit{Requests: 1, Per: 50 * time.Millisecond})
+		client := uniqueClient(t)
+
+		if permitted, _, _ := l.take(rateLimitBucketCreate, client); !permitted {
+			t.Fatalf("expected first request to be permitted")
+		}
+
+		if permitted, _, _ := l.take(rateLimitBucketCreate, client); permitted {
+			t.Fatalf("expected second request to be rate limited")
+		}
+
+		<-time.After(60 * time.Millisecond)
+
+		if permitted, _, _ := l.take(rateLimitBucketCreate, client); !permitted {
+			t.Errorf("expected request to be permitted after the bucket refilled")
+		}
+	})
+
+	t.Run("state survives a restart", func(t *testing.T) {
+		client := uniqueClient(t)
+
+		if permitted, _, _ := newTestRateLimiter(RateLimit{Requests: 1, Per: time.Hour}).take(rateLimitBucketCreate, client); !permitted {
+			t.Fatalf("expected first request to be permitted")
+		}
+
+		if permitted, _, _ := newTestRateLimiter(RateLimit{Requests: 1, Per: time.Hour}).take(rateLimitBucketCreate, client); permitted {
+			t.Errorf("expected a new rate limiter to continue limiting the client")
+		}
+	})
+
+	t.Run("locks out clients with too many failed lookups", func(t *testing.T) {
+		l := newTestRateLimiter(RateLimit{Requests: 2, Per: time.Hour})
+		client := uniqueClient(t)
+
+		for i := 0; i < 2; i++ {
+			if err := l.recordFailedLookup(client); err != nil {
+				t.Fatalf("recording failed lookup: %v", err)
+			} else if locked, _, _ := l.lockedOut(client); locked {
+				t.Fatalf("did not expect client to be locked out after %v failures", i+1)
+			}
+		}
+
+		if err := l.recordFailedLookup(client); err != nil {
+			t.Fatalf("recording failed lookup: %v", err)
+		}
+
+		if locked, d, err := l.lockedOut(client); err != nil {
+			t.Errorf("checking lockout: %v", err)
+		} else if !locked {
+			t.Errorf("expected client to be locked out")
+		} else if d <= 0 || d > l.lockout {
+			t.Errorf("expected lockout to last at most %v, got %v", l.lockout, d)
+		}
+	})
+
+	t.Run("does nothing when disabled", func(t *testing.T) {
+		l := newTestRateLimiter(RateLimit{})
+		client := uniqueClient(t)
+
+		for i := 0; i < 5; i++ {
+			if permitted, _, _ := l.take(rateLimitBucketCreate, client); !permitted {
+				t.Fatalf("expected request to be permitted")
+			}
+
+			l.recordFailedLookup(client)
+		}
+
+		if locked, _, _ := l.lockedOut(client); locked {
+			t.Errorf("did not expect client to be locked out")
+		}
+	})
+}
+
+func TestRateLimitedMiddleware(t *testing.T) {
+	limiter := app.limiter
+	defer func() { app.limiter = limiter }()
+
+	app.limiter = newTestRateLimiter(RateLimit{Requests: 1, Per: time.Hour})
+
+	handler := app.rateLimited(rateLimitBucketCreate, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	t.Run("renders a page with a retry after header when rate limited", func(t *testing.T) {
+		client := uniqueClient(t)
+		rc := func(r *http.Request) { r.Header.Set("X-Forwarded-For", client) }
+
+		if r := get(t, handler, rc); r.statusCode != http.StatusNoContent {
+			t.Fatalf("expected first request to be permitted, got %v", r.statusCode)
+		}
+
+		r := get(t, handler, rc)
+		if r.statusCode != http.StatusTooManyRequests {
+			t.Errorf("expected 429 status code, got %v", r.statusCode)
+		} else if s, err := strconv.Atoi(r.headers.Get("Retry-After")); err != nil || s < 1 {
+			t.Errorf("expected Retry-After header to be a positive number of seconds, got %v", r.headers.Get("Retry-After"))
+		} else if !strings.Contains(r.body, "too many requests") {
+			t.Errorf("expected 'too many requests' in body")
+		}
+	})
+
+	t.Run("returns a structured error for api requests", func(t *testing.T) {
+		client := uniqueClient(t)
+		rc := func(r *http.Request) {
+			r.URL.Path = "/api/v1/secrets"
+			r.Header.Set("X-Forwarded-For", client)
+		}
+
+		apiRequest(t, "POST", handler, "", rc)
+
+		if r := apiRequest(t, "POST", handler, "", rc); r.statusCode != http.StatusTooManyRequests {
+			t.Errorf("expected 429 status code, got %v", r.statusCode)
+		} else if e := apiErrorCode(t, r); e != "rate_limited" {
+			t.Errorf("expected rate_limited error code, got %v", e)
+		}
+	})
+
+	t.Run("rejects locked out clients from secret lookups", func(t *testing.T) {
+		client := uniqueClient(t)
+		rc := func(r *http.Request) {
+			r.SetPathValue("accessID", "does-not-exist")
+			r.Header.Set("X-Forwarded-For", client)
+		}
+
+		interstitial := app.rateLimited("", app.handleAccessSecretInterstitial)
+
+		if r := get(t, interstitial, rc); !responseIsRedirectTo(r, "/") {
+			t.Errorf("expected redirect to home page, got %v", r.statusCode)
+		}
+
+		if r := get(t, interstitial, rc); !responseIsRedirectTo(r, "/") {
+			t.Errorf("expected redirect to home page, got %v", r.statusCode)
+		}
+
+		if r := get(t, interstitial, rc); r.statusCode != http.StatusTooManyRequests {
+			t.Errorf("expected 429 status code once locked out, got %v", r.statusCode)
+		}
+	})
+}
+
+func TestParseRateLimit(t *testing.T) {
+	if r, err := parseRateLimit("test", "20/1m"); err != nil || r.Requests != 20 || r.Per != time.Minute {
+		t.Errorf("expected 20/1m to be parsed, got %+v (%v)", r, err)
+	}
+
+	if r, err := parseRateLimit("test", ""); err != nil || r.enabled() {
+		t.Errorf("expected empty rate limit to be disabled, got %+v (%v)", r, err)
+	}
+
+	for _, v := range []string{"20", "a/1m", "20/a", "-1/1m", "20/0s"} {
+		if _, err := parseRateLimit("test", v); err == nil {
+			t.Errorf("expected %v to be rejected", v)
+		}
+	}
+}
+
+// newTestRateLimiter creates a rate limiter backed by the test database, applying the same limit to all buckets
+func newTestRateLimiter(limit RateLimit) *rateLimiter {
+	return &rateLimiter{
+		db: app.db,
+		limits: map[string]RateLimit{
+			rateLimitBucketCreate:        limit,
+			rateLimitBucketView:          limit,
+			rateLimitBucketFailedLookups: limit,
+		},
+		lockout: time.Minute,
+		clientIP: func(r *http.Request) string {
+			return app.clientIP(r).String()
+		},
+	}
+}
+
+// uniqueClient returns an IP address that has not been used by any other test
+func uniqueClient(t *testing.T) string {
+	id, err := secureID(4)
+	if err != nil {
+		t.Fatalf("generating id: %v", err)
+	}
+
+	return "2001:db8::" + id[:4] + ":" + id[4:]
+}
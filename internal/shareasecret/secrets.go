@@ -0,0 +1,791 @@
+package shareasecret
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lsymds/shareasecret/pkg/secretcrypto"
+)
+
+// errSecretNotFound is returned when a secret does not exist, has been deleted or (when viewing) the viewing key used
+// is invalid or has been used before
+var errSecretNotFound = errors.New("secret not found")
+
+// errNoViewsRemaining is returned when a viewing key cannot be created for a secret because every one of its remaining
+// views has been reserved by a key that has not yet been used
+var errNoViewsRemaining = errors.New("no views remaining")
+
+// errViewingKeyExpired is returned when a viewing key was not used within its time to live, after which a new one must
+// be created to view the secret
+var errViewingKeyExpired = errors.New("viewing key expired")
+
+// defaultViewingKeyTTL is how long a viewing key can be used for after it was created, when it is not configured
+const defaultViewingKeyTTL = 10 * time.Minute
+
+// validationError is an error whose message is safe to display to the user that made the request
+type validationError string
+
+func (v validationError) Error() string {
+	return string(v)
+}
+
+const (
+	errInvalidSecretFormat = validationError("Secret format is invalid. Please try again.")
+	errInvalidTTL          = validationError("Unable to parse the TTL (time to live) for the secret.")
+	errInvalidMaximumViews = validationError("Unable to parse the maximum views permitted for the secret.")
+)
+
+// newSecret contains the user provided values required to create a secret
+type newSecret struct {
+	cipherText string
+	ttl        int
+	maxViews   int
+
+	// verifier is set if the viewer must prove knowledge of the secret's encryption key before its ciphertext is
+	// released
+	verifier string
+
+	// attachments are the files attached to the secret, encrypted with its key
+	attachments []attachment
+
+	// inviteID is set if the secret is being created via an invite, which is used up in the process
+	inviteID string
+
+	// uploadID is set if the secret (and its attachments) were uploaded in chunks, and the upload is deleted once the
+	// secret is created from it
+	uploadID string
+
+	// challenge is set if a proof of work was required to create the secret, and is spent in the process
+	challenge *solvedChallenge
+
+	// creator is set if the secret is being created by a signed in user
+	creator *identity
+
+	// apiTokenID is set if the secret is being created by a request authorised with an API token
+	apiTokenID string
+
+	// organisation is set if the secret is being created for (and will be owned by) an organisation
+	organisation *Organisation
+
+	// policy is the creation policy the secret must be within
+	policy *creationPolicy
+}
+
+// validate ensures the new secret is structurally valid, returning a [validationError] if not
+func (s newSecret) validate() error {
+	// the size is checked first so that oversized secrets are rejected without being decoded
+	if s.policy != nil && s.policy.maxSecretSize > 0 && len(s.cipherText) > s.policy.maxSecretSize {
+		return errSecretTooLarge
+	}
+
+	if s.policy != nil {
+		if err := s.policy.permitsAttachments(s.attachments); err != nil {
+			return err
+		}
+	}
+
+	// the server cannot decrypt the secret, but it can ensure it is an envelope that could have been produced by
+	// encrypting one
+	e, err := secretcrypto.Parse(s.cipherText)
+	if err != nil {
+		return errInvalidSecretFormat
+	}
+
+	if s.verifier != "" {
+		if e.KeyInURL() {
+			return errVerifierKeyInURL
+		} else if _, err := hashVerifier(s.verifier); err != nil {
+			return err
+		}
+	}
+
+	if err := validateAttachments(e, s.attachments); err != nil {
+		return err
+	}
+
+	if s.maxViews < 0 {
+		return errInvalidMaximumViews
+	}
+
+	if s.organisation != nil {
+		if err := s.organisation.permitsSecret(s.ttl, s.maxViews); err != nil {
+			return err
+		}
+	}
+
+	if s.policy != nil {
+		return s.policy.permits(s)
+	}
+
+	return nil
+}
+
+// createdSecret contains the identifiers generated when a secret is created
+type createdSecret struct {
+	accessID     string
+	managementID string
+}
+
+// viewedSecret contains the result of a successful secret view
+type viewedSecret struct {
+	cipherText  string
+	attachments []attachment
+	finalView   bool
+}
+
+// managedSecret contains the information about a secret visible to its creator
+type managedSecret struct {
+	accessID     string
+	ttl          int
+	maximumViews int
+	views        int
+	createdAt    time.Time
+	expiresAt    time.Time
+
+	// invite is only set if the secret was created via an invite
+	invite *invite
+
+	// keyInURL is set if the secret was encrypted with a generated key, which is only ever in its viewing URL
+	keyInURL bool
+
+	// keyAttempts is only set if the secret is protected by a key verifier
+	keyAttempts *keyAttempts
+
+	// attachments is the number of files attached to the secret
+	attachments int
+}
+
+// createSecret validates and persists a secret, generating two cryptographically random, 192 bit identifiers to use
+// for viewing and management of the secret respectively
+func (d *database) createSecret(s newSecret) (createdSecret, error) {
+	if err := s.validate(); err != nil {
+		return createdSecret{}, err
+	}
+
+	accessID, err := secureID(24)
+	if err != nil {
+		return createdSecret{}, fmt.Errorf("generating access id: %w", err)
+	}
+
+	managementID, err := secureID(24)
+	if err != nil {
+		return createdSecret{}, fmt.Errorf("generating management id: %w", err)
+	}
+
+	now := time.Now()
+
+	// the contents of attachments are stored before the transaction begins, as they may be stored in the database, and
+	// are deleted again if the secret is not created
+	attachmentIDs, err := d.storeAttachments(s.attachments)
+	if err != nil {
+		return createdSecret{}, err
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			d.deleteAttachmentContents(attachmentIDs)
+		}
+	}()
+
+	// begin a transaction so that an invite or upload is only used up if the secret is created
+	tx, err := d.db.Begin()
+	if err != nil {
+		return createdSecret{}, fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	var inviteID sql.NullString
+	if s.inviteID != "" {
+		if err := useInvite(tx, s.inviteID, now); err != nil {
+			return createdSecret{}, err
+		}
+
+		inviteID = sql.NullString{Valid: true, String: s.inviteID}
+	}
+
+	if s.uploadID != "" {
+		if err := useUpload(tx, s.uploadID); err != nil {
+			return createdSecret{}, err
+		}
+	}
+
+	if s.challenge != nil {
+		if err := spendChallenge(tx, *s.challenge); err != nil {
+			return createdSecret{}, err
+		}
+	}
+
+	var creatorSubject sql.NullString
+	var creatorEmail sql.NullString
+	var creatorAccountID sql.NullString
+	if s.creator != nil {
+		creatorSubject = sql.NullString{Valid: s.creator.Subject != "", String: s.creator.Subject}
+		creatorEmail = sql.NullString{Valid: s.creator.Email != "", String: s.creator.Email}
+		creatorAccountID = sql.NullString{Valid: s.creator.AccountID != "", String: s.creator.AccountID}
+	}
+
+	apiTokenID := sql.NullString{Valid: s.apiTokenID != "", String: s.apiTokenID}
+
+	var organisationID sql.NullString
+	if s.organisation != nil {
+		organisationID = sql.NullString{Valid: true, String: s.organisation.ID}
+	}
+
+	// only a hash of the verifier is stored, so that it cannot be presented by anybody that can read the database
+	var verifierHash sql.NullString
+	var maximumKeyAttempts sql.NullInt64
+	if s.verifier != "" {
+		h, err := hashVerifier(s.verifier)
+		if err != nil {
+			return createdSecret{}, err
+		}
+
+		verifierHash = sql.NullString{Valid: true, String: h}
+		maximumKeyAttempts = sql.NullInt64{Valid: true, Int64: defaultMaxKeyAttempts}
+		if s.policy != nil {
+			maximumKeyAttempts.Int64 = int64(s.policy.maxKeyAttempts)
+		}
+	}
+
+	var secretID int64
+	err = tx.QueryRow(
+		`
+			INSERT INTO
+				secrets (
+					access_id,
+					management_id,
+					cipher_text,
+					ttl,
+					maximum_views,
+					created_at,
+					expires_at,
+					invite_id,
+					creator_subject,
+					creator_email,
+					creator_account_id,
+					api_token_id,
+					organisation_id,
+					verifier_hash,
+					maximum_key_attempts
+				)
+			VALUES
+				(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING
+				id
+		`,
+		accessID,
+		managementID,
+		s.cipherText,
+		s.ttl,
+		s.maxViews,
+		now.UnixMilli(),
+		now.Add(time.Duration(s.ttl)*time.Minute).UnixMilli(),
+		inviteID,
+		creatorSubject,
+		creatorEmail,
+		creatorAccountID,
+		apiTokenID,
+		organisationID,
+		verifierHash,
+		maximumKeyAttempts,
+	).Scan(&secretID)
+	if err != nil {
+		return createdSecret{}, fmt.Errorf("inserting secret: %w", err)
+	}
+
+	if err := insertAttachments(tx, secretID, attachmentIDs, s.attachments, now); err != nil {
+		return createdSecret{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return createdSecret{}, fmt.Errorf("committing tx: %w", err)
+	}
+
+	committed = true
+
+	return createdSecret{accessID: accessID, managementID: managementID}, nil
+}
+
+// secretExists identifies whether a secret with the given access identifier exists and has neither been deleted nor
+// expired
+func (d *database) secretExists(accessID string) error {
+	var secretID int
+	err := d.reader.QueryRow(
+		`
+			SELECT
+				id
+			FROM
+				secrets
+			WHERE
+				access_id = ? AND
+				deleted_at IS NULL AND
+				expires_at > ?
+		`,
+		accessID,
+		time.Now().UnixMilli(),
+	).Scan(&secretID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return errSecretNotFound
+	}
+
+	return err
+}
+
+// createSecretView creates a 'view' of a secret, returning the 64 bit viewing key that must be used to actually view
+// it. The view is created without a viewing date, as this is set when the secret is viewed.
+//
+// One of the secret's views is reserved for the key, so that a secret never has more keys outstanding than it has views
+// remaining. [errNoViewsRemaining] is returned if every remaining view has already been reserved.
+func (d *database) createSecretView(accessID string) (string, error) {
+	key, err := secureID(8)
+	if err != nil {
+		return "", fmt.Errorf("creating secret viewing key: %w", err)
+	}
+
+	now := time.Now().UnixMilli()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	// views reserved by this secret's expired keys are released first, so that they can be reserved again
+	_, err = d.releaseExpiredViewingKeys(
+		tx,
+		now,
+		"secret_id IN (SELECT id FROM secrets WHERE access_id = ?)",
+		accessID,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	// the view is reserved in the same statement that checks there's one to reserve, so that concurrent requests can't
+	// both reserve the last one
+	var secretID int64
+	err = tx.QueryRow(
+		`
+			UPDATE
+				secrets
+			SET
+				reserved_views = reserved_views + 1
+			WHERE
+				access_id = ? AND
+				deleted_at IS NULL AND
+				expires_at > ? AND
+				(maximum_views = 0 OR reserved_views < maximum_views)
+			RETURNING
+				id
+		`,
+		accessID,
+		now,
+	).Scan(&secretID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		if err := d.secretExists(accessID); err != nil {
+			return "", err
+		}
+
+		return "", errNoViewsRemaining
+	} else if err != nil {
+		return "", fmt.Errorf("reserving secret view: %w", err)
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO secret_views (secret_id, viewing_key, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		secretID,
+		key,
+		now,
+		now+d.viewingKeyTTL.Milliseconds(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("inserting secret view: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("committing tx: %w", err)
+	}
+
+	return key, nil
+}
+
+// viewSecret uses a viewing key to retrieve the cipher text of a secret, marking the secret as deleted if the view is
+// equal to or exceeds the maximum number of permitted views. [errViewingKeyExpired] is returned if the viewing key was
+// not used within its time to live.
+//
+// If the secret is protected by a key verifier the verifier must match, otherwise the viewing key is not used and
+// [errVerificationRequired] (if the verifier is empty) or an [incorrectKeyError] is returned.
+func (d *database) viewSecret(accessID string, viewingKey string, verifier string) (viewedSecret, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return viewedSecret{}, fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	now := time.Now().UnixMilli()
+
+	// retrieve the secret's key verifier (if it has one) and check the viewing key is one of its unused ones, or
+	// return an error if it cannot be found
+	var secretID int64
+	var verifierHash sql.NullString
+	var keyExpiresAt int64
+
+	err = tx.QueryRow(
+		`
+			SELECT
+				s.id,
+				s.verifier_hash,
+				v.expires_at
+			FROM
+				secrets s
+				INNER JOIN secret_views v ON v.secret_id = s.id
+			WHERE
+				s.access_id = ? AND
+				s.deleted_at IS NULL AND
+				s.expires_at > ? AND
+				v.viewing_key = ? AND
+				v.viewed_at IS NULL
+		`,
+		accessID,
+		now,
+		viewingKey,
+	).Scan(&secretID, &verifierHash, &keyExpiresAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return viewedSecret{}, errSecretNotFound
+	} else if err != nil {
+		return viewedSecret{}, fmt.Errorf("retrieving secret: %w", err)
+	} else if keyExpiresAt <= now {
+		return viewedSecret{}, errViewingKeyExpired
+	}
+
+	// the cipher text of a secret protected by a key verifier is only released to those that know its encryption key,
+	// so that it cannot be taken away and brute forced
+	if verifierHash.Valid {
+		if verifier == "" {
+			return viewedSecret{}, errVerificationRequired
+		}
+
+		if ok, err := verifierMatches(verifier, verifierHash.String); err != nil {
+			return viewedSecret{}, err
+		} else if !ok {
+			incorrect, err := recordIncorrectKey(tx, accessID)
+			if err != nil {
+				return viewedSecret{}, err
+			}
+
+			if err := tx.Commit(); err != nil {
+				return viewedSecret{}, fmt.Errorf("committing tx: %w", err)
+			}
+
+			// the secret is deleted once no more incorrect keys can be tried
+			if incorrect.attemptsRemaining == 0 {
+				if _, err := d.destroyDeletedAttachments(); err != nil {
+					return viewedSecret{}, err
+				}
+			}
+
+			return viewedSecret{}, incorrect
+		}
+	}
+
+	// use the viewing key so nobody else can use it to see the secret, which fails if a concurrent view already has
+	rs, err := tx.Exec(
+		`
+			UPDATE
+				secret_views
+			SET
+				viewed_at = ?1
+			WHERE
+				secret_id = ?2 AND
+				viewing_key = ?3 AND
+				viewed_at IS NULL AND
+				expires_at > ?1
+		`,
+		now,
+		secretID,
+		viewingKey,
+	)
+	if err != nil {
+		return viewedSecret{}, fmt.Errorf("updating secret view: %w", err)
+	}
+
+	if rc, err := rs.RowsAffected(); err != nil {
+		return viewedSecret{}, fmt.Errorf("rows affected: %w", err)
+	} else if rc == 0 {
+		return viewedSecret{}, errSecretNotFound
+	}
+
+	// the cipher text is read and the view counted in one conditional statement, so that however many viewers there
+	// are at once, it is never released more times than the secret's maximum views
+	var cipherText string
+	var views int
+	var maxViews int
+
+	err = tx.QueryRow(
+		`
+			UPDATE
+				secrets
+			SET
+				views = views + 1
+			WHERE
+				id = ? AND
+				deleted_at IS NULL AND
+				expires_at > ? AND
+				(maximum_views = 0 OR views < maximum_views)
+			RETURNING
+				cipher_text,
+				views,
+				maximum_views
+		`,
+		secretID,
+		now,
+	).Scan(&cipherText, &views, &maxViews)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return viewedSecret{}, errSecretNotFound
+	} else if err != nil {
+		return viewedSecret{}, fmt.Errorf("counting secret view: %w", err)
+	}
+
+	// attachments are released with the secret, as they are destroyed along with it if this is the final view
+	attachments, err := d.secretAttachments(tx, secretID)
+	if err != nil {
+		return viewedSecret{}, err
+	}
+
+	// mark the secret as being deleted if this view is equal to or exceeds the maximum permitted views for the secret
+	finalView := maxViews > 0 && views >= maxViews
+	if finalView {
+		_, err := tx.Exec(
+			"UPDATE secrets SET deleted_at = ?, deletion_reason = ?, cipher_text = NULL WHERE id = ?",
+			now,
+			deletionReasonMaximumViewCountHit,
+			secretID,
+		)
+		if err != nil {
+			return viewedSecret{}, fmt.Errorf("deleting secret: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return viewedSecret{}, fmt.Errorf("committing tx: %w", err)
+	}
+
+	if finalView && len(attachments) > 0 {
+		if _, err := d.destroyDeletedAttachments(); err != nil {
+			return viewedSecret{}, err
+		}
+	}
+
+	return viewedSecret{cipherText: cipherText, attachments: attachments, finalView: finalView}, nil
+}
+
+// secretByManagementID retrieves the information about a secret that is visible to its creator
+func (d *database) secretByManagementID(managementID string) (managedSecret, error) {
+	var s managedSecret
+	var createdAt int64
+	var expiresAt int64
+	var inviteID sql.NullString
+	var inviteLabel sql.NullString
+	var maximumKeyAttempts sql.NullInt64
+	var failedKeyAttempts int
+
+	err := d.reader.QueryRow(
+		`
+			SELECT
+				s.access_id,
+				s.ttl,
+				s.maximum_views,
+				s.views,
+				s.created_at,
+				s.expires_at,
+				i.id,
+				i.label,
+				s.cipher_text LIKE ?,
+				s.maximum_key_attempts,
+				s.failed_key_attempts,
+				(SELECT COUNT(1) FROM secret_attachments a WHERE a.secret_id = s.id)
+			FROM
+				secrets s
+				LEFT JOIN invites i ON i.id = s.invite_id
+			WHERE
+				s.management_id = ? AND
+				s.deleted_at IS NULL AND
+				s.expires_at > ?
+		`,
+		secretcrypto.Version+"."+secretcrypto.KDFNone+".%",
+		managementID,
+		time.Now().UnixMilli(),
+	).Scan(
+		&s.accessID,
+		&s.ttl,
+		&s.maximumViews,
+		&s.views,
+		&createdAt,
+		&expiresAt,
+		&inviteID,
+		&inviteLabel,
+		&s.keyInURL,
+		&maximumKeyAttempts,
+		&failedKeyAttempts,
+		&s.attachments,
+	)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return managedSecret{}, errSecretNotFound
+	} else if err != nil {
+		return managedSecret{}, err
+	}
+
+	s.createdAt = time.UnixMilli(createdAt)
+	s.expiresAt = time.UnixMilli(expiresAt)
+
+	if inviteID.Valid {
+		s.invite = &invite{id: inviteID.String, label: inviteLabel.String}
+	}
+
+	if maximumKeyAttempts.Valid {
+		s.keyAttempts = &keyAttempts{failed: failedKeyAttempts, maximum: int(maximumKeyAttempts.Int64)}
+	}
+
+	return s, nil
+}
+
+// deleteSecret deletes a secret (if it hasn't already been deleted) on behalf of its creator, returning
+// [errSecretNotFound] if there was nothing to delete
+func (d *database) deleteSecret(managementID string) error {
+	return d.deleteSecretWhere("management_id = ?", managementID)
+}
+
+// deleteExpiredSecrets deletes every secret that has expired by the given time and has not already been deleted,
+// returning the number of secrets that were deleted
+func (d *database) deleteExpiredSecrets(now time.Time) (int64, error) {
+	rs, err := d.db.Exec(
+		`
+			UPDATE
+				secrets
+			SET
+				deleted_at = ?1,
+				deletion_reason = ?2,
+				cipher_text = NULL
+			WHERE
+				expires_at <= ?1 AND
+				deleted_at IS NULL
+		`,
+		now.UnixMilli(),
+		deletionReasonExpired,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return rs.RowsAffected()
+}
+
+// deleteExpiredViewingKeys deletes every unused viewing key that has expired by the given time, releasing the views
+// reserved for them, and returns the number of keys that were deleted
+func (d *database) deleteExpiredViewingKeys(now time.Time) (int64, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	c, err := d.releaseExpiredViewingKeys(tx, now.UnixMilli(), "1 = 1")
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing tx: %w", err)
+	}
+
+	return c, nil
+}
+
+// releaseExpiredViewingKeys deletes the unused viewing keys matching the condition that have expired by now (in unix
+// milliseconds), and releases the views reserved for them so they can be reserved by new keys. It returns the number
+// of keys that were deleted.
+func (d *database) releaseExpiredViewingKeys(tx *transaction, now int64, condition string, args ...any) (int64, error) {
+	// the keys are deleted first so that a key deleted by concurrent transactions only has its view released once
+	rows, err := tx.Query(
+		"DELETE FROM secret_views WHERE viewed_at IS NULL AND expires_at <= ? AND "+condition+" RETURNING secret_id",
+		append([]any{now}, args...)...,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("deleting expired viewing keys: %w", err)
+	}
+	defer rows.Close()
+
+	released := map[int64]int{}
+	var c int64
+	for rows.Next() {
+		var secretID int64
+		if err := rows.Scan(&secretID); err != nil {
+			return 0, fmt.Errorf("scanning expired viewing key: %w", err)
+		}
+
+		released[secretID]++
+		c++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("deleting expired viewing keys: %w", err)
+	}
+	rows.Close()
+
+	for secretID, views := range released {
+		_, err := tx.Exec("UPDATE secrets SET reserved_views = reserved_views - ? WHERE id = ?", views, secretID)
+		if err != nil {
+			return 0, fmt.Errorf("releasing reserved views: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// deleteSecretWhere deletes the secret matching the condition, returning [errSecretNotFound] if there was nothing to
+// delete
+func (d *database) deleteSecretWhere(condition string, args ...any) error {
+	if rc, err := d.deleteSecretsWhere(condition, args...); err != nil {
+		return err
+	} else if rc == 0 {
+		return errSecretNotFound
+	}
+
+	return nil
+}
+
+// deleteSecretsWhere deletes every secret matching the condition that has not already been deleted, returning the
+// number of secrets that were deleted
+func (d *database) deleteSecretsWhere(condition string, args ...any) (int64, error) {
+	rs, err := d.db.Exec(
+		"UPDATE secrets SET deleted_at = ?, deletion_reason = ?, cipher_text = NULL WHERE "+condition+" AND deleted_at IS NULL",
+		append([]any{time.Now().UnixMilli(), deletionReasonUserDeleted}, args...)...,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	rc, err := rs.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+
+	if rc > 0 {
+		if _, err := d.destroyDeletedAttachments(); err != nil {
+			return rc, err
+		}
+	}
+
+	return rc, nil
+}
@@ -1,13 +1,19 @@
 package shareasecret
 
 import (
+	"crypto/rand"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io/fs"
 	"net"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -22,15 +28,91 @@ const deletionReasonUserDeleted = "user_deleted"
 // hit or exceeded
 const deletionReasonMaximumViewCountHit = "maximum_view_count_hit"
 
+// deletionReasonMaximumKeyAttemptsHit is a deletion reason used when too many incorrect encryption keys have been
+// tried against a secret protected by a key verifier
+const deletionReasonMaximumKeyAttemptsHit = "maximum_key_attempts_hit"
+
+// databaseDriverSQLite and databaseDriverPostgres are the database engines that can be configured
+const (
+	databaseDriverSQLite   = "sqlite"
+	databaseDriverPostgres = "postgres"
+)
+
 // Configuration contains all of the possible configuration options for the application.
 type Configuration struct {
 	Database struct {
-		Path string
+		// Driver is the database engine used, which is either "sqlite" (the default) or "postgres"
+		Driver string
+		Path   string
+		URL    string
+		SQLite SQLitePragmas
 	}
 	Server struct {
-		BaseUrl       string
-		ListeningAddr string
+		BaseUrl           string
+		ListeningAddr     string
+		ReadTimeout       time.Duration
+		ReadHeaderTimeout time.Duration
+		WriteTimeout      time.Duration
+		IdleTimeout       time.Duration
+		MaxHeaderBytes    int
+		ShutdownTimeout   time.Duration
+		TLS               struct {
+			CertFile     string
+			KeyFile      string
+			RedirectAddr string
+		}
+		TrustedProxies []net.IPNet
+		ClientIPHeader string
+	}
+	RateLimits struct {
+		Create          RateLimit
+		View            RateLimit
+		FailedLookups   RateLimit
+		LockoutDuration time.Duration
+	}
+	ProofOfWork struct {
+		Difficulty     int
+		MaxDifficulty  int
+		ScaleThreshold int
+		ChallengeTTL   time.Duration
 	}
+	OIDC struct {
+		Issuer              string
+		ClientID            string
+		ClientSecret        string
+		Scopes              []string
+		AllowedEmailDomains []string
+		AllowedGroups       []string
+		GroupsClaim         string
+		SessionDuration     time.Duration
+	}
+	Passkeys struct {
+		Enabled          bool
+		RelyingPartyName string
+		SessionDuration  time.Duration
+	}
+	Policy struct {
+		TTLs                []time.Duration
+		DefaultTTL          time.Duration
+		MaxTTL              time.Duration
+		MinViews            int
+		MaxViews            int
+		AllowUnlimitedViews bool
+		MaxSecretSize       int
+		MaxKeyAttempts      int
+		MaxAttachments      int
+		MaxAttachmentSize   int
+	}
+	ViewingKeys struct {
+		// TTL is how long a viewing key can be used for after it was created
+		TTL time.Duration
+	}
+	Attachments struct {
+		// Dir is the directory the contents of attachments are stored in. They are stored in the database if it is
+		// empty.
+		Dir string
+	}
+	SigningKey                 []byte
 	SecretCreationRestrictions struct {
 		IPAddresses struct {
 			FixedIPs []net.IP
@@ -47,9 +129,41 @@ func (c *Configuration) PopulateFromEnv() error {
 		return fmt.Errorf("loading env file: %w", err)
 	}
 
-	c.Database.Path = os.Getenv("SHAREASECRET_DB_PATH")
-	if c.Database.Path == "" {
-		return fmt.Errorf("SHAREASECRET_DB_PATH not set")
+	c.Database.Driver = os.Getenv("SHAREASECRET_DB_DRIVER")
+	switch c.Database.Driver {
+	case "", databaseDriverSQLite:
+		c.Database.Driver = databaseDriverSQLite
+
+		c.Database.Path = os.Getenv("SHAREASECRET_DB_PATH")
+		if c.Database.Path == "" {
+			return fmt.Errorf("SHAREASECRET_DB_PATH not set")
+		}
+
+		if c.Database.SQLite.BusyTimeout, err = envDuration("SHAREASECRET_DB_BUSY_TIMEOUT", 5*time.Second); err != nil {
+			return err
+		}
+
+		c.Database.SQLite.Synchronous = strings.ToUpper(strings.TrimSpace(os.Getenv("SHAREASECRET_DB_SYNCHRONOUS")))
+		if c.Database.SQLite.Synchronous == "" {
+			c.Database.SQLite.Synchronous = "NORMAL"
+		} else if !slices.Contains(sqliteSynchronousModes, c.Database.SQLite.Synchronous) {
+			return fmt.Errorf("SHAREASECRET_DB_SYNCHRONOUS must be one of %v", strings.Join(sqliteSynchronousModes, ", "))
+		}
+
+		if c.Database.SQLite.CacheSize, err = envInt("SHAREASECRET_DB_CACHE_SIZE", 0); err != nil {
+			return err
+		}
+
+		if c.Database.SQLite.MmapSize, err = envInt("SHAREASECRET_DB_MMAP_SIZE", 0); err != nil {
+			return err
+		}
+	case databaseDriverPostgres:
+		c.Database.URL = os.Getenv("SHAREASECRET_DB_URL")
+		if c.Database.URL == "" {
+			return fmt.Errorf("SHAREASECRET_DB_URL not set")
+		}
+	default:
+		return fmt.Errorf("SHAREASECRET_DB_DRIVER must be %v or %v", databaseDriverSQLite, databaseDriverPostgres)
 	}
 
 	c.Server.BaseUrl = os.Getenv("SHAREASECRET_BASE_URL")
@@ -62,6 +176,224 @@ func (c *Configuration) PopulateFromEnv() error {
 		c.Server.ListeningAddr = "127.0.0.1:8994"
 	}
 
+	if c.Server.ReadTimeout, err = envDuration("SHAREASECRET_SERVER_READ_TIMEOUT", 15*time.Second); err != nil {
+		return err
+	}
+
+	if c.Server.ReadHeaderTimeout, err = envDuration("SHAREASECRET_SERVER_READ_HEADER_TIMEOUT", 5*time.Second); err != nil {
+		return err
+	}
+
+	if c.Server.WriteTimeout, err = envDuration("SHAREASECRET_SERVER_WRITE_TIMEOUT", 30*time.Second); err != nil {
+		return err
+	}
+
+	if c.Server.IdleTimeout, err = envDuration("SHAREASECRET_SERVER_IDLE_TIMEOUT", 2*time.Minute); err != nil {
+		return err
+	}
+
+	if c.Server.MaxHeaderBytes, err = envInt("SHAREASECRET_SERVER_MAX_HEADER_BYTES", 64*1024); err != nil {
+		return err
+	}
+
+	if c.Server.ShutdownTimeout, err = envDuration("SHAREASECRET_SERVER_SHUTDOWN_TIMEOUT", 30*time.Second); err != nil {
+		return err
+	}
+
+	c.Server.TLS.CertFile = os.Getenv("SHAREASECRET_TLS_CERT_FILE")
+	c.Server.TLS.KeyFile = os.Getenv("SHAREASECRET_TLS_KEY_FILE")
+	if (c.Server.TLS.CertFile == "") != (c.Server.TLS.KeyFile == "") {
+		return fmt.Errorf("SHAREASECRET_TLS_CERT_FILE and SHAREASECRET_TLS_KEY_FILE must be set together")
+	}
+
+	c.Server.TLS.RedirectAddr = os.Getenv("SHAREASECRET_TLS_REDIRECT_ADDR")
+
+	// proxies running on the same host are trusted by default, as that is how most reverse proxies are deployed
+	trustedProxies, ok := os.LookupEnv("SHAREASECRET_TRUSTED_PROXIES")
+	if !ok {
+		trustedProxies = "127.0.0.0/8,::1"
+	}
+
+	if c.Server.TrustedProxies, err = parseNetworks("SHAREASECRET_TRUSTED_PROXIES", trustedProxies); err != nil {
+		return err
+	}
+
+	switch h := strings.TrimSpace(os.Getenv("SHAREASECRET_CLIENT_IP_HEADER")); {
+	case h == "" || strings.EqualFold(h, clientIPHeaderXForwardedFor):
+		c.Server.ClientIPHeader = clientIPHeaderXForwardedFor
+	case strings.EqualFold(h, clientIPHeaderXRealIP):
+		c.Server.ClientIPHeader = clientIPHeaderXRealIP
+	case strings.EqualFold(h, clientIPHeaderForwarded):
+		c.Server.ClientIPHeader = clientIPHeaderForwarded
+	default:
+		return fmt.Errorf("invalid header (%v) in SHAREASECRET_CLIENT_IP_HEADER", h)
+	}
+
+	if c.RateLimits.Create, err = envRateLimit("SHAREASECRET_RATE_LIMIT_CREATE", "20/1m"); err != nil {
+		return err
+	}
+
+	if c.RateLimits.View, err = envRateLimit("SHAREASECRET_RATE_LIMIT_VIEW", "60/1m"); err != nil {
+		return err
+	}
+
+	if c.RateLimits.FailedLookups, err = envRateLimit("SHAREASECRET_RATE_LIMIT_FAILED_LOOKUPS", "10/10m"); err != nil {
+		return err
+	}
+
+	if c.RateLimits.LockoutDuration, err = envDuration("SHAREASECRET_RATE_LIMIT_LOCKOUT_DURATION", 15*time.Minute); err != nil {
+		return err
+	}
+
+	if c.ProofOfWork.Difficulty, err = envInt("SHAREASECRET_POW_DIFFICULTY", 0); err != nil {
+		return err
+	} else if c.ProofOfWork.Difficulty > maximumProofOfWorkDifficulty {
+		return fmt.Errorf("SHAREASECRET_POW_DIFFICULTY cannot be greater than %v", maximumProofOfWorkDifficulty)
+	}
+
+	maxDifficulty := min(c.ProofOfWork.Difficulty+4, maximumProofOfWorkDifficulty)
+	if c.ProofOfWork.MaxDifficulty, err = envInt("SHAREASECRET_POW_MAX_DIFFICULTY", maxDifficulty); err != nil {
+		return err
+	} else if c.ProofOfWork.MaxDifficulty < c.ProofOfWork.Difficulty || c.ProofOfWork.MaxDifficulty > maximumProofOfWorkDifficulty {
+		return fmt.Errorf(
+			"SHAREASECRET_POW_MAX_DIFFICULTY must be between SHAREASECRET_POW_DIFFICULTY and %v",
+			maximumProofOfWorkDifficulty,
+		)
+	}
+
+	if c.ProofOfWork.ScaleThreshold, err = envInt("SHAREASECRET_POW_SCALE_THRESHOLD", 30); err != nil {
+		return err
+	}
+
+	if c.ProofOfWork.ChallengeTTL, err = envDuration("SHAREASECRET_POW_CHALLENGE_TTL", 10*time.Minute); err != nil {
+		return err
+	}
+
+	c.OIDC.Issuer = strings.TrimSpace(os.Getenv("SHAREASECRET_OIDC_ISSUER"))
+	c.OIDC.ClientID = strings.TrimSpace(os.Getenv("SHAREASECRET_OIDC_CLIENT_ID"))
+	c.OIDC.ClientSecret = os.Getenv("SHAREASECRET_OIDC_CLIENT_SECRET")
+	if c.OIDC.Issuer != "" && c.OIDC.ClientID == "" {
+		return fmt.Errorf("SHAREASECRET_OIDC_CLIENT_ID must be set when SHAREASECRET_OIDC_ISSUER is set")
+	}
+
+	c.OIDC.Scopes = splitList(os.Getenv("SHAREASECRET_OIDC_SCOPES"), " ")
+	if len(c.OIDC.Scopes) == 0 {
+		c.OIDC.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	c.OIDC.AllowedEmailDomains = splitList(os.Getenv("SHAREASECRET_OIDC_ALLOWED_EMAIL_DOMAINS"), ",")
+	c.OIDC.AllowedGroups = splitList(os.Getenv("SHAREASECRET_OIDC_ALLOWED_GROUPS"), ",")
+
+	c.OIDC.GroupsClaim = strings.TrimSpace(os.Getenv("SHAREASECRET_OIDC_GROUPS_CLAIM"))
+	if c.OIDC.GroupsClaim == "" {
+		c.OIDC.GroupsClaim = "groups"
+	}
+
+	if c.OIDC.SessionDuration, err = envDuration("SHAREASECRET_OIDC_SESSION_DURATION", 12*time.Hour); err != nil {
+		return err
+	}
+
+	if v := strings.TrimSpace(os.Getenv("SHAREASECRET_PASSKEYS_ENABLED")); v != "" {
+		if c.Passkeys.Enabled, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("invalid boolean (%v) in SHAREASECRET_PASSKEYS_ENABLED", v)
+		}
+	}
+
+	c.Passkeys.RelyingPartyName = strings.TrimSpace(os.Getenv("SHAREASECRET_PASSKEYS_RP_NAME"))
+	if c.Passkeys.RelyingPartyName == "" {
+		c.Passkeys.RelyingPartyName = "shareasecret"
+	}
+
+	if c.Passkeys.SessionDuration, err = envDuration("SHAREASECRET_PASSKEYS_SESSION_DURATION", 12*time.Hour); err != nil {
+		return err
+	}
+
+	if v := strings.TrimSpace(os.Getenv("SHAREASECRET_POLICY_TTLS")); v != "" {
+		for _, ttl := range splitList(v, ",") {
+			d, err := time.ParseDuration(ttl)
+			if err != nil || d < time.Minute || d%time.Minute != 0 {
+				return fmt.Errorf("invalid duration (%v) in SHAREASECRET_POLICY_TTLS, which must be whole minutes", ttl)
+			}
+
+			c.Policy.TTLs = append(c.Policy.TTLs, d)
+		}
+	}
+
+	if c.Policy.DefaultTTL, err = envDuration("SHAREASECRET_POLICY_DEFAULT_TTL", 0); err != nil {
+		return err
+	}
+
+	if c.Policy.MaxTTL, err = envDuration("SHAREASECRET_POLICY_MAX_TTL", 0); err != nil {
+		return err
+	}
+
+	if c.Policy.MinViews, err = envInt("SHAREASECRET_POLICY_MIN_VIEWS", 1); err != nil {
+		return err
+	}
+
+	if c.Policy.MaxViews, err = envInt("SHAREASECRET_POLICY_MAX_VIEWS", 0); err != nil {
+		return err
+	}
+
+	c.Policy.AllowUnlimitedViews = true
+	if v := strings.TrimSpace(os.Getenv("SHAREASECRET_POLICY_ALLOW_UNLIMITED_VIEWS")); v != "" {
+		if c.Policy.AllowUnlimitedViews, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("invalid boolean (%v) in SHAREASECRET_POLICY_ALLOW_UNLIMITED_VIEWS", v)
+		}
+	}
+
+	if c.Policy.MaxSecretSize, err = envInt("SHAREASECRET_POLICY_MAX_SECRET_SIZE", 64*1024); err != nil {
+		return err
+	}
+
+	if c.Policy.MaxKeyAttempts, err = envInt("SHAREASECRET_POLICY_MAX_KEY_ATTEMPTS", defaultMaxKeyAttempts); err != nil {
+		return err
+	} else if c.Policy.MaxKeyAttempts < 1 {
+		return fmt.Errorf("SHAREASECRET_POLICY_MAX_KEY_ATTEMPTS must be at least 1")
+	}
+
+	if c.Policy.MaxAttachments, err = envInt("SHAREASECRET_POLICY_MAX_ATTACHMENTS", 5); err != nil {
+		return err
+	}
+
+	if c.Policy.MaxAttachmentSize, err = envInt("SHAREASECRET_POLICY_MAX_ATTACHMENT_SIZE", defaultMaxAttachmentSize); err != nil {
+		return err
+	} else if c.Policy.MaxAttachmentSize < 1 {
+		return fmt.Errorf("SHAREASECRET_POLICY_MAX_ATTACHMENT_SIZE must be at least 1")
+	}
+
+	viewingKeyTTL, err := envInt("SHAREASECRET_VIEWING_KEY_TTL_SECONDS", int(defaultViewingKeyTTL.Seconds()))
+	if err != nil {
+		return err
+	} else if viewingKeyTTL == 0 {
+		return fmt.Errorf("SHAREASECRET_VIEWING_KEY_TTL_SECONDS must be greater than 0")
+	}
+	c.ViewingKeys.TTL = time.Duration(viewingKeyTTL) * time.Second
+
+	switch storage := strings.TrimSpace(os.Getenv("SHAREASECRET_ATTACHMENT_STORAGE")); storage {
+	case "", "database":
+	case "filesystem":
+		c.Attachments.Dir = strings.TrimSpace(os.Getenv("SHAREASECRET_ATTACHMENT_DIR"))
+		if c.Attachments.Dir == "" {
+			return fmt.Errorf("SHAREASECRET_ATTACHMENT_DIR must be set when SHAREASECRET_ATTACHMENT_STORAGE is filesystem")
+		}
+	default:
+		return fmt.Errorf("invalid storage (%v) in SHAREASECRET_ATTACHMENT_STORAGE", storage)
+	}
+
+	// the signing key is generated when the application starts if it is not set, which invalidates anything signed by
+	// a previous instance of the application
+	if k := os.Getenv("SHAREASECRET_SIGNING_KEY"); k != "" {
+		if len(k) < 32 {
+			return fmt.Errorf("SHAREASECRET_SIGNING_KEY must be at least 32 characters long")
+		}
+
+		c.SigningKey = []byte(k)
+	} else if c.Database.Driver == databaseDriverPostgres {
+		// instances sharing a database must share a signing key, otherwise anything signed by one is rejected by the rest
+		return fmt.Errorf("SHAREASECRET_SIGNING_KEY must be set when SHAREASECRET_DB_DRIVER is postgres")
+	}
+
 	if cr := strings.TrimSpace(os.Getenv("SHAREASECRET_SECRET_CREATION_IP_RESTRICTIONS")); cr != "" {
 		for _, v := range strings.Split(cr, ",") {
 			v = strings.TrimSpace(v)
@@ -90,31 +422,263 @@ func (c *Configuration) PopulateFromEnv() error {
 	return nil
 }
 
+// parseNetworks parses a comma separated list of IP addresses and/or CIDRs, treating individual IP addresses as a
+// network containing only that address
+func parseNetworks(name string, v string) ([]net.IPNet, error) {
+	networks := []net.IPNet{}
+
+	for _, v := range strings.Split(v, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		if strings.Contains(v, "/") {
+			_, nw, err := net.ParseCIDR(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR (%v) in %v: %w", v, name, err)
+			}
+
+			networks = append(networks, *nw)
+		} else if ip := net.ParseIP(v); ip == nil {
+			return nil, fmt.Errorf("invalid ip in %v: %v", name, v)
+		} else if ip.To4() != nil {
+			networks = append(networks, net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)})
+		} else {
+			networks = append(networks, net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+		}
+	}
+
+	return networks, nil
+}
+
+// splitList splits a list of values separated by sep, discarding empty values and surrounding whitespace
+func splitList(v string, sep string) []string {
+	values := []string{}
+
+	for _, v := range strings.Split(v, sep) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+// envDuration parses the environment variable of the given name as a [time.Duration] (i.e. 30s or 1m), returning the
+// default value if it is not set
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration (%v) in %v", v, name)
+	}
+
+	return d, nil
+}
+
+// envRateLimit parses the environment variable of the given name as a [RateLimit] (i.e. 20/1m), returning the default
+// value if it is not set
+func envRateLimit(name string, def string) (RateLimit, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		v = def
+	}
+
+	return parseRateLimit(name, v)
+}
+
+// envInt parses the environment variable of the given name as a non-negative integer, returning the default value if
+// it is not set
+func envInt(name string, def int) (int, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid number (%v) in %v", v, name)
+	}
+
+	return i, nil
+}
+
 // Application is a wrapper/container for the "ShareASecret" project. All jobs and entry points hang off of this
 // struct.
 type Application struct {
 	db        *database
+	store     Store
 	config    *Configuration
 	router    *http.ServeMux
 	baseURL   string
 	webAssets fs.FS
+	jobs      sync.WaitGroup
+
+	// instanceID identifies this instance amongst any others sharing its database, such as when holding job leases
+	instanceID string
+
+	clientIPs *clientIPResolver
+	limiter   *rateLimiter
+	signer    *signer
+	pow       *proofOfWork
+	policy    *creationPolicy
+
+	// oidc is only set if OpenID Connect sign in has been configured
+	oidc *oidcProvider
+
+	// passkeys is only set if passkey accounts have been enabled
+	passkeys *relyingParty
+
+	// certificates is only set if TLS has been configured
+	certificates *certificateReloader
 }
 
 // NewApplication initializes the Application struct which provides access to all available components of the project.
 func NewApplication(config *Configuration, webAssets fs.FS) (*Application, error) {
-	db, err := newDatabase("file:" + config.Database.Path)
+	var db *database
+	var err error
+	if config.Database.Driver == databaseDriverPostgres {
+		db, err = newPostgresDatabase(config.Database.URL)
+	} else {
+		db, err = newSQLiteDatabase(config.Database.Path, config.Database.SQLite)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("new db: %w", err)
 	}
 
+	instanceID, err := secureID(12)
+	if err != nil {
+		return nil, fmt.Errorf("generating instance id: %w", err)
+	}
+
 	application := &Application{
-		db:        db,
-		config:    config,
-		router:    http.NewServeMux(),
-		baseURL:   config.Server.BaseUrl,
-		webAssets: webAssets,
+		db:         db,
+		store:      db,
+		instanceID: instanceID,
+		config:     config,
+		router:     http.NewServeMux(),
+		baseURL:    config.Server.BaseUrl,
+		webAssets:  webAssets,
+		clientIPs: &clientIPResolver{
+			trustedProxies: config.Server.TrustedProxies,
+			header:         config.Server.ClientIPHeader,
+		},
+	}
+	application.limiter = &rateLimiter{
+		db: db,
+		limits: map[string]RateLimit{
+			rateLimitBucketCreate:        config.RateLimits.Create,
+			rateLimitBucketView:          config.RateLimits.View,
+			rateLimitBucketFailedLookups: config.RateLimits.FailedLookups,
+		},
+		lockout: config.RateLimits.LockoutDuration,
+		clientIP: func(r *http.Request) string {
+			if ip := application.clientIP(r); ip != nil {
+				return ip.String()
+			}
+
+			return "unknown"
+		},
+	}
+
+	signingKey := config.SigningKey
+	if len(signingKey) == 0 {
+		signingKey = make([]byte, 32)
+		if _, err := rand.Read(signingKey); err != nil {
+			return nil, fmt.Errorf("generating signing key: %w", err)
+		}
+	}
+
+	if application.policy, err = newCreationPolicy(config); err != nil {
+		return nil, err
+	}
+
+	if config.ViewingKeys.TTL > 0 {
+		db.viewingKeyTTL = config.ViewingKeys.TTL
+	}
+
+	if config.Attachments.Dir != "" {
+		if db.attachments, err = newFileAttachmentStore(config.Attachments.Dir); err != nil {
+			return nil, err
+		}
+	}
+
+	application.signer = &signer{key: signingKey}
+	application.pow = &proofOfWork{
+		db:             db,
+		signer:         application.signer,
+		difficulty:     config.ProofOfWork.Difficulty,
+		maxDifficulty:  config.ProofOfWork.MaxDifficulty,
+		scaleThreshold: config.ProofOfWork.ScaleThreshold,
+		ttl:            config.ProofOfWork.ChallengeTTL,
+	}
+
+	if config.OIDC.Issuer != "" {
+		application.oidc = &oidcProvider{
+			issuer:              config.OIDC.Issuer,
+			clientID:            config.OIDC.ClientID,
+			clientSecret:        config.OIDC.ClientSecret,
+			redirectURL:         config.Server.BaseUrl + "/auth/callback",
+			scopes:              config.OIDC.Scopes,
+			allowedEmailDomains: config.OIDC.AllowedEmailDomains,
+			allowedGroups:       config.OIDC.AllowedGroups,
+			groupsClaim:         config.OIDC.GroupsClaim,
+			httpClient:          &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+
+	if config.Passkeys.Enabled {
+		application.passkeys, err = newRelyingParty(config.Server.BaseUrl, config.Passkeys.RelyingPartyName)
+		if err != nil {
+			return nil, err
+		}
 	}
+
 	application.mapRoutes()
 
+	if config.Server.TLS.CertFile != "" {
+		application.certificates, err = newCertificateReloader(config.Server.TLS.CertFile, config.Server.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+	}
+
 	return application, nil
 }
+
+// NewServer creates a [http.Server] that serves the application on the configured listening address with the
+// configured timeouts and limits. If TLS has been configured the server's TLSConfig is set, and it should be started
+// with ListenAndServeTLS.
+func (a *Application) NewServer() *http.Server {
+	var tlsConfig *tls.Config
+	if a.certificates != nil {
+		tlsConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: a.certificates.getCertificate,
+		}
+	}
+
+	return &http.Server{
+		Addr:              a.config.Server.ListeningAddr,
+		Handler:           a,
+		ReadTimeout:       a.config.Server.ReadTimeout,
+		ReadHeaderTimeout: a.config.Server.ReadHeaderTimeout,
+		WriteTimeout:      a.config.Server.WriteTimeout,
+		IdleTimeout:       a.config.Server.IdleTimeout,
+		MaxHeaderBytes:    a.config.Server.MaxHeaderBytes,
+		TLSConfig:         tlsConfig,
+	}
+}
+
+// Close waits for any running jobs to stop (which they will once the context they were started with is cancelled)
+// and then closes the database.
+func (a *Application) Close() error {
+	a.jobs.Wait()
+
+	return a.db.close()
+}
@@ -2,7 +2,9 @@ package shareasecret
 
 import (
 	"database/sql"
+	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"strings"
 	"testing"
@@ -11,14 +13,44 @@ import (
 
 var app *Application
 
+// testProxyAddr is the remote address of all test requests, and belongs to a trusted proxy
+const testProxyAddr = "10.0.0.1:51234"
+
+// testEncryptedSecret is a secret (an empty string encrypted with the key "empty plaintext") in the format produced by
+// the web interface
+const testEncryptedSecret = "v2.pbkdf2-sha256-600000.yjq9raoOFbzI29ZPE2XGYQ.AAECAwQFBgcICQoLDA0ODw.EBESExQVFhcYGRob"
+
+// testKeyInURLEncryptedSecret is a secret (an empty string) encrypted with a generated key, as produced by the web
+// interface when the key is put in the viewing URL
+const testKeyInURLEncryptedSecret = "v2.none.-mxfvymMV8hxPqKNCLpXjQ.yurl3CCw3oWWONj6pWtEBg.hkz958_uBWSClrn0"
+
 func TestMain(m *testing.M) {
 	_, nw, _ := net.ParseCIDR("127.0.0.0/8")
 
 	config := &Configuration{}
 	config.Database.Path = "shareasecret_test.db"
+	config.Database.SQLite.BusyTimeout = 5 * time.Second
 	config.Server.BaseUrl = "http://127.0.0.1:8999"
 	config.SecretCreationRestrictions.IPAddresses.CIDRs = []net.IPNet{*nw}
 
+	// test requests are made via a trusted proxy (see the testProxyAddr constant) which sets the X-Forwarded-For header
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	config.Server.TrustedProxies = []net.IPNet{*proxies}
+	config.Server.ClientIPHeader = clientIPHeaderXForwardedFor
+	config.Policy.AllowUnlimitedViews = true
+
+	// the suite is ran against PostgreSQL instead of SQLite if a database to run it against is provided
+	if url := os.Getenv("SHAREASECRET_TEST_POSTGRES_URL"); url != "" {
+		schemaURL, drop, err := newTestPostgresSchema(url)
+		if err != nil {
+			panic(err)
+		}
+		defer drop()
+
+		config.Database.Driver = databaseDriverPostgres
+		config.Database.URL = schemaURL
+	}
+
 	a, err := NewApplication(config, os.DirFS("../web/"))
 	if err != nil {
 		panic(err)
@@ -38,6 +70,38 @@ func TestMain(m *testing.M) {
 	m.Run()
 }
 
+// newTestPostgresSchema creates an empty schema in the PostgreSQL database at the URL, so that tests always start from
+// an empty database, and returns a URL that uses it along with a function that drops it
+func newTestPostgresSchema(dsn string) (string, func() error, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing url: %w", err)
+	}
+
+	con, err := sql.Open(postgresDialect.driver, dsn)
+	if err != nil {
+		return "", nil, err
+	}
+
+	id, _ := secureID(8)
+	schema := "shareasecret_test_" + id
+	if _, err := con.Exec("CREATE SCHEMA " + schema); err != nil {
+		con.Close()
+		return "", nil, fmt.Errorf("creating schema: %w", err)
+	}
+
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	return u.String(), func() error {
+		defer con.Close()
+
+		_, err := con.Exec("DROP SCHEMA " + schema + " CASCADE")
+		return err
+	}, nil
+}
+
 // createSecret creates a secret instance in the database
 func createSecret(t *testing.T, deletedAt time.Time, deletionReason string) (string, string) {
 	accessID, _ := secureID(24)
@@ -56,17 +120,18 @@ func createSecret(t *testing.T, deletedAt time.Time, deletionReason string) (str
 
 	_, err := app.db.db.Exec(
 		`
-			INSERT INTO secrets (access_id, management_id, maximum_views, ttl, cipher_text, deleted_at, deletion_reason, created_at)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			INSERT INTO secrets (access_id, management_id, maximum_views, ttl, cipher_text, deleted_at, deletion_reason, created_at, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 		`,
 		accessID,
 		managementID,
 		1,
 		30,
-		"a.b.c",
+		testEncryptedSecret,
 		dbDeletedAt,
 		dbDeletionReason,
 		time.Now().UnixMilli(),
+		time.Now().Add(30*time.Minute).UnixMilli(),
 	)
 	if err != nil {
 		t.Errorf("creating secret: %v", err)
@@ -75,6 +140,48 @@ func createSecret(t *testing.T, deletedAt time.Time, deletionReason string) (str
 	return accessID, managementID
 }
 
+// expireSecret moves the expiry of a secret into the past without marking it as deleted, as would be the case if the
+// expiry job had not ran yet
+func expireSecret(t *testing.T, accessID string) {
+	_, err := app.db.db.Exec(
+		"UPDATE secrets SET expires_at = ? WHERE access_id = ?",
+		time.Now().Add(-1*time.Minute).UnixMilli(),
+		accessID,
+	)
+	if err != nil {
+		t.Errorf("expiring secret: %v", err)
+	}
+}
+
+// createExpiredViewingKey creates an unused viewing key for a secret (reserving one of its views) that expired a minute
+// ago
+func createExpiredViewingKey(t *testing.T, accessID string) string {
+	viewingKey, _ := secureID(8)
+
+	_, err := app.db.db.Exec(
+		`
+			INSERT INTO secret_views (secret_id, viewing_key, created_at, expires_at)
+			SELECT id, ?, ?, ?
+			FROM secrets
+			WHERE access_id = ?
+		`,
+		viewingKey,
+		time.Now().Add(-11*time.Minute).UnixMilli(),
+		time.Now().Add(-1*time.Minute).UnixMilli(),
+		accessID,
+	)
+	if err != nil {
+		t.Errorf("creating expired viewing key: %v", err)
+	}
+
+	_, err = app.db.db.Exec("UPDATE secrets SET reserved_views = reserved_views + 1 WHERE access_id = ?", accessID)
+	if err != nil {
+		t.Errorf("reserving view: %v", err)
+	}
+
+	return viewingKey
+}
+
 // until continuously loops until the given function returns truthy or the maximum tries are exceeded (at which point a
 // test failure will occur)
 func until(t *testing.T, try func() bool, maximumTries uint8, delay time.Duration) {
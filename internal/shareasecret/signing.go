@@ -0,0 +1,43 @@
+package shareasecret
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// signer signs and verifies values issued by the server (i.e. proof of work challenges and invites) so that they can
+// be handed out without being stored, and trusted when they are handed back
+type signer struct {
+	key []byte
+}
+
+// sign returns the base64 encoded HMAC-SHA256 signature of the payload. The purpose is included in the signature so
+// that a value signed for one purpose can't be used for another.
+func (s *signer) sign(purpose string, payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(purpose + ":" + payload))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signed returns the payload with its signature appended, separated by a period
+func (s *signer) signed(purpose string, payload string) string {
+	return payload + "." + s.sign(purpose, payload)
+}
+
+// verify checks the signature of a value created by [signer.signed], returning the payload if it is valid
+func (s *signer) verify(purpose string, value string) (string, bool) {
+	i := strings.LastIndex(value, ".")
+	if i < 0 {
+		return "", false
+	}
+
+	payload, signature := value[:i], value[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(s.sign(purpose, payload))) {
+		return "", false
+	}
+
+	return payload, true
+}
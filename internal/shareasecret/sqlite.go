@@ -2,102 +2,101 @@ package shareasecret
 
 import (
 	"database/sql"
-	"embed"
 	"fmt"
-	"io/fs"
-	"sort"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
 
-//go:embed migrations/*.sql
-var migrationFS embed.FS
-
-// database is a wrapper around a SQLite database
-type database struct {
-	db *sql.DB
+// sqliteDialect is the dialect of SQLite databases
+var sqliteDialect = dialect{
+	driver:     "sqlite",
+	migrations: "migrations/sqlite",
 }
 
-// newDatabase creates a SQLite connection and then runs any applicable migrations or seeders
-func newDatabase(connectionString string) (*database, error) {
-	con, err := sql.Open("sqlite", connectionString)
-	if err != nil {
-		return nil, err
-	}
+// SQLitePragmas contains the pragmas that are set on every connection to a SQLite database
+type SQLitePragmas struct {
+	// BusyTimeout is how long a connection waits for a lock held by another (such as that of another process writing
+	// to the database) before failing
+	BusyTimeout time.Duration
 
-	db := &database{
-		db: con,
-	}
+	// Synchronous is how often SQLite waits for writes to reach the disk: OFF, NORMAL, FULL or EXTRA
+	Synchronous string
 
-	if err := db.migrate(); err != nil {
-		return nil, fmt.Errorf("migrate: %w", err)
-	}
+	// CacheSize is the maximum size of each connection's page cache in KiB, or SQLite's default if it is 0
+	CacheSize int
 
-	return db, nil
+	// MmapSize is the maximum number of bytes of the database that are memory mapped, which is disabled if it is 0
+	MmapSize int
 }
 
-// migrate migrates the database within a transaction, rolling it back and returning the error
-// should any occurr
-func (d *database) migrate() error {
-	// you have to enable WAL outside of a transaction
-	if _, err := d.db.Exec("PRAGMA journal_mode = wal;"); err != nil {
-		return fmt.Errorf("unable to enable wal: %w", err)
-	}
+// sqliteSynchronousModes are the values the synchronous pragma can be set to
+var sqliteSynchronousModes = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
 
-	// you have to enable foreign key checks outside of a transaction
-	if _, err := d.db.Exec("PRAGMA foreign_keys = ON;"); err != nil {
-		return fmt.Errorf("unable to enable foreign keys: %w", err)
-	}
+// newSQLiteDatabase creates the pools of connections to a SQLite database and then runs any applicable migrations or
+// seeders.
+//
+// SQLite only permits one writer at a time, so writes are made through a pool of one connection that they queue for,
+// rather than racing for the lock and failing with SQLITE_BUSY. Transactions begin immediately (taking the write lock
+// up front) so that one which reads before writing can't fail if another process writes in between. Lookups are made
+// through a separate pool of read-only connections, which WAL lets read alongside the writer.
+func newSQLiteDatabase(path string, pragmas SQLitePragmas) (*database, error) {
+	dsn := sqliteDSN(path, pragmas)
 
-	// Create the migrations table if it doesn't yet exist
-	if _, err := d.db.Exec("CREATE TABLE IF NOT EXISTS migrations (name TEXT PRIMARY KEY);"); err != nil {
-		return fmt.Errorf("create migration table: %w", err)
+	writer, err := sql.Open("sqlite", dsn+"&_txlock=immediate&_pragma=journal_mode(wal)")
+	if err != nil {
+		return nil, err
 	}
 
-	// retrieve a list of migration files to execute, then execute them all within a transaction
-	fileNames, err := fs.Glob(migrationFS, "migrations/*.sql")
+	writer.SetMaxOpenConns(1)
+	writer.SetConnMaxLifetime(0)
+
+	reader, err := sql.Open("sqlite", dsn+"&_pragma=query_only(1)")
 	if err != nil {
-		return fmt.Errorf("globbing migration files: %w", err)
+		writer.Close()
+		return nil, err
 	}
-	sort.Strings(fileNames)
 
-	tx, err := d.db.Begin()
+	reader.SetMaxOpenConns(max(4, runtime.NumCPU()))
+
+	db, err := openDatabase(sqliteDialect, writer, reader)
 	if err != nil {
-		return fmt.Errorf("unable to start transaction: %w", err)
+		reader.Close()
+		writer.Close()
+		return nil, err
 	}
-	defer tx.Rollback()
 
-	for _, fileName := range fileNames {
-		if err = d.migrateFile(fileName, tx); err != nil {
-			return err
-		}
-	}
+	return db, nil
+}
 
-	tx.Commit()
+// sqliteDSN builds the connection string of a SQLite database, which sets the pragmas on each connection as it is
+// opened
+func sqliteDSN(path string, pragmas SQLitePragmas) string {
+	p := []string{
+		fmt.Sprintf("busy_timeout(%d)", pragmas.BusyTimeout.Milliseconds()),
+		"foreign_keys(1)",
+	}
 
-	return nil
-}
+	if pragmas.Synchronous != "" {
+		p = append(p, fmt.Sprintf("synchronous(%s)", pragmas.Synchronous))
+	}
 
-// migrateFile runs a migration file if it hasn't been ran already
-func (d *database) migrateFile(fileName string, tx *sql.Tx) error {
-	// check if the migration has been ran before and, if it has, return early
-	var c int
-	if err := tx.QueryRow("SELECT COUNT(*) FROM migrations WHERE name = ?", fileName).Scan(&c); err != nil {
-		return err
-	} else if c != 0 {
-		return nil
+	// the cache size is negated as SQLite treats negative sizes as KiB rather than pages
+	if pragmas.CacheSize != 0 {
+		p = append(p, fmt.Sprintf("cache_size(%d)", -pragmas.CacheSize))
 	}
 
-	// read the file and execute it against the database
-	if buf, err := fs.ReadFile(migrationFS, fileName); err != nil {
-		return err
-	} else if _, err := tx.Exec(string(buf)); err != nil {
-		return err
+	if pragmas.MmapSize != 0 {
+		p = append(p, fmt.Sprintf("mmap_size(%d)", pragmas.MmapSize))
 	}
 
-	if _, err := tx.Exec("INSERT INTO migrations (name) VALUES (?)", fileName); err != nil {
-		return err
+	q := make([]string, len(p))
+	for i, v := range p {
+		q[i] = "_pragma=" + url.QueryEscape(v)
 	}
 
-	return nil
+	return "file:" + path + "?" + strings.Join(q, "&")
 }
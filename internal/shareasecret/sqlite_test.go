@@ -0,0 +1,218 @@
+package shareasecret
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSQLiteDatabase(t *testing.T) {
+	t.Run("sets pragmas on every connection", func(t *testing.T) {
+		db, err := newSQLiteDatabase(
+			filepath.Join(t.TempDir(), "pragmas.db"),
+			SQLitePragmas{BusyTimeout: 1234 * time.Millisecond, Synchronous: "FULL", CacheSize: 4096, MmapSize: 1 << 20},
+		)
+		if err != nil {
+			t.Fatalf("opening database: %v", err)
+		}
+		defer db.close()
+
+		// several connections are held open at once so that the pool can't hand out the same one each time
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				tx, err := db.reader.Begin()
+				if err != nil {
+					t.Errorf("begin tx: %v", err)
+					return
+				}
+				defer tx.Rollback()
+
+				var busyTimeout, synchronous, cacheSize, mmapSize, foreignKeys int
+				tx.QueryRow("PRAGMA busy_timeout").Scan(&busyTimeout)
+				tx.QueryRow("PRAGMA synchronous").Scan(&synchronous)
+				tx.QueryRow("PRAGMA cache_size").Scan(&cacheSize)
+				tx.QueryRow("PRAGMA mmap_size").Scan(&mmapSize)
+				tx.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys)
+
+				if busyTimeout != 1234 || synchronous != 2 || cacheSize != -4096 || mmapSize != 1<<20 || foreignKeys != 1 {
+					t.Errorf("unexpected pragmas %v %v %v %v %v", busyTimeout, synchronous, cacheSize, mmapSize, foreignKeys)
+				}
+			}()
+		}
+		wg.Wait()
+
+		var journalMode string
+		db.db.QueryRow("PRAGMA journal_mode").Scan(&journalMode)
+
+		if journalMode != "wal" {
+			t.Errorf("expected wal journal mode, got %v", journalMode)
+		}
+	})
+
+	t.Run("only reads through the reader pool", func(t *testing.T) {
+		db := newTestSQLiteDatabase(t)
+
+		_, err := db.reader.Exec("DELETE FROM secrets")
+		if err == nil || !strings.Contains(err.Error(), "readonly") {
+			t.Errorf("expected write to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("indexes the queries that filter every secret", func(t *testing.T) {
+		db := newTestSQLiteDatabase(t)
+
+		queries := map[string]string{
+			"SELECT COUNT(1) FROM secrets WHERE created_at > ?":                         "idx_secrets_created_at",
+			"SELECT id FROM secrets WHERE creator_subject = ? ORDER BY created_at DESC": "idx_secrets_creator_subject",
+		}
+
+		for query, index := range queries {
+			rows, err := db.reader.Query("EXPLAIN QUERY PLAN "+query, 0)
+			if err != nil {
+				t.Fatalf("explaining query: %v", err)
+			}
+
+			var plan []string
+			for rows.Next() {
+				var id, parent, unused int
+				var detail string
+				if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+					t.Fatalf("scanning query plan: %v", err)
+				}
+
+				plan = append(plan, detail)
+			}
+			rows.Close()
+
+			if !strings.Contains(strings.Join(plan, "\n"), index) {
+				t.Errorf("expected %q to use %v, got %v", query, index, plan)
+			}
+		}
+	})
+
+	t.Run("queues concurrent writers rather than failing them", func(t *testing.T) {
+		db := newTestSQLiteDatabase(t)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 100)
+		for i := 0; i < cap(errs); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				c, err := db.createSecret(newSecret{cipherText: testEncryptedSecret, ttl: 30, maxViews: 2})
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				key, err := db.createSecretView(c.accessID)
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				_, err = db.viewSecret(c.accessID, key, "")
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+		}
+	})
+}
+
+func BenchmarkSQLiteDatabase(b *testing.B) {
+	db, err := newSQLiteDatabase(
+		filepath.Join(b.TempDir(), "bench.db"),
+		SQLitePragmas{BusyTimeout: 5 * time.Second, Synchronous: "NORMAL"},
+	)
+	if err != nil {
+		b.Fatalf("opening database: %v", err)
+	}
+	defer db.close()
+
+	b.Run("parallel creates", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := db.createSecret(newSecret{cipherText: testEncryptedSecret, ttl: 30, maxViews: 1}); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	})
+
+	b.Run("parallel views", func(b *testing.B) {
+		accessIDs := make([]string, b.N)
+		for i := range accessIDs {
+			c, err := db.createSecret(newSecret{cipherText: testEncryptedSecret, ttl: 30, maxViews: 1})
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			accessIDs[i] = c.accessID
+		}
+
+		var next sync.Mutex
+		i := 0
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				next.Lock()
+				accessID := accessIDs[i]
+				i++
+				next.Unlock()
+
+				key, err := db.createSecretView(accessID)
+				if err != nil {
+					b.Error(err)
+					continue
+				}
+
+				if _, err := db.viewSecret(accessID, key, ""); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	})
+
+	b.Run("parallel lookups", func(b *testing.B) {
+		c, err := db.createSecret(newSecret{cipherText: testEncryptedSecret, ttl: 30, maxViews: 0})
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := db.secretByManagementID(c.managementID); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	})
+}
+
+// newTestSQLiteDatabase creates a SQLite database in a temporary directory that is closed once the test completes
+func newTestSQLiteDatabase(t *testing.T) *database {
+	db, err := newSQLiteDatabase(filepath.Join(t.TempDir(), "test.db"), SQLitePragmas{BusyTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+
+	t.Cleanup(func() { db.close() })
+
+	return db
+}
@@ -0,0 +1,272 @@
+package shareasecret
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFS embed.FS
+
+// Store persists secrets through their lifecycle, from creation through to their deletion by a viewer, their creator or
+// expiry. It's implemented by [database] for each of the supported database engines.
+type Store interface {
+	// createSecret validates and persists a secret
+	createSecret(s newSecret) (createdSecret, error)
+
+	// uploadedSecret retrieves the content of a complete upload so that a secret can be created from it
+	uploadedSecret(uploadID string) (string, []attachment, error)
+
+	// secretExists identifies whether a secret exists and can still be viewed
+	secretExists(accessID string) error
+
+	// createSecretView mints a single use viewing key for a secret
+	createSecretView(accessID string) (string, error)
+
+	// viewSecret uses a viewing key to view a secret. The view must be atomic, so that concurrent viewers can't view a
+	// secret more times than it permits.
+	viewSecret(accessID string, viewingKey string, verifier string) (viewedSecret, error)
+
+	// secretKeyChallenge retrieves the key challenge for a secret protected by a key verifier without using the viewing
+	// key
+	secretKeyChallenge(accessID string, viewingKey string) (keyChallenge, error)
+
+	// secretByManagementID retrieves the information about a secret that is visible to its creator
+	secretByManagementID(managementID string) (managedSecret, error)
+
+	// deleteSecret deletes a secret on behalf of its creator
+	deleteSecret(managementID string) error
+
+	// secretsByCreator retrieves a page of the secrets created by the creator that are in the given state, and whether
+	// there are more pages
+	secretsByCreator(c secretCreator, state secretState, page int) ([]creatorSecret, bool, error)
+
+	// deleteSecretsByCreator deletes the secrets with the given access identifiers that were created by the creator,
+	// returning how many were deleted
+	deleteSecretsByCreator(c secretCreator, accessIDs []string) (int64, error)
+
+	// deleteAllSecretsByCreator deletes every active secret created by the creator, returning how many were deleted
+	deleteAllSecretsByCreator(c secretCreator) (int64, error)
+
+	// deleteExpiredSecrets deletes the secrets that have expired by the given time, returning how many were deleted
+	deleteExpiredSecrets(now time.Time) (int64, error)
+
+	// deleteExpiredViewingKeys deletes the unused viewing keys that have expired by the given time, releasing the views
+	// reserved for them, and returns how many were deleted
+	deleteExpiredViewingKeys(now time.Time) (int64, error)
+
+	// acquireJobLease acquires (or renews) the lease on a job for the holder, returning false if another holder's lease
+	// has not yet expired. It ensures background jobs aren't run by more than one instance at a time.
+	acquireJobLease(name string, holder string, ttl time.Duration) (bool, error)
+}
+
+// dialect contains the differences between the database engines that can be used
+type dialect struct {
+	// driver is the name of the database/sql driver for the engine
+	driver string
+
+	// migrations is the directory of the engine's migrations within migrationFS
+	migrations string
+
+	// lock is a statement that prevents migrations being ran concurrently by instances sharing the database
+	lock string
+
+	// numberedPlaceholders is set if the engine only supports $N placeholders, which queries (written with ? and ?N
+	// placeholders) are rewritten to use
+	numberedPlaceholders bool
+}
+
+// rebind rewrites the placeholders in a query into those supported by the dialect
+func (d dialect) rebind(query string) string {
+	if !d.numberedPlaceholders || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+
+	n := 0
+	quoted := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && !quoted:
+			b.WriteByte('$')
+
+			// ?N placeholders keep their number, and ? placeholders are numbered in the order they appear
+			if i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9' {
+				continue
+			}
+
+			n++
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
+
+// conn is a connection pool to a database that rewrites queries for its dialect
+type conn struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (c *conn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.DB.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.DB.Query(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) QueryRow(query string, args ...any) *sql.Row {
+	return c.DB.QueryRow(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) Begin() (*transaction, error) {
+	t, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &transaction{Tx: t, dialect: c.dialect}, nil
+}
+
+// transaction is a transaction that rewrites queries for its dialect
+type transaction struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (t *transaction) Exec(query string, args ...any) (sql.Result, error) {
+	return t.Tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t *transaction) Query(query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t *transaction) QueryRow(query string, args ...any) *sql.Row {
+	return t.Tx.QueryRow(t.dialect.rebind(query), args...)
+}
+
+// database is a [Store] (and the persistence for everything else) backed by a SQL database
+type database struct {
+	db *conn
+
+	// reader is used for lookups that don't write anything, which is a separate, read-only pool of connections for
+	// SQLite so that they aren't queued behind its single writer
+	reader *conn
+
+	// attachments stores the encrypted contents of attachments, which is the database itself unless configured
+	// otherwise
+	attachments attachmentStore
+
+	// viewingKeyTTL is how long a viewing key can be used for after it was created
+	viewingKeyTTL time.Duration
+}
+
+// openDatabase wraps the pools of connections to a database of the given dialect, which writer and reader may be the
+// same pool of, and then runs any applicable migrations
+func openDatabase(d dialect, writer *sql.DB, reader *sql.DB) (*database, error) {
+	db := &database{
+		db:            &conn{DB: writer, dialect: d},
+		reader:        &conn{DB: reader, dialect: d},
+		viewingKeyTTL: defaultViewingKeyTTL,
+	}
+	db.attachments = &databaseAttachmentStore{db: db.db, reader: db.reader}
+
+	if err := db.migrate(); err != nil {
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+
+	return db, nil
+}
+
+// close closes the pools of connections to the database
+func (d *database) close() error {
+	if d.reader.DB != d.db.DB {
+		if err := d.reader.Close(); err != nil {
+			return err
+		}
+	}
+
+	return d.db.Close()
+}
+
+// migrate migrates the database within a transaction, rolling it back and returning the error
+// should any occurr
+func (d *database) migrate() error {
+	// retrieve a list of migration files to execute, then execute them all within a transaction
+	fileNames, err := fs.Glob(migrationFS, d.db.dialect.migrations+"/*.sql")
+	if err != nil {
+		return fmt.Errorf("globbing migration files: %w", err)
+	}
+	sort.Strings(fileNames)
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("unable to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if d.db.dialect.lock != "" {
+		if _, err := tx.Exec(d.db.dialect.lock); err != nil {
+			return fmt.Errorf("locking migrations: %w", err)
+		}
+	}
+
+	// Create the migrations table if it doesn't yet exist
+	if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS migrations (name TEXT PRIMARY KEY);"); err != nil {
+		return fmt.Errorf("create migration table: %w", err)
+	}
+
+	for _, fileName := range fileNames {
+		if err = d.migrateFile(fileName, tx); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// migrateFile runs a migration file if it hasn't been ran already
+func (d *database) migrateFile(fileName string, tx *transaction) error {
+	// migrations are recorded without the dialect's directory, which they were ran from before there was more than one
+	name := "migrations/" + path.Base(fileName)
+
+	// check if the migration has been ran before and, if it has, return early
+	var c int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM migrations WHERE name = ?", name).Scan(&c); err != nil {
+		return err
+	} else if c != 0 {
+		return nil
+	}
+
+	// read the file and execute it against the database
+	if buf, err := fs.ReadFile(migrationFS, fileName); err != nil {
+		return err
+	} else if _, err := tx.Exec(string(buf)); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	if _, err := tx.Exec("INSERT INTO migrations (name) VALUES (?)", name); err != nil {
+		return err
+	}
+
+	return nil
+}
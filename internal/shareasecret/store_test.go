@@ -0,0 +1,389 @@
+package shareasecret
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStores(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		db := newTestSQLiteDatabase(t)
+
+		testStore(t, db)
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		url := os.Getenv("SHAREASECRET_TEST_POSTGRES_URL")
+		if url == "" {
+			t.Skip("SHAREASECRET_TEST_POSTGRES_URL not set")
+		}
+
+		url, drop, err := newTestPostgresSchema(url)
+		if err != nil {
+			t.Fatalf("creating schema: %v", err)
+		}
+		defer drop()
+
+		db, err := newPostgresDatabase(url)
+		if err != nil {
+			t.Fatalf("opening database: %v", err)
+		}
+		defer db.close()
+
+		testStore(t, db)
+	})
+}
+
+// testStore runs the conformance tests every [Store] implementation must pass
+func testStore(t *testing.T, s Store) {
+	create := func(t *testing.T, maxViews int) createdSecret {
+		c, err := s.createSecret(newSecret{cipherText: testEncryptedSecret, ttl: 30, maxViews: maxViews})
+		if err != nil {
+			t.Fatalf("creating secret: %v", err)
+		}
+
+		return c
+	}
+
+	view := func(t *testing.T, accessID string) (viewedSecret, error) {
+		key, err := s.createSecretView(accessID)
+		if err != nil {
+			t.Fatalf("creating secret view: %v", err)
+		}
+
+		return s.viewSecret(accessID, key, "")
+	}
+
+	t.Run("creates and views secrets until their maximum views are reached", func(t *testing.T) {
+		c := create(t, 2)
+
+		if v, err := view(t, c.accessID); err != nil || v.cipherText != testEncryptedSecret || v.finalView {
+			t.Fatalf("expected first view to succeed, got %v %v", v, err)
+		}
+
+		if v, err := view(t, c.accessID); err != nil || !v.finalView {
+			t.Fatalf("expected final view to succeed, got %v %v", v, err)
+		}
+
+		if _, err := s.createSecretView(c.accessID); !errors.Is(err, errSecretNotFound) {
+			t.Errorf("expected viewed secret to be deleted, got %v", err)
+		}
+	})
+
+	t.Run("rejects reused and unknown viewing keys", func(t *testing.T) {
+		c := create(t, 0)
+
+		key, _ := s.createSecretView(c.accessID)
+		if _, err := s.viewSecret(c.accessID, key, ""); err != nil {
+			t.Fatalf("expected view to succeed, got %v", err)
+		}
+
+		if _, err := s.viewSecret(c.accessID, key, ""); !errors.Is(err, errSecretNotFound) {
+			t.Errorf("expected reused key to be rejected, got %v", err)
+		}
+
+		if _, err := s.viewSecret(c.accessID, "unknown", ""); !errors.Is(err, errSecretNotFound) {
+			t.Errorf("expected unknown key to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("retrieves secrets by their management id", func(t *testing.T) {
+		c := create(t, 3)
+		view(t, c.accessID)
+
+		m, err := s.secretByManagementID(c.managementID)
+		if err != nil || m.accessID != c.accessID || m.maximumViews != 3 || m.views != 1 || m.ttl != 30 {
+			t.Errorf("unexpected secret %+v %v", m, err)
+		}
+
+		if _, err := s.secretByManagementID("unknown"); !errors.Is(err, errSecretNotFound) {
+			t.Errorf("expected unknown secret not to be found, got %v", err)
+		}
+	})
+
+	t.Run("deletes secrets", func(t *testing.T) {
+		c := create(t, 0)
+
+		if err := s.deleteSecret(c.managementID); err != nil {
+			t.Fatalf("deleting secret: %v", err)
+		}
+
+		if err := s.deleteSecret(c.managementID); !errors.Is(err, errSecretNotFound) {
+			t.Errorf("expected deleted secret not to be deleted again, got %v", err)
+		}
+
+		if _, err := s.secretByManagementID(c.managementID); !errors.Is(err, errSecretNotFound) {
+			t.Errorf("expected deleted secret not to be found, got %v", err)
+		}
+
+		if _, err := s.createSecretView(c.accessID); !errors.Is(err, errSecretNotFound) {
+			t.Errorf("expected deleted secret not to be viewable, got %v", err)
+		}
+	})
+
+	t.Run("identifies secrets that can still be viewed", func(t *testing.T) {
+		c := create(t, 0)
+
+		if err := s.secretExists(c.accessID); err != nil {
+			t.Errorf("expected secret to exist, got %v", err)
+		}
+
+		s.deleteSecret(c.managementID)
+
+		if err := s.secretExists(c.accessID); !errors.Is(err, errSecretNotFound) {
+			t.Errorf("expected deleted secret not to exist, got %v", err)
+		}
+	})
+
+	t.Run("lists and deletes the secrets created by a creator", func(t *testing.T) {
+		subject, _ := secureID(8)
+		creator := secretCreator{subject: subject}
+
+		var created []createdSecret
+		for i := 0; i < 3; i++ {
+			c, err := s.createSecret(newSecret{cipherText: testEncryptedSecret, ttl: 30, creator: &identity{Subject: subject}})
+			if err != nil {
+				t.Fatalf("creating secret: %v", err)
+			}
+			created = append(created, c)
+		}
+
+		if secrets, more, err := s.secretsByCreator(creator, secretStateActive, 1); err != nil || len(secrets) != 3 || more {
+			t.Fatalf("expected creator's secrets to be listed, got %v %v %v", secrets, more, err)
+		} else if secrets[0].accessID != created[2].accessID {
+			t.Errorf("expected newest secret first, got %v", secrets[0].accessID)
+		}
+
+		if deleted, err := s.deleteSecretsByCreator(creator, []string{created[0].accessID, "unknown"}); err != nil || deleted != 1 {
+			t.Errorf("expected one secret to be deleted, got %v %v", deleted, err)
+		}
+
+		if deleted, err := s.deleteSecretsByCreator(secretCreator{subject: "someone else"}, []string{created[1].accessID}); err != nil || deleted != 0 {
+			t.Errorf("expected another creator's secret not to be deleted, got %v %v", deleted, err)
+		}
+
+		if deleted, err := s.deleteAllSecretsByCreator(creator); err != nil || deleted != 2 {
+			t.Errorf("expected remaining secrets to be deleted, got %v %v", deleted, err)
+		}
+
+		if secrets, _, err := s.secretsByCreator(creator, secretStateDeleted, 1); err != nil || len(secrets) != 3 {
+			t.Errorf("expected deleted secrets to be listed, got %v %v", secrets, err)
+		}
+	})
+
+	t.Run("deletes expired secrets", func(t *testing.T) {
+		c := create(t, 0)
+		key, _ := s.createSecretView(c.accessID)
+
+		if deleted, err := s.deleteExpiredSecrets(time.Now().Add(31 * time.Minute)); err != nil || deleted < 1 {
+			t.Fatalf("expected secrets to be deleted, got %v %v", deleted, err)
+		}
+
+		if _, err := s.viewSecret(c.accessID, key, ""); !errors.Is(err, errSecretNotFound) {
+			t.Errorf("expected expired secret not to be viewable, got %v", err)
+		}
+	})
+
+	t.Run("reserves a view for every viewing key", func(t *testing.T) {
+		c := create(t, 2)
+
+		for i := 0; i < 2; i++ {
+			if _, err := s.createSecretView(c.accessID); err != nil {
+				t.Fatalf("expected viewing key to be created, got %v", err)
+			}
+		}
+
+		if _, err := s.createSecretView(c.accessID); !errors.Is(err, errNoViewsRemaining) {
+			t.Errorf("expected no views to remain, got %v", err)
+		}
+
+		if _, err := s.createSecretView("unknown"); !errors.Is(err, errSecretNotFound) {
+			t.Errorf("expected unknown secret not to be found, got %v", err)
+		}
+	})
+
+	t.Run("reveals a one view secret exactly once to hundreds of concurrent viewers", func(t *testing.T) {
+		c := create(t, 1)
+
+		var wg sync.WaitGroup
+		errs := make([]error, 300)
+		for i := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				var key string
+				if key, errs[i] = s.createSecretView(c.accessID); errs[i] == nil {
+					_, errs[i] = s.viewSecret(c.accessID, key, "")
+				}
+			}()
+		}
+		wg.Wait()
+
+		views := 0
+		for _, err := range errs {
+			if err == nil {
+				views++
+			} else if !errors.Is(err, errSecretNotFound) && !errors.Is(err, errNoViewsRemaining) {
+				t.Errorf("unexpected error %v", err)
+			}
+		}
+
+		if views != 1 {
+			t.Errorf("expected secret to be viewed once, got %v", views)
+		}
+	})
+
+	t.Run("never counts more views than a secret permits when more keys are outstanding", func(t *testing.T) {
+		d, ok := s.(*database)
+		if !ok {
+			t.Skip("keys can only be created beyond a secret's views directly in its database")
+		}
+
+		// keys created before views were reserved for them may outnumber a secret's views
+		c := create(t, 1)
+		keys := make([]string, 50)
+		for i := range keys {
+			keys[i], _ = secureID(8)
+
+			_, err := d.db.Exec(
+				"INSERT INTO secret_views (secret_id, viewing_key, created_at, expires_at) SELECT id, ?, ?, ? FROM secrets WHERE access_id = ?",
+				keys[i],
+				time.Now().UnixMilli(),
+				time.Now().Add(time.Minute).UnixMilli(),
+				c.accessID,
+			)
+			if err != nil {
+				t.Fatalf("inserting viewing key: %v", err)
+			}
+		}
+
+		var wg sync.WaitGroup
+		errs := make([]error, len(keys))
+		for i, key := range keys {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = s.viewSecret(c.accessID, key, "")
+			}()
+		}
+		wg.Wait()
+
+		views := 0
+		for _, err := range errs {
+			if err == nil {
+				views++
+			} else if !errors.Is(err, errSecretNotFound) {
+				t.Errorf("unexpected error %v", err)
+			}
+		}
+
+		if views != 1 {
+			t.Errorf("expected secret to be viewed once, got %v", views)
+		}
+	})
+
+	t.Run("deletes expired viewing keys and releases the views reserved for them", func(t *testing.T) {
+		c := create(t, 1)
+		key, _ := s.createSecretView(c.accessID)
+
+		if _, err := s.createSecretView(c.accessID); !errors.Is(err, errNoViewsRemaining) {
+			t.Fatalf("expected no views to remain, got %v", err)
+		}
+
+		if deleted, err := s.deleteExpiredViewingKeys(time.Now().Add(defaultViewingKeyTTL)); err != nil || deleted < 1 {
+			t.Fatalf("expected viewing keys to be deleted, got %v %v", deleted, err)
+		}
+
+		if _, err := s.viewSecret(c.accessID, key, ""); !errors.Is(err, errSecretNotFound) {
+			t.Errorf("expected deleted viewing key to be rejected, got %v", err)
+		}
+
+		if v, err := view(t, c.accessID); err != nil || !v.finalView {
+			t.Errorf("expected released view to be used, got %v %v", v, err)
+		}
+	})
+
+	t.Run("rejects expired viewing keys and releases their views when new keys are created", func(t *testing.T) {
+		d, ok := s.(*database)
+		if !ok {
+			t.Skip("viewing keys can only be expired directly in their database")
+		}
+
+		c := create(t, 1)
+		key, _ := s.createSecretView(c.accessID)
+
+		_, err := d.db.Exec("UPDATE secret_views SET expires_at = ? WHERE viewing_key = ?", time.Now().UnixMilli(), key)
+		if err != nil {
+			t.Fatalf("expiring viewing key: %v", err)
+		}
+
+		if _, err := s.viewSecret(c.accessID, key, ""); !errors.Is(err, errViewingKeyExpired) {
+			t.Errorf("expected expired viewing key to be rejected, got %v", err)
+		}
+
+		if v, err := view(t, c.accessID); err != nil || !v.finalView {
+			t.Errorf("expected released view to be used, got %v %v", v, err)
+		}
+	})
+
+	t.Run("leases jobs to one holder at a time", func(t *testing.T) {
+		name, _ := secureID(8)
+
+		if ok, err := s.acquireJobLease(name, "a", time.Minute); err != nil || !ok {
+			t.Fatalf("expected lease to be acquired, got %v %v", ok, err)
+		}
+
+		if ok, err := s.acquireJobLease(name, "b", time.Minute); err != nil || ok {
+			t.Errorf("expected held lease not to be acquired, got %v %v", ok, err)
+		}
+
+		// the holder renews the lease, but with one that has already expired
+		if ok, err := s.acquireJobLease(name, "a", -time.Minute); err != nil || !ok {
+			t.Errorf("expected lease to be renewed, got %v %v", ok, err)
+		}
+
+		if ok, err := s.acquireJobLease(name, "b", time.Minute); err != nil || !ok {
+			t.Errorf("expected expired lease to be acquired, got %v %v", ok, err)
+		}
+	})
+}
+
+func TestPostgresConfiguration(t *testing.T) {
+	t.Setenv("SHAREASECRET_DB_DRIVER", databaseDriverPostgres)
+	t.Setenv("SHAREASECRET_DB_URL", "postgres://localhost/shareasecret")
+	t.Setenv("SHAREASECRET_BASE_URL", "http://127.0.0.1:8994")
+	t.Setenv("SHAREASECRET_SIGNING_KEY", "")
+
+	if err := (&Configuration{}).PopulateFromEnv(); err == nil || !strings.Contains(err.Error(), "SHAREASECRET_SIGNING_KEY") {
+		t.Errorf("expected a signing key to be required, got %v", err)
+	}
+
+	t.Setenv("SHAREASECRET_SIGNING_KEY", strings.Repeat("k", 32))
+
+	if err := (&Configuration{}).PopulateFromEnv(); err != nil {
+		t.Errorf("expected configuration with a signing key to be valid, got %v", err)
+	}
+}
+
+func TestDialectRebind(t *testing.T) {
+	cases := map[string]string{
+		"SELECT 1 FROM secrets WHERE a = ? AND b = ?":       "SELECT 1 FROM secrets WHERE a = $1 AND b = $2",
+		"UPDATE secrets SET a = ?1 WHERE b = ?2 AND c > ?1": "UPDATE secrets SET a = $1 WHERE b = $2 AND c > $1",
+		"SELECT '?' FROM secrets WHERE a = ?":               "SELECT '?' FROM secrets WHERE a = $1",
+	}
+
+	for query, expected := range cases {
+		if rebound := postgresDialect.rebind(query); rebound != expected {
+			t.Errorf("expected %q, got %q", expected, rebound)
+		}
+
+		if rebound := sqliteDialect.rebind(query); rebound != query {
+			t.Errorf("expected sqlite query to be unchanged, got %q", rebound)
+		}
+	}
+}
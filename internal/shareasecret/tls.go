@@ -0,0 +1,139 @@
+package shareasecret
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// certificateReloader serves a TLS certificate and key pair read from disk, reloading them whenever either file
+// changes so that certificates can be rotated without restarting the application
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	version string
+}
+
+// newCertificateReloader creates a [certificateReloader], loading the certificate and key pair immediately
+func newCertificateReloader(certFile string, keyFile string) (*certificateReloader, error) {
+	c := &certificateReloader{certFile: certFile, keyFile: keyFile}
+
+	if _, err := c.reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// reload reloads the certificate and key pair if either file has changed since they were last loaded, returning
+// whether they were reloaded. The previously loaded certificate continues to be served if the files are invalid (i.e.
+// because they are only partially written).
+func (c *certificateReloader) reload() (bool, error) {
+	version, err := c.filesVersion()
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.RLock()
+	unchanged := version == c.version
+	c.mu.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("loading certificate: %w", err)
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.version = version
+	c.mu.Unlock()
+
+	return true, nil
+}
+
+// filesVersion returns a value that changes whenever the certificate or key file is modified
+func (c *certificateReloader) filesVersion() (string, error) {
+	version := ""
+
+	for _, f := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return "", fmt.Errorf("stat %v: %w", f, err)
+		}
+
+		version += fmt.Sprintf("%d:%d;", fi.ModTime().UnixNano(), fi.Size())
+	}
+
+	return version, nil
+}
+
+// getCertificate returns the most recently loaded certificate and is intended to be used as the GetCertificate
+// function of a [tls.Config]
+func (c *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
+
+// RunReloadTLSCertificateJob runs a background job that reloads the TLS certificate and key whenever they change on
+// disk. It does nothing if TLS has not been configured. The job stops once the context is cancelled.
+func (a *Application) RunReloadTLSCertificateJob(ctx context.Context) {
+	if a.certificates == nil {
+		return
+	}
+
+	a.runJobInBackground(
+		ctx,
+		"reload_tls_certificate",
+		func(l zerolog.Logger) error {
+			reloaded, err := a.certificates.reload()
+			if err != nil {
+				return err
+			}
+
+			if reloaded {
+				l.Info().Msg("reloaded tls certificate")
+			}
+
+			return nil
+		},
+		30*time.Second,
+	)
+}
+
+// NewRedirectServer creates a [http.Server] that redirects all requests to the application's base URL. It returns nil
+// if TLS or a redirect listening address have not been configured.
+func (a *Application) NewRedirectServer() *http.Server {
+	if a.certificates == nil || a.config.Server.TLS.RedirectAddr == "" {
+		return nil
+	}
+
+	return &http.Server{
+		Addr:              a.config.Server.TLS.RedirectAddr,
+		Handler:           http.HandlerFunc(a.handleRedirectToHTTPS),
+		ReadTimeout:       a.config.Server.ReadTimeout,
+		ReadHeaderTimeout: a.config.Server.ReadHeaderTimeout,
+		WriteTimeout:      a.config.Server.WriteTimeout,
+		IdleTimeout:       a.config.Server.IdleTimeout,
+		MaxHeaderBytes:    a.config.Server.MaxHeaderBytes,
+	}
+}
+
+// handleRedirectToHTTPS permanently redirects the request to the same path under the application's base URL
+func (a *Application) handleRedirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, a.baseURL+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
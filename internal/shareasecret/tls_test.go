@@ -0,0 +1,135 @@
+package shareasecret
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	writeCertificate(t, certFile, keyFile, "first.example", time.Now())
+
+	c, err := newCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("creating reloader: %v", err)
+	}
+
+	t.Run("serves the loaded certificate", func(t *testing.T) {
+		if cn := servedCommonName(t, c); cn != "first.example" {
+			t.Errorf("expected first.example certificate, got %v", cn)
+		}
+	})
+
+	t.Run("does not reload unchanged certificates", func(t *testing.T) {
+		if reloaded, err := c.reload(); err != nil {
+			t.Errorf("reloading: %v", err)
+		} else if reloaded {
+			t.Errorf("did not expect certificate to be reloaded")
+		}
+	})
+
+	t.Run("keeps serving the previous certificate if the new one is invalid", func(t *testing.T) {
+		if err := os.WriteFile(keyFile, []byte("partially written"), 0600); err != nil {
+			t.Fatalf("writing key: %v", err)
+		}
+
+		if _, err := c.reload(); err == nil {
+			t.Errorf("expected invalid certificate to fail to reload")
+		} else if cn := servedCommonName(t, c); cn != "first.example" {
+			t.Errorf("expected first.example certificate, got %v", cn)
+		}
+	})
+
+	t.Run("reloads changed certificates", func(t *testing.T) {
+		writeCertificate(t, certFile, keyFile, "second.example", time.Now().Add(time.Minute))
+
+		if reloaded, err := c.reload(); err != nil {
+			t.Errorf("reloading: %v", err)
+		} else if !reloaded {
+			t.Errorf("expected certificate to be reloaded")
+		} else if cn := servedCommonName(t, c); cn != "second.example" {
+			t.Errorf("expected second.example certificate, got %v", cn)
+		}
+	})
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	r := get(t, app.handleRedirectToHTTPS, func(r *http.Request) {
+		r.URL.Path = "/secret/abc"
+		r.URL.RawQuery = "a=b"
+	})
+
+	if r.statusCode != http.StatusPermanentRedirect {
+		t.Errorf("expected 308 status code, got %v", r.statusCode)
+	} else if l := r.headers.Get("Location"); l != app.baseURL+"/secret/abc?a=b" {
+		t.Errorf("expected redirect to base url, got %v", l)
+	}
+}
+
+// writeCertificate writes a self signed certificate for the common name, and its key, to the given files. The files'
+// modification times are set to the given time so that changes are detected regardless of file system precision.
+func writeCertificate(t *testing.T, certFile string, keyFile string, commonName string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshalling key: %v", err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("writing certificate: %v", err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("writing key: %v", err)
+	}
+
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, modTime, modTime); err != nil {
+			t.Fatalf("setting modification time: %v", err)
+		}
+	}
+}
+
+// servedCommonName returns the common name of the certificate currently served by the reloader
+func servedCommonName(t *testing.T, c *certificateReloader) string {
+	cert, err := c.getCertificate(nil)
+	if err != nil {
+		t.Fatalf("getting certificate: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parsing certificate: %v", err)
+	}
+
+	return leaf.Subject.CommonName
+}
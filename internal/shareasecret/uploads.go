@@ -0,0 +1,561 @@
+package shareasecret
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// tusVersion is the version of the tus resumable upload protocol (https://tus.io/protocols/resumable-upload) that
+	// uploads implement, along with its creation, expiration and termination extensions
+	tusVersion = "1.0.0"
+
+	// uploadTTL is how long an upload is kept for after it was created or last appended to, after which it is deleted
+	// as abandoned
+	uploadTTL = 24 * time.Hour
+
+	// maxUploadChunkSize is the largest chunk, in bytes, that can be appended to an upload in a single request, which
+	// is advertised in the Tus-Max-Chunk-Size header
+	maxUploadChunkSize = 4 * 1024 * 1024
+
+	// uploadPieceSize is the size, in bytes, of the pieces a chunk is stored in as it is received, so that no more than
+	// a piece of it is held in memory at once
+	uploadPieceSize = 256 * 1024
+)
+
+const (
+	errUploadNotFound   = validationError("Upload does not exist or has expired. Please try again.")
+	errUploadIncomplete = validationError("Upload is incomplete. Please finish it and try again.")
+	errInvalidUpload    = validationError("Upload format is invalid. Please try again.")
+	errUploadAndSecret  = validationError("A secret cannot be both uploaded and included in the request.")
+)
+
+// errUploadOffsetMismatch is returned when appending to an upload at an offset other than its current one, which
+// happens when the uploader has lost track of how much of the upload the server received
+var errUploadOffsetMismatch = errors.New("upload offset mismatch")
+
+// upload is a payload (an encrypted secret and its attachments) that is uploaded in chunks before the secret is created
+// from it, so that large payloads can be resumed rather than restarted should the connection drop
+type upload struct {
+	id        string
+	length    int64
+	offset    int64
+	expiresAt time.Time
+}
+
+// complete identifies whether every byte of the upload has been received
+func (u upload) complete() bool {
+	return u.offset == u.length
+}
+
+// uploadedSecret is the format of the content of an upload, which contains the parts of a request to create a secret
+// that are too large to reliably send in one
+type uploadedSecret struct {
+	EncryptedSecret string          `json:"encryptedSecret"`
+	Attachments     []apiAttachment `json:"attachments"`
+}
+
+// createUpload creates an upload of the given length with a cryptographically random, 192 bit identifier
+func (d *database) createUpload(length int64) (upload, error) {
+	id, err := secureID(24)
+	if err != nil {
+		return upload{}, fmt.Errorf("generating upload id: %w", err)
+	}
+
+	now := time.Now()
+	u := upload{id: id, length: length, expiresAt: now.Add(uploadTTL)}
+
+	_, err = d.db.Exec(
+		"INSERT INTO uploads (id, length, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		u.id,
+		u.length,
+		now.UnixMilli(),
+		u.expiresAt.UnixMilli(),
+	)
+	if err != nil {
+		return upload{}, fmt.Errorf("inserting upload: %w", err)
+	}
+
+	return u, nil
+}
+
+// uploadByID retrieves an upload that has not expired, returning [errUploadNotFound] if there is no such upload
+func (d *database) uploadByID(id string) (upload, error) {
+	u := upload{id: id}
+	var expiresAt int64
+
+	err := d.reader.QueryRow(
+		"SELECT length, upload_offset, expires_at FROM uploads WHERE id = ? AND expires_at > ?",
+		id,
+		time.Now().UnixMilli(),
+	).Scan(&u.length, &u.offset, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return upload{}, errUploadNotFound
+	} else if err != nil {
+		return upload{}, fmt.Errorf("querying upload: %w", err)
+	}
+
+	u.expiresAt = time.UnixMilli(expiresAt)
+
+	return u, nil
+}
+
+// appendUpload appends a chunk to an upload at the given offset, which must be the upload's current offset, and
+// extends its expiry. Returns [errUploadNotFound] if the upload does not exist or has expired, and
+// [errUploadOffsetMismatch] if the offset is not its current one or the chunk would exceed its length.
+func (d *database) appendUpload(id string, offset int64, chunk []byte) (upload, error) {
+	now := time.Now()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return upload{}, fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	// the offset is only moved on if it has not been by a concurrent request since it was checked
+	u := upload{id: id}
+	var expiresAt int64
+	err = tx.QueryRow(
+		`
+			UPDATE
+				uploads
+			SET
+				upload_offset = upload_offset + ?1,
+				expires_at = ?2
+			WHERE
+				id = ?3 AND
+				upload_offset = ?4 AND
+				upload_offset + ?1 <= length AND
+				expires_at > ?5
+			RETURNING
+				length, upload_offset, expires_at
+		`,
+		len(chunk),
+		now.Add(uploadTTL).UnixMilli(),
+		id,
+		offset,
+		now.UnixMilli(),
+	).Scan(&u.length, &u.offset, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := d.uploadByID(id); err != nil {
+			return upload{}, err
+		}
+
+		return upload{}, errUploadOffsetMismatch
+	} else if err != nil {
+		return upload{}, fmt.Errorf("updating upload: %w", err)
+	}
+
+	u.expiresAt = time.UnixMilli(expiresAt)
+
+	if len(chunk) > 0 {
+		if _, err := tx.Exec(
+			"INSERT INTO upload_chunks (upload_id, upload_offset, content) VALUES (?, ?, ?)",
+			id,
+			offset,
+			chunk,
+		); err != nil {
+			return upload{}, fmt.Errorf("inserting upload chunk: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return upload{}, fmt.Errorf("committing tx: %w", err)
+	}
+
+	return u, nil
+}
+
+// uploadedSecret retrieves and decodes the content of a complete upload, returning [errUploadNotFound] or
+// [errUploadIncomplete] if it cannot be used to create a secret and [errInvalidUpload] if its content is not in the
+// [uploadedSecret] format
+func (d *database) uploadedSecret(id string) (string, []attachment, error) {
+	u, err := d.uploadByID(id)
+	if err != nil {
+		return "", nil, err
+	} else if !u.complete() {
+		return "", nil, errUploadIncomplete
+	}
+
+	rows, err := d.reader.Query("SELECT content FROM upload_chunks WHERE upload_id = ? ORDER BY upload_offset", id)
+	if err != nil {
+		return "", nil, fmt.Errorf("querying upload chunks: %w", err)
+	}
+
+	defer rows.Close()
+
+	content := make([]byte, 0, u.length)
+	for rows.Next() {
+		var chunk []byte
+		if err := rows.Scan(&chunk); err != nil {
+			return "", nil, fmt.Errorf("scanning upload chunk: %w", err)
+		}
+
+		content = append(content, chunk...)
+	}
+
+	if err := rows.Err(); err != nil {
+		return "", nil, fmt.Errorf("reading upload chunks: %w", err)
+	}
+
+	var s uploadedSecret
+	if err := json.Unmarshal(content, &s); err != nil {
+		return "", nil, errInvalidUpload
+	}
+
+	names := make([]string, len(s.Attachments))
+	contents := make([]string, len(s.Attachments))
+	for i, at := range s.Attachments {
+		names[i], contents[i] = at.Name, at.Content
+	}
+
+	attachments, err := decodeAttachments(names, contents)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return s.EncryptedSecret, attachments, nil
+}
+
+// deleteUpload deletes an upload and the chunks received for it, returning [errUploadNotFound] if it does not exist
+func (d *database) deleteUpload(id string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	if err := useUpload(tx, id); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing tx: %w", err)
+	}
+
+	return nil
+}
+
+// useUpload deletes an upload and its chunks within the transaction, which a secret is being created from. Returns
+// [errUploadNotFound] if it does not exist, so that each upload can only be used to create one secret.
+func useUpload(tx *transaction, id string) error {
+	if _, err := tx.Exec("DELETE FROM upload_chunks WHERE upload_id = ?", id); err != nil {
+		return fmt.Errorf("deleting upload chunks: %w", err)
+	}
+
+	rs, err := tx.Exec("DELETE FROM uploads WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("deleting upload: %w", err)
+	}
+
+	if c, err := rs.RowsAffected(); err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	} else if c == 0 {
+		return errUploadNotFound
+	}
+
+	return nil
+}
+
+// RunDeleteExpiredUploadsJob runs a background job that removes uploads (and the chunks received for them) that were
+// abandoned before a secret was created from them. The job stops once the context is cancelled.
+func (a *Application) RunDeleteExpiredUploadsJob(ctx context.Context) {
+	a.runLeasedJobInBackground(
+		ctx,
+		"delete_expired_uploads",
+		func(l zerolog.Logger) error {
+			now := time.Now().UnixMilli()
+
+			tx, err := a.db.db.Begin()
+			if err != nil {
+				return err
+			}
+
+			defer tx.Rollback()
+
+			if _, err := tx.Exec(
+				"DELETE FROM upload_chunks WHERE upload_id IN (SELECT id FROM uploads WHERE expires_at <= ?)",
+				now,
+			); err != nil {
+				return err
+			}
+
+			rows, err := tx.Exec("DELETE FROM uploads WHERE expires_at <= ?", now)
+			if err != nil {
+				return err
+			}
+
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+
+			c, err := rows.RowsAffected()
+			if err != nil {
+				return err
+			}
+
+			l.Info().Int64("deleted_uploads", c).Msg("deleted expired uploads")
+
+			return nil
+		},
+		10*time.Minute,
+	)
+}
+
+// tusResumable rejects requests made with a version of the tus protocol other than the one implemented, and marks
+// every response as being made with it
+func tusResumable(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+
+		if r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			apiErr(w, http.StatusPreconditionFailed, "unsupported_version", "Only version "+tusVersion+" of the tus protocol is supported.")
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// handleAPIUploadOptions describes the tus protocol version, extensions and maximum upload size the server supports
+func (a *Application) handleAPIUploadOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,expiration,termination")
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(a.policy.maxRequestSize(), 10))
+	w.Header().Set("Tus-Max-Chunk-Size", strconv.Itoa(maxUploadChunkSize))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPICreateUpload creates an upload of the length given in the Upload-Length header, which is limited to the
+// size of the largest request to create a secret. The requester must be permitted to create secrets, either themselves
+// or via the invite or for the organisation given in the Upload-Metadata header.
+func (a *Application) handleAPICreateUpload(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		apiErr(w, http.StatusBadRequest, "invalid_request", "The Upload-Length header must be set to the size of the upload.")
+		return
+	}
+
+	if length > a.policy.maxRequestSize() {
+		apiErr(w, http.StatusRequestEntityTooLarge, "request_too_large", errRequestTooLarge.Error())
+		return
+	}
+
+	metadata := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+
+	ok, err := a.requesterCanUpload(r, metadata["organisation"], metadata["invite"])
+	if errors.Is(err, errInvalidAPIToken) {
+		apiUnauthorized(w)
+		return
+	} else if err != nil {
+		l.Err(err).Msg("retrieving api token")
+		apiInternalServerError(w)
+		return
+	} else if !ok {
+		apiErr(w, http.StatusForbidden, "forbidden", "You are not permitted to create secrets.")
+		return
+	}
+
+	u, err := a.db.createUpload(length)
+	if err != nil {
+		l.Err(err).Msg("creating upload")
+		apiInternalServerError(w)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/uploads/%s", u.id))
+	w.Header().Set("Upload-Expires", u.expiresAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Tus-Max-Chunk-Size", strconv.Itoa(maxUploadChunkSize))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleAPIUpload reports how much of an upload has been received, so that it can be resumed from there
+func (a *Application) handleAPIUpload(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+
+	u, err := a.db.uploadByID(r.PathValue("uploadID"))
+	if errors.Is(err, errUploadNotFound) {
+		a.recordFailedLookup(r)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		l.Err(err).Msg("retrieving upload")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeUploadHeaders(w, u)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleAPIAppendUpload appends the request body, of at most [maxUploadChunkSize] bytes, to an upload at the offset
+// given in the Upload-Offset header. The body is stored in pieces as it is received and, if the request is interrupted,
+// whatever was received of it is kept so that the upload can be resumed from there.
+func (a *Application) handleAPIAppendUpload(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+	id := r.PathValue("uploadID")
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		apiErr(w, http.StatusUnsupportedMediaType, "invalid_request", "The Content-Type header must be application/offset+octet-stream.")
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		apiErr(w, http.StatusBadRequest, "invalid_request", "The Upload-Offset header must be set to the offset to append at.")
+		return
+	}
+
+	u, err := a.db.uploadByID(id)
+	if errors.Is(err, errUploadNotFound) {
+		a.recordFailedLookup(r)
+		apiErr(w, http.StatusNotFound, "not_found", errUploadNotFound.Error())
+		return
+	} else if err != nil {
+		l.Err(err).Msg("retrieving upload")
+		apiInternalServerError(w)
+		return
+	} else if offset != u.offset {
+		writeUploadHeaders(w, u)
+		apiErr(w, http.StatusConflict, "upload_offset_mismatch", "The Upload-Offset header does not match the upload's offset.")
+		return
+	}
+
+	remaining := u.length - u.offset
+	if r.ContentLength > maxUploadChunkSize {
+		apiErr(w, http.StatusRequestEntityTooLarge, "request_too_large", fmt.Sprintf("The request body exceeds the maximum chunk size of %d bytes.", maxUploadChunkSize))
+		return
+	} else if r.ContentLength > remaining {
+		apiErr(w, http.StatusRequestEntityTooLarge, "request_too_large", "The request body exceeds the remaining length of the upload.")
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, min(remaining, maxUploadChunkSize))
+	piece := make([]byte, min(remaining, uploadPieceSize))
+
+	for appended := false; ; appended = true {
+		n, err := io.ReadFull(body, piece)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			err = nil
+		}
+
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			apiErr(w, http.StatusRequestEntityTooLarge, "request_too_large", "The request body exceeds the remaining length of the upload or the maximum chunk size.")
+			return
+		}
+
+		// the connection may have dropped part way through the chunk, in which case the part that was received is kept.
+		// An empty chunk is still appended, which extends the upload's expiry.
+		if n > 0 || (err == nil && !appended) {
+			var appendErr error
+			u, appendErr = a.db.appendUpload(id, u.offset, piece[:n])
+			if errors.Is(appendErr, errUploadNotFound) {
+				apiErr(w, http.StatusNotFound, "not_found", errUploadNotFound.Error())
+				return
+			} else if errors.Is(appendErr, errUploadOffsetMismatch) {
+				apiErr(w, http.StatusConflict, "upload_offset_mismatch", "The Upload-Offset header does not match the upload's offset.")
+				return
+			} else if appendErr != nil {
+				l.Err(appendErr).Msg("appending to upload")
+				apiInternalServerError(w)
+				return
+			}
+		}
+
+		if err != nil {
+			return
+		} else if n == 0 || n < len(piece) {
+			break
+		}
+	}
+
+	writeUploadHeaders(w, u)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPIDeleteUpload deletes an upload that is no longer needed
+func (a *Application) handleAPIDeleteUpload(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+
+	err := a.db.deleteUpload(r.PathValue("uploadID"))
+	if errors.Is(err, errUploadNotFound) {
+		a.recordFailedLookup(r)
+		apiErr(w, http.StatusNotFound, "not_found", errUploadNotFound.Error())
+		return
+	} else if err != nil {
+		l.Err(err).Msg("deleting upload")
+		apiInternalServerError(w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requesterCanUpload determines whether the requester can create uploads, which is the case if they could create a
+// secret for the organisation or via the invite (if either are set). Returns [errInvalidAPIToken] if the request is
+// authorised with an unusable API token.
+func (a *Application) requesterCanUpload(r *http.Request, organisation string, invite string) (bool, error) {
+	if organisation != "" {
+		_, _, ok := a.requesterCanCreateOrganisationSecret(r, organisation)
+		return ok, nil
+	} else if invite != "" {
+		_, ok := a.inviteCanCreateSecret(r, invite)
+		return ok, nil
+	}
+
+	token, err := a.apiTokenFromRequest(r)
+	if err != nil {
+		return false, err
+	} else if token != nil {
+		return token.HasScope(APITokenScopeCreate), nil
+	}
+
+	_, ok := a.requesterCanCreateSecret(r)
+	return ok, nil
+}
+
+// writeUploadHeaders writes the headers describing the progress and expiry of an upload to the response
+func writeUploadHeaders(w http.ResponseWriter, u upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.length, 10))
+	w.Header().Set("Upload-Expires", u.expiresAt.UTC().Format(http.TimeFormat))
+}
+
+// parseUploadMetadata parses the value of an Upload-Metadata header, which is a comma separated list of keys and their
+// base64 encoded values, ignoring any pairs that are malformed
+func parseUploadMetadata(h string) map[string]string {
+	metadata := map[string]string{}
+
+	for _, pair := range strings.Split(h, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if k == "" {
+			continue
+		}
+
+		if decoded, err := base64.StdEncoding.DecodeString(v); err == nil {
+			metadata[k] = string(decoded)
+		}
+	}
+
+	return metadata
+}
@@ -0,0 +1,271 @@
+package shareasecret
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestUploads(t *testing.T) {
+	payload := `{"encryptedSecret":"` + testEncryptedSecret + `"}`
+
+	t.Run("advertises the supported protocol", func(t *testing.T) {
+		r := apiRequest(t, "OPTIONS", app.handleAPIUploadOptions, "", emptyRequestConfigurer)
+		if r.statusCode != 204 || r.headers.Get("Tus-Version") != tusVersion || !strings.Contains(r.headers.Get("Tus-Extension"), "creation") {
+			t.Errorf("unexpected response %v %v", r.statusCode, r.headers)
+		} else if r.headers.Get("Tus-Max-Chunk-Size") != strconv.Itoa(maxUploadChunkSize) {
+			t.Errorf("expected maximum chunk size to be advertised, got %v", r.headers)
+		}
+	})
+
+	t.Run("rejects requests made with other protocol versions", func(t *testing.T) {
+		r := apiRequest(t, "POST", tusResumable(app.handleAPICreateUpload), "", withUploadHeaders("Upload-Length", "10"))
+		if r.statusCode != 412 || r.headers.Get("Tus-Version") != tusVersion {
+			t.Errorf("expected 412 status code, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("rejects uploads larger than the policy permits", func(t *testing.T) {
+		policy, _ := newCreationPolicy(&Configuration{})
+		policy.maxSecretSize = 1024
+		defer useTestPolicy(policy)()
+
+		r := uploadRequest(t, "POST", app.handleAPICreateUpload, "", nil, "Upload-Length", "1048576")
+		if r.statusCode != 413 || apiErrorCode(t, r) != "request_too_large" {
+			t.Errorf("expected request_too_large error, got %v %v", r.statusCode, r.body)
+		}
+	})
+
+	t.Run("only permits requesters that can create secrets to upload", func(t *testing.T) {
+		r := uploadRequest(t, "POST", app.handleAPICreateUpload, "", nil, "Upload-Length", "10", "X-Forwarded-For", "203.0.113.9")
+		if r.statusCode != 403 {
+			t.Errorf("expected 403 status code, got %v", r.statusCode)
+		}
+
+		metadata := "invite " + base64.StdEncoding.EncodeToString([]byte(app.inviteToken(createInvite(t, false))))
+		r = uploadRequest(t, "POST", app.handleAPICreateUpload, "", nil, "Upload-Length", "10", "X-Forwarded-For", "203.0.113.9", "Upload-Metadata", metadata)
+		if r.statusCode != 201 {
+			t.Errorf("expected 201 status code, got %v %v", r.statusCode, r.body)
+		}
+	})
+
+	t.Run("resumes uploads and creates a secret from them", func(t *testing.T) {
+		id := createUpload(t, len(payload))
+
+		r := uploadRequest(t, "PATCH", app.handleAPIAppendUpload, id, strings.NewReader(payload[:10]), "Upload-Offset", "0")
+		if r.statusCode != 204 || r.headers.Get("Upload-Offset") != "10" {
+			t.Fatalf("expected chunk to be appended, got %v %v", r.statusCode, r.body)
+		}
+
+		r = uploadRequest(t, "PATCH", app.handleAPIAppendUpload, id, strings.NewReader(payload[10:]), "Upload-Offset", "0")
+		if r.statusCode != 409 || apiErrorCode(t, r) != "upload_offset_mismatch" {
+			t.Errorf("expected upload_offset_mismatch error, got %v %v", r.statusCode, r.body)
+		}
+
+		// the connection drops part of the way through the next chunk, of which what was received is kept
+		interrupted := io.MultiReader(strings.NewReader(payload[10:20]), iotest.ErrReader(errors.New("connection reset")))
+		uploadRequest(t, "PATCH", app.handleAPIAppendUpload, id, interrupted, "Upload-Offset", "10")
+
+		r = uploadRequest(t, "HEAD", app.handleAPIUpload, id, nil)
+		if r.statusCode != 200 || r.headers.Get("Upload-Offset") != "20" || r.headers.Get("Upload-Length") != strconv.Itoa(len(payload)) {
+			t.Fatalf("expected upload to be resumable from 20, got %v %v", r.statusCode, r.headers)
+		}
+
+		r = apiRequest(t, "POST", app.handleAPICreateSecret, `{"upload":"`+id+`","ttl":30,"maxViews":1}`, emptyRequestConfigurer)
+		if r.statusCode != 400 || !strings.Contains(r.body, errUploadIncomplete.Error()) {
+			t.Errorf("expected incomplete upload to be rejected, got %v %v", r.statusCode, r.body)
+		}
+
+		r = uploadRequest(t, "PATCH", app.handleAPIAppendUpload, id, strings.NewReader(payload[20:]+"extra"), "Upload-Offset", "20")
+		if r.statusCode != 413 {
+			t.Errorf("expected chunk exceeding the upload to be rejected, got %v %v", r.statusCode, r.body)
+		}
+
+		r = uploadRequest(t, "PATCH", app.handleAPIAppendUpload, id, strings.NewReader(payload[20:]), "Upload-Offset", "20")
+		if r.statusCode != 204 || r.headers.Get("Upload-Offset") != strconv.Itoa(len(payload)) {
+			t.Fatalf("expected upload to be completed, got %v %v", r.statusCode, r.body)
+		}
+
+		r = apiRequest(t, "POST", app.handleAPICreateSecret, `{"upload":"`+id+`","ttl":30,"maxViews":1}`, emptyRequestConfigurer)
+
+		var created apiCreateSecretResponse
+		if err := json.Unmarshal([]byte(r.body), &created); err != nil || r.statusCode != 201 {
+			t.Fatalf("expected secret to be created, got %v %v", r.statusCode, r.body)
+		}
+
+		viewingKey, _ := app.db.createSecretView(created.AccessID)
+		if secret, err := app.db.viewSecret(created.AccessID, viewingKey, ""); err != nil || secret.cipherText != testEncryptedSecret {
+			t.Errorf("expected uploaded secret, got %v %v", secret.cipherText, err)
+		}
+
+		r = apiRequest(t, "POST", app.handleAPICreateSecret, `{"upload":"`+id+`","ttl":30,"maxViews":1}`, emptyRequestConfigurer)
+		if r.statusCode != 400 || !strings.Contains(r.body, errUploadNotFound.Error()) {
+			t.Errorf("expected used upload to be rejected, got %v %v", r.statusCode, r.body)
+		}
+
+		if r := uploadRequest(t, "HEAD", app.handleAPIUpload, id, nil); r.statusCode != 404 {
+			t.Errorf("expected used upload to be deleted, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("rejects chunks larger than the maximum chunk size", func(t *testing.T) {
+		id := createUpload(t, maxUploadChunkSize+1)
+		chunk := strings.Repeat("a", maxUploadChunkSize+1)
+
+		r := uploadRequest(t, "PATCH", app.handleAPIAppendUpload, id, strings.NewReader(chunk), "Upload-Offset", "0")
+		if r.statusCode != 413 || apiErrorCode(t, r) != "request_too_large" {
+			t.Errorf("expected request_too_large error, got %v %v", r.statusCode, r.body)
+		}
+
+		// chunks of an unknown length are cut off at the maximum chunk size
+		r = uploadRequest(t, "PATCH", app.handleAPIAppendUpload, id, io.MultiReader(strings.NewReader(chunk)), "Upload-Offset", "0")
+		if r.statusCode != 413 {
+			t.Errorf("expected 413 status code, got %v %v", r.statusCode, r.body)
+		}
+
+		r = uploadRequest(t, "PATCH", app.handleAPIAppendUpload, id, strings.NewReader(chunk[1:]), "Upload-Offset", "0")
+		if r.statusCode != 409 {
+			t.Errorf("expected the pieces received before the chunk was cut off to be kept, got %v %v", r.statusCode, r.headers)
+		}
+	})
+
+	t.Run("stores chunks in pieces as they are received", func(t *testing.T) {
+		id := createUpload(t, 2*uploadPieceSize+10)
+
+		r := uploadRequest(t, "PATCH", app.handleAPIAppendUpload, id, io.MultiReader(strings.NewReader(strings.Repeat("a", 2*uploadPieceSize+10))), "Upload-Offset", "0")
+		if r.statusCode != 204 || r.headers.Get("Upload-Offset") != strconv.Itoa(2*uploadPieceSize+10) {
+			t.Fatalf("expected chunk to be appended, got %v %v", r.statusCode, r.body)
+		}
+
+		var c int
+		if err := app.db.db.QueryRow("SELECT COUNT(*) FROM upload_chunks WHERE upload_id = ?", id).Scan(&c); err != nil || c != 3 {
+			t.Errorf("expected chunk to be stored in 3 pieces, got %v %v", c, err)
+		}
+	})
+
+	t.Run("rejects uploads that are not secrets or are sent alongside one", func(t *testing.T) {
+		id := createUpload(t, 5)
+		uploadRequest(t, "PATCH", app.handleAPIAppendUpload, id, strings.NewReader("nope!"), "Upload-Offset", "0")
+
+		cases := map[string]string{
+			"invalid":    `{"upload":"` + id + `","ttl":30,"maxViews":1}`,
+			"and secret": `{"upload":"` + id + `","encryptedSecret":"` + testEncryptedSecret + `","ttl":30,"maxViews":1}`,
+		}
+
+		for n, body := range cases {
+			r := apiRequest(t, "POST", app.handleAPICreateSecret, body, emptyRequestConfigurer)
+			if r.statusCode != 400 || apiErrorCode(t, r) != "validation_failed" {
+				t.Errorf("%v: expected validation_failed error, got %v %v", n, r.statusCode, r.body)
+			}
+		}
+	})
+
+	t.Run("web form creates secrets from uploads", func(t *testing.T) {
+		id := createUpload(t, len(payload))
+		uploadRequest(t, "PATCH", app.handleAPIAppendUpload, id, strings.NewReader(payload), "Upload-Offset", "0")
+
+		body := url.Values{"ttl": {"30"}, "maxViews": {"1"}, "upload": {id}}
+		if r := post(t, app.handleCreateSecret, body.Encode(), emptyRequestConfigurer); r.statusCode != 201 {
+			t.Errorf("expected 201 status code, got %v %v", r.statusCode, r.body)
+		}
+	})
+
+	t.Run("deletes uploads", func(t *testing.T) {
+		id := createUpload(t, len(payload))
+
+		if r := uploadRequest(t, "DELETE", app.handleAPIDeleteUpload, id, nil); r.statusCode != 204 {
+			t.Errorf("expected 204 status code, got %v", r.statusCode)
+		}
+
+		if r := uploadRequest(t, "HEAD", app.handleAPIUpload, id, nil); r.statusCode != 404 {
+			t.Errorf("expected deleted upload to be gone, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("deletes abandoned uploads once they expire", func(t *testing.T) {
+		id := createUpload(t, len(payload))
+		uploadRequest(t, "PATCH", app.handleAPIAppendUpload, id, strings.NewReader(payload[:10]), "Upload-Offset", "0")
+
+		if _, err := app.db.db.Exec("UPDATE uploads SET expires_at = ? WHERE id = ?", time.Now().Add(-time.Minute).UnixMilli(), id); err != nil {
+			t.Fatalf("expiring upload: %v", err)
+		}
+
+		if r := uploadRequest(t, "HEAD", app.handleAPIUpload, id, nil); r.statusCode != 404 {
+			t.Errorf("expected expired upload to be gone, got %v", r.statusCode)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		app.RunDeleteExpiredUploadsJob(ctx)
+
+		until(t, func() bool {
+			var c int
+			app.db.db.QueryRow("SELECT COUNT(*) FROM upload_chunks WHERE upload_id = ?", id).Scan(&c)
+			return c == 0
+		}, 10, 5*time.Millisecond)
+	})
+}
+
+func TestParseUploadMetadata(t *testing.T) {
+	m := parseUploadMetadata("invite aW52aXRl, organisation b3Jn,empty,bad !!!")
+
+	if m["invite"] != "invite" || m["organisation"] != "org" || m["empty"] != "" {
+		t.Errorf("unexpected metadata %v", m)
+	}
+
+	if _, ok := m["bad"]; ok {
+		t.Errorf("expected malformed pair to be ignored, got %v", m)
+	}
+}
+
+// uploadRequest makes a tus request (with the given header names and values) for an upload, and consumes the response
+func uploadRequest(t *testing.T, method string, endpoint http.HandlerFunc, uploadID string, body io.Reader, headers ...string) consumedResponse {
+	if body == nil {
+		body = strings.NewReader("")
+	}
+
+	r := httptest.NewRequest(method, "/api/v1/uploads", body)
+	r.RemoteAddr = testProxyAddr
+	r.Header.Set("X-Forwarded-For", "127.0.0.1")
+	r.Header.Set("Tus-Resumable", tusVersion)
+	r.Header.Set("Content-Type", "application/offset+octet-stream")
+	r.SetPathValue("uploadID", uploadID)
+	withUploadHeaders(headers...)(r)
+
+	recorder := httptest.NewRecorder()
+	tusResumable(endpoint).ServeHTTP(recorder, r)
+
+	b, _ := io.ReadAll(recorder.Body)
+
+	return consumedResponse{statusCode: recorder.Code, body: string(b), headers: recorder.Header()}
+}
+
+// withUploadHeaders sets the given header names and values on a request
+func withUploadHeaders(headers ...string) func(r *http.Request) {
+	return func(r *http.Request) {
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+	}
+}
+
+// createUpload creates an upload of the given length, returning its identifier
+func createUpload(t *testing.T, length int) string {
+	r := uploadRequest(t, "POST", app.handleAPICreateUpload, "", nil, "Upload-Length", strconv.Itoa(length))
+	if r.statusCode != 201 {
+		t.Fatalf("expected upload to be created, got %v %v", r.statusCode, r.body)
+	}
+
+	return strings.TrimPrefix(r.headers.Get("Location"), "/api/v1/uploads/")
+}
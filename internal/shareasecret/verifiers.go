@@ -0,0 +1,177 @@
+package shareasecret
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lsymds/shareasecret/pkg/secretcrypto"
+)
+
+// errVerificationRequired is returned when a secret protected by a key verifier is viewed without proving knowledge of
+// its encryption key
+var errVerificationRequired = errors.New("secret requires its encryption key to be verified")
+
+// errVerificationNotRequired is returned when a key challenge is requested for a secret without a key verifier
+var errVerificationNotRequired = errors.New("secret does not have a key verifier")
+
+const (
+	errInvalidVerifier  = validationError("Key verifier is invalid. Please try again.")
+	errVerifierKeyInURL = validationError("Secrets with their key in their link cannot also have a key verifier.")
+)
+
+// incorrectKeyError is returned when the verifier presented for a secret does not match the one it was created with
+type incorrectKeyError struct {
+	// attemptsRemaining is the number of incorrect keys that can still be tried, and is 0 if the secret has been
+	// deleted as a result of this attempt
+	attemptsRemaining int
+}
+
+func (e incorrectKeyError) Error() string {
+	switch e.attemptsRemaining {
+	case 0:
+		return "The encryption key is incorrect. Too many incorrect keys have been tried, so the secret has been deleted."
+	case 1:
+		return "The encryption key is incorrect. The secret will be deleted if the next key tried is incorrect."
+	default:
+		return fmt.Sprintf(
+			"The encryption key is incorrect. %d more incorrect keys can be tried before the secret is deleted.",
+			e.attemptsRemaining,
+		)
+	}
+}
+
+// keyChallenge contains what a viewer needs to derive the encryption key of a secret protected by a key verifier, and
+// thus its verifier, without being given the secret's ciphertext
+type keyChallenge struct {
+	iterations        int
+	salt              string
+	attemptsRemaining int
+}
+
+// keyAttempts describes how many incorrect encryption keys have been tried against a secret protected by a key
+// verifier
+type keyAttempts struct {
+	failed  int
+	maximum int
+}
+
+// hashVerifier validates a key verifier (an unpadded base64url encoded HMAC) and returns the hex encoded SHA-256 hash
+// of it that is stored in place of the verifier itself
+func hashVerifier(verifier string) (string, error) {
+	b, err := base64.RawURLEncoding.Strict().DecodeString(verifier)
+	if err != nil || len(b) != secretcrypto.VerifierSize {
+		return "", errInvalidVerifier
+	}
+
+	h := sha256.Sum256(b)
+
+	return hex.EncodeToString(h[:]), nil
+}
+
+// verifierMatches identifies whether the verifier hashes to the stored hash, in constant time
+func verifierMatches(verifier string, hash string) (bool, error) {
+	h, err := hashVerifier(verifier)
+	if err != nil {
+		return false, err
+	}
+
+	return subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1, nil
+}
+
+// secretKeyChallenge retrieves the key challenge for a secret protected by a key verifier using an unused viewing key.
+// It does not use the viewing key.
+func (d *database) secretKeyChallenge(accessID string, viewingKey string) (keyChallenge, error) {
+	var cipherText string
+	var verifierHash sql.NullString
+	var maximumAttempts sql.NullInt64
+	var failedAttempts int
+	var keyExpiresAt int64
+
+	now := time.Now().UnixMilli()
+
+	err := d.reader.QueryRow(
+		`
+			SELECT
+				s.cipher_text,
+				s.verifier_hash,
+				s.maximum_key_attempts,
+				s.failed_key_attempts,
+				v.expires_at
+			FROM
+				secrets s
+				INNER JOIN secret_views v ON v.secret_id = s.id
+			WHERE
+				s.access_id = ? AND
+				s.deleted_at IS NULL AND
+				s.expires_at > ? AND
+				v.viewing_key = ? AND
+				v.viewed_at IS NULL
+		`,
+		accessID,
+		now,
+		viewingKey,
+	).Scan(&cipherText, &verifierHash, &maximumAttempts, &failedAttempts, &keyExpiresAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return keyChallenge{}, errSecretNotFound
+	} else if err != nil {
+		return keyChallenge{}, fmt.Errorf("retrieving secret: %w", err)
+	} else if keyExpiresAt <= now {
+		return keyChallenge{}, errViewingKeyExpired
+	} else if !verifierHash.Valid {
+		return keyChallenge{}, errVerificationNotRequired
+	}
+
+	e, err := secretcrypto.Parse(cipherText)
+	if err != nil {
+		return keyChallenge{}, fmt.Errorf("parsing secret: %w", err)
+	}
+
+	return keyChallenge{
+		iterations:        e.Iterations,
+		salt:              base64.RawURLEncoding.EncodeToString(e.Salt),
+		attemptsRemaining: max(int(maximumAttempts.Int64)-failedAttempts, 0),
+	}, nil
+}
+
+// recordIncorrectKey counts an incorrect key tried against a secret as part of a transaction, deleting it if the
+// maximum number of attempts has been reached, and returns the [incorrectKeyError] to return to the viewer once the
+// transaction is committed
+func recordIncorrectKey(tx *transaction, accessID string) (incorrectKeyError, error) {
+	var failedAttempts int
+	var maximumAttempts int
+
+	// the count is incremented and read in one statement so that concurrent attempts cannot both be counted as the last
+	err := tx.QueryRow(
+		`
+			UPDATE secrets
+			SET failed_key_attempts = failed_key_attempts + 1
+			WHERE access_id = ?
+			RETURNING failed_key_attempts, maximum_key_attempts
+		`,
+		accessID,
+	).Scan(&failedAttempts, &maximumAttempts)
+	if err != nil {
+		return incorrectKeyError{}, fmt.Errorf("recording incorrect key: %w", err)
+	}
+
+	if failedAttempts >= maximumAttempts {
+		_, err := tx.Exec(
+			"UPDATE secrets SET deleted_at = ?, deletion_reason = ?, cipher_text = NULL WHERE access_id = ? AND deleted_at IS NULL",
+			time.Now().UnixMilli(),
+			deletionReasonMaximumKeyAttemptsHit,
+			accessID,
+		)
+		if err != nil {
+			return incorrectKeyError{}, fmt.Errorf("deleting secret: %w", err)
+		}
+	}
+
+	return incorrectKeyError{attemptsRemaining: max(maximumAttempts-failedAttempts, 0)}, nil
+}
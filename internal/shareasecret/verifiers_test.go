@@ -0,0 +1,170 @@
+package shareasecret
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/lsymds/shareasecret/pkg/secretcrypto"
+)
+
+// testIncorrectVerifier is a well formed verifier that does not match the key of testEncryptedSecret
+const testIncorrectVerifier = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8"
+
+func TestKeyVerifiers(t *testing.T) {
+	e, _ := secretcrypto.Parse(testEncryptedSecret)
+	verifier, err := secretcrypto.Verifier(e, "empty plaintext")
+	if err != nil {
+		t.Fatalf("deriving verifier: %v", err)
+	}
+
+	t.Run("rejects invalid verifiers", func(t *testing.T) {
+		cases := map[string]string{
+			"malformed verifier": `{"encryptedSecret":"` + testEncryptedSecret + `","ttl":30,"maxViews":1,"verifier":"abc"}`,
+			"key in url":         `{"encryptedSecret":"` + testKeyInURLEncryptedSecret + `","ttl":30,"maxViews":1,"verifier":"` + verifier + `"}`,
+		}
+
+		for n, body := range cases {
+			r := apiRequest(t, "POST", app.handleAPICreateSecret, body, emptyRequestConfigurer)
+			if r.statusCode != 400 || apiErrorCode(t, r) != "validation_failed" {
+				t.Errorf("%v: expected validation_failed error, got %v %v", n, r.statusCode, r.body)
+			}
+		}
+	})
+
+	t.Run("only releases the secret once the key is verified", func(t *testing.T) {
+		accessID, managementID := createVerifiedSecret(t, verifier)
+		viewingKey, _ := app.db.createSecretView(accessID)
+		withKeys := withViewingKey(accessID, viewingKey)
+
+		r := apiRequest(t, "GET", app.handleAPIAccessSecret, "", withKeys)
+		if r.statusCode != 403 || apiErrorCode(t, r) != "verification_required" {
+			t.Fatalf("expected verification_required error, got %v %v", r.statusCode, r.body)
+		}
+
+		r = apiRequest(t, "GET", app.handleAPISecretKeyChallenge, "", withKeys)
+
+		var challenge apiKeyChallengeResponse
+		if err := json.Unmarshal([]byte(r.body), &challenge); err != nil || r.statusCode != 200 {
+			t.Fatalf("expected challenge, got %v %v", r.statusCode, r.body)
+		} else if challenge.Salt != "AAECAwQFBgcICQoLDA0ODw" || challenge.Iterations != 600000 || challenge.AttemptsRemaining != 3 {
+			t.Errorf("unexpected challenge %+v", challenge)
+		}
+
+		r = apiRequest(t, "POST", app.handleAPIVerifySecretView, `{"verifier":"`+testIncorrectVerifier+`"}`, withKeys)
+		if r.statusCode != 403 || apiErrorCode(t, r) != "incorrect_key" || !strings.Contains(r.body, "2 more incorrect keys") {
+			t.Errorf("expected incorrect_key error, got %v %v", r.statusCode, r.body)
+		}
+
+		r = apiRequest(t, "GET", app.handleAPIManageSecret, "", withPathValue("managementID", managementID))
+		if !strings.Contains(r.body, `"maxKeyAttempts":3,"failedKeyAttempts":1`) {
+			t.Errorf("expected failed key attempts in metadata, got %v", r.body)
+		}
+
+		r = apiRequest(t, "POST", app.handleAPIVerifySecretView, `{"verifier":"`+verifier+`"}`, withKeys)
+
+		var accessed apiAccessSecretResponse
+		if err := json.Unmarshal([]byte(r.body), &accessed); err != nil || r.statusCode != 200 {
+			t.Fatalf("expected secret, got %v %v", r.statusCode, r.body)
+		} else if accessed.EncryptedSecret != testEncryptedSecret || !accessed.FinalView {
+			t.Errorf("unexpected response %+v", accessed)
+		}
+
+		r = apiRequest(t, "POST", app.handleAPIVerifySecretView, `{"verifier":"`+verifier+`"}`, withKeys)
+		if r.statusCode != 404 {
+			t.Errorf("expected viewing key to have been used, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("deletes the secret after too many incorrect keys", func(t *testing.T) {
+		accessID, _ := createVerifiedSecret(t, verifier)
+		viewingKey, _ := app.db.createSecretView(accessID)
+		withKeys := withViewingKey(accessID, viewingKey)
+
+		for i, code := range []string{"incorrect_key", "incorrect_key", "maximum_key_attempts_hit"} {
+			r := apiRequest(t, "POST", app.handleAPIVerifySecretView, `{"verifier":"`+testIncorrectVerifier+`"}`, withKeys)
+			if r.statusCode != 403 || apiErrorCode(t, r) != code {
+				t.Errorf("attempt %d: expected %v error, got %v %v", i+1, code, r.statusCode, r.body)
+			}
+		}
+
+		var deletionReason sql.NullString
+		var cipherText sql.NullString
+
+		err := app.db.db.
+			QueryRow("SELECT deletion_reason, cipher_text FROM secrets WHERE access_id = ?", accessID).
+			Scan(&deletionReason, &cipherText)
+		if err != nil {
+			t.Errorf("querying secret: %v", err)
+		} else if deletionReason.String != deletionReasonMaximumKeyAttemptsHit || cipherText.Valid {
+			t.Errorf("expected secret to be deleted, got %v", deletionReason.String)
+		}
+
+		r := apiRequest(t, "POST", app.handleAPIVerifySecretView, `{"verifier":"`+verifier+`"}`, withKeys)
+		if r.statusCode != 404 {
+			t.Errorf("expected deleted secret to not be found, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("web view asks for the key without the ciphertext", func(t *testing.T) {
+		accessID, _ := createVerifiedSecret(t, verifier)
+		viewingKey, _ := app.db.createSecretView(accessID)
+
+		r := get(t, app.handleAccessSecret, withViewingKey(accessID, viewingKey))
+
+		if r.statusCode != 200 {
+			t.Errorf("expected 200 status code, got %v", r.statusCode)
+		} else if strings.Contains(r.body, testEncryptedSecret) {
+			t.Errorf("expected ciphertext to be withheld")
+		} else if !strings.Contains(r.body, `data-salt="AAECAwQFBgcICQoLDA0ODw"`) || !strings.Contains(r.body, "/verify") {
+			t.Errorf("expected key challenge in body")
+		}
+	})
+
+	t.Run("web form accepts a verifier", func(t *testing.T) {
+		body := url.Values{"ttl": {"30"}, "maxViews": {"1"}, "encryptedSecret": {testEncryptedSecret}, "verifier": {verifier}}
+
+		r := post(t, app.handleCreateSecret, body.Encode(), emptyRequestConfigurer)
+		if r.statusCode != 201 {
+			t.Fatalf("expected 201 status code, got %v %v", r.statusCode, r.body)
+		}
+
+		var verifierHash sql.NullString
+		err := app.db.db.QueryRow(
+			"SELECT verifier_hash FROM secrets WHERE management_id = ?",
+			strings.TrimPrefix(r.headers.Get("Location"), "/manage-secret/"),
+		).Scan(&verifierHash)
+		if err != nil {
+			t.Errorf("querying secret: %v", err)
+		} else if !verifierHash.Valid || verifierHash.String == verifier {
+			t.Errorf("expected a hash of the verifier to be stored, got %v", verifierHash.String)
+		}
+	})
+}
+
+// createVerifiedSecret creates a secret (testEncryptedSecret) protected by the verifier that is deleted after 3
+// incorrect keys, returning its access and management identifiers
+func createVerifiedSecret(t *testing.T, verifier string) (string, string) {
+	policy, _ := newCreationPolicy(&Configuration{})
+	policy.maxKeyAttempts = 3
+
+	created, err := app.db.createSecret(
+		newSecret{cipherText: testEncryptedSecret, ttl: 30, maxViews: 1, verifier: verifier, policy: policy},
+	)
+	if err != nil {
+		t.Fatalf("creating secret: %v", err)
+	}
+
+	return created.accessID, created.managementID
+}
+
+// withViewingKey sets the access identifier and viewing key path values of a request
+func withViewingKey(accessID string, viewingKey string) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.SetPathValue("accessID", accessID)
+		r.SetPathValue("viewingKey", viewingKey)
+	}
+}
@@ -2,20 +2,18 @@ package shareasecret
 
 import (
 	"crypto/rand"
-	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/fs"
-	"net"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/a-h/templ"
 	"github.com/lsymds/go-utils/pkg/http/middleware"
+	"github.com/lsymds/shareasecret/pkg/secretcrypto"
 	"github.com/lsymds/staticmodtimefs"
 	"github.com/rs/zerolog"
 )
@@ -33,17 +31,49 @@ func (a *Application) mapRoutes() {
 	a.router.Handle("GET /nojs", templ.Handler(pageNoJavascript()))
 	a.router.Handle("GET /oops", templ.Handler(pageOops()))
 
-	a.router.HandleFunc("POST /secret", a.handleCreateSecret)
-	a.router.HandleFunc("GET /secret/{accessID}", a.handleAccessSecretInterstitial)
-	a.router.HandleFunc("POST /secret/{accessID}", a.handleCreateSecretView)
-	a.router.HandleFunc("GET /secret/{accessID}/{viewingKey}", a.handleAccessSecret)
-	a.router.HandleFunc("GET /manage-secret/{managementID}", a.handleManageSecret)
-	a.router.HandleFunc("POST /manage-secret/{managementID}/delete", a.handleDeleteSecret)
+	if a.oidc != nil {
+		a.router.HandleFunc("GET /auth/login", a.rateLimited("", a.handleLogin))
+		a.router.HandleFunc("GET /auth/callback", a.rateLimited("", a.handleAuthCallback))
+	}
+
+	if a.passkeys != nil {
+		a.router.HandleFunc("GET /passkeys/login", a.rateLimited("", a.handleGetPasskeyLogin))
+		a.router.HandleFunc("POST /passkeys/login", a.rateLimited("", a.handlePasskeyLogin))
+		a.router.HandleFunc("GET /passkeys/enrol/{token}", a.rateLimited("", a.handleGetEnrolment))
+		a.router.HandleFunc("POST /passkeys/enrol/{token}", a.rateLimited("", a.handleEnrol))
+	}
+
+	if a.signInEnabled() {
+		a.router.HandleFunc("POST /auth/logout", a.handleLogout)
+		a.router.HandleFunc("GET /my-secrets", a.rateLimited("", a.handleMySecrets))
+		a.router.HandleFunc("POST /my-secrets/delete", a.rateLimited("", a.handleDeleteMySecrets))
+	}
+
+	a.router.HandleFunc("GET /o/{organisation}", a.rateLimited("", a.handleGetOrganisation))
+	a.router.HandleFunc("GET /o/{organisation}/theme.css", a.handleOrganisationTheme)
+
+	if a.signInEnabled() {
+		a.router.HandleFunc("GET /o/{organisation}/secrets", a.rateLimited("", a.handleOrganisationSecrets))
+		a.router.HandleFunc("POST /o/{organisation}/secrets/delete", a.rateLimited("", a.handleDeleteOrganisationSecrets))
+	}
+
+	a.router.HandleFunc("POST /invite", a.rateLimited(rateLimitBucketCreate, a.handleCreateInvite))
+	a.router.HandleFunc("GET /invite/{token}", a.rateLimited(rateLimitBucketView, a.handleGetInvite))
+	a.router.HandleFunc("POST /secret", a.rateLimited(rateLimitBucketCreate, a.handleCreateSecret))
+	a.router.HandleFunc("GET /secret/{accessID}", a.rateLimited(rateLimitBucketView, a.handleAccessSecretInterstitial))
+	a.router.HandleFunc("POST /secret/{accessID}", a.rateLimited(rateLimitBucketView, a.handleCreateSecretView))
+	a.router.HandleFunc("GET /secret/{accessID}/{viewingKey}", a.rateLimited("", a.handleAccessSecret))
+	a.router.HandleFunc("GET /manage-secret/{managementID}", a.rateLimited("", a.handleManageSecret))
+	a.router.HandleFunc("POST /manage-secret/{managementID}/delete", a.rateLimited("", a.handleDeleteSecret))
+
+	a.mapAPIRoutes()
 }
 
 // ServeHTTP is the root [http.Handler] method for the application. It serves all application routes, wrapping them with
 // any required middlewares
 func (a *Application) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	clientIP := a.clientIP(r)
+
 	middleware.Logging(
 		middleware.Recovery(
 			a.router,
@@ -51,7 +81,9 @@ func (a *Application) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				http.Redirect(w, r, "/oops", http.StatusSeeOther)
 			}),
 		),
-		nil,
+		func(c *zerolog.Context) {
+			*c = c.IPAddr("client_ip", clientIP)
+		},
 	).ServeHTTP(w, r)
 }
 
@@ -65,88 +97,187 @@ func serveFile(fs fs.FS, fileName string) http.Handler {
 // handleGetIndex renders the root page for the application - this is where visitors are able to create secrets
 // (performed in the [handleCreateSecret] handler)
 func (a *Application) handleGetIndex(w http.ResponseWriter, r *http.Request) {
-	ns := notificationsFromRequest(r, w)
-	ipRestricted := !requestingIPCanCreateSecret(a.config, r)
+	user, canCreate := a.requesterCanCreateSecret(r)
+
+	p := indexPage{
+		restricted:        !canCreate,
+		canInvite:         a.requesterCanInvite(r),
+		signInAvailable:   a.oidc != nil,
+		passkeysAvailable: a.passkeys != nil,
+		user:              user,
+		policy:            a.policy,
+	}
+	if canCreate {
+		p.challenge = a.issueChallenge(r)
+	}
 
-	pageIndex(ns, ipRestricted).Render(r.Context(), w)
+	pageIndex(notificationsFromRequest(r, w), p).Render(r.Context(), w)
 }
 
-// handleCreateSecret validates and persists a secret (consisting of encrypted ciphertext)
-func (a *Application) handleCreateSecret(w http.ResponseWriter, r *http.Request) {
-	l := zerolog.Ctx(r.Context())
+// handleGetInvite renders the root page for a visitor holding an invite, permitting them to create secrets regardless
+// of their IP address
+func (a *Application) handleGetInvite(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
 
-	// redirect to the home page if requester is not permitted to create secrets
-	if !requestingIPCanCreateSecret(a.config, r) {
+	if _, ok := a.inviteCanCreateSecret(r, token); !ok {
+		a.recordFailedLookup(r)
+		setFlashErr(errInvalidInvite.Error(), w)
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	secret := ""
-	ttl := 0
-	maxViews := 0
+	pageIndex(
+		notificationsFromRequest(r, w),
+		indexPage{invite: token, challenge: a.issueChallenge(r), policy: a.policy},
+	).Render(r.Context(), w)
+}
+
+// handleCreateInvite creates an invite link that permits whoever holds it to create secrets, regardless of their IP
+// address
+func (a *Application) handleCreateInvite(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+
+	// redirect to the home page if requester is not permitted to invite others
+	if !a.requesterCanInvite(r) {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 
-	// parse and validate the request
 	if err := r.ParseForm(); err != nil {
+		setFlashErr("Unable to parse request form. Please try again.", w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	ttl, err := strconv.Atoi(r.Form.Get("ttl"))
+	if err != nil {
+		setFlashErr(errInvalidInviteTTL.Error(), w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	i, err := a.db.createInvite(newInvite{
+		label:     r.Form.Get("label"),
+		ttl:       ttl,
+		singleUse: r.Form.Get("singleUse") != "",
+	})
+
+	var ve validationError
+	if errors.As(err, &ve) {
+		setFlashErr(ve.Error(), w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		l.Err(err).Msg("creating invite")
+		redirectToOopsPage(w, r)
+		return
+	}
+
+	pageInviteCreated(a.inviteURL(i), i.singleUse, i.expiresAt).Render(r.Context(), w)
+}
+
+// handleCreateSecret validates and persists a secret (consisting of encrypted ciphertext)
+func (a *Application) handleCreateSecret(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+
+	s := newSecret{policy: a.policy}
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.policy.maxRequestSize())
+
+	// parse the request, leaving the validation of its contents to the database layer
+	var mbe *http.MaxBytesError
+	if err := r.ParseForm(); errors.As(err, &mbe) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(errRequestTooLarge.Error()))
+		return
+	} else if err != nil {
 		badRequest("Unable to parse request form. Please try again.", w)
 		return
 	} else {
-		// very little we can do here aside from validating the structure of the "encrypted" text string received matches
-		// how the front-end should have formatted it
-		secret = r.Form.Get("encryptedSecret")
-		if strings.Count(secret, ".") != 2 {
-			badRequest("Secret format is invalid. Please try again.", w)
+		// secrets created for an organisation are owned by it and can only be created by its members (or from the IP
+		// addresses it permits). Secrets created via an invite are attributed to it regardless of who created them,
+		// otherwise the requester must be signed in or permitted to create secrets by their IP address.
+		if id := r.Form.Get("organisation"); id != "" {
+			var ok bool
+			if s.organisation, s.creator, ok = a.requesterCanCreateOrganisationSecret(r, id); !ok {
+				badRequest(errOrganisationForbidden.Error(), w)
+				return
+			}
+		} else if token := r.Form.Get("invite"); token != "" {
+			var ok bool
+			if s.inviteID, ok = a.inviteCanCreateSecret(r, token); !ok {
+				badRequest(errInvalidInvite.Error(), w)
+				return
+			}
+		} else {
+			var ok bool
+			if s.creator, ok = a.requesterCanCreateSecret(r); !ok {
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+		}
+
+		s.verifier = r.Form.Get("verifier")
+
+		// verify the proof of work before doing anything else, as the point of it is to make creating secrets expensive
+		var ve validationError
+		s.challenge, err = a.verifyProofOfWork(r.Form.Get("powChallenge"), r.Form.Get("powSolution"))
+		if errors.As(err, &ve) {
+			badRequest(ve.Error(), w)
+			return
+		} else if err != nil {
+			l.Err(err).Msg("verifying proof of work")
+			internalServerError(w)
 			return
 		}
 
-		ttl, err = strconv.Atoi(r.Form.Get("ttl"))
-		if err != nil {
-			badRequest("Unable to parse the TTL (time to live) for the secret.", w)
+		// large secrets (and their attachments) are uploaded in chunks beforehand, see [handleAPICreateUpload]
+		if s.uploadID = r.Form.Get("upload"); s.uploadID != "" {
+			if r.Form.Get("encryptedSecret") != "" || len(r.Form["attachmentName"]) > 0 {
+				badRequest(errUploadAndSecret.Error(), w)
+				return
+			}
+
+			s.cipherText, s.attachments, err = a.store.uploadedSecret(s.uploadID)
+		} else {
+			s.cipherText = r.Form.Get("encryptedSecret")
+			s.attachments, err = decodeAttachments(r.Form["attachmentName"], r.Form["attachmentContent"])
+		}
+
+		if errors.As(err, &ve) {
+			badRequest(ve.Error(), w)
+			return
+		} else if err != nil {
+			l.Err(err).Msg("retrieving upload")
+			internalServerError(w)
 			return
 		}
 
-		maxViews, err = strconv.Atoi(r.Form.Get("maxViews"))
-		if err != nil || maxViews < 0 {
-			badRequest("Unable to parse the maximum views permitted for the secret.", w)
+		s.ttl, err = strconv.Atoi(r.Form.Get("ttl"))
+		if err != nil {
+			badRequest(errInvalidTTL.Error(), w)
 			return
 		}
-	}
 
-	// create the secret, and generate two cryptographically random, 192 bit identifiers to use for viewing and
-	// management of the secret respectively
-	accessID, err := secureID(24)
-	if err != nil {
-		l.Err(err).Msg("generating access id")
-		internalServerError(w)
-		return
+		s.maxViews, err = strconv.Atoi(r.Form.Get("maxViews"))
+		if err != nil {
+			badRequest(errInvalidMaximumViews.Error(), w)
+			return
+		}
 	}
 
-	managementID, err := secureID(24)
-	if err != nil {
-		l.Err(err).Msg("generating management id")
-		internalServerError(w)
+	var ve validationError
+	created, err := a.store.createSecret(s)
+	if errors.As(err, &ve) {
+		badRequest(ve.Error(), w)
 		return
-	}
-
-	if _, err := a.db.db.Exec(
-		`
-			INSERT INTO
-				secrets (access_id, management_id, cipher_text, ttl, maximum_views, created_at)
-			VALUES
-				(?, ?, ?, ?, ?, ?)
-		`,
-		accessID,
-		managementID,
-		secret,
-		ttl,
-		maxViews,
-		time.Now().UnixMilli(),
-	); err != nil {
+	} else if err != nil {
 		l.Err(err).Msg("creating secret")
 		internalServerError(w)
 		return
 	}
 
-	http.Redirect(w, r, fmt.Sprintf("/manage-secret/%s", managementID), http.StatusCreated)
+	http.Redirect(w, r, fmt.Sprintf("/manage-secret/%s", created.managementID), http.StatusCreated)
 }
 
 // handleAccessSecretInterstitial presents a disclaimer to the visitor informing them that proceeding will use
@@ -161,22 +292,11 @@ func (a *Application) handleAccessSecretInterstitial(w http.ResponseWriter, r *h
 		Str("access_id", accessID).
 		Logger()
 
-	// retrieve the row identifier of the secret if it exists and has not been deleted
-	var secretID int
-	err := a.db.db.QueryRow(
-		`
-			SELECT
-				id
-			FROM
-				secrets
-			WHERE
-				access_id = ? AND
-				deleted_at IS NULL
-		`,
-		accessID,
-	).Scan(&secretID)
-
-	if errors.Is(sql.ErrNoRows, err) {
+	// ensure the secret exists and has not been deleted
+	err := a.store.secretExists(accessID)
+
+	if errors.Is(err, errSecretNotFound) {
+		a.recordFailedLookup(r)
 		setFlashErr("Secret does not exist or has been deleted.", w)
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
@@ -186,7 +306,7 @@ func (a *Application) handleAccessSecretInterstitial(w http.ResponseWriter, r *h
 		return
 	}
 
-	pageViewSecretInterstitial().Render(r.Context(), w)
+	pageViewSecretInterstitial(notificationsFromRequest(r, w)).Render(r.Context(), w)
 }
 
 // handleCreateSecretView creates a 'view' of a secret and is the POST accompaniment to the
@@ -199,46 +319,21 @@ func (a *Application) handleCreateSecretView(w http.ResponseWriter, r *http.Requ
 		Str("access_id", accessID).
 		Logger()
 
-	// create a 64 bit viewing key for the secret view record
-	key, err := secureID(8)
-	if err != nil {
-		l.Err(err).Msg("creating secret viewing key")
-		redirectToOopsPage(w, r)
-		return
-	}
+	key, err := a.store.createSecretView(accessID)
 
-	// create the secret view without a viewing date, as this will be set when the viewing page route is actually
-	// called
-	rs, err := a.db.db.Exec(
-		`
-			INSERT INTO secret_views (secret_id, viewing_key, created_at)
-			SELECT
-				id,
-				?,
-				?
-			FROM
-				secrets
-			WHERE
-				access_id = ? AND
-				deleted_at IS NULL
-		`,
-		key,
-		time.Now().UnixMilli(),
-		accessID,
-	)
-
-	if errors.Is(sql.ErrNoRows, err) {
+	if errors.Is(err, errSecretNotFound) {
+		a.recordFailedLookup(r)
 		setFlashErr("Secret does not exist or has been deleted.", w)
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
+	} else if errors.Is(err, errNoViewsRemaining) {
+		setFlashErr("Every remaining view of this secret is already being used by someone who opened it. Please try again later.", w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	} else if err != nil {
 		l.Err(err).Msg("creating secret view")
 		redirectToOopsPage(w, r)
 		return
-	} else if rc, err := rs.RowsAffected(); err != nil || rc == 0 {
-		l.Error().Err(err).Msg("creating secret view")
-		redirectToOopsPage(w, r)
-		return
 	}
 
 	// redirect them to the actual viewing page of the secret (which will then mark the secret view as viewed)
@@ -260,87 +355,50 @@ func (a *Application) handleAccessSecret(w http.ResponseWriter, r *http.Request)
 		Str("viewing_key", viewingKey).
 		Logger()
 
-	// begin a transaction so the retrieval of the secret's details and the recording of the view being used are atomic
-	tx, err := a.db.db.Begin()
-	if err != nil {
-		l.Err(err).Msg("begin tx")
-		redirectToOopsPage(w, r)
-		return
+	secret, err := a.store.viewSecret(accessID, viewingKey, "")
+
+	// secrets protected by a key verifier are only released once the visitor has proven they know the encryption key,
+	// which the rendered page does via the API without using the viewing key
+	if errors.Is(err, errVerificationRequired) {
+		var challenge keyChallenge
+		if challenge, err = a.store.secretKeyChallenge(accessID, viewingKey); err == nil {
+			pageVerifySecret(
+				fmt.Sprintf("/api/v1/secrets/%s/views/%s/verify", accessID, viewingKey),
+				challenge,
+				notifications,
+			).Render(r.Context(), w)
+			return
+		}
 	}
 
-	defer tx.Rollback()
-
-	// retrieve the cipher text and secret view id for the relevant secret, or return an error if that secret cannot be
-	// found
-	var cipherText string
-	var secretViewID int
-	var maxViews int
-	var currentViews int
-
-	err = tx.QueryRow(
-		`
-			SELECT
-				s.cipher_text,
-				v.id,
-				s.maximum_views,
-				(SELECT COUNT(1) FROM secret_views v2 WHERE v2.secret_id = v.secret_id AND viewed_at IS NOT NULL)
-			FROM
-				secrets s
-				INNER JOIN secret_views v ON v.secret_id = s.id
-			WHERE
-				s.access_id = ? AND
-				s.deleted_at IS NULL AND
-				v.viewing_key = ? AND
-				v.viewed_at IS NULL
-		`,
-		accessID,
-		viewingKey,
-	).Scan(&cipherText, &secretViewID, &maxViews, &currentViews)
-
-	if errors.Is(sql.ErrNoRows, err) {
+	if errors.Is(err, errSecretNotFound) {
+		a.recordFailedLookup(r)
 		setFlashErr("Secret does not exist, has been deleted, or the unique viewing key you attempted to use has been used before.", w)
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
-	} else if err != nil {
-		l.Err(err).Msg("retrieving secret")
-		redirectToOopsPage(w, r)
+	} else if errors.Is(err, errViewingKeyExpired) {
+		// the secret is still there to be opened again, which gives the visitor a new viewing key
+		setFlashErr(
+			"The link you used to view this secret has expired, as it was not used soon enough after the secret was opened. Open the secret again below to get a new link.",
+			w,
+		)
+		http.Redirect(w, r, fmt.Sprintf("/secret/%s", accessID), http.StatusSeeOther)
 		return
-	}
-
-	// record the secret view as being used so nobody else can use it to see the secret
-	_, err = tx.Exec("UPDATE secret_views SET viewed_at = ? WHERE id = ?", time.Now().UnixMilli(), secretViewID)
-	if err != nil {
-		l.Err(err).Msg("updating secret view")
+	} else if err != nil {
+		l.Err(err).Msg("viewing secret")
 		redirectToOopsPage(w, r)
 		return
 	}
 
-	// mark the secret as being deleted if this view is equal to or exceeds the maximum permitted views for the secret
-	if maxViews > 0 && currentViews+1 >= maxViews {
-		_, err := tx.Exec(
-			"UPDATE secrets SET deleted_at = ?, deletion_reason = ?, cipher_text = NULL WHERE access_id = ?",
-			time.Now().UnixMilli(),
-			deletionReasonMaximumViewCountHit,
-			accessID,
-		)
-
-		if err != nil {
-			l.Err(err).Msg("deleting secret")
-			redirectToOopsPage(w, r)
-			return
-		}
-
+	if secret.finalView {
 		notifications.warningMsg = "Maximum views reached. This secret will not be accessible again."
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		l.Err(err).Msg("committing tx")
-		redirectToOopsPage(w, r)
-		return
-	}
+	// secrets encrypted with a generated key are decrypted with the key in the fragment of the URL, rather than one
+	// entered by the visitor
+	e, _ := secretcrypto.Parse(secret.cipherText)
 
-	pageViewSecret(cipherText, notifications).Render(r.Context(), w)
+	pageViewSecret(secret.cipherText, secret.attachments, e.KeyInURL(), notifications).Render(r.Context(), w)
 }
 
 // handleManageSecret renders the management page of a secret and is intended for the original creator of the secret
@@ -354,23 +412,11 @@ func (a *Application) handleManageSecret(w http.ResponseWriter, r *http.Request)
 		Str("management_id", managementID).
 		Logger()
 
-	// retrieve the ID in order to view and decrypt the secret, or return an error if that secret cannot be found
-	var accessID string
-
-	err := a.db.db.QueryRow(
-		`
-			SELECT
-				access_id
-			FROM
-				secrets
-			WHERE
-				management_id = ? AND
-				deleted_at IS NULL
-		`,
-		managementID,
-	).Scan(&accessID)
-
-	if errors.Is(sql.ErrNoRows, err) {
+	// retrieve the secret in order to display its viewing URL, or return an error if that secret cannot be found
+	secret, err := a.store.secretByManagementID(managementID)
+
+	if errors.Is(err, errSecretNotFound) {
+		a.recordFailedLookup(r)
 		setFlashErr("Secret does not exist or has been deleted.", w)
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
@@ -381,8 +427,11 @@ func (a *Application) handleManageSecret(w http.ResponseWriter, r *http.Request)
 	}
 
 	pageManageSecret(
-		fmt.Sprintf("%s/secret/%s", a.baseURL, accessID),
+		fmt.Sprintf("%s/secret/%s", a.baseURL, secret.accessID),
 		fmt.Sprintf("%s/manage-secret/%s/delete", a.baseURL, managementID),
+		secret.invite,
+		secret.keyInURL,
+		secret.keyAttempts,
 		notificationsFromRequest(r, w),
 	).Render(r.Context(), w)
 }
@@ -394,13 +443,8 @@ func (a *Application) handleDeleteSecret(w http.ResponseWriter, r *http.Request)
 
 	// delete the secret (if it hasn't already been deleted), returning the user to the manage secret page with an error
 	// message if that fails
-	_, err := a.db.db.Exec(
-		"UPDATE secrets SET deleted_at = ?, deletion_reason = ?, cipher_text = NULL WHERE management_id = ? AND deleted_at IS NULL",
-		time.Now().UnixMilli(),
-		deletionReasonUserDeleted,
-		managementID,
-	)
-	if err != nil {
+	err := a.store.deleteSecret(managementID)
+	if err != nil && !errors.Is(err, errSecretNotFound) {
 		l.Err(err).Str("management_id", managementID).Msg("deleting secret")
 		redirectToOopsPage(w, r)
 		return
@@ -496,14 +540,46 @@ func secureID(size int) (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
+// secretCreationRestricted identifies whether only specific IP addresses or signed in users are allowed to create
+// secrets
+func (a *Application) secretCreationRestricted() bool {
+	return a.ipRestricted() || a.signInEnabled()
+}
+
+// ipRestricted identifies whether specific IP addresses have been allowed to create secrets
+func (a *Application) ipRestricted() bool {
+	config := a.config
+
+	return len(config.SecretCreationRestrictions.IPAddresses.FixedIPs) != 0 ||
+		len(config.SecretCreationRestrictions.IPAddresses.CIDRs) != 0
+}
+
+// requesterCanCreateSecret identifies whether the requester is permitted to create secrets, either by being signed in
+// or by their IP address. Either is sufficient when both are configured. The signed in user is returned so that the
+// secrets they create can be attributed to them.
+func (a *Application) requesterCanCreateSecret(r *http.Request) (*identity, bool) {
+	if user := a.signedInUser(r); user != nil {
+		return user, true
+	}
+
+	// when sign in is the only restriction, nobody else can create secrets
+	if a.signInEnabled() && !a.ipRestricted() {
+		return nil, false
+	}
+
+	return nil, a.requestingIPCanCreateSecret(r)
+}
+
 // requestingIPCanCreateSecret identifies whether the request was made from an IP address that has been specifically
 // allowed to create secrets.
-func requestingIPCanCreateSecret(config *Configuration, r *http.Request) bool {
-	if len(config.SecretCreationRestrictions.IPAddresses.FixedIPs) == 0 && len(config.SecretCreationRestrictions.IPAddresses.CIDRs) == 0 {
+func (a *Application) requestingIPCanCreateSecret(r *http.Request) bool {
+	config := a.config
+
+	if !a.ipRestricted() {
 		return true
 	}
 
-	sourceIP := net.ParseIP(strings.Split(r.Header.Get("X-Forwarded-For"), ",")[0])
+	sourceIP := a.clientIP(r)
 	if sourceIP == nil {
 		return false
 	}
@@ -524,3 +600,28 @@ func requestingIPCanCreateSecret(config *Configuration, r *http.Request) bool {
 
 	return false
 }
+
+// requesterCanInvite identifies whether the requester can invite others to create secrets. Invites are only useful
+// (and thus only permitted) when the creation of secrets is restricted.
+func (a *Application) requesterCanInvite(r *http.Request) bool {
+	_, ok := a.requesterCanCreateSecret(r)
+
+	return a.secretCreationRestricted() && ok
+}
+
+// inviteCanCreateSecret identifies whether the invite token belongs to an invite that can currently be used to create
+// a secret, returning the invite's identifier if so. It is the alternative to [requesterCanCreateSecret] for those
+// who are not otherwise allowed to create secrets.
+func (a *Application) inviteCanCreateSecret(r *http.Request, token string) (string, bool) {
+	id, ok := a.inviteIDFromToken(token)
+	if !ok {
+		return "", false
+	}
+
+	err := a.db.inviteUsable(id)
+	if err != nil && !errors.Is(err, errInvalidInvite) {
+		zerolog.Ctx(r.Context()).Err(err).Str("invite_id", id).Msg("checking invite")
+	}
+
+	return id, err == nil
+}
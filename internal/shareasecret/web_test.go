@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
@@ -26,7 +27,7 @@ func TestSecretCreation(t *testing.T) {
 		r := post(
 			t,
 			app.handleCreateSecret,
-			"ttl=30&encryptedSecret=a.b.c&maxViews=1",
+			"ttl=30&encryptedSecret="+testEncryptedSecret+"&maxViews=1",
 			func(r *http.Request) { r.Header.Del("X-Forwarded-For") },
 		)
 
@@ -45,8 +46,46 @@ func TestSecretCreation(t *testing.T) {
 		}
 	})
 
+	t.Run("bad request for malformed envelopes", func(t *testing.T) {
+		for _, cipherText := range []string{
+			"a.b.c",
+			"v2.pbkdf2-sha256-600000.yjq9raoOFbzI29ZPE2XGYQ.AAECAwQFBgcICQoL.EBESExQVFhcYGRob",
+			"v2.pbkdf2-sha256-1.yjq9raoOFbzI29ZPE2XGYQ.AAECAwQFBgcICQoLDA0ODw.EBESExQVFhcYGRob",
+		} {
+			body := url.Values{"ttl": {"30"}, "maxViews": {"1"}, "encryptedSecret": {cipherText}}.Encode()
+
+			if r := post(t, app.handleCreateSecret, body, emptyRequestConfigurer); r.statusCode != 400 {
+				t.Errorf("wanted 400 status code for %v, got %v", cipherText, r.statusCode)
+			}
+		}
+	})
+
+	t.Run("accepts secrets in the legacy format", func(t *testing.T) {
+		body := url.Values{
+			"ttl":             {"30"},
+			"maxViews":        {"1"},
+			"encryptedSecret": {"yjq9raoOFbzI29ZPE2XGYQ==.AAECAwQFBgcICQoLDA0ODw==.EBESExQVFhcYGRob"},
+		}
+
+		if r := post(t, app.handleCreateSecret, body.Encode(), emptyRequestConfigurer); r.statusCode != 201 {
+			t.Errorf("wanted 201 status code, got %v: %v", r.statusCode, r.body)
+		}
+	})
+
+	t.Run("accepts secrets encrypted with a generated key", func(t *testing.T) {
+		body := url.Values{
+			"ttl":             {"30"},
+			"maxViews":        {"1"},
+			"encryptedSecret": {testKeyInURLEncryptedSecret},
+		}
+
+		if r := post(t, app.handleCreateSecret, body.Encode(), emptyRequestConfigurer); r.statusCode != 201 {
+			t.Errorf("wanted 201 status code, got %v: %v", r.statusCode, r.body)
+		}
+	})
+
 	t.Run("bad request for invalid ttl", func(t *testing.T) {
-		if r := post(t, app.handleCreateSecret, "ttl=30x&encryptedSecret=a.b.c&maxViews=1", emptyRequestConfigurer); r.statusCode != 400 {
+		if r := post(t, app.handleCreateSecret, "ttl=30x&encryptedSecret="+testEncryptedSecret+"&maxViews=1", emptyRequestConfigurer); r.statusCode != 400 {
 			t.Errorf("wanted 400 status code, got %v", r.statusCode)
 		} else if !strings.Contains(r.body, "parse the TTL") {
 			t.Errorf("wanted 'parse the TTL' in body, got %v", r.body)
@@ -54,7 +93,7 @@ func TestSecretCreation(t *testing.T) {
 	})
 
 	t.Run("bad request for invalid maximum views", func(t *testing.T) {
-		if r := post(t, app.handleCreateSecret, "ttl=30&encryptedSecret=a.b.c&maxViews=-30", emptyRequestConfigurer); r.statusCode != 400 {
+		if r := post(t, app.handleCreateSecret, "ttl=30&encryptedSecret="+testEncryptedSecret+"&maxViews=-30", emptyRequestConfigurer); r.statusCode != 400 {
 			t.Errorf("wanted 400 status code, got %v", r.statusCode)
 		} else if !strings.Contains(r.body, "parse the maximum views") {
 			t.Errorf("wanted 'parse the maximum views' in body, got %v", r.body)
@@ -62,7 +101,7 @@ func TestSecretCreation(t *testing.T) {
 	})
 
 	t.Run("creates the secret and redirects correctly", func(t *testing.T) {
-		r := post(t, app.handleCreateSecret, "ttl=30&encryptedSecret=a.b.c&maxViews=1", emptyRequestConfigurer)
+		r := post(t, app.handleCreateSecret, "ttl=30&encryptedSecret="+testEncryptedSecret+"&maxViews=1", emptyRequestConfigurer)
 		if r.statusCode != 201 {
 			t.Errorf("wanted 201 status code, got %v", r.statusCode)
 		} else if _, ok := r.headers["Location"]; !ok {
@@ -170,20 +209,49 @@ func TestSecretAccess(t *testing.T) {
 		}
 	})
 
+	t.Run("sends visitor back to open the secret again if viewing key has expired", func(t *testing.T) {
+		accessID, _ := createSecret(t, time.Time{}, "")
+		viewingKey := createExpiredViewingKey(t, accessID)
+
+		r := get(t, app.handleAccessSecret, func(hr *http.Request) {
+			hr.SetPathValue("accessID", accessID)
+			hr.SetPathValue("viewingKey", viewingKey)
+		})
+		if !responseIsRedirectTo(r, fmt.Sprintf("/secret/%v", accessID)) {
+			t.Fatalf("expected redirect to /secret/%v, got %v %v", accessID, r.statusCode, r.headers.Get("Location"))
+		}
+
+		flash := cookie(r.cookies, "flash_err")
+		if flash == nil {
+			t.Fatalf("expected flash_err cookie to be present")
+		}
+
+		r = get(t, app.handleAccessSecretInterstitial, func(hr *http.Request) {
+			hr.SetPathValue("accessID", accessID)
+			hr.AddCookie(flash)
+		})
+		if r.statusCode != 200 {
+			t.Errorf("expected 200 status code, got %v", r.statusCode)
+		} else if !strings.Contains(r.body, "The link you used to view this secret has expired") {
+			t.Errorf("expected expiry explanation in body")
+		}
+	})
+
 	t.Run("returns error if secret viewing key has been used already", func(t *testing.T) {
 		accessID, _ := createSecret(t, time.Time{}, "")
 		viewingKey, _ := secureID(8)
 
 		_, err := app.db.db.Exec(
 			`
-				INSERT INTO secret_views (secret_id, viewing_key, viewed_at, created_at)
-				SELECT id, ?, NULL, ?
+				INSERT INTO secret_views (secret_id, viewing_key, viewed_at, created_at, expires_at)
+				SELECT id, ?, NULL, ?, ?
 				FROM secrets
 				WHERE access_id = ?
 			`,
 			viewingKey,
 			time.Now().UnixMilli(),
 			time.Now().UnixMilli(),
+			time.Now().Add(time.Minute).UnixMilli(),
 			accessID,
 		)
 		if err != nil {
@@ -202,6 +270,118 @@ func TestSecretAccess(t *testing.T) {
 	})
 }
 
+func TestSecretKeyInURL(t *testing.T) {
+	body := url.Values{"ttl": {"30"}, "maxViews": {"1"}, "encryptedSecret": {testKeyInURLEncryptedSecret}}
+
+	r := post(t, app.handleCreateSecret, body.Encode(), emptyRequestConfigurer)
+	if r.statusCode != 201 {
+		t.Fatalf("wanted 201 status code, got %v: %v", r.statusCode, r.body)
+	}
+
+	managementID := strings.TrimPrefix(r.headers.Get("Location"), "/manage-secret/")
+
+	t.Run("manage page completes the viewing url in the browser", func(t *testing.T) {
+		r := get(t, app.handleManageSecret, func(r *http.Request) { r.SetPathValue("managementID", managementID) })
+
+		if r.statusCode != 200 {
+			t.Errorf("expected 200 status code, got %v", r.statusCode)
+		} else if !strings.Contains(r.body, `id="keyInURLMissing"`) || !strings.Contains(r.body, `data-key-in-url="true"`) {
+			t.Errorf("expected key in url notice and data attribute in body")
+		}
+	})
+
+	t.Run("api reports the secret's key is in its url", func(t *testing.T) {
+		r := apiRequest(t, "GET", app.handleAPIManageSecret, "", withPathValue("managementID", managementID))
+
+		if r.statusCode != 200 || !strings.Contains(r.body, `"keyInUrl":true`) {
+			t.Errorf("expected keyInUrl in response, got %v %v", r.statusCode, r.body)
+		}
+	})
+
+	t.Run("view page does not ask for an encryption key", func(t *testing.T) {
+		var accessID string
+		if err := app.db.db.QueryRow("SELECT access_id FROM secrets WHERE management_id = ?", managementID).Scan(&accessID); err != nil {
+			t.Fatalf("querying secret: %v", err)
+		}
+
+		r := post(t, app.handleCreateSecretView, "", func(r *http.Request) { r.SetPathValue("accessID", accessID) })
+
+		r = get(t, app.handleAccessSecret, func(hr *http.Request) {
+			hr.SetPathValue("accessID", accessID)
+			hr.SetPathValue("viewingKey", (strings.Split(r.headers.Get("Location"), "/")[3]))
+		})
+
+		if r.statusCode != 200 {
+			t.Errorf("expected 200 status code, got %v", r.statusCode)
+		} else if strings.Contains(r.body, `name="password"`) || !strings.Contains(r.body, `data-key-in-url="true"`) {
+			t.Errorf("expected view page without an encryption key field")
+		}
+	})
+}
+
+func TestSecretExpiry(t *testing.T) {
+	t.Run("interstitial redirects home if secret has expired", func(t *testing.T) {
+		accessID, _ := createSecret(t, time.Time{}, "")
+		expireSecret(t, accessID)
+
+		r := get(t, app.handleAccessSecretInterstitial, func(r *http.Request) { r.SetPathValue("accessID", accessID) })
+		if !responseIsRedirectTo(r, "/") {
+			t.Errorf("expected redirect to home page, got %v", r.statusCode)
+		}
+	})
+
+	t.Run("viewing key cannot be created if secret has expired", func(t *testing.T) {
+		accessID, _ := createSecret(t, time.Time{}, "")
+		expireSecret(t, accessID)
+
+		r := post(t, app.handleCreateSecretView, "", func(r *http.Request) { r.SetPathValue("accessID", accessID) })
+		if !responseIsRedirectTo(r, "/") {
+			t.Errorf("expected redirect to home page, got %v", r.statusCode)
+		}
+
+		var c int
+
+		err := app.db.db.QueryRow(
+			"SELECT COUNT(1) FROM secret_views v INNER JOIN secrets s ON s.id = v.secret_id WHERE s.access_id = ?",
+			accessID,
+		).Scan(&c)
+		if err != nil {
+			t.Errorf("querying secret views: %v", err)
+		} else if c != 0 {
+			t.Errorf("expected no secret views to have been created, got %v", c)
+		}
+	})
+
+	t.Run("existing viewing key cannot be used if secret has expired", func(t *testing.T) {
+		accessID, _ := createSecret(t, time.Time{}, "")
+
+		r := post(t, app.handleCreateSecretView, "", func(r *http.Request) { r.SetPathValue("accessID", accessID) })
+		viewingKey := strings.Split(r.headers.Get("Location"), "/")[3]
+
+		expireSecret(t, accessID)
+
+		r = get(t, app.handleAccessSecret, func(hr *http.Request) {
+			hr.SetPathValue("accessID", accessID)
+			hr.SetPathValue("viewingKey", viewingKey)
+		})
+		if !responseIsRedirectTo(r, "/") {
+			t.Errorf("expected redirect to home page, got %v", r.statusCode)
+		} else if strings.Contains(r.body, testEncryptedSecret) {
+			t.Errorf("did not expect cipher text to be in body")
+		}
+	})
+
+	t.Run("management page redirects home if secret has expired", func(t *testing.T) {
+		accessID, managementID := createSecret(t, time.Time{}, "")
+		expireSecret(t, accessID)
+
+		r := get(t, app.handleManageSecret, func(r *http.Request) { r.SetPathValue("managementID", managementID) })
+		if !responseIsRedirectTo(r, "/") {
+			t.Errorf("expected redirect to home page, got %v", r.statusCode)
+		}
+	})
+}
+
 // post calls the handler, constructing an appropriate request and body and returning a simplified, already-read
 // version of the response
 func post(t *testing.T, endpoint http.HandlerFunc, body string, rc func(r *http.Request)) consumedResponse {
@@ -211,6 +391,7 @@ func post(t *testing.T, endpoint http.HandlerFunc, body string, rc func(r *http.
 	if err != nil {
 		t.Error()
 	}
+	r.RemoteAddr = testProxyAddr
 	r.Header.Add("X-Forwarded-For", "127.0.0.1")
 	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	rc(r)
@@ -242,6 +423,7 @@ func get(t *testing.T, endpoint http.HandlerFunc, rc func(r *http.Request)) cons
 	if err != nil {
 		t.Error()
 	}
+	r.RemoteAddr = testProxyAddr
 	r.Header.Add("X-Forwarded-For", "127.0.0.1")
 	rc(r)
 
@@ -0,0 +1,387 @@
+package shareasecret
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+)
+
+// COSE algorithm identifiers of the public keys accepted from authenticators
+const (
+	coseAlgorithmES256 = -7
+	coseAlgorithmRS256 = -257
+)
+
+// authenticator data flags, as defined by the WebAuthn specification
+const (
+	authenticatorFlagUserPresent            = 0x01
+	authenticatorFlagAttestedCredentialData = 0x40
+)
+
+// relyingParty verifies the WebAuthn registrations (passkeys being created) and assertions (passkeys being used to
+// sign in) made by browsers on behalf of the application. Attestation is not verified as passkeys can only be
+// registered via an enrolment link, which is what establishes trust in whoever registers them.
+type relyingParty struct {
+	// id is the relying party identifier (the host name of the application) that passkeys are scoped to
+	id string
+
+	// name is displayed by browsers when creating a passkey
+	name string
+
+	// origin is the origin (scheme, host and port) the application is served from
+	origin string
+}
+
+// newRelyingParty creates a relying party for an application served from the base URL
+func newRelyingParty(baseURL string, name string) (*relyingParty, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid base url (%v) for passkeys", baseURL)
+	}
+
+	return &relyingParty{id: u.Hostname(), name: name, origin: u.Scheme + "://" + u.Host}, nil
+}
+
+// webauthnCredential is a passkey's public key credential, as registered by an authenticator
+type webauthnCredential struct {
+	id        []byte
+	publicKey []byte
+	signCount uint32
+}
+
+// authenticatorData is the parsed form of the data an authenticator signs over
+type authenticatorData struct {
+	flags     byte
+	signCount uint32
+
+	// credential is only set when registering a passkey
+	credential *webauthnCredential
+}
+
+// verifyRegistration verifies the response of an authenticator to a request to create a passkey, returning the
+// credential that was created
+func (rp *relyingParty) verifyRegistration(challenge string, clientDataJSON []byte, attestationObject []byte) (webauthnCredential, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return webauthnCredential{}, err
+	}
+
+	v, n, err := decodeCBOR(attestationObject, 0)
+	if err != nil {
+		return webauthnCredential{}, fmt.Errorf("decoding attestation object: %w", err)
+	} else if n != len(attestationObject) {
+		return webauthnCredential{}, errors.New("attestation object contains trailing data")
+	}
+
+	attestation, _ := v.(map[any]any)
+	raw, ok := attestation["authData"].([]byte)
+	if !ok {
+		return webauthnCredential{}, errors.New("attestation object does not contain authenticator data")
+	}
+
+	data, err := rp.parseAuthenticatorData(raw)
+	if err != nil {
+		return webauthnCredential{}, err
+	} else if data.credential == nil {
+		return webauthnCredential{}, errors.New("authenticator data does not contain a credential")
+	}
+
+	return *data.credential, nil
+}
+
+// verifyAssertion verifies the response of an authenticator to a request to sign in with a previously registered
+// passkey, returning the passkey's new signature counter
+func (rp *relyingParty) verifyAssertion(
+	challenge string,
+	credential webauthnCredential,
+	clientDataJSON []byte,
+	rawAuthenticatorData []byte,
+	signature []byte,
+) (uint32, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	data, err := rp.parseAuthenticatorData(rawAuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if err := verifyCOSESignature(credential.publicKey, append(bytes.Clone(rawAuthenticatorData), clientDataHash[:]...), signature); err != nil {
+		return 0, err
+	}
+
+	// authenticators that count signatures always increase the count, so anything else suggests the passkey has been
+	// cloned
+	if (data.signCount != 0 || credential.signCount != 0) && data.signCount <= credential.signCount {
+		return 0, fmt.Errorf("signature counter went from %d to %d", credential.signCount, data.signCount)
+	}
+
+	return data.signCount, nil
+}
+
+// verifyClientData verifies that the client data collected by the browser is for the expected ceremony and challenge,
+// and that it was collected on the application's origin
+func (rp *relyingParty) verifyClientData(clientDataJSON []byte, ceremony string, challenge string) error {
+	var c struct {
+		Type        string `json:"type"`
+		Challenge   string `json:"challenge"`
+		Origin      string `json:"origin"`
+		CrossOrigin bool   `json:"crossOrigin"`
+	}
+
+	if err := json.Unmarshal(clientDataJSON, &c); err != nil {
+		return fmt.Errorf("decoding client data: %w", err)
+	}
+
+	switch {
+	case c.Type != ceremony:
+		return fmt.Errorf("unexpected client data type %v", c.Type)
+	case challenge == "" || c.Challenge != challenge:
+		return errors.New("unexpected challenge")
+	case c.Origin != rp.origin || c.CrossOrigin:
+		return fmt.Errorf("unexpected origin %v", c.Origin)
+	}
+
+	return nil
+}
+
+// parseAuthenticatorData parses the data signed by an authenticator, verifying that it is scoped to the relying party
+// and that the user was present
+func (rp *relyingParty) parseAuthenticatorData(b []byte) (authenticatorData, error) {
+	if len(b) < 37 {
+		return authenticatorData{}, errors.New("authenticator data is too short")
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.id))
+	if !bytes.Equal(b[:32], rpIDHash[:]) {
+		return authenticatorData{}, errors.New("authenticator data is for another relying party")
+	}
+
+	data := authenticatorData{flags: b[32], signCount: binary.BigEndian.Uint32(b[33:37])}
+	if data.flags&authenticatorFlagUserPresent == 0 {
+		return authenticatorData{}, errors.New("user was not present")
+	}
+
+	if data.flags&authenticatorFlagAttestedCredentialData == 0 {
+		return data, nil
+	}
+
+	// attested credential data consists of a 16 byte AAGUID, a 2 byte length, the credential id and its public key
+	b = b[37:]
+	if len(b) < 18 {
+		return authenticatorData{}, errors.New("attested credential data is too short")
+	}
+
+	idLength := int(binary.BigEndian.Uint16(b[16:18]))
+	if idLength == 0 || idLength > 1023 || len(b) < 18+idLength {
+		return authenticatorData{}, errors.New("invalid credential id")
+	}
+
+	id := b[18 : 18+idLength]
+	b = b[18+idLength:]
+
+	_, n, err := decodeCBOR(b, 0)
+	if err != nil {
+		return authenticatorData{}, fmt.Errorf("decoding credential public key: %w", err)
+	}
+
+	if _, err := coseKey(b[:n]); err != nil {
+		return authenticatorData{}, err
+	}
+
+	data.credential = &webauthnCredential{id: bytes.Clone(id), publicKey: bytes.Clone(b[:n]), signCount: data.signCount}
+
+	return data, nil
+}
+
+// coseKey parses a COSE encoded public key, supporting ES256 (P-256) and RS256 keys
+func coseKey(b []byte) (crypto.PublicKey, error) {
+	v, _, err := decodeCBOR(b, 0)
+	if err != nil {
+		return nil, fmt.Errorf("decoding public key: %w", err)
+	}
+
+	k, _ := v.(map[any]any)
+
+	switch alg, _ := k[int64(3)].(int64); alg {
+	case coseAlgorithmES256:
+		x, _ := k[int64(-2)].([]byte)
+		y, _ := k[int64(-3)].([]byte)
+		if kty, _ := k[int64(1)].(int64); kty != 2 {
+			return nil, errors.New("es256 public key is not an elliptic curve key")
+		} else if crv, _ := k[int64(-1)].(int64); crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("es256 public key is not a p-256 point")
+		}
+
+		// ecdh validates that the point is on the curve, which ecdsa does not
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, fmt.Errorf("es256 public key: %w", err)
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case coseAlgorithmRS256:
+		n, _ := k[int64(-1)].([]byte)
+		e, _ := k[int64(-2)].([]byte)
+		if kty, _ := k[int64(1)].(int64); kty != 3 {
+			return nil, errors.New("rs256 public key is not an rsa key")
+		} else if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("rs256 public key is too small or invalid")
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key algorithm %v", k[int64(3)])
+	}
+}
+
+// verifyCOSESignature verifies the signature of the data using a COSE encoded public key
+func verifyCOSESignature(publicKey []byte, data []byte, signature []byte) error {
+	k, err := coseKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(data)
+
+	switch k := k.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest[:], signature) {
+			return errors.New("invalid signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("invalid signature: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// decodeCBOR decodes the first CBOR data item in b, returning it and the number of bytes it occupied. Only the subset
+// of CBOR produced by authenticators is supported: integers (as int64), byte and text strings, arrays, maps (as
+// map[any]any) and the simple values false, true and null.
+func decodeCBOR(b []byte, depth int) (any, int, error) {
+	if depth > 16 {
+		return nil, 0, errors.New("cbor is nested too deeply")
+	}
+
+	if len(b) == 0 {
+		return nil, 0, errors.New("unexpected end of cbor")
+	}
+
+	major, info := b[0]>>5, b[0]&0x1f
+	n := 1
+
+	// the additional information is either the argument itself or the number of bytes following that contain it
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(b) < 1+size {
+			return nil, 0, errors.New("unexpected end of cbor")
+		}
+
+		for _, c := range b[1 : 1+size] {
+			arg = arg<<8 | uint64(c)
+		}
+		n += size
+	default:
+		return nil, 0, fmt.Errorf("unsupported cbor additional information %d", info)
+	}
+
+	remaining := uint64(len(b) - n)
+
+	switch major {
+	case 0, 1:
+		if arg > 1<<63-1 {
+			return nil, 0, errors.New("cbor integer overflows")
+		}
+
+		if major == 1 {
+			return -1 - int64(arg), n, nil
+		}
+
+		return int64(arg), n, nil
+	case 2, 3:
+		if arg > remaining {
+			return nil, 0, errors.New("unexpected end of cbor")
+		}
+
+		s := b[n : n+int(arg)]
+		if major == 3 {
+			return string(s), n + int(arg), nil
+		}
+
+		return bytes.Clone(s), n + int(arg), nil
+	case 4:
+		if arg > remaining {
+			return nil, 0, errors.New("unexpected end of cbor")
+		}
+
+		items := make([]any, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			v, m, err := decodeCBOR(b[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			items = append(items, v)
+			n += m
+		}
+
+		return items, n, nil
+	case 5:
+		if arg > remaining/2 {
+			return nil, 0, errors.New("unexpected end of cbor")
+		}
+
+		m := make(map[any]any, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, kn, err := decodeCBOR(b[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += kn
+
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, 0, errors.New("unsupported cbor map key")
+			}
+
+			v, vn, err := decodeCBOR(b[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += vn
+
+			m[k] = v
+		}
+
+		return m, n, nil
+	case 7:
+		switch info {
+		case 20:
+			return false, n, nil
+		case 21:
+			return true, n, nil
+		case 22:
+			return nil, n, nil
+		}
+	}
+
+	return nil, 0, fmt.Errorf("unsupported cbor major type %d", major)
+}
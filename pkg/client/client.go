@@ -0,0 +1,865 @@
+// Package client is a typed client for the JSON API of a shareasecret server.
+//
+// Secrets are never sent to the server in plaintext. [Client.CreateSecret] and [Client.AccessSecret] deal in secrets
+// that have already been encrypted with the [secretcrypto] package, whereas [Client.SendSecret] and
+// [Client.OpenSecret] encrypt and decrypt on the caller's behalf.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lsymds/shareasecret/pkg/secretcrypto"
+)
+
+// Client makes requests to a shareasecret server's JSON API
+type Client struct {
+	// BaseURL is the base URL of the shareasecret server i.e. https://secret.mycompany.example
+	BaseURL string
+
+	// HTTPClient is the client used to make requests
+	HTTPClient *http.Client
+
+	// Invite is the token of an invite link (see [ParseInviteURL]) that is used to create secrets on servers that only
+	// permit specific IP addresses to create them
+	Invite string
+
+	// Organisation is the identifier of the organisation that owns the secrets created with the client. Only the
+	// organisation's members (or those requesting from the IP addresses it permits) can create them.
+	Organisation string
+
+	// Token is an API token that authorises requests, permitting the creation of secrets regardless of any restrictions
+	// and the management of secrets created with it (depending on its scopes)
+	Token string
+
+	// Attachments are files that are encrypted with the secret's key and attached to every secret sent with the client
+	Attachments []File
+
+	// UploadChunkSize is the size of the chunks that secrets (and their attachments) are uploaded in when they are too
+	// large to reliably send in a single request, which is resumed should a chunk fail. Defaults to 1 MiB, is reduced to
+	// the largest chunk the server accepts, and a negative value sends every secret in a single request.
+	UploadChunkSize int
+}
+
+const (
+	// defaultUploadChunkSize is the size of the chunks secrets are uploaded in if [Client.UploadChunkSize] is not set
+	defaultUploadChunkSize = 1 << 20
+
+	// maxUploadRetries is the number of times in a row a chunk is retried before an upload is abandoned
+	maxUploadRetries = 5
+
+	// tusVersion is the version of the tus resumable upload protocol the server implements uploads with
+	tusVersion = "1.0.0"
+)
+
+// uploadRetryDelay is how long the first retry of a failed chunk is delayed for, with each subsequent retry waiting
+// longer
+var uploadRetryDelay = time.Second
+
+// File is a file attached to a secret, before it is encrypted or once it has been decrypted
+type File struct {
+	Name    string
+	Content []byte
+}
+
+// Attachment is a file attached to a secret, encrypted with the secret's key (see [EncryptAttachments])
+type Attachment struct {
+	// Name is the file's name, encrypted into the same envelope format as the secret
+	Name string `json:"name"`
+
+	// Content is the iv followed by the file's encrypted content, unpadded base64url encoded
+	Content string `json:"content"`
+}
+
+// New creates a [Client] for the shareasecret server hosted at the base URL
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// CreateSecretRequest contains the values required to create a secret
+type CreateSecretRequest struct {
+	// EncryptedSecret is the secret encrypted with [secretcrypto.Encrypt]
+	EncryptedSecret string `json:"encryptedSecret"`
+
+	// TTL is the number of minutes until the secret expires
+	TTL int `json:"ttl"`
+
+	// MaxViews is the maximum number of times the secret can be viewed, with 0 being infinite
+	MaxViews int `json:"maxViews"`
+
+	// ProofOfWork is the solution to a challenge issued by the server. It is only required by servers configured to
+	// require one, and is solved automatically by [Client.CreateSecret] if it is left empty.
+	ProofOfWork *ProofOfWork `json:"proofOfWork,omitempty"`
+
+	// Invite is the token of an invite link. It defaults to the client's Invite if left empty.
+	Invite string `json:"invite,omitempty"`
+
+	// Organisation is the identifier of the organisation that will own the secret. It defaults to the client's
+	// Organisation if left empty.
+	Organisation string `json:"organisation,omitempty"`
+
+	// Verifier is the verifier of the key the secret was encrypted with (see [secretcrypto.EncryptWithVerifier]). If it
+	// is set, the encrypted secret is only released to viewers that prove they know the key, and the secret is deleted
+	// once too many incorrect keys have been tried.
+	Verifier string `json:"verifier,omitempty"`
+
+	// Attachments are files encrypted with the secret's key, which are released and deleted along with the secret
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	// Upload is the identifier of a complete upload containing the encrypted secret and its attachments, which are
+	// then not set. [Client.CreateSecret] uploads large secrets and sets it automatically.
+	Upload string `json:"upload,omitempty"`
+}
+
+// ProofOfWork contains the solution to a [Challenge]
+type ProofOfWork struct {
+	Challenge string `json:"challenge"`
+	Solution  string `json:"solution"`
+}
+
+// Challenge is a proof of work challenge that must be solved before a secret can be created
+type Challenge struct {
+	Challenge string `json:"challenge"`
+
+	// Difficulty is the number of leading zero bits the SHA-256 hash of "{challenge}:{solution}" must have
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// Solve finds a solution to the challenge. It is CPU intensive, and returns early if the context is cancelled.
+func (c Challenge) Solve(ctx context.Context) (*ProofOfWork, error) {
+	prefix := []byte(c.Challenge + ":")
+
+	for counter := uint64(0); ; counter++ {
+		if counter%100000 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		solution := strconv.FormatUint(counter, 36)
+		if leadingZeroBits(sha256.Sum256(append(prefix, solution...))) >= c.Difficulty {
+			return &ProofOfWork{Challenge: c.Challenge, Solution: solution}, nil
+		}
+	}
+}
+
+// CreatedSecret contains the identifiers and URLs of a created secret
+type CreatedSecret struct {
+	AccessID      string `json:"accessId"`
+	ManagementID  string `json:"managementId"`
+	ViewSecretURL string `json:"viewSecretUrl"`
+	ManagementURL string `json:"managementUrl"`
+}
+
+// AccessedSecret contains the encrypted contents of a viewed secret
+type AccessedSecret struct {
+	EncryptedSecret string       `json:"encryptedSecret"`
+	Attachments     []Attachment `json:"attachments"`
+
+	// FinalView identifies whether the view was the last permitted view of the secret
+	FinalView bool `json:"finalView"`
+}
+
+// KeyChallenge contains what is needed to derive the verifier of the encryption key of a secret protected by one
+type KeyChallenge struct {
+	// Iterations and Salt are the key derivation parameters of the secret, with the salt being unpadded base64url
+	// encoded
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"`
+
+	// AttemptsRemaining is the number of incorrect keys that can be tried before the secret is deleted
+	AttemptsRemaining int `json:"attemptsRemaining"`
+}
+
+// Verifier derives the verifier of the key derived from the password
+func (k KeyChallenge) Verifier(password string) (string, error) {
+	salt, err := base64.RawURLEncoding.DecodeString(k.Salt)
+	if err != nil {
+		return "", fmt.Errorf("decoding salt: %w", err)
+	}
+
+	return secretcrypto.Verifier(secretcrypto.Envelope{Iterations: k.Iterations, Salt: salt}, password)
+}
+
+// SecretMetadata contains the information about a secret visible to its creator
+type SecretMetadata struct {
+	AccessID      string    `json:"accessId"`
+	ViewSecretURL string    `json:"viewSecretUrl"`
+	TTL           int       `json:"ttl"`
+	MaxViews      int       `json:"maxViews"`
+	Views         int       `json:"views"`
+	CreatedAt     time.Time `json:"createdAt"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+
+	// Status is one of active, expired, viewed or deleted. It is only set when listing secrets.
+	Status string `json:"status"`
+
+	// KeyInURL identifies whether the secret was encrypted with a generated key that is only part of the viewing URL
+	// returned when it was created. ViewSecretURL never contains the key.
+	KeyInURL bool `json:"keyInUrl"`
+
+	// Attachments is the number of files attached to the secret
+	Attachments int `json:"attachments"`
+
+	// MaxKeyAttempts and FailedKeyAttempts are only set if the secret is protected by a key verifier
+	MaxKeyAttempts    int `json:"maxKeyAttempts"`
+	FailedKeyAttempts int `json:"failedKeyAttempts"`
+
+	// DeletedAt and DeletionReason are only set once the secret has been deleted
+	DeletedAt      *time.Time `json:"deletedAt,omitempty"`
+	DeletionReason string     `json:"deletionReason,omitempty"`
+}
+
+// Error is returned when the server responds to a request with an error
+type Error struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d %s)", e.Message, e.StatusCode, e.Code)
+}
+
+// CreateSecret persists an already encrypted secret on the server. Secrets (and their attachments) larger than
+// [Client.UploadChunkSize] are uploaded in chunks first. If the server requires a proof of work and none was provided,
+// a challenge is retrieved and solved before trying again.
+func (c *Client) CreateSecret(ctx context.Context, req CreateSecretRequest) (*CreatedSecret, error) {
+	var res CreatedSecret
+
+	if req.Invite == "" {
+		req.Invite = c.Invite
+	}
+
+	if req.Organisation == "" {
+		req.Organisation = c.Organisation
+	}
+
+	if req.Upload == "" && c.UploadChunkSize >= 0 {
+		payload, err := json.Marshal(uploadedSecret{EncryptedSecret: req.EncryptedSecret, Attachments: req.Attachments})
+		if err != nil {
+			return nil, fmt.Errorf("encoding upload: %w", err)
+		}
+
+		if len(payload) > c.uploadChunkSize() {
+			if req.Upload, err = c.upload(ctx, payload, req.Invite, req.Organisation); err != nil {
+				return nil, err
+			}
+
+			req.EncryptedSecret, req.Attachments = "", nil
+		}
+	}
+
+	err := c.do(ctx, "POST", "/api/v1/secrets", req, &res)
+
+	var e *Error
+	if req.ProofOfWork == nil && errors.As(err, &e) && e.Code == "proof_of_work_failed" {
+		challenge, err := c.Challenge(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if req.ProofOfWork, err = challenge.Solve(ctx); err != nil {
+			return nil, err
+		}
+
+		return c.CreateSecret(ctx, req)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// Challenge retrieves a proof of work challenge that must be solved before a secret can be created. Servers that do
+// not require a proof of work respond with a not_found [Error].
+func (c *Client) Challenge(ctx context.Context) (*Challenge, error) {
+	var res Challenge
+	if err := c.do(ctx, "GET", "/api/v1/challenge", nil, &res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// CreateViewingKey creates a single use viewing key for a secret. Creating a viewing key does not use a view of the
+// secret.
+func (c *Client) CreateViewingKey(ctx context.Context, accessID string) (string, error) {
+	var res struct {
+		ViewingKey string `json:"viewingKey"`
+	}
+
+	if err := c.do(ctx, "POST", fmt.Sprintf("/api/v1/secrets/%s/views", url.PathEscape(accessID)), nil, &res); err != nil {
+		return "", err
+	}
+
+	return res.ViewingKey, nil
+}
+
+// AccessSecret uses a viewing key created by [Client.CreateViewingKey] to retrieve the encrypted contents of a secret,
+// using a view of the secret
+func (c *Client) AccessSecret(ctx context.Context, accessID string, viewingKey string) (*AccessedSecret, error) {
+	var res AccessedSecret
+
+	err := c.do(
+		ctx,
+		"GET",
+		fmt.Sprintf("/api/v1/secrets/%s/views/%s", url.PathEscape(accessID), url.PathEscape(viewingKey)),
+		nil,
+		&res,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// KeyChallenge retrieves the key challenge of a secret protected by a key verifier using a viewing key created by
+// [Client.CreateViewingKey], without using the viewing key
+func (c *Client) KeyChallenge(ctx context.Context, accessID string, viewingKey string) (*KeyChallenge, error) {
+	var res KeyChallenge
+
+	err := c.do(
+		ctx,
+		"GET",
+		fmt.Sprintf("/api/v1/secrets/%s/views/%s/challenge", url.PathEscape(accessID), url.PathEscape(viewingKey)),
+		nil,
+		&res,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// VerifySecretView is [Client.AccessSecret] for secrets protected by a key verifier. An incorrect verifier results in
+// an incorrect_key [Error], or a maximum_key_attempts_hit [Error] if the secret was deleted as a result.
+func (c *Client) VerifySecretView(ctx context.Context, accessID string, viewingKey string, verifier string) (*AccessedSecret, error) {
+	var res AccessedSecret
+
+	err := c.do(
+		ctx,
+		"POST",
+		fmt.Sprintf("/api/v1/secrets/%s/views/%s/verify", url.PathEscape(accessID), url.PathEscape(viewingKey)),
+		struct {
+			Verifier string `json:"verifier"`
+		}{verifier},
+		&res,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// ViewSecret creates a viewing key for the secret and immediately uses it to retrieve the secret's encrypted contents,
+// using a view of the secret
+func (c *Client) ViewSecret(ctx context.Context, accessID string) (*AccessedSecret, error) {
+	key, err := c.CreateViewingKey(ctx, accessID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.AccessSecret(ctx, accessID, key)
+}
+
+// Secret retrieves the metadata of a secret using its management identifier
+func (c *Client) Secret(ctx context.Context, managementID string) (*SecretMetadata, error) {
+	var res SecretMetadata
+	if err := c.do(ctx, "GET", fmt.Sprintf("/api/v1/manage/%s", url.PathEscape(managementID)), nil, &res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// DeleteSecret deletes a secret using its management identifier
+func (c *Client) DeleteSecret(ctx context.Context, managementID string) error {
+	return c.do(ctx, "DELETE", fmt.Sprintf("/api/v1/manage/%s", url.PathEscape(managementID)), nil, nil)
+}
+
+// Secrets retrieves the metadata of every live secret created with the client's API token. The token must have the
+// read scope.
+func (c *Client) Secrets(ctx context.Context) ([]SecretMetadata, error) {
+	secrets := []SecretMetadata{}
+
+	for page := 1; page != 0; {
+		s, next, err := c.SecretsPage(ctx, "active", page)
+		if err != nil {
+			return nil, err
+		}
+
+		secrets = append(secrets, s...)
+		page = next
+	}
+
+	return secrets, nil
+}
+
+// SecretsPage retrieves a page (starting from 1) of the secrets created with the client's API token that are in the
+// given state (one of all, active, expired, viewed or deleted), newest first. The number of the next page is also
+// returned, or 0 if there are no more. The token must have the read scope.
+func (c *Client) SecretsPage(ctx context.Context, state string, page int) ([]SecretMetadata, int, error) {
+	var res struct {
+		Secrets  []SecretMetadata `json:"secrets"`
+		NextPage int              `json:"nextPage"`
+	}
+
+	q := url.Values{"state": {state}, "page": {strconv.Itoa(page)}}
+	if err := c.do(ctx, "GET", "/api/v1/secrets?"+q.Encode(), nil, &res); err != nil {
+		return nil, 0, err
+	}
+
+	return res.Secrets, res.NextPage, nil
+}
+
+// DeleteSecrets deletes up to 100 secrets created with the client's API token using their access identifiers,
+// returning the number that were deleted. The token must have the manage scope.
+func (c *Client) DeleteSecrets(ctx context.Context, accessIDs []string) (int, error) {
+	return c.deleteSecrets(ctx, deleteSecretsRequest{AccessIDs: accessIDs})
+}
+
+// DeleteAllSecrets deletes every active secret created with the client's API token, returning the number that were
+// deleted. The token must have the manage scope.
+func (c *Client) DeleteAllSecrets(ctx context.Context) (int, error) {
+	return c.deleteSecrets(ctx, deleteSecretsRequest{All: true})
+}
+
+// deleteSecretsRequest identifies the secrets to delete in bulk
+type deleteSecretsRequest struct {
+	AccessIDs []string `json:"accessIds,omitempty"`
+	All       bool     `json:"all,omitempty"`
+}
+
+// deleteSecrets bulk deletes secrets created with the client's API token
+func (c *Client) deleteSecrets(ctx context.Context, req deleteSecretsRequest) (int, error) {
+	var res struct {
+		Deleted int `json:"deleted"`
+	}
+
+	if err := c.do(ctx, "POST", "/api/v1/secrets/delete", req, &res); err != nil {
+		return 0, err
+	}
+
+	return res.Deleted, nil
+}
+
+// DeleteSecretByAccessID deletes a secret created with the client's API token using its access identifier. The token
+// must have the manage scope.
+func (c *Client) DeleteSecretByAccessID(ctx context.Context, accessID string) error {
+	return c.do(ctx, "DELETE", fmt.Sprintf("/api/v1/secrets/%s", url.PathEscape(accessID)), nil, nil)
+}
+
+// SendSecret encrypts the plaintext with the password and persists it on the server
+func (c *Client) SendSecret(ctx context.Context, plainText []byte, password string, ttl int, maxViews int) (*CreatedSecret, error) {
+	encryptedSecret, err := secretcrypto.Encrypt(plainText, password)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting secret: %w", err)
+	}
+
+	return c.sendSecret(ctx, CreateSecretRequest{EncryptedSecret: encryptedSecret, TTL: ttl, MaxViews: maxViews}, password)
+}
+
+// SendSecretWithVerifier is [Client.SendSecret], but the secret is only released to viewers that prove they know the
+// password and is deleted once too many incorrect passwords have been tried
+func (c *Client) SendSecretWithVerifier(ctx context.Context, plainText []byte, password string, ttl int, maxViews int) (*CreatedSecret, error) {
+	encryptedSecret, verifier, err := secretcrypto.EncryptWithVerifier(plainText, password)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting secret: %w", err)
+	}
+
+	return c.sendSecret(
+		ctx,
+		CreateSecretRequest{EncryptedSecret: encryptedSecret, TTL: ttl, MaxViews: maxViews, Verifier: verifier},
+		password,
+	)
+}
+
+// SendSecretWithKeyInURL encrypts the plaintext with a generated key and persists it on the server. The key is added to
+// the fragment of the returned viewing URL, which is never sent to the server, so the viewing URL alone is enough to
+// open the secret.
+func (c *Client) SendSecretWithKeyInURL(ctx context.Context, plainText []byte, ttl int, maxViews int) (*CreatedSecret, error) {
+	key, err := secretcrypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedSecret, err := secretcrypto.EncryptWithKey(plainText, key)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting secret: %w", err)
+	}
+
+	created, err := c.sendSecret(ctx, CreateSecretRequest{EncryptedSecret: encryptedSecret, TTL: ttl, MaxViews: maxViews}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	created.ViewSecretURL += "#" + url.Values{"k": {key}}.Encode()
+
+	return created, nil
+}
+
+// sendSecret encrypts the client's attachments with the password the request's secret was encrypted with, attaching
+// them to it, and persists it on the server
+func (c *Client) sendSecret(ctx context.Context, req CreateSecretRequest, password string) (*CreatedSecret, error) {
+	if len(c.Attachments) > 0 {
+		var err error
+		if req.Attachments, err = EncryptAttachments(req.EncryptedSecret, password, c.Attachments); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.CreateSecret(ctx, req)
+}
+
+// OpenSecret uses a view of the secret and decrypts it with the password (or, if the secret was sent with its key in
+// its viewing URL, with that key). If the secret is protected by a key verifier, the password is verified first.
+func (c *Client) OpenSecret(ctx context.Context, accessID string, password string) ([]byte, error) {
+	plainText, _, err := c.OpenSecretWithAttachments(ctx, accessID, password)
+	return plainText, err
+}
+
+// OpenSecretWithAttachments is [Client.OpenSecret], but also decrypts and returns the files attached to the secret
+func (c *Client) OpenSecretWithAttachments(ctx context.Context, accessID string, password string) ([]byte, []File, error) {
+	key, err := c.CreateViewingKey(ctx, accessID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s, err := c.AccessSecret(ctx, accessID, key)
+
+	var e *Error
+	if errors.As(err, &e) && e.Code == "verification_required" {
+		s, err = c.verifyAndAccessSecret(ctx, accessID, key, password)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plainText, err := secretcrypto.Decrypt(s.EncryptedSecret, password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files, err := DecryptAttachments(s.EncryptedSecret, password, s.Attachments)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return plainText, files, nil
+}
+
+// EncryptAttachments encrypts files with the key of the encrypted secret they are to be attached to, which is derived
+// from the password the secret was encrypted with (or is the generated key it was encrypted with)
+func EncryptAttachments(encryptedSecret string, password string, files []File) ([]Attachment, error) {
+	k, err := secretcrypto.DeriveKey(encryptedSecret, password)
+	if err != nil {
+		return nil, err
+	}
+
+	attachments := make([]Attachment, 0, len(files))
+	for _, f := range files {
+		name, content, err := k.EncryptAttachment(f.Name, f.Content)
+		if err != nil {
+			return nil, fmt.Errorf("encrypting attachment: %w", err)
+		}
+
+		attachments = append(attachments, Attachment{Name: name, Content: base64.RawURLEncoding.EncodeToString(content)})
+	}
+
+	return attachments, nil
+}
+
+// DecryptAttachments decrypts the files attached to an encrypted secret with the password the secret was encrypted with
+// (or the generated key it was encrypted with)
+func DecryptAttachments(encryptedSecret string, password string, attachments []Attachment) ([]File, error) {
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+
+	k, err := secretcrypto.DeriveKey(encryptedSecret, password)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]File, 0, len(attachments))
+	for _, a := range attachments {
+		content, err := base64.RawURLEncoding.DecodeString(a.Content)
+		if err != nil {
+			return nil, secretcrypto.ErrMalformedAttachment
+		}
+
+		name, content, err := k.DecryptAttachment(a.Name, content)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, File{Name: name, Content: content})
+	}
+
+	return files, nil
+}
+
+// verifyAndAccessSecret derives the verifier of the password for a secret protected by a key verifier, and uses it to
+// retrieve the secret's encrypted contents
+func (c *Client) verifyAndAccessSecret(ctx context.Context, accessID string, viewingKey string, password string) (*AccessedSecret, error) {
+	challenge, err := c.KeyChallenge(ctx, accessID, viewingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := challenge.Verifier(password)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.VerifySecretView(ctx, accessID, viewingKey, verifier)
+}
+
+// uploadedSecret is the content of an upload, which contains the parts of a [CreateSecretRequest] that are too large to
+// reliably send in one
+type uploadedSecret struct {
+	EncryptedSecret string       `json:"encryptedSecret"`
+	Attachments     []Attachment `json:"attachments,omitempty"`
+}
+
+// uploadChunkSize returns the size of the chunks secrets are uploaded in
+func (c *Client) uploadChunkSize() int {
+	if c.UploadChunkSize > 0 {
+		return c.UploadChunkSize
+	}
+
+	return defaultUploadChunkSize
+}
+
+// upload uploads the content in chunks using the tus resumable upload protocol, returning the identifier of the
+// complete upload. Chunks that fail are retried from wherever the server got up to, so the content only has to be
+// encrypted once no matter how unreliable the connection is.
+func (c *Client) upload(ctx context.Context, content []byte, invite string, organisation string) (string, error) {
+	h := http.Header{}
+	h.Set("Upload-Length", strconv.Itoa(len(content)))
+
+	metadata := []string{}
+	if invite != "" {
+		metadata = append(metadata, "invite "+base64.StdEncoding.EncodeToString([]byte(invite)))
+	}
+	if organisation != "" {
+		metadata = append(metadata, "organisation "+base64.StdEncoding.EncodeToString([]byte(organisation)))
+	}
+	if len(metadata) > 0 {
+		h.Set("Upload-Metadata", strings.Join(metadata, ","))
+	}
+
+	res, err := c.doUpload(ctx, "POST", "/api/v1/uploads", h, nil)
+	if err != nil {
+		return "", err
+	}
+
+	path := res.Header.Get("Location")
+
+	// chunks are kept within the largest the server accepts
+	chunkSize := c.uploadChunkSize()
+	if n, err := strconv.Atoi(res.Header.Get("Tus-Max-Chunk-Size")); err == nil && n > 0 {
+		chunkSize = min(chunkSize, n)
+	}
+
+	offset, failures := 0, 0
+	for offset < len(content) {
+		h := http.Header{}
+		h.Set("Content-Type", "application/offset+octet-stream")
+		h.Set("Upload-Offset", strconv.Itoa(offset))
+
+		chunk := content[offset:min(offset+chunkSize, len(content))]
+
+		_, err := c.doUpload(ctx, "PATCH", path, h, chunk)
+		if err == nil {
+			offset += len(chunk)
+			failures = 0
+			continue
+		}
+
+		// only chunks that failed because of the connection, the server or a mismatched offset (i.e. the server
+		// received part of a previous chunk) are worth retrying
+		var e *Error
+		if ctx.Err() != nil || (errors.As(err, &e) && e.StatusCode != http.StatusConflict && e.StatusCode < 500) {
+			return "", err
+		}
+
+		if failures++; failures > maxUploadRetries {
+			return "", fmt.Errorf("uploading: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Duration(failures) * uploadRetryDelay):
+		}
+
+		// resume from wherever the server got up to, which may be part of the way through the failed chunk
+		if res, err := c.doUpload(ctx, "HEAD", path, nil, nil); err == nil {
+			if n, err := strconv.Atoi(res.Header.Get("Upload-Offset")); err == nil {
+				offset = n
+			}
+		}
+	}
+
+	return strings.TrimPrefix(path, "/api/v1/uploads/"), nil
+}
+
+// doUpload makes a tus protocol request to the server, returning the response (whose body has been closed) if it was
+// successful and an [Error] if the server responded with one
+func (c *Client) doUpload(ctx context.Context, method string, path string, h http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return nil, responseError(method, path, res)
+	}
+
+	return res, nil
+}
+
+// do makes a request to the server, encoding the request body and decoding the response body as JSON
+func (c *Client) do(ctx context.Context, method string, path string, body any, out any) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, &buf)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return responseError(method, path, res)
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return fmt.Errorf("decoding response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// responseError decodes the [Error] an unsuccessful response was returned with
+func responseError(method string, path string, res *http.Response) error {
+	var e struct {
+		Error Error `json:"error"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&e); err != nil {
+		return fmt.Errorf("%s %s: unexpected status code %d", method, path, res.StatusCode)
+	}
+
+	e.Error.StatusCode = res.StatusCode
+	return &e.Error
+}
+
+// leadingZeroBits counts the number of zero bits at the start of a hash
+func leadingZeroBits(hash [sha256.Size]byte) int {
+	n := 0
+	for _, b := range hash {
+		n += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+
+	return n
+}
+
+// ParseSecretURL splits a secret's viewing URL (i.e. https://secret.mycompany.example/secret/{accessID}) into the
+// base URL of the server that hosts it and the secret's access identifier
+func ParseSecretURL(secretURL string) (string, string, error) {
+	u, err := url.Parse(secretURL)
+	if err != nil {
+		return "", "", fmt.Errorf("parsing url: %w", err)
+	}
+
+	prefix, accessID, ok := strings.Cut(u.Path, "/secret/")
+	if !ok || accessID == "" || strings.Contains(accessID, "/") || u.Scheme == "" || u.Host == "" {
+		return "", "", fmt.Errorf("%s is not a secret viewing url", secretURL)
+	}
+
+	return fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, prefix), accessID, nil
+}
+
+// KeyFromSecretURL returns the key in the fragment of a secret's viewing URL (i.e.
+// https://secret.mycompany.example/secret/{accessID}#k={key}), or an empty string if it does not contain one
+func KeyFromSecretURL(secretURL string) string {
+	u, err := url.Parse(secretURL)
+	if err != nil {
+		return ""
+	}
+
+	fragment, err := url.ParseQuery(u.Fragment)
+	if err != nil {
+		return ""
+	}
+
+	return fragment.Get("k")
+}
+
+// ParseInviteURL splits an invite link (i.e. https://secret.mycompany.example/invite/{token}) into the base URL of the
+// server that issued it and the invite's token
+func ParseInviteURL(inviteURL string) (string, string, error) {
+	u, err := url.Parse(inviteURL)
+	if err != nil {
+		return "", "", fmt.Errorf("parsing url: %w", err)
+	}
+
+	prefix, token, ok := strings.Cut(u.Path, "/invite/")
+	if !ok || token == "" || strings.Contains(token, "/") || u.Scheme == "" || u.Host == "" {
+		return "", "", fmt.Errorf("%s is not an invite url", inviteURL)
+	}
+
+	return fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, prefix), token, nil
+}
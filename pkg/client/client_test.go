@@ -0,0 +1,478 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/lsymds/shareasecret/internal/shareasecret"
+	"github.com/lsymds/shareasecret/pkg/secretcrypto"
+)
+
+func TestClient(t *testing.T) {
+	c := newTestClient(t, nil)
+	ctx := context.Background()
+
+	t.Run("sends and opens a secret", func(t *testing.T) {
+		created, err := c.SendSecret(ctx, []byte("a secret"), "a password", 30, 1)
+		if err != nil {
+			t.Fatalf("sending secret: %v", err)
+		}
+
+		baseURL, accessID, err := ParseSecretURL(created.ViewSecretURL)
+		if err != nil {
+			t.Fatalf("parsing secret url: %v", err)
+		} else if baseURL != c.BaseURL || accessID != created.AccessID {
+			t.Errorf("unexpected secret url %v", created.ViewSecretURL)
+		}
+
+		if pt, err := c.OpenSecret(ctx, accessID, "a password"); err != nil {
+			t.Errorf("opening secret: %v", err)
+		} else if string(pt) != "a secret" {
+			t.Errorf("wanted 'a secret', got %q", string(pt))
+		}
+
+		var e *Error
+		if _, err := c.OpenSecret(ctx, accessID, "a password"); !errors.As(err, &e) || e.StatusCode != 404 {
+			t.Errorf("expected a 404 error opening a secret with no views left, got %v", err)
+		}
+	})
+
+	t.Run("sends and opens a secret with its key in its url", func(t *testing.T) {
+		created, err := c.SendSecretWithKeyInURL(ctx, []byte("a secret"), 30, 1)
+		if err != nil {
+			t.Fatalf("sending secret: %v", err)
+		}
+
+		key := KeyFromSecretURL(created.ViewSecretURL)
+		if key == "" {
+			t.Fatalf("expected key in secret url %v", created.ViewSecretURL)
+		}
+
+		if _, accessID, err := ParseSecretURL(created.ViewSecretURL); err != nil || accessID != created.AccessID {
+			t.Errorf("unexpected secret url %v (%v)", created.ViewSecretURL, err)
+		}
+
+		if m, err := c.Secret(ctx, created.ManagementID); err != nil || !m.KeyInURL {
+			t.Errorf("expected metadata to report the key is in the url, got %+v %v", m, err)
+		}
+
+		if pt, err := c.OpenSecret(ctx, created.AccessID, key); err != nil {
+			t.Errorf("opening secret: %v", err)
+		} else if string(pt) != "a secret" {
+			t.Errorf("wanted 'a secret', got %q", string(pt))
+		}
+	})
+
+	t.Run("sends and opens a secret protected by a key verifier", func(t *testing.T) {
+		created, err := c.SendSecretWithVerifier(ctx, []byte("a secret"), "a password", 30, 2)
+		if err != nil {
+			t.Fatalf("sending secret: %v", err)
+		}
+
+		var e *Error
+		if _, err := c.OpenSecret(ctx, created.AccessID, "the wrong password"); !errors.As(err, &e) || e.Code != "incorrect_key" {
+			t.Errorf("expected incorrect_key error, got %v", err)
+		}
+
+		if pt, err := c.OpenSecret(ctx, created.AccessID, "a password"); err != nil {
+			t.Errorf("opening secret: %v", err)
+		} else if string(pt) != "a secret" {
+			t.Errorf("wanted 'a secret', got %q", string(pt))
+		}
+
+		if m, err := c.Secret(ctx, created.ManagementID); err != nil {
+			t.Errorf("retrieving metadata: %v", err)
+		} else if m.MaxKeyAttempts != 5 || m.FailedKeyAttempts != 1 || m.Views != 1 {
+			t.Errorf("unexpected metadata %+v", m)
+		}
+	})
+
+	t.Run("retrieves metadata for and deletes a secret", func(t *testing.T) {
+		encryptedSecret, err := secretcrypto.Encrypt([]byte("a secret"), "a password")
+		if err != nil {
+			t.Fatalf("encrypting: %v", err)
+		}
+
+		created, err := c.CreateSecret(ctx, CreateSecretRequest{EncryptedSecret: encryptedSecret, TTL: 60, MaxViews: 3})
+		if err != nil {
+			t.Fatalf("creating secret: %v", err)
+		}
+
+		if _, err := c.ViewSecret(ctx, created.AccessID); err != nil {
+			t.Fatalf("viewing secret: %v", err)
+		}
+
+		if m, err := c.Secret(ctx, created.ManagementID); err != nil {
+			t.Errorf("retrieving metadata: %v", err)
+		} else if m.AccessID != created.AccessID || m.TTL != 60 || m.MaxViews != 3 || m.Views != 1 {
+			t.Errorf("unexpected metadata %+v", m)
+		}
+
+		if err := c.DeleteSecret(ctx, created.ManagementID); err != nil {
+			t.Errorf("deleting secret: %v", err)
+		}
+
+		var e *Error
+		if _, err := c.Secret(ctx, created.ManagementID); !errors.As(err, &e) || e.Code != "not_found" {
+			t.Errorf("expected not_found error retrieving a deleted secret, got %v", err)
+		}
+	})
+
+	t.Run("returns validation errors", func(t *testing.T) {
+		var e *Error
+
+		_, err := c.CreateSecret(ctx, CreateSecretRequest{EncryptedSecret: "a", TTL: 60, MaxViews: 1})
+		if !errors.As(err, &e) || e.StatusCode != 400 || e.Code != "validation_failed" {
+			t.Errorf("expected validation_failed error, got %v", err)
+		}
+	})
+}
+
+func TestClientAttachments(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestClient(t, func(config *shareasecret.Configuration) {
+		config.Policy.MaxAttachments = 2
+		config.Attachments.Dir = dir
+	})
+	ctx := context.Background()
+
+	c.Attachments = []File{{Name: "kubeconfig", Content: []byte("apiVersion: v1")}, {Name: "keystore.p12", Content: []byte{0, 1, 2}}}
+	defer func() { c.Attachments = nil }()
+
+	t.Run("sends and opens attachments encrypted with the secret's key", func(t *testing.T) {
+		created, err := c.SendSecretWithVerifier(ctx, []byte("a secret"), "a password", 30, 1)
+		if err != nil {
+			t.Fatalf("sending secret: %v", err)
+		}
+
+		if m, err := c.Secret(ctx, created.ManagementID); err != nil || m.Attachments != 2 {
+			t.Errorf("expected metadata to report 2 attachments, got %+v %v", m, err)
+		}
+
+		if stored, _ := os.ReadDir(dir); len(stored) != 2 {
+			t.Errorf("expected 2 stored attachments, got %v", len(stored))
+		}
+
+		pt, files, err := c.OpenSecretWithAttachments(ctx, created.AccessID, "a password")
+		if err != nil {
+			t.Fatalf("opening secret: %v", err)
+		} else if string(pt) != "a secret" || len(files) != 2 {
+			t.Fatalf("unexpected secret %q with %d attachments", string(pt), len(files))
+		}
+
+		if files[0].Name != "kubeconfig" || string(files[0].Content) != "apiVersion: v1" || files[1].Name != "keystore.p12" {
+			t.Errorf("unexpected attachments %+v", files)
+		}
+
+		if stored, _ := os.ReadDir(dir); len(stored) != 0 {
+			t.Errorf("expected attachments to be destroyed after the final view, got %v", len(stored))
+		}
+	})
+
+	t.Run("sends attachments with a secret's key in its url", func(t *testing.T) {
+		created, err := c.SendSecretWithKeyInURL(ctx, []byte("a secret"), 30, 1)
+		if err != nil {
+			t.Fatalf("sending secret: %v", err)
+		}
+
+		if _, files, err := c.OpenSecretWithAttachments(ctx, created.AccessID, KeyFromSecretURL(created.ViewSecretURL)); err != nil {
+			t.Errorf("opening secret: %v", err)
+		} else if len(files) != 2 || files[0].Name != "kubeconfig" {
+			t.Errorf("unexpected attachments %+v", files)
+		}
+	})
+}
+
+func TestClientUploads(t *testing.T) {
+	c := newTestClient(t, func(config *shareasecret.Configuration) {
+		config.Policy.MaxAttachments = 1
+	})
+	ctx := context.Background()
+
+	flaky := &flakyTransport{}
+	c.HTTPClient.Transport = flaky
+	c.UploadChunkSize = 256
+	c.Attachments = []File{{Name: "keystore.p12", Content: bytes.Repeat([]byte{7}, 4096)}}
+
+	delay := uploadRetryDelay
+	uploadRetryDelay = time.Millisecond
+	defer func() { uploadRetryDelay = delay }()
+
+	t.Run("uploads large secrets in chunks, resuming those that fail", func(t *testing.T) {
+		created, err := c.SendSecret(ctx, []byte("a secret"), "a password", 30, 1)
+		if err != nil {
+			t.Fatalf("sending secret: %v", err)
+		}
+
+		if flaky.failures == 0 {
+			t.Errorf("expected some chunks to have failed")
+		}
+
+		pt, files, err := c.OpenSecretWithAttachments(ctx, created.AccessID, "a password")
+		if err != nil {
+			t.Fatalf("opening secret: %v", err)
+		} else if string(pt) != "a secret" || len(files) != 1 || !bytes.Equal(files[0].Content, c.Attachments[0].Content) {
+			t.Errorf("unexpected secret %q with %d attachments", string(pt), len(files))
+		}
+	})
+
+	t.Run("keeps chunks within the largest the server accepts", func(t *testing.T) {
+		c.UploadChunkSize = 6 << 20
+		c.Attachments = []File{{Name: "backup.tar", Content: bytes.Repeat([]byte{7}, 5<<20)}}
+		defer func() { c.UploadChunkSize = 256 }()
+
+		if _, err := c.SendSecret(ctx, []byte("a secret"), "a password", 30, 1); err != nil {
+			t.Fatalf("sending secret: %v", err)
+		}
+	})
+
+	t.Run("sends small secrets in a single request", func(t *testing.T) {
+		c.Attachments = nil
+		patches := flaky.patches
+
+		if _, err := c.SendSecret(ctx, []byte("a secret"), "a password", 30, 1); err != nil {
+			t.Fatalf("sending secret: %v", err)
+		} else if flaky.patches != patches {
+			t.Errorf("expected secret not to be uploaded")
+		}
+	})
+}
+
+// flakyTransport drops the connection part of the way through every third chunk of an upload
+type flakyTransport struct {
+	patches  int
+	failures int
+}
+
+func (f *flakyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != "PATCH" {
+		return http.DefaultTransport.RoundTrip(r)
+	}
+
+	if f.patches++; f.patches%3 != 0 {
+		return http.DefaultTransport.RoundTrip(r)
+	}
+
+	f.failures++
+
+	body, _ := io.ReadAll(r.Body)
+	r = r.Clone(r.Context())
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body[:len(body)/2]), iotest.ErrReader(errors.New("connection reset"))))
+
+	if res, err := http.DefaultTransport.RoundTrip(r); err == nil {
+		res.Body.Close()
+	}
+
+	return nil, errors.New("connection reset")
+}
+
+func TestClientProofOfWork(t *testing.T) {
+	c := newTestClient(t, func(config *shareasecret.Configuration) {
+		config.ProofOfWork.Difficulty = 8
+		config.ProofOfWork.MaxDifficulty = 8
+		config.ProofOfWork.ChallengeTTL = time.Minute
+	})
+	ctx := context.Background()
+
+	t.Run("solves a challenge when the server requires one", func(t *testing.T) {
+		if _, err := c.SendSecret(ctx, []byte("a secret"), "a password", 30, 1); err != nil {
+			t.Errorf("sending secret: %v", err)
+		}
+	})
+
+	t.Run("does not reuse solutions", func(t *testing.T) {
+		challenge, err := c.Challenge(ctx)
+		if err != nil {
+			t.Fatalf("retrieving challenge: %v", err)
+		}
+
+		pow, err := challenge.Solve(ctx)
+		if err != nil {
+			t.Fatalf("solving challenge: %v", err)
+		}
+
+		encryptedSecret, err := secretcrypto.Encrypt([]byte("a secret"), "a password")
+		if err != nil {
+			t.Fatalf("encrypting secret: %v", err)
+		}
+
+		req := CreateSecretRequest{EncryptedSecret: encryptedSecret, TTL: 30, MaxViews: 1, ProofOfWork: pow}
+		if _, err := c.CreateSecret(ctx, req); err != nil {
+			t.Fatalf("creating secret: %v", err)
+		}
+
+		var e *Error
+		if _, err := c.CreateSecret(ctx, req); !errors.As(err, &e) || e.Code != "proof_of_work_failed" {
+			t.Errorf("expected proof_of_work_failed error reusing a solution, got %v", err)
+		}
+	})
+}
+
+func TestClientAPITokens(t *testing.T) {
+	_, nw, _ := net.ParseCIDR("192.0.2.0/24")
+
+	// only an unrelated network can create secrets, so the token is the only way the client is permitted to
+	c, a := newTestServer(t, func(config *shareasecret.Configuration) {
+		config.SecretCreationRestrictions.IPAddresses.CIDRs = []net.IPNet{*nw}
+	})
+	ctx := context.Background()
+
+	t.Run("forbidden without a token", func(t *testing.T) {
+		var e *Error
+		if _, err := c.SendSecret(ctx, []byte("a secret"), "a password", 30, 1); !errors.As(err, &e) || e.Code != "forbidden" {
+			t.Errorf("expected forbidden error, got %v", err)
+		}
+	})
+
+	t.Run("creates, lists and deletes secrets with a token", func(t *testing.T) {
+		_, token, err := a.CreateAPIToken(
+			"ci",
+			[]string{shareasecret.APITokenScopeCreate, shareasecret.APITokenScopeRead, shareasecret.APITokenScopeManage},
+			time.Hour,
+		)
+		if err != nil {
+			t.Fatalf("creating token: %v", err)
+		}
+
+		c.Token = token
+		defer func() { c.Token = "" }()
+
+		created, err := c.SendSecret(ctx, []byte("a secret"), "a password", 30, 1)
+		if err != nil {
+			t.Fatalf("sending secret: %v", err)
+		}
+
+		secrets, err := c.Secrets(ctx)
+		if err != nil {
+			t.Fatalf("listing secrets: %v", err)
+		} else if len(secrets) != 1 || secrets[0].AccessID != created.AccessID {
+			t.Errorf("expected created secret to be listed, got %+v", secrets)
+		}
+
+		if err := c.DeleteSecretByAccessID(ctx, created.AccessID); err != nil {
+			t.Errorf("deleting secret: %v", err)
+		}
+
+		deleted, _, err := c.SecretsPage(ctx, "deleted", 1)
+		if err != nil {
+			t.Fatalf("listing deleted secrets: %v", err)
+		} else if len(deleted) != 1 || deleted[0].Status != "deleted" || deleted[0].DeletionReason != "user_deleted" {
+			t.Errorf("expected deleted secret to be listed, got %+v", deleted)
+		}
+	})
+
+	t.Run("bulk deletes secrets with a token", func(t *testing.T) {
+		_, token, err := a.CreateAPIToken(
+			"incident",
+			[]string{shareasecret.APITokenScopeCreate, shareasecret.APITokenScopeManage},
+			time.Hour,
+		)
+		if err != nil {
+			t.Fatalf("creating token: %v", err)
+		}
+
+		c.Token = token
+		defer func() { c.Token = "" }()
+
+		ids := []string{}
+		for i := 0; i < 3; i++ {
+			created, err := c.SendSecret(ctx, []byte("a secret"), "a password", 30, 1)
+			if err != nil {
+				t.Fatalf("sending secret: %v", err)
+			}
+
+			ids = append(ids, created.AccessID)
+		}
+
+		if n, err := c.DeleteSecrets(ctx, ids[:1]); err != nil || n != 1 {
+			t.Errorf("expected one secret to be deleted, got %v %v", n, err)
+		}
+
+		if n, err := c.DeleteAllSecrets(ctx); err != nil || n != 2 {
+			t.Errorf("expected remaining secrets to be deleted, got %v %v", n, err)
+		}
+	})
+}
+
+func TestParseSecretURL(t *testing.T) {
+	t.Run("splits valid urls", func(t *testing.T) {
+		cases := map[string][2]string{
+			"https://secret.mycompany.example/secret/abc":         {"https://secret.mycompany.example", "abc"},
+			"http://127.0.0.1:8994/secret/abc":                    {"http://127.0.0.1:8994", "abc"},
+			"https://mycompany.example/shareasecret/secret/abc12": {"https://mycompany.example/shareasecret", "abc12"},
+		}
+
+		for u, want := range cases {
+			baseURL, accessID, err := ParseSecretURL(u)
+			if err != nil {
+				t.Errorf("parsing %v: %v", u, err)
+			} else if baseURL != want[0] || accessID != want[1] {
+				t.Errorf("wanted %v, got [%v %v]", want, baseURL, accessID)
+			}
+		}
+	})
+
+	t.Run("rejects invalid urls", func(t *testing.T) {
+		for _, u := range []string{"abc", "https://example.com/", "https://example.com/secret/", "https://example.com/secret/a/b"} {
+			if _, _, err := ParseSecretURL(u); err == nil {
+				t.Errorf("expected %v to be rejected", u)
+			}
+		}
+	})
+}
+
+func TestParseInviteURL(t *testing.T) {
+	baseURL, token, err := ParseInviteURL("https://secret.mycompany.example/invite/abc.123.def")
+	if err != nil {
+		t.Errorf("parsing url: %v", err)
+	} else if baseURL != "https://secret.mycompany.example" || token != "abc.123.def" {
+		t.Errorf("unexpected base url %v and token %v", baseURL, token)
+	}
+
+	for _, u := range []string{"abc", "https://example.com/secret/abc", "https://example.com/invite/"} {
+		if _, _, err := ParseInviteURL(u); err == nil {
+			t.Errorf("expected %v to be rejected", u)
+		}
+	}
+}
+
+// newTestClient boots a shareasecret server backed by a temporary database and returns a client for it. The
+// configuration can optionally be modified before the server is booted.
+func newTestClient(t *testing.T, configure func(config *shareasecret.Configuration)) *Client {
+	c, _ := newTestServer(t, configure)
+
+	return c
+}
+
+// newTestServer is [newTestClient], but also returns the application serving requests
+func newTestServer(t *testing.T, configure func(config *shareasecret.Configuration)) (*Client, *shareasecret.Application) {
+	srv := httptest.NewUnstartedServer(nil)
+
+	config := &shareasecret.Configuration{}
+	config.Database.Path = filepath.Join(t.TempDir(), "shareasecret_test.db")
+	config.Server.BaseUrl = "http://" + srv.Listener.Addr().String()
+	if configure != nil {
+		configure(config)
+	}
+
+	a, err := shareasecret.NewApplication(config, os.DirFS("../../web/"))
+	if err != nil {
+		t.Fatalf("creating application: %v", err)
+	}
+
+	srv.Config.Handler = a
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	return New(srv.URL), a
+}
@@ -0,0 +1,439 @@
+// Package secretcrypto encrypts and decrypts secrets in the format used by shareasecret.
+//
+// An encrypted secret is an envelope of five dot separated parts: v2.kdf.ciphertext.salt.iv. The version (v2) identifies
+// the format, kdf describes how the encryption key was derived from a password (i.e. pbkdf2-sha256-600000) and the
+// remaining parts are unpadded base64url encoded. The plaintext is encrypted with AES-256-GCM using a key derived from
+// a password with PBKDF2-HMAC-SHA256. Carrying the iteration count in the envelope means it can be raised without
+// breaking secrets that were encrypted before.
+//
+// Secrets can instead be encrypted with a randomly generated key (see [GenerateKey] and [EncryptWithKey]), which is
+// shared in the fragment of the secret's viewing URL rather than as a separate password. The kdf part of their envelope
+// is none, and their salt is unused.
+//
+// A secret can also be accompanied by a verifier (see [EncryptWithVerifier] and [Verifier]): an HMAC-SHA256 of a fixed
+// label keyed with the encryption key. It proves knowledge of the key without revealing it, so a server can limit the
+// number of wrong keys tried before releasing the ciphertext.
+//
+// Files can be attached to a secret (see [DeriveKey] and [Key.EncryptAttachment]). Each is encrypted with the secret's
+// key: its name as an envelope that shares the secret's kdf and salt, and its content as the iv followed by the
+// ciphertext, which is left unencoded so that it can be stored as is.
+//
+// Secrets encrypted before the envelope was versioned consist of three standard base64 encoded parts
+// (ciphertext.salt.iv) and were derived with 600,000 iterations. They can still be parsed and decrypted.
+//
+// The format is identical to that produced by the web interface, so secrets encrypted with this package can be
+// decrypted in a browser and vice versa.
+package secretcrypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Version prefixes every encrypted secret in the versioned envelope format
+	Version = "v2"
+
+	// Iterations is the number of PBKDF2 iterations used to derive an encryption key from a password when encrypting
+	Iterations = 600000
+
+	// LegacyIterations is the number of PBKDF2 iterations used by secrets encrypted before the envelope was versioned
+	LegacyIterations = 600000
+
+	// MinIterations and MaxIterations bound the PBKDF2 iterations an envelope can specify, so that secrets cannot be
+	// encrypted with a trivially weak key or one that would take an unreasonable time to derive when decrypting
+	MinIterations = 100000
+	MaxIterations = 10000000
+
+	// SaltSize is the size in bytes of the salt passed to PBKDF2
+	SaltSize = 16
+
+	// IVSize is the size in bytes of the AES-GCM initialization vector
+	IVSize = 12
+
+	// TagSize is the size in bytes of the AES-GCM authentication tag, which every ciphertext is at least as long as
+	TagSize = 16
+
+	// KeySize is the size in bytes of the derived AES-256 key
+	KeySize = 32
+
+	// VerifierSize is the size in bytes of a key verifier
+	VerifierSize = 32
+
+	// AttachmentOverhead is the number of bytes the encrypted content of an attachment is larger than its plaintext:
+	// the iv that prefixes it and the authentication tag
+	AttachmentOverhead = IVSize + TagSize
+)
+
+// verifierLabel is the message a key verifier is the HMAC of
+const verifierLabel = "shareasecret key verifier"
+
+// kdfPrefix prefixes the iteration count in the kdf part of an envelope
+const kdfPrefix = "pbkdf2-sha256-"
+
+// KDFNone is the kdf part of an envelope encrypted with a generated key rather than one derived from a password
+const KDFNone = "none"
+
+var (
+	// ErrMalformed is returned when an encrypted secret is not a valid envelope in either the versioned or legacy format
+	ErrMalformed = errors.New("encrypted secret is not in the v2.kdf.ciphertext.salt.iv or ciphertext.salt.iv format")
+
+	// ErrInvalidKey is returned when a generated key is not a base64url encoded 256 bit key
+	ErrInvalidKey = errors.New("key is not a generated 256 bit key")
+
+	// ErrMalformedAttachment is returned when an encrypted attachment was not encrypted with the key of the secret it
+	// accompanies, or its content is too short to have been encrypted at all
+	ErrMalformedAttachment = errors.New("attachment is not encrypted with the secret's key")
+
+	// ErrDecryptionFailed is returned when an encrypted secret cannot be decrypted, usually because the password is
+	// incorrect
+	ErrDecryptionFailed = errors.New("unable to decrypt secret (is the encryption key correct?)")
+)
+
+// Envelope is an encrypted secret decoded into its parts
+type Envelope struct {
+	// Iterations is the number of PBKDF2 iterations the key was derived with, or 0 if the secret was encrypted with a
+	// generated key
+	Iterations int
+	CipherText []byte
+	Salt       []byte
+	IV         []byte
+}
+
+// Parse decodes an encrypted secret in either the versioned or legacy format, returning [ErrMalformed] if any of its
+// parts are invalid
+func Parse(encryptedSecret string) (Envelope, error) {
+	parts := strings.Split(encryptedSecret, ".")
+
+	var e Envelope
+	var err error
+
+	switch {
+	case len(parts) == 5 && parts[0] == Version:
+		if parts[1] != KDFNone {
+			iterations, ok := strings.CutPrefix(parts[1], kdfPrefix)
+			if !ok {
+				return Envelope{}, ErrMalformed
+			}
+
+			if e.Iterations, err = strconv.Atoi(iterations); err != nil || e.Iterations < MinIterations || e.Iterations > MaxIterations {
+				return Envelope{}, ErrMalformed
+			}
+		}
+
+		err = decodeParts(base64.RawURLEncoding, parts[2:], &e.CipherText, &e.Salt, &e.IV)
+	case len(parts) == 3:
+		e.Iterations = LegacyIterations
+		err = decodeParts(base64.StdEncoding, parts, &e.CipherText, &e.Salt, &e.IV)
+	default:
+		return Envelope{}, ErrMalformed
+	}
+
+	if err != nil || len(e.Salt) != SaltSize || len(e.IV) != IVSize || len(e.CipherText) < TagSize {
+		return Envelope{}, ErrMalformed
+	}
+
+	return e, nil
+}
+
+// KeyInURL identifies whether the secret was encrypted with a generated key, which is shared in its viewing URL
+func (e Envelope) KeyInURL() bool {
+	return e.Iterations == 0
+}
+
+// String encodes the envelope in the versioned format
+func (e Envelope) String() string {
+	kdf := KDFNone
+	if !e.KeyInURL() {
+		kdf = kdfPrefix + strconv.Itoa(e.Iterations)
+	}
+
+	return strings.Join(
+		[]string{
+			Version,
+			kdf,
+			base64.RawURLEncoding.EncodeToString(e.CipherText),
+			base64.RawURLEncoding.EncodeToString(e.Salt),
+			base64.RawURLEncoding.EncodeToString(e.IV),
+		},
+		".",
+	)
+}
+
+// SharesKey identifies whether both envelopes were encrypted with keys derived with the same kdf and salt, and so (given
+// the same password) with the same key
+func (e Envelope) SharesKey(o Envelope) bool {
+	return e.Iterations == o.Iterations && hmac.Equal(e.Salt, o.Salt)
+}
+
+// Encrypt encrypts the plaintext with a key derived from the password, returning it in the versioned envelope format
+func Encrypt(plainText []byte, password string) (string, error) {
+	return encrypt(rand.Reader, plainText, password, Iterations)
+}
+
+// EncryptWithVerifier is [Encrypt], but also returns the verifier of the key the plaintext was encrypted with
+func EncryptWithVerifier(plainText []byte, password string) (string, string, error) {
+	encryptedSecret, err := Encrypt(plainText, password)
+	if err != nil {
+		return "", "", err
+	}
+
+	e, err := Parse(encryptedSecret)
+	if err != nil {
+		return "", "", err
+	}
+
+	verifier, err := Verifier(e, password)
+	if err != nil {
+		return "", "", err
+	}
+
+	return encryptedSecret, verifier, nil
+}
+
+// Verifier derives the key for the envelope from the password (only its iterations and salt are required) and returns
+// its verifier, unpadded base64url encoded
+func Verifier(e Envelope, password string) (string, error) {
+	key, err := deriveKey(password, e.Salt, e.Iterations)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(verifierLabel))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// GenerateKey generates a random key for use with [EncryptWithKey], returning it unpadded base64url encoded so that it
+// can be placed in a URL
+func GenerateKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("generating key: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// EncryptWithKey encrypts the plaintext with a key returned by [GenerateKey], returning it in the versioned envelope
+// format
+func EncryptWithKey(plainText []byte, key string) (string, error) {
+	return encrypt(rand.Reader, plainText, key, 0)
+}
+
+// Decrypt decrypts a secret in either the versioned or legacy format with a key derived from the password or, if the
+// secret was encrypted with a generated key, with that key
+func Decrypt(encryptedSecret string, password string) ([]byte, error) {
+	e, err := Parse(encryptedSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(password, e.Salt, e.Iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	plainText, err := gcm.Open(nil, e.IV, e.CipherText, nil)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	return plainText, nil
+}
+
+// Key is the encryption key of a secret, which encrypts and decrypts the files attached to it
+type Key struct {
+	envelope Envelope
+	gcm      cipher.AEAD
+}
+
+// DeriveKey derives the encryption key of the encrypted secret from the password (or, if the secret was encrypted with
+// a generated key, uses that key). The password is not checked against the secret.
+func DeriveKey(encryptedSecret string, password string) (*Key, error) {
+	e, err := Parse(encryptedSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(password, e.Salt, e.Iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{envelope: Envelope{Iterations: e.Iterations, Salt: e.Salt}, gcm: gcm}, nil
+}
+
+// EncryptAttachment encrypts the name and content of a file, returning the name as an envelope and the content as its
+// iv followed by its ciphertext
+func (k *Key) EncryptAttachment(name string, content []byte) (string, []byte, error) {
+	return k.encryptAttachment(rand.Reader, name, content)
+}
+
+// DecryptAttachment decrypts the name and content of a file encrypted by [Key.EncryptAttachment]
+func (k *Key) DecryptAttachment(encryptedName string, encryptedContent []byte) (string, []byte, error) {
+	e, err := ParseAttachment(k.envelope, encryptedName, encryptedContent)
+	if err != nil {
+		return "", nil, err
+	}
+
+	name, err := k.gcm.Open(nil, e.IV, e.CipherText, nil)
+	if err != nil {
+		return "", nil, ErrDecryptionFailed
+	}
+
+	content, err := k.gcm.Open(nil, encryptedContent[:IVSize], encryptedContent[IVSize:], nil)
+	if err != nil {
+		return "", nil, ErrDecryptionFailed
+	}
+
+	return string(name), content, nil
+}
+
+// ParseAttachment ensures an encrypted attachment was encrypted with the key of the secret whose envelope is given,
+// returning the envelope of its name or [ErrMalformedAttachment] if not
+func ParseAttachment(secret Envelope, encryptedName string, encryptedContent []byte) (Envelope, error) {
+	e, err := Parse(encryptedName)
+	if err != nil || !e.SharesKey(secret) || len(encryptedContent) < AttachmentOverhead {
+		return Envelope{}, ErrMalformedAttachment
+	}
+
+	return e, nil
+}
+
+// encryptAttachment encrypts the name and content of a file, sourcing their ivs from the random reader
+func (k *Key) encryptAttachment(random io.Reader, name string, content []byte) (string, []byte, error) {
+	e := Envelope{Iterations: k.envelope.Iterations, Salt: k.envelope.Salt, IV: make([]byte, IVSize)}
+	if _, err := io.ReadFull(random, e.IV); err != nil {
+		return "", nil, fmt.Errorf("generating iv: %w", err)
+	}
+
+	e.CipherText = k.gcm.Seal(nil, e.IV, []byte(name), nil)
+
+	iv := make([]byte, IVSize, IVSize+len(content)+TagSize)
+	if _, err := io.ReadFull(random, iv); err != nil {
+		return "", nil, fmt.Errorf("generating iv: %w", err)
+	}
+
+	return e.String(), k.gcm.Seal(iv, iv, content, nil), nil
+}
+
+// encrypt encrypts the plaintext, sourcing the salt and iv from the random reader. The password is a generated key if
+// iterations is 0.
+func encrypt(random io.Reader, plainText []byte, password string, iterations int) (string, error) {
+	e := Envelope{Iterations: iterations, Salt: make([]byte, SaltSize), IV: make([]byte, IVSize)}
+
+	if _, err := io.ReadFull(random, e.Salt); err != nil {
+		return "", fmt.Errorf("generating salt: %w", err)
+	}
+
+	if _, err := io.ReadFull(random, e.IV); err != nil {
+		return "", fmt.Errorf("generating iv: %w", err)
+	}
+
+	gcm, err := newGCM(password, e.Salt, e.Iterations)
+	if err != nil {
+		return "", err
+	}
+
+	e.CipherText = gcm.Seal(nil, e.IV, plainText, nil)
+
+	return e.String(), nil
+}
+
+// decodeParts decodes each of the parts with the encoding into the corresponding destination
+func decodeParts(enc *base64.Encoding, parts []string, dst ...*[]byte) error {
+	for i, p := range parts {
+		b, err := enc.Strict().DecodeString(p)
+		if err != nil {
+			return err
+		}
+
+		*dst[i] = b
+	}
+
+	return nil
+}
+
+// newGCM derives an AES-256 key from the password and salt and wraps it in the GCM block cipher mode
+func newGCM(password string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := deriveKey(password, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating gcm: %w", err)
+	}
+
+	return gcm, nil
+}
+
+// deriveKey derives an AES-256 key from the password and salt. If iterations is 0 the password is instead a generated
+// key, which is used as is.
+func deriveKey(password string, salt []byte, iterations int) ([]byte, error) {
+	if iterations == 0 {
+		key, err := base64.RawURLEncoding.Strict().DecodeString(password)
+		if err != nil || len(key) != KeySize {
+			return nil, ErrInvalidKey
+		}
+
+		return key, nil
+	}
+
+	return pbkdf2SHA256([]byte(password), salt, iterations, KeySize), nil
+}
+
+// pbkdf2SHA256 derives a key of keyLen bytes from the password and salt as per RFC 8018 using HMAC-SHA256 as the
+// pseudorandom function
+func pbkdf2SHA256(password []byte, salt []byte, iterations int, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+
+	var counter [4]byte
+	key := make([]byte, 0, blocks*hashLen)
+	u := make([]byte, hashLen)
+
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		u = prf.Sum(u[:0])
+
+		t := make([]byte, hashLen)
+		copy(t, u)
+
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+
+		key = append(key, t...)
+	}
+
+	return key[:keyLen]
+}
@@ -0,0 +1,338 @@
+package secretcrypto
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// browserVectors were produced by the encrypt function in web/js/core.mjs with a random source that returns 0, 1, 2...
+// so that the salt and iv are known
+var browserVectors = []struct {
+	plainText       string
+	password        string
+	encryptedSecret string
+}{
+	{
+		"correct horse battery staple",
+		"hunter2",
+		"v2.pbkdf2-sha256-600000.tPj9M7wyUAO5nEhy6nHS-yx2fpidBnlj73KRk5Iz4dRgxVXWTjZJMQc4ZXM.AAECAwQFBgcICQoLDA0ODw.EBESExQVFhcYGRob",
+	},
+	{
+		"",
+		"empty plaintext",
+		"v2.pbkdf2-sha256-600000.yjq9raoOFbzI29ZPE2XGYQ.AAECAwQFBgcICQoLDA0ODw.EBESExQVFhcYGRob",
+	},
+}
+
+// legacyBrowserVectors were produced by the encrypt function in web/js/core.mjs before the envelope was versioned
+var legacyBrowserVectors = []struct {
+	plainText       string
+	password        string
+	encryptedSecret string
+}{
+	{
+		"correct horse battery staple",
+		"hunter2",
+		"tPj9M7wyUAO5nEhy6nHS+yx2fpidBnlj73KRk5Iz4dRgxVXWTjZJMQc4ZXM=.AAECAwQFBgcICQoLDA0ODw==.EBESExQVFhcYGRob",
+	},
+	{
+		"correct horse battery staple",
+		"hunter2",
+		"xPkCpv5jhCZH36VOk3A71ZOGMksK2zQAiY4q8YlSBOkPl/wHjtGyDxIfzjg=.xLvwiU4pUla8OjMhezSrnw==.0jvATybHu2zOz1P9",
+	},
+	{
+		"multi\nline ✓ secret",
+		"🔑 unicode key",
+		"H269NB8CF3PVzacL++Ezh12twD4t+NHpaVeh68vjJqj52xn3pg==.pTxIa7Jybl5QykyOVwh6zg==.gh0wIQ8iuCvcziwe",
+	},
+}
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 7914, section 11
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+
+	if got := hex.EncodeToString(pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1, 64)); got != want {
+		t.Errorf("wanted %v, got %v", want, got)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	t.Run("produces identical output to the browser", func(t *testing.T) {
+		for _, v := range browserVectors {
+			got, err := encrypt(&sequentialReader{}, []byte(v.plainText), v.password, Iterations)
+			if err != nil {
+				t.Errorf("encrypting: %v", err)
+			} else if got != v.encryptedSecret {
+				t.Errorf("wanted %v, got %v", v.encryptedSecret, got)
+			}
+		}
+	})
+
+	t.Run("encrypted secrets can be decrypted", func(t *testing.T) {
+		encryptedSecret, err := Encrypt([]byte("a secret"), "a password")
+		if err != nil {
+			t.Fatalf("encrypting: %v", err)
+		}
+
+		if !strings.HasPrefix(encryptedSecret, "v2.pbkdf2-sha256-600000.") || strings.Count(encryptedSecret, ".") != 4 {
+			t.Errorf("expected v2.kdf.ciphertext.salt.iv format, got %v", encryptedSecret)
+		}
+
+		if pt, err := Decrypt(encryptedSecret, "a password"); err != nil {
+			t.Errorf("decrypting: %v", err)
+		} else if string(pt) != "a secret" {
+			t.Errorf("wanted 'a secret', got %q", string(pt))
+		}
+	})
+}
+
+func TestEncryptWithKey(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	encryptedSecret, err := EncryptWithKey([]byte("a secret"), key)
+	if err != nil {
+		t.Fatalf("encrypting: %v", err)
+	}
+
+	if e, err := Parse(encryptedSecret); err != nil || !e.KeyInURL() || !strings.HasPrefix(encryptedSecret, "v2.none.") {
+		t.Errorf("expected an envelope without a kdf, got %v: %v", encryptedSecret, err)
+	}
+
+	if pt, err := Decrypt(encryptedSecret, key); err != nil {
+		t.Errorf("decrypting: %v", err)
+	} else if string(pt) != "a secret" {
+		t.Errorf("wanted 'a secret', got %q", string(pt))
+	}
+
+	otherKey, _ := GenerateKey()
+	if _, err := Decrypt(encryptedSecret, otherKey); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("expected ErrDecryptionFailed, got %v", err)
+	}
+
+	for _, k := range []string{"a password", key[:20], key + "=="} {
+		if _, err := Decrypt(encryptedSecret, k); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("expected %v to be rejected with ErrInvalidKey, got %v", k, err)
+		}
+
+		if _, err := EncryptWithKey([]byte("a secret"), k); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("expected %v to be rejected with ErrInvalidKey, got %v", k, err)
+		}
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	t.Run("produces identical output to the browser", func(t *testing.T) {
+		// produced by the encryptWithVerifier function in web/js/core.mjs alongside the first of the browserVectors
+		want := "MYo6oJ61dWEhm_-B1dstuKNMblDSn-CsxOdGNvRoo6U"
+
+		e, err := Parse(browserVectors[0].encryptedSecret)
+		if err != nil {
+			t.Fatalf("parsing: %v", err)
+		}
+
+		if got, err := Verifier(e, browserVectors[0].password); err != nil {
+			t.Errorf("deriving verifier: %v", err)
+		} else if got != want {
+			t.Errorf("wanted %v, got %v", want, got)
+		}
+	})
+
+	t.Run("differs by password", func(t *testing.T) {
+		encryptedSecret, verifier, err := EncryptWithVerifier([]byte("a secret"), "a password")
+		if err != nil {
+			t.Fatalf("encrypting: %v", err)
+		}
+
+		e, _ := Parse(encryptedSecret)
+
+		if got, _ := Verifier(e, "a password"); got != verifier {
+			t.Errorf("expected verifier %v to be derived again, got %v", verifier, got)
+		}
+
+		if got, _ := Verifier(e, "another password"); got == verifier {
+			t.Errorf("expected a different password to produce a different verifier")
+		}
+	})
+}
+
+func TestAttachments(t *testing.T) {
+	t.Run("produces identical output to the browser", func(t *testing.T) {
+		// produced by the encryptAttachments function in web/js/core.mjs after the first of the browserVectors, which
+		// consumed the first 28 random bytes
+		wantName := "v2.pbkdf2-sha256-600000._r29BJoXUY_1PYuWLYpyPKdhSC-PkXlSW1o.AAECAwQFBgcICQoLDA0ODw.HB0eHyAhIiMkJSYn"
+		wantContent := "KCkqKywtLi8wMTIzyuhSOPwHc3l-XmFNHkyr8TGD5pocDkkzZLhABId_"
+
+		k, err := DeriveKey(browserVectors[0].encryptedSecret, browserVectors[0].password)
+		if err != nil {
+			t.Fatalf("deriving key: %v", err)
+		}
+
+		name, content, err := k.encryptAttachment(&sequentialReader{next: 28}, "kubeconfig", []byte("apiVersion: v1"))
+		if err != nil {
+			t.Fatalf("encrypting: %v", err)
+		} else if name != wantName || base64.RawURLEncoding.EncodeToString(content) != wantContent {
+			t.Errorf("wanted %v and %v, got %v and %v", wantName, wantContent, name, base64.RawURLEncoding.EncodeToString(content))
+		}
+	})
+
+	t.Run("round trips with the secret's key", func(t *testing.T) {
+		key, _ := GenerateKey()
+		encryptedSecret, _ := EncryptWithKey([]byte("a secret"), key)
+
+		k, err := DeriveKey(encryptedSecret, key)
+		if err != nil {
+			t.Fatalf("deriving key: %v", err)
+		}
+
+		encryptedName, encryptedContent, err := k.EncryptAttachment("keystore.p12", []byte{0, 1, 2, 255})
+		if err != nil {
+			t.Fatalf("encrypting: %v", err)
+		} else if len(encryptedContent) != 4+AttachmentOverhead {
+			t.Errorf("expected content to be %d bytes, got %d", 4+AttachmentOverhead, len(encryptedContent))
+		}
+
+		name, content, err := k.DecryptAttachment(encryptedName, encryptedContent)
+		if err != nil {
+			t.Fatalf("decrypting: %v", err)
+		} else if name != "keystore.p12" || hex.EncodeToString(content) != "000102ff" {
+			t.Errorf("unexpected attachment %v %x", name, content)
+		}
+	})
+
+	t.Run("rejects attachments encrypted with another key", func(t *testing.T) {
+		k, _ := DeriveKey(browserVectors[0].encryptedSecret, browserVectors[0].password)
+		other, _ := DeriveKey(browserVectors[0].encryptedSecret, "another password")
+		encryptedName, encryptedContent, _ := k.EncryptAttachment("kubeconfig", []byte("apiVersion: v1"))
+
+		if _, _, err := other.DecryptAttachment(encryptedName, encryptedContent); !errors.Is(err, ErrDecryptionFailed) {
+			t.Errorf("expected ErrDecryptionFailed, got %v", err)
+		}
+
+		e, _ := Parse(browserVectors[1].encryptedSecret)
+		e.Salt = make([]byte, SaltSize)
+
+		if _, err := ParseAttachment(e, encryptedName, encryptedContent); !errors.Is(err, ErrMalformedAttachment) {
+			t.Errorf("expected ErrMalformedAttachment for a different salt, got %v", err)
+		}
+
+		if _, err := ParseAttachment(k.envelope, encryptedName, encryptedContent[:AttachmentOverhead-1]); !errors.Is(err, ErrMalformedAttachment) {
+			t.Errorf("expected ErrMalformedAttachment for truncated content, got %v", err)
+		}
+	})
+}
+
+func TestDecrypt(t *testing.T) {
+	t.Run("decrypts secrets encrypted by the browser", func(t *testing.T) {
+		for _, v := range append(browserVectors, legacyBrowserVectors...) {
+			if pt, err := Decrypt(v.encryptedSecret, v.password); err != nil {
+				t.Errorf("decrypting: %v", err)
+			} else if string(pt) != v.plainText {
+				t.Errorf("wanted %q, got %q", v.plainText, string(pt))
+			}
+		}
+	})
+
+	t.Run("fails with the wrong password", func(t *testing.T) {
+		if _, err := Decrypt(browserVectors[0].encryptedSecret, "the wrong password"); !errors.Is(err, ErrDecryptionFailed) {
+			t.Errorf("expected ErrDecryptionFailed, got %v", err)
+		}
+	})
+
+	t.Run("decrypts secrets derived with other iteration counts", func(t *testing.T) {
+		e := Envelope{Iterations: MinIterations, Salt: make([]byte, SaltSize), IV: make([]byte, IVSize)}
+
+		gcm, err := newGCM("a password", e.Salt, e.Iterations)
+		if err != nil {
+			t.Fatalf("creating gcm: %v", err)
+		}
+
+		e.CipherText = gcm.Seal(nil, e.IV, []byte("a secret"), nil)
+
+		if pt, err := Decrypt(e.String(), "a password"); err != nil {
+			t.Errorf("decrypting: %v", err)
+		} else if string(pt) != "a secret" {
+			t.Errorf("wanted 'a secret', got %q", string(pt))
+		}
+
+		if _, err := Decrypt(e.String(), "a password"+"!"); !errors.Is(err, ErrDecryptionFailed) {
+			t.Errorf("expected ErrDecryptionFailed, got %v", err)
+		}
+	})
+
+	t.Run("rejects malformed secrets", func(t *testing.T) {
+		for _, s := range []string{"a.b", "a.b.c.d", "!!.AAAA.AAAA", "AAAA.AAAA.AAAA"} {
+			if _, err := Decrypt(s, "a password"); !errors.Is(err, ErrMalformed) {
+				t.Errorf("expected %v to be rejected with ErrMalformed, got %v", s, err)
+			}
+		}
+	})
+}
+
+func TestParse(t *testing.T) {
+	const (
+		cipherText = "yjq9raoOFbzI29ZPE2XGYQ"
+		salt       = "AAECAwQFBgcICQoLDA0ODw"
+		iv         = "EBESExQVFhcYGRob"
+	)
+
+	t.Run("parses versioned and legacy secrets", func(t *testing.T) {
+		e, err := Parse("v2.pbkdf2-sha256-750000." + cipherText + "." + salt + "." + iv)
+		if err != nil || e.Iterations != 750000 || len(e.CipherText) != TagSize || len(e.Salt) != SaltSize || len(e.IV) != IVSize {
+			t.Errorf("unexpected envelope %+v: %v", e, err)
+		}
+
+		e, err = Parse("v2.none." + cipherText + "." + salt + "." + iv)
+		if err != nil || !e.KeyInURL() {
+			t.Errorf("unexpected envelope %+v: %v", e, err)
+		}
+
+		e, err = Parse(legacyBrowserVectors[0].encryptedSecret)
+		if err != nil || e.Iterations != LegacyIterations {
+			t.Errorf("unexpected envelope %+v: %v", e, err)
+		}
+	})
+
+	t.Run("rejects malformed secrets", func(t *testing.T) {
+		cases := map[string]string{
+			"unknown version":     "v3.pbkdf2-sha256-600000." + cipherText + "." + salt + "." + iv,
+			"unknown kdf":         "v2.argon2id-600000." + cipherText + "." + salt + "." + iv,
+			"invalid iterations":  "v2.pbkdf2-sha256-lots." + cipherText + "." + salt + "." + iv,
+			"too few iterations":  "v2.pbkdf2-sha256-1000." + cipherText + "." + salt + "." + iv,
+			"too many iterations": "v2.pbkdf2-sha256-900000000." + cipherText + "." + salt + "." + iv,
+			"missing part":        "v2.pbkdf2-sha256-600000." + cipherText + "." + salt,
+			"padded part":         "v2.pbkdf2-sha256-600000." + cipherText + "==." + salt + "." + iv,
+			"standard alphabet":   "v2.pbkdf2-sha256-600000." + cipherText + "." + salt + "." + "EBESExQVFhcYGRo/",
+			"short salt":          "v2.pbkdf2-sha256-600000." + cipherText + ".AAECAwQFBgcICQoL." + iv,
+			"short iv":            "v2.pbkdf2-sha256-600000." + cipherText + "." + salt + ".EBESExQVFhcY",
+			"short ciphertext":    "v2.pbkdf2-sha256-600000.yjq9raoOFbzI29ZP." + salt + "." + iv,
+			"short legacy salt":   "yjq9raoOFbzI29ZPE2XGYQ==.AAECAwQFBgcICQoL.EBESExQVFhcYGRob",
+		}
+
+		for n, s := range cases {
+			if _, err := Parse(s); !errors.Is(err, ErrMalformed) {
+				t.Errorf("%v: expected ErrMalformed, got %v", n, err)
+			}
+		}
+	})
+}
+
+// sequentialReader is an [io.Reader] that returns the bytes 0, 1, 2... and so on
+type sequentialReader struct {
+	next byte
+}
+
+func (r *sequentialReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.next
+		r.next++
+	}
+
+	return len(p), nil
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"fmt"
+	"io"
 	"io/fs"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/lsymds/shareasecret/internal/cli"
 	"github.com/lsymds/shareasecret/internal/shareasecret"
 	"github.com/rs/zerolog/log"
 )
@@ -19,6 +25,16 @@ var embeddedWebAssets embed.FS
 var version string = "0.0.1"
 
 func main() {
+	// any recognised client command is executed instead of the server
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		if err := cli.Run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	log.Info().Str("version", version).Msg("starting shareasecret")
 
 	config := &shareasecret.Configuration{}
@@ -41,14 +57,81 @@ func main() {
 		os.Exit(1)
 	}
 
+	// API tokens, passkey accounts and organisations are managed against the server's database rather than served
+	management := map[string]func([]string, *shareasecret.Application, io.Writer, io.Writer) error{
+		"token":   cli.RunToken,
+		"account": cli.RunAccount,
+		"org":     cli.RunOrganisation,
+	}
+
+	if len(os.Args) > 1 && management[os.Args[1]] != nil {
+		err := management[os.Args[1]](os.Args[2:], application, os.Stdout, os.Stderr)
+		application.Close()
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	// stop serving requests and running jobs when asked to terminate
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// run any jobs
-	application.RunDeleteExpiredSecretsJob()
+	application.RunDeleteExpiredSecretsJob(ctx)
+	application.RunDeleteExpiredViewingKeysJob(ctx)
+	application.RunReloadTLSCertificateJob(ctx)
+	application.RunDeleteStaleRateLimitsJob(ctx)
+	application.RunDeleteSpentChallengesJob(ctx)
+	application.RunDeleteExpiredUploadsJob(ctx)
 
-	// serve all HTTP endpoints
-	log.Info().Str("addr", config.Server.ListeningAddr).Msg("booting HTTP server")
-	err = http.ListenAndServe(config.Server.ListeningAddr, application)
-	if err != nil {
+	// serve all HTTP endpoints, alongside a redirect to them if TLS is enabled
+	servers := []*http.Server{application.NewServer()}
+	if rs := application.NewRedirectServer(); rs != nil {
+		servers = append(servers, rs)
+	}
+
+	serverErr := make(chan error, len(servers))
+
+	for _, server := range servers {
+		go func(server *http.Server) {
+			if server.TLSConfig != nil {
+				log.Info().Str("addr", server.Addr).Msg("booting HTTPS server")
+				serverErr <- server.ListenAndServeTLS("", "")
+			} else {
+				log.Info().Str("addr", server.Addr).Msg("booting HTTP server")
+				serverErr <- server.ListenAndServe()
+			}
+		}(server)
+	}
+
+	select {
+	case err := <-serverErr:
 		log.Error().Err(err).Msg("listen and serve")
 		os.Exit(1)
+	case <-ctx.Done():
+		stop()
+	}
+
+	// drain any in-flight requests (such as secrets being viewed) before stopping the jobs and closing the database
+	log.Info().Dur("timeout", config.Server.ShutdownTimeout).Msg("shutting down HTTP servers")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownTimeout)
+	defer cancel()
+
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Str("addr", server.Addr).Msg("shutting down HTTP server")
+		}
+	}
+
+	if err := application.Close(); err != nil {
+		log.Error().Err(err).Msg("closing application")
+		os.Exit(1)
 	}
+
+	log.Info().Msg("stopped shareasecret")
 }
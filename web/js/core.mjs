@@ -1,30 +1,110 @@
+/**
+ * The version that prefixes every encrypted secret, identifying the format of the parts that follow.
+ */
+const ENVELOPE_VERSION = "v2";
+
+/**
+ * The number of PBKDF2 iterations used to derive the encryption key when encrypting. It is carried in each encrypted
+ * secret so that it can be raised without breaking secrets encrypted before.
+ */
+const KDF_ITERATIONS = 600000;
+
+/**
+ * The number of PBKDF2 iterations used by secrets encrypted before the envelope was versioned.
+ */
+const LEGACY_KDF_ITERATIONS = 600000;
+
+/**
+ * The key derivation parameters of an envelope encrypted with a generated key rather than a password.
+ */
+const KDF_NONE = "none";
+
+/**
+ * The message a key verifier is the HMAC of, keyed with the encryption key.
+ */
+const VERIFIER_LABEL = "shareasecret key verifier";
+
 /**
  * Encrypts provided plaintext via the WebCrypto API.
  * @param {string} plainText The text to be encrypted.
  * @param {string} password The password to use to encrypt the text.
- * @returns {Promise<string>} A string consisting of the encrypted secret, salt, and IV.
+ * @returns {Promise<string>} An envelope consisting of the version, key derivation parameters, encrypted secret, salt,
+ * and IV.
  */
 export async function encrypt(plainText, password) {
-	const enc = new TextEncoder();
-	const salt = window.crypto.getRandomValues(new Uint8Array(16));
-	const iv = window.crypto.getRandomValues(new Uint8Array(12));
-	const encryptionKey = await _keyFromPassword(password, salt, "encrypt");
+	return (await _encrypt(plainText, password, KDF_ITERATIONS)).encryptedSecret;
+}
 
-	const cipherText = await window.crypto.subtle.encrypt(
-		{ name: "AES-GCM", iv },
-		encryptionKey,
-		enc.encode(plainText)
+/**
+ * Encrypts provided plaintext via the WebCrypto API, also returning a verifier of the encryption key that the server
+ * can use to check viewers know the key before releasing the encrypted secret to them.
+ * @param {string} plainText The text to be encrypted.
+ * @param {string} password The password to use to encrypt the text.
+ * @returns {Promise<{encryptedSecret: string, verifier: string}>} The envelope (as returned by encrypt) and the
+ * base64url encoded verifier.
+ */
+export async function encryptWithVerifier(plainText, password) {
+	return await _encrypt(plainText, password, KDF_ITERATIONS);
+}
+
+/**
+ * Derives the encryption key of a secret protected by a key verifier from a password, along with its verifier.
+ * @param {string} password The plaintext password to derive the key from.
+ * @param {{salt: string, iterations: number}} challenge The base64url encoded salt and the PBKDF2 iterations of the
+ * secret, as issued by the server.
+ * @returns {Promise<{key: Uint8Array, verifier: string}>} The key, for use with decryptWithKey, and its verifier.
+ */
+export async function deriveKeyAndVerifier(password, challenge) {
+	const key = await _deriveKeyBytes(
+		password,
+		_base64URLStringToArray(challenge.salt),
+		challenge.iterations
+	);
+
+	return { key, verifier: await _verifierFromKeyBytes(key) };
+}
+
+/**
+ * Encrypts provided plaintext via the WebCrypto API with a randomly generated 256 bit key, which is intended to be
+ * shared in the fragment of the secret's viewing URL instead of a password.
+ * @param {string} plainText The text to be encrypted.
+ * @returns {Promise<{encryptedSecret: string, key: string}>} The envelope (as returned by encrypt) and the base64url
+ * encoded key.
+ */
+export async function encryptWithGeneratedKey(plainText) {
+	const key = _arrayToBase64URLString(
+		window.crypto.getRandomValues(new Uint8Array(32))
 	);
 
-	return `${_arrayToBase64String(
-		new Uint8Array(cipherText)
-	)}.${_arrayToBase64String(salt)}.${_arrayToBase64String(iv)}`;
+	return {
+		encryptedSecret: (await _encrypt(plainText, key, 0)).encryptedSecret,
+		key,
+	};
+}
+
+/**
+ * Reads the key of a secret encrypted with a generated key from the fragment of the page's URL (#k=...), removing it
+ * from the address bar and history so that it is not seen again.
+ * @returns {string|undefined} The key, if the URL contains one.
+ */
+export function takeKeyFromURLFragment() {
+	const key = new URLSearchParams(window.location.hash.slice(1)).get("k");
+	if (key) {
+		window.history.replaceState(
+			null,
+			"",
+			window.location.pathname + window.location.search
+		);
+	}
+
+	return key || undefined;
 }
 
 /**
  * Decrypts encrypted ciphertext with a given password.
- * @param {string} cipherText Encrypted ciphertext returned from the encrypt function.
- * @param {string} password The plaintext password to attempt to decrypt the ciphertext with.
+ * @param {string} cipherText Encrypted ciphertext returned from the encrypt or encryptWithGeneratedKey functions, or in
+ * the unversioned ciphertext.salt.iv format encrypt returned previously.
+ * @param {string} password The plaintext password (or generated key) to attempt to decrypt the ciphertext with.
  * @returns {Promise<string>} The decrypted text.
  */
 export async function decrypt(cipherText, password) {
@@ -32,27 +112,284 @@ export async function decrypt(cipherText, password) {
 		return;
 	}
 
-	const encryptionComponents = cipherText.split(".");
-	if (encryptionComponents.length !== 3) {
+	const envelope = _parseEnvelope(cipherText);
+	if (!envelope) {
 		return;
 	}
 
-	const [encryptedContentText, saltText, ivText] = encryptionComponents;
-	const encryptedContent = _base64StringToArray(encryptedContentText);
-	const salt = _base64StringToArray(saltText);
-	const iv = _base64StringToArray(ivText);
+	return await _decryptEnvelope(
+		envelope,
+		await _deriveKeyBytes(password, envelope.salt, envelope.iterations)
+	);
+}
+
+/**
+ * Decrypts encrypted ciphertext with a key already derived by deriveKeyAndVerifier.
+ * @param {string} cipherText Encrypted ciphertext returned from the encrypt function.
+ * @param {Uint8Array} key The derived encryption key.
+ * @returns {Promise<string>} The decrypted text.
+ */
+export async function decryptWithKey(cipherText, key) {
+	const envelope = _parseEnvelope(cipherText || "");
+	if (!envelope) {
+		return;
+	}
 
-	const decryptionKey = await _keyFromPassword(password, salt, "decrypt");
+	return await _decryptEnvelope(envelope, key);
+}
+
+/**
+ * Encrypts files to attach to a secret with the secret's encryption key. Each file's name is encrypted into an envelope
+ * that shares the secret's key derivation parameters, and its content is encrypted into its IV followed by its
+ * ciphertext.
+ * @param {string} encryptedSecret The secret the files accompany, as returned by the encrypt functions.
+ * @param {string} password The password (or generated key) the secret was encrypted with.
+ * @param {{name: string, content: Uint8Array}[]} files The files to encrypt.
+ * @returns {Promise<{name: string, content: string}[]>} The encrypted files, with their contents base64url encoded.
+ */
+export async function encryptAttachments(encryptedSecret, password, files) {
+	const envelope = _parseEnvelope(encryptedSecret);
+	const keyBytes = await _deriveKeyBytes(
+		password,
+		envelope.salt,
+		envelope.iterations
+	);
+	const encryptionKey = await _importKey(keyBytes, "encrypt");
+
+	const attachments = [];
+	for (const file of files) {
+		const nameIV = window.crypto.getRandomValues(new Uint8Array(12));
+		const name = await window.crypto.subtle.encrypt(
+			{ name: "AES-GCM", iv: nameIV },
+			encryptionKey,
+			new TextEncoder().encode(file.name)
+		);
+
+		const contentIV = window.crypto.getRandomValues(new Uint8Array(12));
+		const content = await window.crypto.subtle.encrypt(
+			{ name: "AES-GCM", iv: contentIV },
+			encryptionKey,
+			file.content
+		);
+
+		const encryptedContent = new Uint8Array(12 + content.byteLength);
+		encryptedContent.set(contentIV);
+		encryptedContent.set(new Uint8Array(content), 12);
+
+		attachments.push({
+			name: [
+				ENVELOPE_VERSION,
+				envelope.iterations
+					? `pbkdf2-sha256-${envelope.iterations}`
+					: KDF_NONE,
+				_arrayToBase64URLString(new Uint8Array(name)),
+				_arrayToBase64URLString(envelope.salt),
+				_arrayToBase64URLString(nameIV),
+			].join("."),
+			content: _arrayToBase64URLString(encryptedContent),
+		});
+	}
+
+	return attachments;
+}
+
+/**
+ * Decrypts the files attached to a secret with the password (or generated key) the secret was encrypted with.
+ * @param {string} encryptedSecret The secret the files accompany.
+ * @param {string} password The plaintext password (or generated key) to decrypt the files with.
+ * @param {{name: string, content: string}[]} attachments The encrypted files, as returned by encryptAttachments.
+ * @returns {Promise<{name: string, content: Uint8Array}[]>} The decrypted files.
+ */
+export async function decryptAttachments(encryptedSecret, password, attachments) {
+	const envelope = _parseEnvelope(encryptedSecret);
+
+	return await decryptAttachmentsWithKey(
+		attachments,
+		await _deriveKeyBytes(password, envelope.salt, envelope.iterations)
+	);
+}
+
+/**
+ * Decrypts the files attached to a secret with a key already derived by deriveKeyAndVerifier.
+ * @param {{name: string, content: string}[]} attachments The encrypted files, as returned by encryptAttachments.
+ * @param {Uint8Array} key The derived encryption key.
+ * @returns {Promise<{name: string, content: Uint8Array}[]>} The decrypted files.
+ */
+export async function decryptAttachmentsWithKey(attachments, key) {
+	const decryptionKey = await _importKey(key, "decrypt");
+
+	const files = [];
+	for (const attachment of attachments) {
+		const nameEnvelope = _parseEnvelope(attachment.name);
+		const name = await window.crypto.subtle.decrypt(
+			{ name: "AES-GCM", iv: nameEnvelope.iv },
+			decryptionKey,
+			nameEnvelope.encryptedContent
+		);
+
+		const encryptedContent = _base64URLStringToArray(attachment.content);
+		const content = await window.crypto.subtle.decrypt(
+			{ name: "AES-GCM", iv: encryptedContent.slice(0, 12) },
+			decryptionKey,
+			encryptedContent.slice(12)
+		);
+
+		files.push({
+			name: new TextDecoder().decode(name),
+			content: new Uint8Array(content),
+		});
+	}
+
+	return files;
+}
+
+/**
+ * Decrypts the encrypted content of a parsed envelope.
+ * @param {{encryptedContent: Uint8Array, iv: Uint8Array}} envelope The parsed envelope.
+ * @param {Uint8Array} keyBytes The encryption key.
+ * @returns {Promise<string>} The decrypted text.
+ */
+async function _decryptEnvelope(envelope, keyBytes) {
+	const decryptionKey = await _importKey(keyBytes, "decrypt");
 
 	const decryptedBuffer = await window.crypto.subtle.decrypt(
-		{ name: "AES-GCM", iv },
+		{ name: "AES-GCM", iv: envelope.iv },
 		decryptionKey,
-		encryptedContent
+		envelope.encryptedContent
 	);
 
 	return new TextDecoder().decode(decryptedBuffer);
 }
 
+/**
+ * Solves a proof of work challenge issued by the server in a background worker, finding a solution such that the
+ * SHA-256 hash of "{challenge}:{solution}" begins with at least the difficulty in zero bits.
+ * @param {string} challenge The signed challenge issued by the server.
+ * @param {number} difficulty The number of leading zero bits required.
+ * @returns {Promise<string>} The solution to the challenge.
+ */
+export function solveProofOfWork(challenge, difficulty) {
+	return new Promise(function (resolve, reject) {
+		const worker = new Worker("/static/js/proof_of_work_worker.mjs", {
+			type: "module",
+		});
+
+		worker.addEventListener("message", function (e) {
+			worker.terminate();
+			resolve(e.data);
+		});
+		worker.addEventListener("error", function (e) {
+			worker.terminate();
+			reject(e);
+		});
+
+		worker.postMessage({ challenge, difficulty });
+	});
+}
+
+/**
+ * The size of the chunks large secrets (and their attachments) are uploaded in, which is also the size above which they
+ * are uploaded rather than sent in a single request.
+ */
+export const UPLOAD_CHUNK_SIZE = 1024 * 1024;
+
+/**
+ * The number of times in a row a chunk is retried before an upload is abandoned.
+ */
+const MAX_UPLOAD_RETRIES = 5;
+
+/**
+ * The version of the tus resumable upload protocol the server implements uploads with.
+ */
+const TUS_VERSION = "1.0.0";
+
+/**
+ * Uploads content (an encrypted secret and its attachments) in chunks using the tus resumable upload protocol. Chunks
+ * that fail are retried from wherever the server got up to, so the content only has to be encrypted once no matter how
+ * unreliable the connection is.
+ * @param {Uint8Array} content The content to upload.
+ * @param {{invite: string|undefined, organisation: string|undefined}} metadata The invite the secret will be created
+ * via, or the organisation it will be created for.
+ * @returns {Promise<string>} The identifier of the complete upload, to create the secret from.
+ * @throws {Error} If the upload could not be completed, with a message that can be displayed.
+ */
+export async function upload(content, metadata) {
+	const headers = {
+		"Tus-Resumable": TUS_VERSION,
+		"Upload-Length": `${content.length}`,
+	};
+
+	const pairs = Object.entries(metadata)
+		.filter(([, v]) => v)
+		.map(([k, v]) => `${k} ${btoa(v)}`);
+	if (pairs.length > 0) {
+		headers["Upload-Metadata"] = pairs.join(",");
+	}
+
+	const created = await fetch("/api/v1/uploads", { method: "POST", headers });
+	if (created.status !== 201) {
+		throw new Error(await _apiErrorMessage(created));
+	}
+
+	const location = created.headers.get("Location");
+
+	let offset = 0;
+	let failures = 0;
+	while (offset < content.length) {
+		const chunk = content.subarray(offset, offset + UPLOAD_CHUNK_SIZE);
+
+		let response;
+		try {
+			response = await fetch(location, {
+				method: "PATCH",
+				headers: {
+					"Tus-Resumable": TUS_VERSION,
+					"Content-Type": "application/offset+octet-stream",
+					"Upload-Offset": `${offset}`,
+				},
+				body: chunk,
+			});
+		} catch (e) {
+			// the connection dropped, which is retried below
+		}
+
+		if (response && response.status === 204) {
+			offset += chunk.length;
+			failures = 0;
+			continue;
+		}
+
+		// only chunks that failed because of the connection, the server or a mismatched offset (i.e. the server received
+		// part of a previous chunk) are worth retrying
+		if (response && response.status !== 409 && response.status < 500) {
+			throw new Error(await _apiErrorMessage(response));
+		}
+
+		if (++failures > MAX_UPLOAD_RETRIES) {
+			throw new Error(
+				"Unable to upload the secret. Please check your connection and try again."
+			);
+		}
+
+		await new Promise((resolve) => setTimeout(resolve, failures * 1000));
+
+		// resume from wherever the server got up to, which may be part of the way through the failed chunk
+		try {
+			const head = await fetch(location, {
+				method: "HEAD",
+				headers: { "Tus-Resumable": TUS_VERSION },
+			});
+			if (head.status === 200) {
+				offset = parseInt(head.headers.get("Upload-Offset"), 10);
+			}
+		} catch (e) {
+			// the connection is still down, so the chunk is retried from the same offset
+		}
+	}
+
+	return location.substring(location.lastIndexOf("/") + 1);
+}
+
 /**
  * Clears and hides the notifications on a given page optionally scoped to a specific element.
  * @param {Element} scope An optional element to scope the notifications to.
@@ -83,13 +420,99 @@ export function showErrorNotification(scope, err) {
 }
 
 /**
- * Dervies a cryptographically secure encryption key from a password using the PBKDF hashing algorithm.
- * @param {string} password The password to derive the key from.
+ * Retrieves the message of the error an unsuccessful API response was returned with.
+ * @param {Response} response The API response.
+ * @returns {Promise<string>} The error's message.
+ */
+async function _apiErrorMessage(response) {
+	try {
+		return (await response.json()).error.message;
+	} catch (e) {
+		return "Something went wrong. Please try again.";
+	}
+}
+
+/**
+ * Parses an encrypted secret in either the versioned or the legacy format into its parts.
+ * @param {string} cipherText The encrypted secret.
+ * @returns {{iterations: number, encryptedContent: Uint8Array, salt: Uint8Array, iv: Uint8Array}|undefined} The parts
+ * of the encrypted secret, or undefined if it is malformed.
+ */
+function _parseEnvelope(cipherText) {
+	const components = cipherText.split(".");
+
+	if (components.length === 5 && components[0] === ENVELOPE_VERSION) {
+		const kdf = /^pbkdf2-sha256-(\d+)$/.exec(components[1]);
+		if (!kdf && components[1] !== KDF_NONE) {
+			return;
+		}
+
+		const [encryptedContentText, saltText, ivText] = components.slice(2);
+
+		return {
+			iterations: kdf ? parseInt(kdf[1], 10) : 0,
+			encryptedContent: _base64URLStringToArray(encryptedContentText),
+			salt: _base64URLStringToArray(saltText),
+			iv: _base64URLStringToArray(ivText),
+		};
+	}
+
+	if (components.length === 3) {
+		const [encryptedContentText, saltText, ivText] = components;
+
+		return {
+			iterations: LEGACY_KDF_ITERATIONS,
+			encryptedContent: _base64StringToArray(encryptedContentText),
+			salt: _base64StringToArray(saltText),
+			iv: _base64StringToArray(ivText),
+		};
+	}
+}
+
+/**
+ * Encrypts provided plaintext, returning it in the versioned envelope format along with the verifier of its key.
+ * @param {string} plainText The text to be encrypted.
+ * @param {string} password The password to derive the key from, or a generated key if iterations is 0.
+ * @param {number} iterations The number of PBKDF2 iterations to derive the key with.
+ * @returns {Promise<{encryptedSecret: string, verifier: string}>} The envelope and the key's verifier.
+ */
+async function _encrypt(plainText, password, iterations) {
+	const enc = new TextEncoder();
+	const salt = window.crypto.getRandomValues(new Uint8Array(16));
+	const iv = window.crypto.getRandomValues(new Uint8Array(12));
+	const keyBytes = await _deriveKeyBytes(password, salt, iterations);
+	const encryptionKey = await _importKey(keyBytes, "encrypt");
+
+	const cipherText = await window.crypto.subtle.encrypt(
+		{ name: "AES-GCM", iv },
+		encryptionKey,
+		enc.encode(plainText)
+	);
+
+	const encryptedSecret = [
+		ENVELOPE_VERSION,
+		iterations ? `pbkdf2-sha256-${iterations}` : KDF_NONE,
+		_arrayToBase64URLString(new Uint8Array(cipherText)),
+		_arrayToBase64URLString(salt),
+		_arrayToBase64URLString(iv),
+	].join(".");
+
+	return { encryptedSecret, verifier: await _verifierFromKeyBytes(keyBytes) };
+}
+
+/**
+ * Dervies a cryptographically secure 256 bit encryption key from a password using the PBKDF hashing algorithm.
+ * @param {string} password The password to derive the key from, or a base64url encoded generated key if iterations is
+ * 0.
  * @param {Uint8Array} salt A cryptographically-secure randomly generated salt.
- * @param {string} use What the derived key will be used for.
- * @returns {Promise<CryptoKey>} The created key.
+ * @param {number} iterations The number of PBKDF2 iterations to derive the key with.
+ * @returns {Promise<Uint8Array>} The derived key.
  */
-async function _keyFromPassword(password, salt, use = "encrypt") {
+async function _deriveKeyBytes(password, salt, iterations) {
+	if (iterations === 0) {
+		return _base64URLStringToArray(password);
+	}
+
 	const enc = new TextEncoder();
 
 	const material = await window.crypto.subtle.importKey(
@@ -97,23 +520,60 @@ async function _keyFromPassword(password, salt, use = "encrypt") {
 		enc.encode(password),
 		"PBKDF2",
 		false,
-		["deriveKey"]
+		["deriveBits"]
 	);
 
-	const derivedKey = await window.crypto.subtle.deriveKey(
+	const derivedBits = await window.crypto.subtle.deriveBits(
 		{
 			name: "PBKDF2",
 			salt,
-			iterations: 600000,
+			iterations,
 			hash: "SHA-256",
 		},
 		material,
-		{ name: "AES-GCM", length: 256 },
+		256
+	);
+
+	return new Uint8Array(derivedBits);
+}
+
+/**
+ * Imports a derived or generated key for use with AES-GCM.
+ * @param {Uint8Array} keyBytes The key.
+ * @param {string} use What the key will be used for.
+ * @returns {Promise<CryptoKey>} The imported key.
+ */
+async function _importKey(keyBytes, use = "encrypt") {
+	return await window.crypto.subtle.importKey(
+		"raw",
+		keyBytes,
+		{ name: "AES-GCM" },
 		false,
 		[use]
 	);
+}
+
+/**
+ * Computes the verifier of an encryption key, which proves knowledge of the key without revealing it.
+ * @param {Uint8Array} keyBytes The key.
+ * @returns {Promise<string>} The unpadded base64url encoded HMAC-SHA256 of the verifier label keyed with the key.
+ */
+async function _verifierFromKeyBytes(keyBytes) {
+	const hmacKey = await window.crypto.subtle.importKey(
+		"raw",
+		keyBytes,
+		{ name: "HMAC", hash: "SHA-256" },
+		false,
+		["sign"]
+	);
+
+	const signature = await window.crypto.subtle.sign(
+		"HMAC",
+		hmacKey,
+		new TextEncoder().encode(VERIFIER_LABEL)
+	);
 
-	return derivedKey;
+	return _arrayToBase64URLString(new Uint8Array(signature));
 }
 
 /**
@@ -129,6 +589,18 @@ function _arrayToBase64String(buffer) {
 	return btoa(binary);
 }
 
+/**
+ * Converts an ArrayBuffer to an unpadded base64url encoded string.
+ * @param {Uint8Array} buffer The buffer to convert each value to a string.
+ * @returns {string} An unpadded base64url encoded string representation of the buffer.
+ */
+function _arrayToBase64URLString(buffer) {
+	return _arrayToBase64String(buffer)
+		.replace(/\+/g, "-")
+		.replace(/\//g, "_")
+		.replace(/=+$/, "");
+}
+
 /**
  * Converts a Base64 encoded string to an ArrayBuffer.
  * @param {string} str The base64 encoded string to convert to an array buffer.
@@ -137,3 +609,15 @@ function _arrayToBase64String(buffer) {
 function _base64StringToArray(str) {
 	return Uint8Array.from(atob(str), (c) => c.charCodeAt(0));
 }
+
+/**
+ * Converts an unpadded base64url encoded string to an ArrayBuffer.
+ * @param {string} str The base64url encoded string to convert to an array buffer.
+ * @returns {Uint8Array}
+ */
+function _base64URLStringToArray(str) {
+	const base64 = str.replace(/-/g, "+").replace(/_/g, "/");
+	const padding = "=".repeat((4 - (base64.length % 4)) % 4);
+
+	return _base64StringToArray(base64 + padding);
+}
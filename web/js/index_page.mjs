@@ -1,7 +1,13 @@
 import {
 	clearAndHideNotifications,
 	encrypt,
+	encryptAttachments,
+	encryptWithGeneratedKey,
+	encryptWithVerifier,
 	showErrorNotification,
+	solveProofOfWork,
+	upload,
+	UPLOAD_CHUNK_SIZE,
 } from "./core.mjs";
 
 document.addEventListener("DOMContentLoaded", function () {
@@ -10,6 +16,23 @@ document.addEventListener("DOMContentLoaded", function () {
 		return;
 	}
 
+	const keyInURLInput = createSecretForm.querySelector(
+		"input[name=keyInURL]"
+	);
+	const verifyKeyInput = createSecretForm.querySelector(
+		"input[name=verifyKey]"
+	);
+	const passwordInput = createSecretForm.querySelector("input[name=password]");
+	const attachmentsInput = createSecretForm.querySelector(
+		"input[name=attachments]"
+	);
+
+	// a generated key replaces the encryption key entirely, so there is no point in entering one or in verifying it
+	keyInURLInput.addEventListener("change", function () {
+		passwordInput.disabled = keyInURLInput.checked;
+		verifyKeyInput.disabled = keyInURLInput.checked;
+	});
+
 	createSecretForm.addEventListener("submit", async function (e) {
 		e.preventDefault();
 
@@ -23,30 +46,123 @@ document.addEventListener("DOMContentLoaded", function () {
 			const plaintextSecret = createSecretForm.querySelector(
 				"textarea[name=plaintextSecret]"
 			).value;
-			const password = createSecretForm.querySelector(
-				"input[name=password]"
-			).value;
 
-			const encryptedSecret = await encrypt(plaintextSecret, password);
+			const files = attachmentsInput ? Array.from(attachmentsInput.files) : [];
+			const attachmentsError = _checkAttachments(createSecretForm, files);
+			if (attachmentsError) {
+				showErrorNotification(createSecretForm, attachmentsError);
+				return;
+			}
+
+			// the generated key is only ever added to the fragment of the management page's URL, which is never sent to
+			// the server, so that the full viewing URL can be shown there once
+			let encryptedSecret, verifier, fragment;
+			let key = passwordInput.value;
+			if (keyInURLInput.checked) {
+				const generated = await encryptWithGeneratedKey(plaintextSecret);
+				encryptedSecret = generated.encryptedSecret;
+				key = generated.key;
+				fragment = `#k=${generated.key}`;
+			} else if (verifyKeyInput.checked) {
+				const verified = await encryptWithVerifier(
+					plaintextSecret,
+					passwordInput.value
+				);
+				encryptedSecret = verified.encryptedSecret;
+				verifier = verified.verifier;
+				fragment = "";
+			} else {
+				encryptedSecret = await encrypt(plaintextSecret, passwordInput.value);
+				fragment = "";
+			}
+
+			const maxSecretSize = parseInt(createSecretForm.dataset.maxSecretSize, 10);
+			if (encryptedSecret.length > maxSecretSize) {
+				showErrorNotification(
+					createSecretForm,
+					"Secret is too large. Please shorten it and try again."
+				);
+				return;
+			}
 
 			const requestData = new URLSearchParams();
 			requestData.append(
 				"ttl",
 				createSecretForm.querySelector("select[name=ttl]").value
 			);
-			requestData.append("encryptedSecret", encryptedSecret);
+			if (verifier) {
+				requestData.append("verifier", verifier);
+			}
+
+			// attachments are encrypted with the same key as the secret, so the secret's viewers can decrypt them
+			const attachments = await encryptAttachments(
+				encryptedSecret,
+				key,
+				await Promise.all(
+					files.map(async (f) => ({
+						name: f.name,
+						content: new Uint8Array(await f.arrayBuffer()),
+					}))
+				)
+			);
+
+			// large secrets are uploaded in chunks that can be resumed should the connection drop, and the secret is then
+			// created from the upload
+			const payload = new TextEncoder().encode(
+				JSON.stringify({ encryptedSecret, attachments })
+			);
+			if (payload.length > UPLOAD_CHUNK_SIZE) {
+				try {
+					requestData.append(
+						"upload",
+						await upload(payload, {
+							invite: createSecretForm.dataset.invite,
+							organisation: createSecretForm.dataset.organisation,
+						})
+					);
+				} catch (e) {
+					showErrorNotification(createSecretForm, e.message);
+					return;
+				}
+			} else {
+				requestData.append("encryptedSecret", encryptedSecret);
+				for (const attachment of attachments) {
+					requestData.append("attachmentName", attachment.name);
+					requestData.append("attachmentContent", attachment.content);
+				}
+			}
 			requestData.append(
 				"maxViews",
 				createSecretForm.querySelector("input[name=maxViews]").value
 			);
 
+			if (createSecretForm.dataset.invite) {
+				requestData.append("invite", createSecretForm.dataset.invite);
+			}
+
+			if (createSecretForm.dataset.organisation) {
+				requestData.append(
+					"organisation",
+					createSecretForm.dataset.organisation
+				);
+			}
+
+			const challenge = await _takeChallenge(createSecretForm);
+			if (challenge) {
+				requestData.append("powChallenge", challenge.challenge);
+				requestData.append(
+					"powSolution",
+					await solveProofOfWork(challenge.challenge, challenge.difficulty)
+				);
+			}
+
 			const response = await fetch("/secret", {
 				method: "POST",
 				body: requestData,
 			});
 
 			if (response.status === 201) {
-				window.location.href = response.headers.get("Location");
+				window.location.href = response.headers.get("Location") + fragment;
 			} else if (response.status === 500) {
 				window.location.href = "/oops";
 			} else {
@@ -57,3 +173,56 @@ document.addEventListener("DOMContentLoaded", function () {
 		}
 	});
 });
+
+/**
+ * Checks the files to attach to a secret are within the limits advertised by the server, which counts their size once
+ * encrypted (each is 28 bytes larger).
+ * @param {HTMLFormElement} form The create secret form, which carries the limits.
+ * @param {File[]} files The files to attach.
+ * @returns {string|undefined} An error to display, if the files are not within the limits.
+ */
+function _checkAttachments(form, files) {
+	if (files.length === 0) {
+		return;
+	}
+
+	const maxAttachments = parseInt(form.dataset.maxAttachments, 10);
+	if (files.length > maxAttachments) {
+		return `At most ${maxAttachments} files can be attached to a secret.`;
+	}
+
+	const size = files.reduce((total, f) => total + f.size + 28, 0);
+	if (size > parseInt(form.dataset.maxAttachmentSize, 10)) {
+		return "Attachments are too large. Please remove some and try again.";
+	}
+}
+
+/**
+ * Takes the proof of work challenge issued with the page (if there is one), fetching a new one if it has already been
+ * used or is about to expire. Each challenge can only be used once.
+ * @param {HTMLFormElement} form The create secret form the challenge was issued with.
+ * @returns {Promise<{challenge: string, difficulty: number}|undefined>} The challenge, if one is required.
+ */
+async function _takeChallenge(form) {
+	if (!form.dataset.powChallenge) {
+		return;
+	}
+
+	const challenge = {
+		challenge: form.dataset.powChallenge,
+		difficulty: parseInt(form.dataset.powDifficulty, 10),
+		expiresAt: parseInt(form.dataset.powExpiresAt, 10),
+	};
+
+	if (!form.dataset.powUsed && challenge.expiresAt - Date.now() > 30000) {
+		form.dataset.powUsed = "true";
+		return challenge;
+	}
+
+	const response = await fetch("/api/v1/challenge");
+	if (response.status !== 200) {
+		return challenge;
+	}
+
+	return await response.json();
+}
@@ -0,0 +1,19 @@
+import { takeKeyFromURLFragment } from "./core.mjs";
+
+document.addEventListener("DOMContentLoaded", function () {
+	const viewingURLInput = document.querySelector(
+		"input[name=viewing_url][data-key-in-url]"
+	);
+	if (!viewingURLInput) {
+		return;
+	}
+
+	// the key of a secret encrypted with a generated key is only in the fragment of this page's URL straight after the
+	// secret is created. It is removed once read, so the full viewing URL is only ever shown once.
+	const key = takeKeyFromURLFragment();
+	if (key) {
+		viewingURLInput.value = `${viewingURLInput.value}#k=${key}`;
+	} else {
+		document.getElementById("keyInURLMissing").removeAttribute("hidden");
+	}
+});
@@ -0,0 +1,133 @@
+import { clearAndHideNotifications, showErrorNotification } from "./core.mjs";
+
+document.addEventListener("DOMContentLoaded", function () {
+	const passkeyForm = document.getElementById("passkeyForm");
+	if (!passkeyForm) {
+		return;
+	}
+
+	passkeyForm.addEventListener("submit", async function (e) {
+		e.preventDefault();
+
+		clearAndHideNotifications(passkeyForm);
+
+		if (!window.PublicKeyCredential) {
+			showErrorNotification(
+				passkeyForm,
+				"Your browser does not support passkeys."
+			);
+			return;
+		}
+
+		const button = passkeyForm.querySelector("button");
+
+		try {
+			button.setAttribute("aria-busy", true);
+
+			if (passkeyForm.dataset.ceremony === "create") {
+				await _createPasskey(passkeyForm);
+			} else {
+				await _usePasskey(passkeyForm);
+			}
+
+			passkeyForm.submit();
+		} catch (e) {
+			console.error(e);
+			showErrorNotification(
+				passkeyForm,
+				"Unable to use a passkey. Please try again."
+			);
+		} finally {
+			button.removeAttribute("aria-busy");
+		}
+	});
+});
+
+/**
+ * Creates a passkey for the account being enrolled, filling the form with the authenticator's response.
+ * @param {HTMLFormElement} form The form containing the registration options.
+ */
+async function _createPasskey(form) {
+	const credential = await navigator.credentials.create({
+		publicKey: {
+			challenge: _base64URLToArray(form.dataset.challenge),
+			rp: { id: form.dataset.rpId, name: form.dataset.rpName },
+			user: {
+				id: _base64URLToArray(form.dataset.userId),
+				name: form.dataset.userName,
+				displayName: form.dataset.userName,
+			},
+			pubKeyCredParams: [
+				{ type: "public-key", alg: -7 },
+				{ type: "public-key", alg: -257 },
+			],
+			authenticatorSelection: {
+				residentKey: "required",
+				userVerification: "preferred",
+			},
+			attestation: "none",
+		},
+	});
+
+	_setField(form, "clientDataJSON", credential.response.clientDataJSON);
+	_setField(form, "attestationObject", credential.response.attestationObject);
+}
+
+/**
+ * Signs in with a passkey chosen by the user, filling the form with the authenticator's response.
+ * @param {HTMLFormElement} form The form containing the sign in options.
+ */
+async function _usePasskey(form) {
+	const credential = await navigator.credentials.get({
+		publicKey: {
+			challenge: _base64URLToArray(form.dataset.challenge),
+			rpId: form.dataset.rpId,
+			userVerification: "preferred",
+		},
+	});
+
+	_setField(form, "credentialId", credential.rawId);
+	_setField(form, "clientDataJSON", credential.response.clientDataJSON);
+	_setField(form, "authenticatorData", credential.response.authenticatorData);
+	_setField(form, "signature", credential.response.signature);
+	_setField(form, "userHandle", credential.response.userHandle);
+}
+
+/**
+ * Sets the value of a hidden form input to the base64 URL encoded buffer.
+ * @param {HTMLFormElement} form The form containing the input.
+ * @param {string} name The name of the input.
+ * @param {ArrayBuffer} buffer The buffer to encode, which may be null.
+ */
+function _setField(form, name, buffer) {
+	form.querySelector(`input[name=${name}]`).value = buffer
+		? _arrayToBase64URL(new Uint8Array(buffer))
+		: "";
+}
+
+/**
+ * Converts a Uint8Array to an unpadded base64 URL encoded string.
+ * @param {Uint8Array} buffer The buffer to convert.
+ * @returns {string}
+ */
+function _arrayToBase64URL(buffer) {
+	const binary = Array.prototype.map
+		.call(buffer, (byte) => String.fromCharCode(byte))
+		.join("");
+
+	return btoa(binary)
+		.replaceAll("+", "-")
+		.replaceAll("/", "_")
+		.replaceAll("=", "");
+}
+
+/**
+ * Converts an unpadded base64 URL encoded string to a Uint8Array.
+ * @param {string} str The string to convert.
+ * @returns {Uint8Array}
+ */
+function _base64URLToArray(str) {
+	const base64 = str.replaceAll("-", "+").replaceAll("_", "/");
+
+	return Uint8Array.from(atob(base64), (c) => c.charCodeAt(0));
+}
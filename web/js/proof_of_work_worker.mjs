@@ -0,0 +1,129 @@
+// Solves proof of work challenges off of the main thread. The WebCrypto API only offers an asynchronous digest function
+// which is far too slow to be called hundreds of thousands of times, hence the SHA-256 implementation below.
+
+const K = new Uint32Array([
+	0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1,
+	0x923f82a4, 0xab1c5ed5, 0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3,
+	0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174, 0xe49b69c1, 0xefbe4786,
+	0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+	0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147,
+	0x06ca6351, 0x14292967, 0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13,
+	0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85, 0xa2bfe8a1, 0xa81a664b,
+	0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+	0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a,
+	0x5b9cca4f, 0x682e6ff3, 0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208,
+	0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2,
+]);
+
+const w = new Uint32Array(64);
+
+self.addEventListener("message", function (e) {
+	const { challenge, difficulty } = e.data;
+	const prefix = new TextEncoder().encode(`${challenge}:`);
+
+	for (let counter = 0; ; counter++) {
+		const solution = counter.toString(36);
+		const message = new Uint8Array(prefix.length + solution.length);
+		message.set(prefix);
+		for (let i = 0; i < solution.length; i++) {
+			message[prefix.length + i] = solution.charCodeAt(i);
+		}
+
+		if (_leadingZeroBits(_sha256(message)) >= difficulty) {
+			self.postMessage(solution);
+			return;
+		}
+	}
+});
+
+/**
+ * Counts the number of zero bits at the start of a hash.
+ * @param {Uint32Array} hash The hash, as big endian words.
+ * @returns {number}
+ */
+function _leadingZeroBits(hash) {
+	let n = 0;
+	for (const word of hash) {
+		n += Math.clz32(word);
+		if (word !== 0) {
+			break;
+		}
+	}
+
+	return n;
+}
+
+/**
+ * Hashes a message with SHA-256.
+ * @param {Uint8Array} message The message to hash.
+ * @returns {Uint32Array} The hash, as big endian words.
+ */
+function _sha256(message) {
+	const h = new Uint32Array([
+		0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a, 0x510e527f, 0x9b05688c,
+		0x1f83d9ab, 0x5be0cd19,
+	]);
+
+	// pad the message with a single set bit, zeroes and the length of the message in bits
+	const length = Math.ceil((message.length + 9) / 64) * 64;
+	const padded = new Uint8Array(length);
+	padded.set(message);
+	padded[message.length] = 0x80;
+	new DataView(padded.buffer).setUint32(length - 4, message.length * 8);
+
+	const view = new DataView(padded.buffer);
+
+	for (let offset = 0; offset < length; offset += 64) {
+		for (let i = 0; i < 16; i++) {
+			w[i] = view.getUint32(offset + i * 4);
+		}
+
+		for (let i = 16; i < 64; i++) {
+			const s0 =
+				_rotr(w[i - 15], 7) ^ _rotr(w[i - 15], 18) ^ (w[i - 15] >>> 3);
+			const s1 = _rotr(w[i - 2], 17) ^ _rotr(w[i - 2], 19) ^ (w[i - 2] >>> 10);
+			w[i] = w[i - 16] + s0 + w[i - 7] + s1;
+		}
+
+		let [a, b, c, d, e, f, g, hh] = h;
+
+		for (let i = 0; i < 64; i++) {
+			const s1 = _rotr(e, 6) ^ _rotr(e, 11) ^ _rotr(e, 25);
+			const ch = (e & f) ^ (~e & g);
+			const t1 = (hh + s1 + ch + K[i] + w[i]) | 0;
+			const s0 = _rotr(a, 2) ^ _rotr(a, 13) ^ _rotr(a, 22);
+			const maj = (a & b) ^ (a & c) ^ (b & c);
+			const t2 = (s0 + maj) | 0;
+
+			hh = g;
+			g = f;
+			f = e;
+			e = (d + t1) | 0;
+			d = c;
+			c = b;
+			b = a;
+			a = (t1 + t2) | 0;
+		}
+
+		h[0] += a;
+		h[1] += b;
+		h[2] += c;
+		h[3] += d;
+		h[4] += e;
+		h[5] += f;
+		h[6] += g;
+		h[7] += hh;
+	}
+
+	return h;
+}
+
+/**
+ * Rotates a 32 bit word to the right.
+ * @param {number} x The word to rotate.
+ * @param {number} n The number of bits to rotate it by.
+ * @returns {number}
+ */
+function _rotr(x, n) {
+	return (x >>> n) | (x << (32 - n));
+}
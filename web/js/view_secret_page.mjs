@@ -1,15 +1,34 @@
 import {
 	clearAndHideNotifications,
 	decrypt,
+	decryptAttachments,
+	decryptAttachmentsWithKey,
+	decryptWithKey,
+	deriveKeyAndVerifier,
 	showErrorNotification,
+	takeKeyFromURLFragment,
 } from "./core.mjs";
 
 document.addEventListener("DOMContentLoaded", function () {
+	const verifySecretForm = document.getElementById("verifySecretForm");
+	if (verifySecretForm) {
+		verifySecretForm.addEventListener("submit", function (e) {
+			e.preventDefault();
+			_verifyAndDecrypt(verifySecretForm);
+		});
+		return;
+	}
+
 	const decryptSecretForm = document.getElementById("decryptSecretForm");
 	if (!decryptSecretForm) {
 		return;
 	}
 
+	if (decryptSecretForm.dataset.keyInUrl) {
+		_decryptWithKeyFromURL(decryptSecretForm);
+		return;
+	}
+
 	decryptSecretForm.addEventListener("submit", async function (e) {
 		e.preventDefault();
 
@@ -35,6 +54,15 @@ document.addEventListener("DOMContentLoaded", function () {
 			);
 			decryptedCipherTextInput.value = decryptedCipherText;
 
+			_showAttachments(
+				decryptSecretForm,
+				await decryptAttachments(
+					cipherTextInput.value,
+					passwordInput.value,
+					_embeddedAttachments(decryptSecretForm)
+				)
+			);
+
 			decryptedCipherTextInput.removeAttribute("disabled");
 			decryptedCipherTextInput.focus();
 
@@ -51,3 +79,165 @@ document.addEventListener("DOMContentLoaded", function () {
 		}
 	});
 });
+
+/**
+ * Decrypts a secret encrypted with a generated key using the key in the fragment of the page's URL, displaying it
+ * without the visitor having to enter anything.
+ * @param {HTMLFormElement} form The decrypt secret form.
+ */
+async function _decryptWithKeyFromURL(form) {
+	const key = takeKeyFromURLFragment();
+	if (!key) {
+		showErrorNotification(
+			form,
+			"The link you followed is missing the secret's key. Ask its sender for the full link."
+		);
+		return;
+	}
+
+	const decryptedCipherTextInput = form.querySelector("textarea[name=display]");
+
+	try {
+		const cipherText = form.querySelector("input[name=cipherText]").value;
+
+		decryptedCipherTextInput.value = await decrypt(cipherText, key);
+
+		_showAttachments(
+			form,
+			await decryptAttachments(cipherText, key, _embeddedAttachments(form))
+		);
+
+		decryptedCipherTextInput.removeAttribute("disabled");
+		decryptedCipherTextInput.focus();
+	} catch (e) {
+		console.error(e);
+		showErrorNotification(
+			form,
+			"Unable to decrypt secret. Has the link you followed been cut off?"
+		);
+	}
+}
+
+/**
+ * Proves knowledge of the encryption key of a secret protected by a key verifier, retrieving the encrypted secret from
+ * the server and decrypting it if the key is correct. Incorrect keys are counted by the server, which deletes the
+ * secret once too many have been tried.
+ * @param {HTMLFormElement} form The verify secret form.
+ */
+async function _verifyAndDecrypt(form) {
+	clearAndHideNotifications(form);
+
+	const submitButton = form.querySelector("button");
+	const passwordInput = form.querySelector("input[name=password]");
+	const decryptedCipherTextInput = form.querySelector("textarea[name=display]");
+
+	try {
+		submitButton.setAttribute("aria-busy", "true");
+
+		const { key, verifier } = await deriveKeyAndVerifier(passwordInput.value, {
+			salt: form.dataset.salt,
+			iterations: parseInt(form.dataset.iterations, 10),
+		});
+
+		const response = await fetch(form.dataset.verifyUrl, {
+			method: "POST",
+			headers: { "Content-Type": "application/json" },
+			body: JSON.stringify({ verifier }),
+		});
+
+		if (response.status === 500) {
+			window.location.href = "/oops";
+			return;
+		}
+
+		const body = await response.json();
+		if (response.status !== 200) {
+			if (body.error.code === "viewing_key_expired") {
+				showErrorNotification(
+					form,
+					"The link you used to view this secret has expired, as it was not used soon enough after the secret was opened. Open the secret again to get a new link."
+				);
+			} else {
+				showErrorNotification(form, body.error.message);
+			}
+
+			// the secret (or the viewing key) can no longer be used, so there is no point in trying another key
+			if (
+				response.status === 404 ||
+				response.status === 410 ||
+				body.error.code === "maximum_key_attempts_hit"
+			) {
+				submitButton.setAttribute("disabled", "true");
+				passwordInput.setAttribute("disabled", "true");
+			}
+			return;
+		}
+
+		decryptedCipherTextInput.value = await decryptWithKey(
+			body.encryptedSecret,
+			key
+		);
+
+		_showAttachments(
+			form,
+			await decryptAttachmentsWithKey(body.attachments || [], key)
+		);
+
+		decryptedCipherTextInput.removeAttribute("disabled");
+		decryptedCipherTextInput.focus();
+
+		submitButton.setAttribute("disabled", "true");
+		passwordInput.setAttribute("disabled", "true");
+
+		if (body.finalView) {
+			form.querySelector("#finalViewWarning").removeAttribute("hidden");
+		}
+	} catch (e) {
+		console.error(e);
+		showErrorNotification(
+			form,
+			"Unable to decrypt secret. Have you entered the correct password?"
+		);
+	} finally {
+		submitButton.removeAttribute("aria-busy");
+	}
+}
+
+/**
+ * Reads the encrypted attachments the server embedded in the page alongside the encrypted secret.
+ * @param {HTMLFormElement} form The decrypt secret form.
+ * @returns {{name: string, content: string}[]} The encrypted attachments.
+ */
+function _embeddedAttachments(form) {
+	return Array.from(form.querySelectorAll("#attachments li"), (li) => ({
+		name: li.dataset.name,
+		content: li.dataset.content,
+	}));
+}
+
+/**
+ * Replaces the list of attachments with links that download the decrypted files, showing it if there are any.
+ * @param {HTMLFormElement} form The form containing the list of attachments.
+ * @param {{name: string, content: Uint8Array}[]} files The decrypted files.
+ */
+function _showAttachments(form, files) {
+	const attachments = form.querySelector("#attachments");
+
+	attachments.querySelector("ul").replaceChildren(
+		...files.map(function (file) {
+			const link = document.createElement("a");
+			link.href = URL.createObjectURL(new Blob([file.content]));
+			link.download = file.name;
+			link.textContent = file.name;
+
+			const item = document.createElement("li");
+			item.append(link);
+
+			return item;
+		})
+	);
+
+	if (files.length > 0) {
+		attachments.removeAttribute("hidden");
+	}
+}